@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// Permissions granted by icacls only on Windows.
+func GrantDirectoryPermissions(directory string, grants []string) error {
+	return ErrNotSupportedOnPlatform
+}
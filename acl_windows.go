@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Grant permissions on directory by icacls. Each grant in icacls "/grant" form.
+func GrantDirectoryPermissions(directory string, grants []string) error {
+	args := []string{directory}
+	for _, grant := range grants {
+		args = append(args, "/grant", grant)
+	}
+	command := exec.Command("icacls", args...)
+	command.SysProcAttr = HiddenWindowProcAttr()
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v - %v", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
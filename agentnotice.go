@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const AgentNoticeDefaultFileName string = "Customizations.json" // Agent notice file name by default.
+
+// Notice for agents about applied customisation, read by WDE plugin.
+type AgentNotice struct {
+	Version        string    `json:"version,omitempty"` // Customisation release version.
+	AppliedTime    time.Time `json:"appliedTime"`
+	Hostname       string    `json:"hostname"`
+	Files          int       `json:"files"`                 // Customisation files of applied release.
+	FailedFiles    int       `json:"failedFiles,omitempty"` // Files not copied in partial update.
+	ProgramVersion string    `json:"programVersion"`
+}
+
+// Get path of agent notice file, by default "Customizations.json" in WDE folder.
+func AgentNoticeFilePath(mainConfig MainCfgYAML) string {
+	folder := ExpandWindowsEnv(mainConfig.Notify.AgentFile.Folder)
+	if folder == "" {
+		folder = WDETargetFolder(mainConfig)
+	}
+	fileName := mainConfig.Notify.AgentFile.FileName
+	if fileName == "" {
+		fileName = AgentNoticeDefaultFileName
+	}
+	return filepath.Join(folder, fileName)
+}
+
+// Save agent notice into file. Written into temporary file and renamed,
+// so plugin never reads partially written notice.
+func WriteAgentNotice(noticeFullPath string, notice AgentNotice) error {
+	noticeBytes, err := json.MarshalIndent(notice, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporaryPath := fmt.Sprint(noticeFullPath, ".tmp")
+	err = SaveBytesIntoFile(temporaryPath, noticeBytes)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temporaryPath, noticeFullPath)
+	if err != nil {
+		os.Remove(temporaryPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ApprovalDefaultTimeout      time.Duration = time.Hour        // Wait for pending approval by default.
+	ApprovalDefaultPollInterval time.Duration = 30 * time.Second // Approval request repeat interval by default.
+	ApprovalMaxListedFiles      int           = 200              // Changed files listed in approval request, the rest only counted.
+)
+
+// Planned changes sent by POST to approval endpoint before WDE folder changed.
+type ApprovalRequest struct {
+	Hostname       string    `json:"hostname"`
+	ProgramVersion string    `json:"programVersion"`
+	RunID          string    `json:"runId"` // Start time string of run, as in log and history file names.
+	RunLabels                // Tag and change ticket of run.
+	ReleaseVersion string    `json:"releaseVersion,omitempty"`
+	Files          int       `json:"files"` // Files to deploy, including unchanged ones.
+	AddedCount     int       `json:"addedCount"`
+	ChangedCount   int       `json:"changedCount"`
+	RemovedCount   int       `json:"removedCount"`
+	Bytes          int64     `json:"bytes"` // Total size of added and changed files.
+	Added          []string  `json:"added,omitempty"`
+	Changed        []string  `json:"changed,omitempty"`
+	Removed        []string  `json:"removed,omitempty"`
+	PlanHash       string    `json:"planHash"` // SHA-256 of deployed file set, signed by approver if secret configured.
+	Nonce          string    `json:"nonce"`    // Random value of this run, signed by approver, so token not replayed by other run.
+	RequestTime    time.Time `json:"requestTime"`
+}
+
+// Response of approval endpoint. Status 202 also means pending approval.
+type ApprovalResponse struct {
+	Approved bool      `json:"approved"`
+	Pending  bool      `json:"pending"`
+	Token    string    `json:"token"`   // Hex HMAC-SHA256 of ApprovalTokenData with shared secret.
+	Expires  time.Time `json:"expires"` // Token not accepted after this time.
+	Reason   string    `json:"reason"`
+}
+
+// Describe files to deploy compared with deployed state for approval request.
+func NewApprovalRequest(files []CustomisationFile, deployed DeployedState, release ReleaseInfo, runID string, labels RunLabels) ApprovalRequest {
+	manifest := Manifest{Files: make([]ManifestFile, 0, len(files))}
+	for _, file := range files {
+		manifest.Files = append(manifest.Files, ManifestFile{FileName: file.FileName, RelativePath: file.RelativePath, Size: file.Size, Hash: file.Hash, Winner: true})
+	}
+	drift := FindDrift(manifest, deployed)
+	estimate := EstimatePending(manifest, deployed)
+	hostname, _ := os.Hostname()
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	limit := func(paths []string) []string {
+		if len(paths) > ApprovalMaxListedFiles {
+			return paths[:ApprovalMaxListedFiles]
+		}
+		return paths
+	}
+	return ApprovalRequest{
+		Hostname:       hostname,
+		ProgramVersion: programVersion,
+		RunID:          runID,
+		RunLabels:      labels,
+		ReleaseVersion: release.Version,
+		Files:          len(files),
+		AddedCount:     len(drift.Added),
+		ChangedCount:   len(drift.Changed),
+		RemovedCount:   len(drift.Removed),
+		Bytes:          estimate.Bytes,
+		Added:          limit(drift.Added),
+		Changed:        limit(drift.Changed),
+		Removed:        limit(drift.Removed),
+		PlanHash:       drift.SourceHash,
+		Nonce:          hex.EncodeToString(nonce),
+		RequestTime:    TimestampNow(),
+	}
+}
+
+// Return data signed by approval token: hostname, run ID, nonce, plan hash and expiry in RFC 3339, separated by new lines.
+func ApprovalTokenData(request ApprovalRequest, expires time.Time) string {
+	return strings.Join([]string{request.Hostname, request.RunID, request.Nonce, request.PlanHash, expires.UTC().Format(time.RFC3339)}, "\n")
+}
+
+// Return hex HMAC-SHA256 of approval token data, expected as approval token.
+func ApprovalToken(secret string, request ApprovalRequest, expires time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ApprovalTokenData(request, expires)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send approval request once. Return true if approved, false if pending.
+// Denial and invalid token returned as ErrApprovalDenied.
+func RequestApproval(mainConfig MainCfgYAML, request ApprovalRequest) (bool, error) {
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return false, err
+	}
+	client := http.Client{Timeout: CoordinationRequestTimeout}
+	response, err := client.Post(mainConfig.Approval.URL, "application/json", bytes.NewReader(requestBytes))
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return false, err
+	}
+	var approval ApprovalResponse
+	if len(bytes.TrimSpace(responseBytes)) > 0 {
+		err = json.Unmarshal(responseBytes, &approval)
+		if err != nil && response.StatusCode == http.StatusOK {
+			return false, fmt.Errorf("can't parse approval response - %v", err)
+		}
+	}
+	switch {
+	case response.StatusCode == http.StatusForbidden:
+		return false, fmt.Errorf("%w - %v", ErrApprovalDenied, approval.Reason)
+	case response.StatusCode == http.StatusAccepted:
+		return false, nil
+	case response.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("approval endpoint response status - %v", response.Status)
+	case approval.Pending:
+		return false, nil
+	}
+	if mainConfig.Approval.Secret != "" {
+		// Approved flag alone not trusted, endpoint must prove knowledge of secret for this plan,
+		// machine and run. Token of other run or expired one not accepted.
+		if approval.Expires.IsZero() || !TimestampNow().Before(approval.Expires) {
+			return false, fmt.Errorf("%w - token expired or without expiry", ErrApprovalDenied)
+		}
+		expected := ApprovalToken(mainConfig.Approval.Secret, request, approval.Expires)
+		if !hmac.Equal([]byte(strings.ToLower(approval.Token)), []byte(expected)) {
+			return false, fmt.Errorf("%w - token not valid for plan %v of run %v", ErrApprovalDenied, request.PlanHash, request.RunID)
+		}
+		return true, nil
+	}
+	if !approval.Approved {
+		return false, fmt.Errorf("%w - %v", ErrApprovalDenied, approval.Reason)
+	}
+	return true, nil
+}
+
+// Request approval until approved, denied or timeout. Request errors logged and repeated.
+func WaitApproval(mainConfig MainCfgYAML, request ApprovalRequest, logger *zap.Logger) error {
+	timeout, pollInterval, err := ApprovalDurations(mainConfig)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		approved, err := RequestApproval(mainConfig, request)
+		switch {
+		case approved:
+			return nil
+		case errors.Is(err, ErrApprovalDenied):
+			return err
+		case err != nil:
+			logger.Warn(fmt.Sprint("Can't request approval - ", err))
+		}
+		if time.Now().Add(pollInterval).After(deadline) {
+			return fmt.Errorf("update not approved within %v", timeout)
+		}
+		logger.Debug(fmt.Sprintf("Approval pending, next request in %v", pollInterval))
+		time.Sleep(pollInterval)
+	}
+}
+
+// Parse timeout and poll interval of approval from config or return defaults.
+func ApprovalDurations(mainConfig MainCfgYAML) (time.Duration, time.Duration, error) {
+	timeout, pollInterval := ApprovalDefaultTimeout, ApprovalDefaultPollInterval
+	var err error
+	if mainConfig.Approval.Timeout != "" {
+		timeout, err = time.ParseDuration(mainConfig.Approval.Timeout)
+		if err != nil {
+			return 0, 0, fmt.Errorf("can't parse Approval.Timeout - %v", err)
+		}
+	}
+	if mainConfig.Approval.PollInterval != "" {
+		pollInterval, err = time.ParseDuration(mainConfig.Approval.PollInterval)
+		if err != nil {
+			return 0, 0, fmt.Errorf("can't parse Approval.PollInterval - %v", err)
+		}
+	}
+	return timeout, pollInterval, nil
+}
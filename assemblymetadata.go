@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	metadataTableAssembly    int = 0x20 // Assembly table of metadata tables stream.
+	metadataTableAssemblyRef int = 0x23 // AssemblyRef table of metadata tables stream.
+	peDirectoryCLRHeader     int = 14   // Index of CLR runtime header in PE data directories.
+)
+
+var ErrNotManagedAssembly = fmt.Errorf("not a .NET assembly")
+
+// .NET assembly name and version.
+type AssemblyIdentity struct {
+	Name    string
+	Version string // "major.minor.build.revision".
+	Culture string // Empty for neutral culture.
+}
+
+// Identity of .NET assembly and assemblies referenced by it.
+type AssemblyMetadata struct {
+	Identity   AssemblyIdentity
+	References []AssemblyIdentity
+}
+
+// Read identity and AssemblyRef table of .NET assembly file.
+// Return ErrNotManagedAssembly for native PE files.
+func ReadAssemblyMetadata(path string) (AssemblyMetadata, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return AssemblyMetadata{}, err
+	}
+	peFile, err := pe.Open(path)
+	if err != nil {
+		return AssemblyMetadata{}, err
+	}
+	defer peFile.Close()
+	clrHeader, err := readPEDirectory(peFile, info.Size(), peDirectoryCLRHeader)
+	if err != nil {
+		return AssemblyMetadata{}, err
+	}
+	if len(clrHeader) < 16 {
+		return AssemblyMetadata{}, ErrNotManagedAssembly
+	}
+	metadata, err := readPERange(peFile, info.Size(), binary.LittleEndian.Uint32(clrHeader[8:]), binary.LittleEndian.Uint32(clrHeader[12:]))
+	if err != nil {
+		return AssemblyMetadata{}, fmt.Errorf("can't read metadata - %v", err)
+	}
+	return parseAssemblyMetadata(metadata)
+}
+
+// Return names of native DLLs imported by PE file.
+func ReadNativeImports(path string) ([]string, error) {
+	peFile, err := pe.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer peFile.Close()
+	return peFile.ImportedLibraries()
+}
+
+// Read data directory of PE file, empty if directory absent.
+func readPEDirectory(peFile *pe.File, fileSize int64, index int) ([]byte, error) {
+	var directories []pe.DataDirectory
+	switch header := peFile.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		directories = header.DataDirectory[:header.NumberOfRvaAndSizes]
+	case *pe.OptionalHeader64:
+		directories = header.DataDirectory[:header.NumberOfRvaAndSizes]
+	default:
+		return nil, ErrNotManagedAssembly
+	}
+	if index >= len(directories) || directories[index].VirtualAddress == 0 {
+		return nil, nil
+	}
+	return readPERange(peFile, fileSize, directories[index].VirtualAddress, directories[index].Size)
+}
+
+// Read bytes of PE image by relative virtual address. Range must lie in file data of one section,
+// so size taken from untrusted headers never allocates more than file holds.
+func readPERange(peFile *pe.File, fileSize int64, rva, size uint32) ([]byte, error) {
+	for _, section := range peFile.Sections {
+		end := section.VirtualAddress + section.VirtualSize
+		if section.Size > section.VirtualSize {
+			end = section.VirtualAddress + section.Size
+		}
+		if rva < section.VirtualAddress || rva >= end {
+			continue
+		}
+		offset := int64(rva - section.VirtualAddress)
+		if offset+int64(size) > int64(section.Size) || int64(section.Offset)+offset+int64(size) > fileSize {
+			return nil, fmt.Errorf("range 0x%x of %v bytes outside of file data", rva, size)
+		}
+		data := make([]byte, size)
+		_, err := section.ReadAt(data, offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("address 0x%x not in any section", rva)
+}
+
+// Column of metadata table: fixed size, heap index, table index or coded index.
+type metadataColumn struct {
+	size   int   // Fixed size in bytes, 0 for index columns.
+	heap   byte  // Heap of index: 's' strings, 'g' GUID, 'b' blob.
+	tables []int // Tables of simple or coded index.
+	bits   uint  // Tag bits of coded index, 0 for simple table index.
+}
+
+func fixedColumn(size int) metadataColumn { return metadataColumn{size: size} }
+func heapColumn(heap byte) metadataColumn { return metadataColumn{heap: heap} }
+func indexColumn(table int) metadataColumn {
+	return metadataColumn{tables: []int{table}}
+}
+func codedColumn(bits uint, tables ...int) metadataColumn {
+	return metadataColumn{tables: tables, bits: bits}
+}
+
+// Coded index tables, -1 for unused tags (ECMA-335 II.24.2.6).
+var (
+	codedTypeDefOrRef        = codedColumn(2, 0x02, 0x01, 0x1B)
+	codedHasConstant         = codedColumn(2, 0x04, 0x08, 0x17)
+	codedHasCustomAttribute  = codedColumn(5, 0x06, 0x04, 0x01, 0x02, 0x08, 0x09, 0x0A, 0x00, 0x0E, 0x17, 0x14, 0x11, 0x1A, 0x1B, 0x20, 0x23, 0x26, 0x27, 0x28, 0x2A, 0x2C, 0x2B)
+	codedHasFieldMarshal     = codedColumn(1, 0x04, 0x08)
+	codedHasDeclSecurity     = codedColumn(2, 0x02, 0x06, 0x20)
+	codedMemberRefParent     = codedColumn(3, 0x02, 0x01, 0x1A, 0x06, 0x1B)
+	codedHasSemantics        = codedColumn(1, 0x14, 0x17)
+	codedMethodDefOrRef      = codedColumn(1, 0x06, 0x0A)
+	codedMemberForwarded     = codedColumn(1, 0x04, 0x06)
+	codedResolutionScope     = codedColumn(2, 0x00, 0x1A, 0x23, 0x01)
+	codedCustomAttributeType = codedColumn(3, -1, -1, 0x06, 0x0A, -1)
+)
+
+// Columns of metadata tables up to AssemblyRef (ECMA-335 II.22).
+var metadataTableColumns = [][]metadataColumn{
+	0x00: {fixedColumn(2), heapColumn('s'), heapColumn('g'), heapColumn('g'), heapColumn('g')},                        // Module
+	0x01: {codedResolutionScope, heapColumn('s'), heapColumn('s')},                                                    // TypeRef
+	0x02: {fixedColumn(4), heapColumn('s'), heapColumn('s'), codedTypeDefOrRef, indexColumn(0x04), indexColumn(0x06)}, // TypeDef
+	0x03: {indexColumn(0x04)},                                                                                         // FieldPtr
+	0x04: {fixedColumn(2), heapColumn('s'), heapColumn('b')},                                                          // Field
+	0x05: {indexColumn(0x06)},                                                                                         // MethodPtr
+	0x06: {fixedColumn(4), fixedColumn(2), fixedColumn(2), heapColumn('s'), heapColumn('b'), indexColumn(0x08)},       // MethodDef
+	0x07: {indexColumn(0x08)},                                                                                         // ParamPtr
+	0x08: {fixedColumn(2), fixedColumn(2), heapColumn('s')},                                                           // Param
+	0x09: {indexColumn(0x02), codedTypeDefOrRef},                                                                      // InterfaceImpl
+	0x0A: {codedMemberRefParent, heapColumn('s'), heapColumn('b')},                                                    // MemberRef
+	0x0B: {fixedColumn(2), codedHasConstant, heapColumn('b')},                                                         // Constant
+	0x0C: {codedHasCustomAttribute, codedCustomAttributeType, heapColumn('b')},                                        // CustomAttribute
+	0x0D: {codedHasFieldMarshal, heapColumn('b')},                                                                     // FieldMarshal
+	0x0E: {fixedColumn(2), codedHasDeclSecurity, heapColumn('b')},                                                     // DeclSecurity
+	0x0F: {fixedColumn(2), fixedColumn(4), indexColumn(0x02)},                                                         // ClassLayout
+	0x10: {fixedColumn(4), indexColumn(0x04)},                                                                         // FieldLayout
+	0x11: {heapColumn('b')},                                                                                           // StandAloneSig
+	0x12: {indexColumn(0x02), indexColumn(0x14)},                                                                      // EventMap
+	0x13: {indexColumn(0x14)},                                                                                         // EventPtr
+	0x14: {fixedColumn(2), heapColumn('s'), codedTypeDefOrRef},                                                        // Event
+	0x15: {indexColumn(0x02), indexColumn(0x17)},                                                                      // PropertyMap
+	0x16: {indexColumn(0x17)},                                                                                         // PropertyPtr
+	0x17: {fixedColumn(2), heapColumn('s'), heapColumn('b')},                                                          // Property
+	0x18: {fixedColumn(2), indexColumn(0x06), codedHasSemantics},                                                      // MethodSemantics
+	0x19: {indexColumn(0x02), codedMethodDefOrRef, codedMethodDefOrRef},                                               // MethodImpl
+	0x1A: {heapColumn('s')},                                                                                           // ModuleRef
+	0x1B: {heapColumn('b')},                                                                                           // TypeSpec
+	0x1C: {fixedColumn(2), codedMemberForwarded, heapColumn('s'), indexColumn(0x1A)},                                  // ImplMap
+	0x1D: {fixedColumn(4), indexColumn(0x04)},                                                                         // FieldRVA
+	0x1E: {fixedColumn(4), fixedColumn(4)},                                                                            // EncLog
+	0x1F: {fixedColumn(4)},                                                                                            // EncMap
+	0x20: {fixedColumn(4), fixedColumn(8), fixedColumn(4), heapColumn('b'), heapColumn('s'), heapColumn('s')},         // Assembly
+	0x21: {fixedColumn(4)},                                                                                            // AssemblyProcessor
+	0x22: {fixedColumn(12)},                                                                                           // AssemblyOS
+	0x23: {fixedColumn(8), fixedColumn(4), heapColumn('b'), heapColumn('s'), heapColumn('s'), heapColumn('b')},        // AssemblyRef
+}
+
+// Parse metadata root, find tables and strings streams and read Assembly and AssemblyRef rows.
+func parseAssemblyMetadata(metadata []byte) (AssemblyMetadata, error) {
+	if len(metadata) < 16 || binary.LittleEndian.Uint32(metadata) != 0x424A5342 {
+		return AssemblyMetadata{}, fmt.Errorf("invalid metadata signature")
+	}
+	versionLength := int(binary.LittleEndian.Uint32(metadata[12:]))
+	position := 16 + versionLength + 2
+	if position+2 > len(metadata) {
+		return AssemblyMetadata{}, fmt.Errorf("truncated metadata header")
+	}
+	streamCount := int(binary.LittleEndian.Uint16(metadata[position:]))
+	position += 2
+	var tables, stringsHeap []byte
+	for i := 0; i < streamCount; i++ {
+		if position+8 > len(metadata) {
+			return AssemblyMetadata{}, fmt.Errorf("truncated stream header")
+		}
+		offset := int(binary.LittleEndian.Uint32(metadata[position:]))
+		size := int(binary.LittleEndian.Uint32(metadata[position+4:]))
+		nameEnd := bytes.IndexByte(metadata[position+8:], 0)
+		if nameEnd < 0 || offset+size > len(metadata) {
+			return AssemblyMetadata{}, fmt.Errorf("invalid stream header")
+		}
+		name := string(metadata[position+8 : position+8+nameEnd])
+		position += 8 + (nameEnd+4)&^3
+		switch name {
+		case "#~":
+			tables = metadata[offset : offset+size]
+		case "#Strings":
+			stringsHeap = metadata[offset : offset+size]
+		}
+	}
+	if tables == nil || stringsHeap == nil {
+		return AssemblyMetadata{}, fmt.Errorf("metadata tables not found")
+	}
+	return parseMetadataTables(tables, stringsHeap)
+}
+
+// Read Assembly and AssemblyRef rows from "#~" stream.
+func parseMetadataTables(tables, stringsHeap []byte) (AssemblyMetadata, error) {
+	if len(tables) < 24 {
+		return AssemblyMetadata{}, fmt.Errorf("truncated metadata tables")
+	}
+	heapSizes := tables[6]
+	valid := binary.LittleEndian.Uint64(tables[8:])
+	position := 24
+	rows := make([]int, 64)
+	for table := 0; table < 64; table++ {
+		if valid&(1<<uint(table)) == 0 {
+			continue
+		}
+		if position+4 > len(tables) {
+			return AssemblyMetadata{}, fmt.Errorf("truncated metadata row counts")
+		}
+		rows[table] = int(binary.LittleEndian.Uint32(tables[position:]))
+		position += 4
+	}
+	if heapSizes&0x40 != 0 {
+		position += 4
+	}
+	heapIndexSize := map[byte]int{'s': 2, 'g': 2, 'b': 2}
+	for bit, heap := range map[byte]byte{0x01: 's', 0x02: 'g', 0x04: 'b'} {
+		if heapSizes&bit != 0 {
+			heapIndexSize[heap] = 4
+		}
+	}
+	columnSize := func(column metadataColumn) int {
+		switch {
+		case column.size > 0:
+			return column.size
+		case column.heap != 0:
+			return heapIndexSize[column.heap]
+		}
+		maxRows := 0
+		for _, table := range column.tables {
+			if table >= 0 && rows[table] > maxRows {
+				maxRows = rows[table]
+			}
+		}
+		if maxRows < 1<<(16-column.bits) {
+			return 2
+		}
+		return 4
+	}
+	readIndex := func(data []byte, size int) int {
+		if size == 2 {
+			return int(binary.LittleEndian.Uint16(data))
+		}
+		return int(binary.LittleEndian.Uint32(data))
+	}
+	readString := func(offset int) string {
+		if offset >= len(stringsHeap) {
+			return ""
+		}
+		end := bytes.IndexByte(stringsHeap[offset:], 0)
+		if end < 0 {
+			return string(stringsHeap[offset:])
+		}
+		return string(stringsHeap[offset : offset+end])
+	}
+	// Read identity from row: version at versionOffset, name and culture are strings of columns nameColumn and nameColumn+1.
+	readIdentity := func(row []byte, columns []metadataColumn, versionOffset, nameColumn int) AssemblyIdentity {
+		offset := 0
+		var identity AssemblyIdentity
+		for id, column := range columns {
+			size := columnSize(column)
+			switch id {
+			case nameColumn:
+				identity.Name = readString(readIndex(row[offset:], size))
+			case nameColumn + 1:
+				identity.Culture = readString(readIndex(row[offset:], size))
+			}
+			offset += size
+		}
+		identity.Version = fmt.Sprintf("%d.%d.%d.%d",
+			binary.LittleEndian.Uint16(row[versionOffset:]), binary.LittleEndian.Uint16(row[versionOffset+2:]),
+			binary.LittleEndian.Uint16(row[versionOffset+4:]), binary.LittleEndian.Uint16(row[versionOffset+6:]))
+		return identity
+	}
+
+	var result AssemblyMetadata
+	for table, columns := range metadataTableColumns {
+		rowSize := 0
+		for _, column := range columns {
+			rowSize += columnSize(column)
+		}
+		tableSize := rowSize * rows[table]
+		if position+tableSize > len(tables) {
+			return AssemblyMetadata{}, fmt.Errorf("truncated metadata table 0x%02x", table)
+		}
+		for row := 0; row < rows[table]; row++ {
+			rowData := tables[position+row*rowSize : position+(row+1)*rowSize]
+			switch table {
+			case metadataTableAssembly:
+				result.Identity = readIdentity(rowData, columns, 4, 4)
+			case metadataTableAssemblyRef:
+				result.References = append(result.References, readIdentity(rowData, columns, 0, 3))
+			}
+		}
+		position += tableSize
+	}
+	if result.Identity.Name == "" {
+		return AssemblyMetadata{}, ErrNotManagedAssembly
+	}
+	return result, nil
+}
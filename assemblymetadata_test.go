@@ -0,0 +1,115 @@
+package main
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const fixtureAssembly string = "testdata/Company.Wde.Fixture.dll" // Managed assembly built from testdata/Fixture.cs.
+
+// Layout of fixture assembly: file content, file offsets of CLR data directory, CLR header and end of metadata.
+type fixtureLayout struct {
+	data              []byte
+	directoryOffset   int
+	clrHeaderOffset   int
+	metadataEndOffset int
+}
+
+// Read fixture assembly and locate its CLR header and metadata.
+func readFixtureLayout(t *testing.T) fixtureLayout {
+	t.Helper()
+	data, err := os.ReadFile(fixtureAssembly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peFile, err := pe.Open(fixtureAssembly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peFile.Close()
+	fileOffset := func(rva uint32) int {
+		for _, section := range peFile.Sections {
+			if rva >= section.VirtualAddress && rva < section.VirtualAddress+section.Size {
+				return int(section.Offset + rva - section.VirtualAddress)
+			}
+		}
+		t.Fatalf("RVA 0x%x of fixture not in file data", rva)
+		return 0
+	}
+	layout := fixtureLayout{data: data}
+	// Data directories follow 96 bytes of PE32 optional header.
+	layout.directoryOffset = int(binary.LittleEndian.Uint32(data[0x3C:])) + 4 + 20 + 96 + peDirectoryCLRHeader*8
+	layout.clrHeaderOffset = fileOffset(binary.LittleEndian.Uint32(data[layout.directoryOffset:]))
+	metadataRVA := binary.LittleEndian.Uint32(data[layout.clrHeaderOffset+8:])
+	layout.metadataEndOffset = fileOffset(metadataRVA) + int(binary.LittleEndian.Uint32(data[layout.clrHeaderOffset+12:]))
+	return layout
+}
+
+// Write data into temporary file and return its path.
+func writeFixture(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), filepath.Base(fixtureAssembly))
+	err := os.WriteFile(path, data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadAssemblyMetadata(t *testing.T) {
+	metadata, err := ReadAssemblyMetadata(fixtureAssembly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AssemblyIdentity{Name: "Company.Wde.Fixture", Version: "1.2.3.4"}
+	if metadata.Identity != want {
+		t.Errorf("identity %+v, want %+v", metadata.Identity, want)
+	}
+	reference := AssemblyIdentity{Name: "netstandard", Version: "2.1.0.0"}
+	if len(metadata.References) != 1 || metadata.References[0] != reference {
+		t.Errorf("references %+v, want [%+v]", metadata.References, reference)
+	}
+}
+
+func TestReadAssemblyMetadataNative(t *testing.T) {
+	// PE file without CLR data directory is native.
+	layout := readFixtureLayout(t)
+	copy(layout.data[layout.directoryOffset:], make([]byte, 8))
+	_, err := ReadAssemblyMetadata(writeFixture(t, layout.data))
+	if err != ErrNotManagedAssembly {
+		t.Errorf("error %v, want %v", err, ErrNotManagedAssembly)
+	}
+}
+
+func TestReadAssemblyMetadataOversizedMetadata(t *testing.T) {
+	// Metadata size larger than file fails before allocation.
+	layout := readFixtureLayout(t)
+	binary.LittleEndian.PutUint32(layout.data[layout.clrHeaderOffset+12:], 0xFFFFFFF0)
+	_, err := ReadAssemblyMetadata(writeFixture(t, layout.data))
+	if err == nil {
+		t.Error("oversized metadata read without error")
+	}
+}
+
+func TestReadAssemblyMetadataTruncated(t *testing.T) {
+	layout := readFixtureLayout(t)
+	for size := 0; size < layout.metadataEndOffset; size += 61 {
+		_, err := ReadAssemblyMetadata(writeFixture(t, layout.data[:size]))
+		if err == nil {
+			t.Errorf("fixture truncated to %v bytes read without error", size)
+		}
+	}
+}
+
+func TestReadAssemblyMetadataCorrupted(t *testing.T) {
+	// Any damaged byte gives result or error, never panic.
+	layout := readFixtureLayout(t)
+	for offset := range layout.data {
+		data := append([]byte{}, layout.data...)
+		data[offset] ^= 0xFF
+		ReadAssemblyMetadata(writeFixture(t, data))
+	}
+}
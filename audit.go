@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const AuditFileName string = "WDE_Audit.jsonl" // Append-only audit log, never rotated.
+
+// Mutating operations recorded in audit log.
+const (
+	AuditFileCopied         string = "file-copied"            // Customisation file copied into WDE folder.
+	AuditFileDeleted        string = "file-deleted"           // File removed from WDE folder.
+	AuditDirectoryDeleted   string = "directory-deleted"      // Directory left empty by removed files deleted from WDE folder.
+	AuditFileBackedUp       string = "file-backed-up"         // Backup file created before change, e.g. .reg export.
+	AuditRegistryWritten    string = "registry-value-written" // Registry value written.
+	AuditRegistryRolledBack string = "registry-rolled-back"   // Failed registry write rolled back.
+)
+
+// One line of audit log.
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	RunID      string    `json:"runId"` // Start time string of run, as in log and history file names.
+	Host       string    `json:"host"`
+	Operation  string    `json:"operation"`
+	Target     string    `json:"target"`               // File path or registry value name.
+	BeforeHash string    `json:"beforeHash,omitempty"` // SHA-256 of file or value data before change, empty if not existed.
+	AfterHash  string    `json:"afterHash,omitempty"`  // SHA-256 after change, empty if removed.
+	Detail     string    `json:"detail,omitempty"`
+	Tag        string    `json:"tag,omitempty"`    // Run tag.
+	Ticket     string    `json:"ticket,omitempty"` // Change ticket which authorized run.
+}
+
+// Append-only JSONL audit log of one run, separate from operational log.
+// Methods of nil log do nothing, so disabled audit not checked by callers.
+type AuditLog struct {
+	mutex  sync.Mutex
+	path   string
+	runID  string
+	host   string
+	labels RunLabels
+	logger *zap.Logger
+}
+
+// Get audit folder from config or default one, relative folders inside workspace.
+func AuditFolderPath(mainConfig MainCfgYAML, programDirectory string) string {
+	return WorkspaceArtifactPath(mainConfig, programDirectory, mainConfig.Audit.Folder, "Audit")
+}
+
+// Return audit log of run or nil if audit disabled.
+func NewAuditLog(mainConfig MainCfgYAML, programDirectory, runID string, labels RunLabels, logger *zap.Logger) *AuditLog {
+	if mainConfig.Audit.Disabled {
+		return nil
+	}
+	host, _ := os.Hostname()
+	return &AuditLog{
+		path:   filepath.Join(AuditFolderPath(mainConfig, programDirectory), AuditFileName),
+		runID:  runID,
+		host:   host,
+		labels: labels,
+		logger: logger,
+	}
+}
+
+// Append record of operation. Write failure logged, operation itself not affected.
+func (al *AuditLog) Record(operation, target, beforeHash, afterHash, detail string) {
+	if al == nil {
+		return
+	}
+	line, err := json.Marshal(AuditRecord{
+		Time:       TimestampNow(),
+		RunID:      al.runID,
+		Host:       al.host,
+		Operation:  operation,
+		Target:     target,
+		BeforeHash: beforeHash,
+		AfterHash:  afterHash,
+		Detail:     detail,
+		Tag:        al.labels.Tag,
+		Ticket:     al.labels.Ticket,
+	})
+	if err == nil {
+		err = al.append(append(line, '\n'))
+	}
+	if err != nil {
+		al.logger.Warn(fmt.Sprintf("Can't write audit record '%v' of '%v' - %v", operation, target, err))
+	}
+}
+
+// Append line into audit file, file and folder created if not exist.
+func (al *AuditLog) append(line []byte) error {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+	err := os.MkdirAll(filepath.Dir(al.path), 0755)
+	if err != nil {
+		return err
+	}
+	auditFile, err := os.OpenFile(al.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = auditFile.Write(line)
+	if closeErr := auditFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Return hash of existing file for audit, empty if audit disabled or file not exist.
+func (al *AuditLog) FileHash(path string) string {
+	if al == nil {
+		return ""
+	}
+	hash, _ := HashFile(path)
+	return hash
+}
+
+// Calculate SHA-256 of registry value data in hex.
+func HashRegistryData(data string) string {
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:])
+}
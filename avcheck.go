@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultAVRecheckDelay time.Duration = 3 * time.Second // Delay before copied files checked again.
+	avSlowCopyBase        time.Duration = 2 * time.Second // Copy longer than base plus time at avSlowCopyMBps considered slow.
+	avSlowCopyMBps        int64         = 5               // Expected minimal copy speed of local disk.
+	avSlowCopyMaxShare    int           = 4               // Slow copies only reported if at least 1/share of copied files affected.
+)
+
+// Reasons of possible antivirus interference.
+const (
+	AVReasonVanished string = "vanished" // Copied file removed shortly after copy, e.g. by ASR rule or quarantine.
+	AVReasonChanged  string = "changed"  // Copied file content changed after copy, e.g. cleaned or truncated.
+	AVReasonSlow     string = "slow"     // Copy much slower than expected, e.g. on-access scan.
+)
+
+// Actions on possible antivirus interference for Copy.OnAVInterference option.
+const (
+	AVInterferenceWarn string = "warn" // Suspects only reported, default.
+	AVInterferenceFail string = "fail" // Vanished and changed files treated as copy errors.
+)
+
+// Copied file with signs of antivirus interference.
+type AVSuspect struct {
+	Path         string
+	Reason       string
+	CopyDuration time.Duration
+}
+
+// Return delay of copied files re-check from config, 0 if re-check disabled.
+// Action on found interference checked too.
+func AVRecheckDelay(mainConfig MainCfgYAML) (time.Duration, error) {
+	switch strings.ToLower(mainConfig.Copy.OnAVInterference) {
+	case "", AVInterferenceWarn, AVInterferenceFail:
+	default:
+		return 0, fmt.Errorf("unknown Copy.OnAVInterference '%v'", mainConfig.Copy.OnAVInterference)
+	}
+	if mainConfig.Copy.AVRecheckDelay == "" {
+		return DefaultAVRecheckDelay, nil
+	}
+	delay, err := time.ParseDuration(mainConfig.Copy.AVRecheckDelay)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("invalid Copy.AVRecheckDelay '%v'", mainConfig.Copy.AVRecheckDelay)
+	}
+	return delay, nil
+}
+
+// Wait delay and check that copied files still exist with the same content.
+// Slow copies reported only if many files affected, single slow file usually just large or locked.
+func RecheckCopiedFiles(files []CustomisationFile, targetDirectory string, delay time.Duration) []AVSuspect {
+	suspects := make([]AVSuspect, 0)
+	slow := make([]AVSuspect, 0)
+	copied := 0
+	time.Sleep(delay)
+	for _, file := range files {
+		if file.CopyStatus != StatusCopied {
+			continue
+		}
+		copied++
+		targetFile := filepath.Join(targetDirectory, file.RelativePath, file.FileName)
+		if time.Duration(file.Size/(avSlowCopyMBps*1024*1024))*time.Second+avSlowCopyBase < file.CopyDuration {
+			slow = append(slow, AVSuspect{Path: targetFile, Reason: AVReasonSlow, CopyDuration: file.CopyDuration})
+		}
+		info, err := os.Stat(targetFile)
+		if os.IsNotExist(err) {
+			suspects = append(suspects, AVSuspect{Path: targetFile, Reason: AVReasonVanished, CopyDuration: file.CopyDuration})
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		changed := info.Size() != file.Size
+		if !changed && file.Hash != "" {
+			hash, err := HashFile(targetFile)
+			changed = err == nil && hash != file.Hash
+		}
+		if changed {
+			suspects = append(suspects, AVSuspect{Path: targetFile, Reason: AVReasonChanged, CopyDuration: file.CopyDuration})
+		}
+	}
+	if len(slow) > 0 && len(slow)*avSlowCopyMaxShare >= copied {
+		suspects = append(suspects, slow...)
+	}
+	return suspects
+}
+
+// Log structured warning for every suspect file.
+func LogAVSuspects(suspects []AVSuspect, events *HistoryEvents, logger *zap.Logger) {
+	for _, suspect := range suspects {
+		logger.Warn("Possible AV interference",
+			zap.String("file", suspect.Path),
+			zap.String("reason", suspect.Reason),
+			zap.Duration("copyDuration", suspect.CopyDuration),
+		)
+		events.Add("Possible AV interference: '%v' %v after copy (copy took %v)", suspect.Path, suspect.Reason, suspect.CopyDuration)
+	}
+	if len(suspects) > 0 {
+		logger.Warn("Check antivirus quarantine and ASR rules events, add WDE folder or signed customisation files to exclusions")
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	BackupFolder      string = "Backup"         // Default folder of file backup sets in workspace.
+	BackupSetFileName string = "BackupSet.json" // Description of backup set in its folder.
+	BackupFilesFolder string = "Files"          // Subfolder of backup set with saved WDE files.
+	BackupStateFile   string = "State.json"     // Deployed state before run saved in backup set.
+	BackupDefaultKeep int    = 5                // Backup sets preserved by default.
+)
+
+// WDE files replaced or removed by one run, saved before change for "rollback" command.
+type BackupSet struct {
+	ID               string          `json:"id"` // Start time string of run, also name of set folder.
+	CreatedTime      time.Time       `json:"createdTime"`
+	WDEFolder        string          `json:"wdeFolder"`
+	RegistrySnapshot string          `json:"registrySnapshot,omitempty"` // Snapshot used by run as previous registry data.
+	Files            []BackupSetFile `json:"files"`
+}
+
+// File of backup set. File not existed before run removed by rollback.
+type BackupSetFile struct {
+	RelativePath string `json:"relativePath"`
+	Existed      bool   `json:"existed"`
+	Hash         string `json:"hash,omitempty"` // SHA-256 of saved file.
+}
+
+// Get folder of backup sets from config.
+func BackupFolderPath(mainConfig MainCfgYAML, programDirectory string) string {
+	return WorkspaceArtifactPath(mainConfig, programDirectory, mainConfig.Backup.Folder, BackupFolder)
+}
+
+// Save WDE files which run would replace or remove into new backup set.
+// Files to deploy already identical in WDE folder not saved, missing ones recorded to be removed by rollback.
+// Deployed state before run saved too, set linked to registry snapshot used by run.
+func CreateBackupSet(backupFolder, id, targetDirectory, registrySnapshot string, files []CustomisationFile, orphans []DeployedStateFile, previousState DeployedState) (BackupSet, error) {
+	set := BackupSet{ID: id, CreatedTime: TimestampNow(), WDEFolder: targetDirectory, RegistrySnapshot: registrySnapshot, Files: make([]BackupSetFile, 0, len(files))}
+	setFolder := filepath.Join(backupFolder, id)
+	backup := func(relativePath string, file *CustomisationFile) error {
+		targetFile := filepath.Join(targetDirectory, relativePath)
+		if _, err := os.Stat(targetFile); os.IsNotExist(err) {
+			if file != nil {
+				set.Files = append(set.Files, BackupSetFile{RelativePath: relativePath})
+			}
+			return nil
+		}
+		if file != nil && IsIdenticalFile(targetFile, *file) {
+			return nil
+		}
+		backupFile := filepath.Join(setFolder, BackupFilesFolder, relativePath)
+		err := os.MkdirAll(filepath.Dir(backupFile), 0755)
+		if err != nil {
+			return err
+		}
+		_, err = copyFile(targetFile, backupFile)
+		if err != nil {
+			return fmt.Errorf("can't back up '%v' - %v", targetFile, err)
+		}
+		hash, err := HashFile(backupFile)
+		if err != nil {
+			return err
+		}
+		set.Files = append(set.Files, BackupSetFile{RelativePath: relativePath, Existed: true, Hash: hash})
+		return nil
+	}
+	for id := range files {
+		err := backup(filepath.Join(files[id].RelativePath, files[id].FileName), &files[id])
+		if err != nil {
+			return set, err
+		}
+	}
+	for _, orphan := range orphans {
+		err := backup(filepath.Join(orphan.RelativePath, orphan.FileName), nil)
+		if err != nil {
+			return set, err
+		}
+	}
+	err := previousState.Save(filepath.Join(setFolder, BackupStateFile))
+	if err != nil {
+		return set, err
+	}
+	setBytes, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return set, err
+	}
+	return set, SaveBytesIntoFile(filepath.Join(setFolder, BackupSetFileName), setBytes)
+}
+
+// List backup sets ordered by creation time, latest at the end. Folders without valid description skipped.
+func ListBackupSets(backupFolder string) ([]BackupSet, error) {
+	dirContent, err := ioutil.ReadDir(backupFolder)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]BackupSet, 0, len(dirContent))
+	for _, entry := range dirContent {
+		if !entry.IsDir() {
+			continue
+		}
+		setBytes, err := ioutil.ReadFile(filepath.Join(backupFolder, entry.Name(), BackupSetFileName))
+		if err != nil {
+			continue
+		}
+		var set BackupSet
+		if json.Unmarshal(setBytes, &set) != nil || set.ID != entry.Name() {
+			continue
+		}
+		sets = append(sets, set)
+	}
+	sort.SliceStable(sets, func(i, j int) bool { return sets[i].CreatedTime.Before(sets[j].CreatedTime) })
+	return sets, nil
+}
+
+// Remove oldest backup sets, keep provided number of latest.
+func ClearOldBackupSets(backupFolder string, keep int) error {
+	sets, err := ListBackupSets(backupFolder)
+	if err != nil {
+		return err
+	}
+	for len(sets) > keep {
+		err = os.RemoveAll(filepath.Join(backupFolder, sets[0].ID))
+		if err != nil {
+			return err
+		}
+		sets = sets[1:]
+	}
+	return nil
+}
+
+// Find backup set by ID or "last".
+func FindBackupSet(sets []BackupSet, id string) (BackupSet, error) {
+	if id == "last" && len(sets) > 0 {
+		return sets[len(sets)-1], nil
+	}
+	for _, set := range sets {
+		if set.ID == id {
+			return set, nil
+		}
+	}
+	return BackupSet{}, fmt.Errorf("backup set '%v' not found", id)
+}
+
+// Restore WDE folder to state before run of backup set: saved files copied back,
+// files not existed before run removed. Failed files reported, the rest restored anyway.
+// Return restored and removed files. Changes recorded in audit log.
+func RestoreBackupSet(set BackupSet, backupFolder string, audit *AuditLog, logger *zap.Logger) ([]BackupSetFile, []BackupSetFile, error) {
+	restored := make([]BackupSetFile, 0, len(set.Files))
+	removed := make([]BackupSetFile, 0)
+	failed := 0
+	for _, file := range set.Files {
+		targetFile := filepath.Join(set.WDEFolder, file.RelativePath)
+		beforeHash := audit.FileHash(targetFile)
+		if !file.Existed {
+			err := os.Remove(targetFile)
+			switch {
+			case err == nil:
+				removed = append(removed, file)
+				audit.Record(AuditFileDeleted, targetFile, beforeHash, "", fmt.Sprint("rollback to backup set ", set.ID))
+			case !os.IsNotExist(err):
+				failed++
+				logger.Error(fmt.Sprintf("Can't remove '%v' - %v", targetFile, err))
+			}
+			continue
+		}
+		backupFile := filepath.Join(backupFolder, set.ID, BackupFilesFolder, file.RelativePath)
+		hash, err := HashFile(backupFile)
+		if err == nil && hash != file.Hash {
+			err = fmt.Errorf("backup file changed, hash %v expected", file.Hash)
+		}
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(targetFile), 0755)
+		}
+		if err == nil {
+			_, err = copyFile(backupFile, targetFile)
+		}
+		if err != nil {
+			failed++
+			logger.Error(fmt.Sprintf("Can't restore '%v' - %v", targetFile, err))
+			continue
+		}
+		restored = append(restored, file)
+		audit.Record(AuditFileCopied, targetFile, beforeHash, file.Hash, fmt.Sprint("rollback from backup set ", set.ID))
+	}
+	if failed > 0 {
+		return restored, removed, fmt.Errorf("%v files of backup set '%v' not restored", failed, set.ID)
+	}
+	return restored, removed, nil
+}
+
+// Replace deployed state with state saved in backup set before its run.
+// Sets saved by earlier versions have no state, current state kept then.
+func restoreBackupState(set BackupSet, backupFolder, stateFileFullPath string) error {
+	savedFullPath := filepath.Join(backupFolder, set.ID, BackupStateFile)
+	if _, err := os.Stat(savedFullPath); os.IsNotExist(err) {
+		return fmt.Errorf("no deployed state in backup set '%v', current state kept", set.ID)
+	}
+	savedState, err := ReadDeployedState(savedFullPath)
+	if err != nil {
+		return err
+	}
+	return savedState.Save(stateFileFullPath)
+}
+
+// Run "rollback" subcommand.
+// Usage: rollback list | rollback [-registry <snapshot>] [-files <backup set>]
+// Registry snapshot written into live DM registry and used by next run, backup set restored into WDE folder
+// with services stopped. Backup set restores registry snapshot it linked to and deployed state before its run.
+func RunRollbackCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	usage := fmt.Errorf("usage: rollback list | rollback [-registry <snapshot>] [-files <backup set>|last]")
+	backupFolder := BackupFolderPath(mainConfig, programDirectory)
+	sets, err := ListBackupSets(backupFolder)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 && args[0] == "list" {
+		for _, set := range sets {
+			existed := 0
+			for _, file := range set.Files {
+				if file.Existed {
+					existed++
+				}
+			}
+			fmt.Printf("%v\t%v files restored, %v files removed by rollback\t%v\tregistry snapshot '%v'\n", set.ID, existed, len(set.Files)-existed, set.WDEFolder, set.RegistrySnapshot)
+		}
+		fmt.Println("Registry snapshots listed by \"registry snapshots list\" command")
+		return nil
+	}
+	flags := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	snapshotID := flags.String("registry", "", "registry snapshot written into DM registry")
+	setID := flags.String("files", "", "backup set restored into WDE folder")
+	err = flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 || (*snapshotID == "" && *setID == "") {
+		return usage
+	}
+	// Both found before anything changed. Backup set and registry snapshot it linked to
+	// restored only together, so WDE folder and "CustomFiles" not out of sync.
+	var set BackupSet
+	if *setID != "" {
+		set, err = FindBackupSet(sets, *setID)
+		if err != nil {
+			return err
+		}
+		if *snapshotID == "" {
+			*snapshotID = set.RegistrySnapshot
+		}
+	}
+	savedRegistryDir := SavedRegistryFolderPath(mainConfig, programDirectory)
+	var snapshot RegistrySnapshot
+	if *snapshotID != "" {
+		snapshots, err := ListRegistrySnapshots(savedRegistryDir)
+		if err != nil {
+			return err
+		}
+		snapshot, err = FindRegistrySnapshot(snapshots, *snapshotID)
+		if err != nil {
+			return err
+		}
+	}
+	if *setID != "" && set.RegistrySnapshot != "" && set.RegistrySnapshot != snapshot.ID {
+		return fmt.Errorf("backup set '%v' linked to registry snapshot '%v', restore them together", set.ID, set.RegistrySnapshot)
+	}
+	if *setID == "" {
+		for _, linked := range sets {
+			if linked.RegistrySnapshot == snapshot.ID {
+				return fmt.Errorf("registry snapshot '%v' linked to backup set '%v', add -files %v to restore them together", snapshot.ID, linked.ID, linked.ID)
+			}
+		}
+	}
+
+	timeString := FileTimestamp(TimestampNow())
+	logFullPath := filepath.Join(
+		LogFolderPath(mainConfig, programDirectory),
+		fmt.Sprint(LogFilePrefix(mainConfig), timeString, ".log"),
+	)
+	logger := NewZapSimpleLoggerWithRotation(mainConfig.Log.Verbose, logFullPath, 10, 1)
+	defer logger.Sync()
+	logger.Info(fmt.Sprintf("Rollback to registry snapshot '%v' and backup set '%v' started", *snapshotID, *setID))
+	release, err := AcquireRunLock(mainConfig, programDirectory, logger)
+	if err != nil {
+		return err
+	}
+	defer release()
+	audit := NewAuditLog(mainConfig, programDirectory, timeString, CurrentRunLabels(), logger)
+	events := make(HistoryEvents, 0, 8)
+	// Loaded DLLs can't be replaced while WDE services run.
+	startServices, err := StopConfiguredServices(mainConfig, &events, logger)
+	defer startServices()
+	if err != nil {
+		logger.Error(fmt.Sprint("Can't stop services, nothing changed - ", err))
+		return err
+	}
+	if *setID != "" {
+		restored, removed, err := RestoreBackupSet(set, backupFolder, audit, logger)
+		logger.Info(fmt.Sprintf("Backup set '%v': %v files restored, %v files removed in '%v'", set.ID, len(restored), len(removed), set.WDEFolder))
+		fmt.Printf("Backup set '%v': %v files restored, %v files removed in '%v'\n", set.ID, len(restored), len(removed), set.WDEFolder)
+		if mainConfig.State.RemoveEmptyDirectories {
+			removedFiles := make([]string, 0, len(removed))
+			for _, file := range removed {
+				removedFiles = append(removedFiles, filepath.Join(set.WDEFolder, file.RelativePath))
+			}
+			emptied := RemoveEmptyDirectories(removedFiles, set.WDEFolder, nil, audit, &events, logger)
+			logger.Info(fmt.Sprintf("%v empty directories removed from WDE folder", len(emptied)))
+		}
+		provenanceFullPath := ProvenanceFilePath(mainConfig, programDirectory)
+		db, dbErr := ReadProvenance(provenanceFullPath)
+		if dbErr == nil {
+			db.RecordRollback(set, restored, removed, timeString, CurrentRunLabels(), TimestampNow())
+			dbErr = db.Save(provenanceFullPath)
+		}
+		if dbErr != nil {
+			logger.Error(fmt.Sprint("Can't record provenance of rollback - ", dbErr))
+		}
+		// Deployed state before run restored, so next run finds orphans as before it.
+		stateErr := restoreBackupState(set, backupFolder, filepath.Join(StateFolderPath(mainConfig, programDirectory), StateFileName))
+		if stateErr != nil {
+			logger.Error(fmt.Sprint("Can't restore deployed state of backup set - ", stateErr))
+		}
+		if err == nil {
+			summaryErr := MarkRunRolledBack(filepath.Join(HistoryFolderPath(mainConfig, programDirectory), fmt.Sprint(SummaryFileName, set.ID, ".json")))
+			if summaryErr != nil {
+				logger.Warn(fmt.Sprint("Can't mark summary of rolled back run - ", summaryErr))
+			}
+		}
+		if err != nil {
+			logger.Error(fmt.Sprint("Rollback of files failed - ", err))
+			return err
+		}
+	}
+	if *snapshotID != "" {
+		err = RestoreRegistrySnapshot(snapshot, mainConfig, programDirectory, true, audit)
+		if err != nil {
+			logger.Error(fmt.Sprint("Rollback of registry failed - ", err))
+			return err
+		}
+		logger.Info(fmt.Sprintf("Registry snapshot '%v' written into '%v'", snapshot.ID, DMRegistryDir))
+	}
+	logger.Info("Rollback finished")
+	fmt.Println("Rollback finished, run WDE Deployment Manager to publish restored customisation")
+	return nil
+}
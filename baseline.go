@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Refresh of registry baseline. Saved registry data replaced by live registry data
+// every Registry.RefreshEveryRuns runs or Registry.RefreshEveryDays days,
+// so changes made in DM GUI picked up instead of overwritten by stale saved data.
+type RegistryBaseline struct {
+	RefreshTime time.Time `json:"refreshTime"` // Time of last baseline read from live registry, zero if never.
+	Runs        int       `json:"runs"`        // Runs with saved data since last refresh.
+}
+
+// Read baseline state from file. Return empty state if file not exists.
+func ReadRegistryBaseline(baselineFileFullPath string) (RegistryBaseline, error) {
+	baseline := RegistryBaseline{}
+	baselineBytes, err := ioutil.ReadFile(baselineFileFullPath)
+	if os.IsNotExist(err) {
+		return baseline, nil
+	}
+	if err != nil {
+		return baseline, err
+	}
+	err = json.Unmarshal(baselineBytes, &baseline)
+	return baseline, err
+}
+
+// Save baseline state into file.
+func (rb RegistryBaseline) Save(baselineFileFullPath string) error {
+	baselineBytes, err := json.MarshalIndent(rb, "", "  ")
+	if err != nil {
+		return err
+	}
+	return SaveBytesIntoFile(baselineFileFullPath, baselineBytes)
+}
+
+// Check if baseline must be refreshed from live registry. Zero limits disable refresh by that limit.
+// Without known refresh time days limit counted from first run with policy.
+func (rb RegistryBaseline) Due(now time.Time, everyRuns, everyDays int) bool {
+	if everyRuns > 0 && rb.Runs >= everyRuns {
+		return true
+	}
+	if everyDays > 0 && !rb.RefreshTime.IsZero() && now.Sub(rb.RefreshTime) >= time.Duration(everyDays)*24*time.Hour {
+		return true
+	}
+	return false
+}
+
+// Save registry baseline state, failure only logged.
+func saveRegistryBaseline(baseline RegistryBaseline, baselineFileFullPath string, logger *zap.Logger) {
+	err := baseline.Save(baselineFileFullPath)
+	if err != nil {
+		logger.Error(fmt.Sprint("Can't save registry baseline state - ", err))
+	}
+}
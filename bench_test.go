@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	benchFiles          int = 10000 // Synthetic files in benchmark set.
+	benchFilesPerFolder int = 250   // Synthetic files in one customisation folder.
+	benchDuplicateEvery int = 10    // Every N-th synthetic file also present in previous folder.
+)
+
+// Generate synthetic collected files with duplicates between neighbour folders.
+func generateBenchFiles(count int) []CustomisationFile {
+	files := make([]CustomisationFile, 0, count)
+	for id := 0; len(files) < count; id++ {
+		folder := filepath.Join(`\\fileserver\WDE`, fmt.Sprintf("%04d_Customisation", id/benchFilesPerFolder))
+		name := fmt.Sprintf("Company.Wde.Module%05d.dll", id)
+		if id%benchDuplicateEvery == 0 && id >= benchFilesPerFolder {
+			name = fmt.Sprintf("Company.Wde.Module%05d.dll", id-benchFilesPerFolder) // Newer build of file from previous folder.
+		}
+		relativePath := ""
+		if id%3 == 0 {
+			relativePath = "Languages"
+		}
+		version, _ := ParseFileVersion(fmt.Sprintf("8.5.%d.%d", id/benchFilesPerFolder+1, id%100))
+		files = append(files, CustomisationFile{
+			FileName:            name,
+			RelativePath:        relativePath,
+			DataFile:            "false",
+			EntryPoint:          "false",
+			IsMainConfigFile:    "false",
+			Optional:            "false",
+			SourcePath:          filepath.Join(folder, relativePath, name),
+			CustomisationFolder: folder,
+			LastWriteTime:       time.Date(2024, 1, 1, 0, 0, id, 0, time.UTC),
+			Size:                int64(1024 + id),
+			Version:             version,
+			Hash:                fmt.Sprintf("%064x", id),
+		})
+	}
+	return files
+}
+
+func BenchmarkValidateCollectedFiles(b *testing.B) {
+	files := generateBenchFiles(benchFiles)
+	strategy, _ := GetCompareStrategy("")
+	policy := NewFilePolicy(nil, nil, nil)
+	logger := zap.NewNop()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ValidateCollectedFiles(files, []string{".txt"}, policy, strategy, logger)
+	}
+}
+
+func BenchmarkConstructCustomFilesRegistryKey(b *testing.B) {
+	files := generateBenchFiles(benchFiles)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ConstructCustomFilesRegistryKey(files)
+	}
+}
+
+func BenchmarkNewPlan(b *testing.B) {
+	files := generateBenchFiles(benchFiles)
+	manifest := NewManifest(nil, files, make([]FileStatus, len(files)), "")
+	for id := range manifest.Files {
+		manifest.Files[id].Winner = true
+	}
+	// Half of files deployed, every fifth of them with other content.
+	deployed, _ := NewDeployedState(time.Time{}, files[:len(files)/2], nil)
+	for id := 0; id < len(deployed.Files); id += 5 {
+		deployed.Files[id].Hash = "changed"
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewPlan(MainCfgYAML{}, manifest, deployed, nil)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Copy files into content-addressed local cache named by hash and set StagedPath.
+// Identical files from different folders transferred from source only once.
+// Hash of cached and transferred content checked, so damaged entry staged again
+// and source changed since scan, when hash taken from scan cache, never staged.
+// Cache entries not used by provided files removed.
+func StageFilesInCache(files []CustomisationFile, cacheFolder string, logger *zap.Logger) error {
+	err := os.MkdirAll(cacheFolder, 0755)
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool, len(files))
+	var transferred, reused int64
+	for id, file := range files {
+		cachedPath := filepath.Join(cacheFolder, file.Hash)
+		files[id].StagedPath = cachedPath
+		if used[file.Hash] {
+			continue
+		}
+		used[file.Hash] = true
+		if _, err := os.Stat(cachedPath); err == nil {
+			hash, err := HashFile(cachedPath)
+			if err == nil && hash == file.Hash {
+				reused++
+				continue
+			}
+			logger.Warn(fmt.Sprintf("Cache entry '%v' damaged, staged again", cachedPath))
+		}
+		// Copy into temporary file first to avoid partial cache entries.
+		nBytes, err := copyFile(file.SourcePath, cachedPath+".tmp")
+		if err != nil {
+			return err
+		}
+		hash, err := HashFile(cachedPath + ".tmp")
+		if err != nil {
+			return err
+		}
+		if hash != file.Hash {
+			os.Remove(cachedPath + ".tmp")
+			return fmt.Errorf("content of '%v' changed since scan, hash %v, expected %v", file.SourcePath, hash, file.Hash)
+		}
+		err = os.Rename(cachedPath+".tmp", cachedPath)
+		if err != nil {
+			return err
+		}
+		transferred += nBytes
+	}
+	logger.Info(fmt.Sprintf("Cache staged %d files as %d unique, %d reused from cache, %d bytes transferred",
+		len(files), len(used), reused, transferred))
+
+	// Remove stale entries.
+	dirContent, err := ioutil.ReadDir(cacheFolder)
+	if err != nil {
+		return err
+	}
+	for _, entity := range dirContent {
+		if entity.IsDir() || used[entity.Name()] {
+			continue
+		}
+		err = os.Remove(filepath.Join(cacheFolder, entity.Name()))
+		if err != nil {
+			logger.Warn(fmt.Sprint("Can't remove stale cache entry - ", err))
+		}
+	}
+	return nil
+}
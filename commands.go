@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Run subcommand provided by first argument with the rest arguments.
+func RunSubcommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	switch args[0] {
+	case "apply":
+		return RunApplyCommand(args[1:], mainConfig, programDirectory)
+	case "completion":
+		return RunCompletionCommand(args[1:], mainConfig, programDirectory)
+	case "digest":
+		return RunDigestCommand(args[1:], mainConfig, programDirectory)
+	case "doctor":
+		return RunDoctorCommand(mainConfig, programDirectory)
+	case "estimate":
+		return RunEstimateCommand(args[1:], mainConfig, programDirectory)
+	case "history":
+		return RunHistoryCommand(args[1:], mainConfig, programDirectory)
+	case "inventory":
+		return RunInventoryCommand(args[1:], mainConfig, programDirectory)
+	case "migrate":
+		return RunMigrateCommand(args[1:], mainConfig, programDirectory)
+	case "migrate-data":
+		return RunMigrateDataCommand(args[1:], mainConfig, programDirectory)
+	case "plan":
+		return RunPlanCommand(args[1:], mainConfig, programDirectory)
+	case "registry":
+		return RunRegistryCommand(args[1:], mainConfig, programDirectory)
+	case "support-bundle":
+		return RunSupportBundleCommand(args[1:], mainConfig, programDirectory)
+	case "status":
+		return RunStatusCommand(args[1:], mainConfig, programDirectory)
+	case "whence":
+		return RunWhenceCommand(args[1:], mainConfig, programDirectory)
+	case "tray":
+		return RunTrayCommand(args[1:], mainConfig, programDirectory)
+	case "rollback":
+		return RunRollbackCommand(args[1:], mainConfig, programDirectory)
+	case "uninstall":
+		return RunUninstallCommand(args[1:], mainConfig, programDirectory)
+	case "secret":
+		return RunSecretCommand(args[1:])
+	}
+	return fmt.Errorf("unknown command '%v'", args[0])
+}
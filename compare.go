@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultCompareStrategy string = "version-mtime" // Strategy used if not configured.
+	CompareConflict        string = "conflict"      // Result of strategy which can't choose file by its rules.
+	CompareTieBreak        string = "tie"           // Result of strategy for files which must be chosen by stable folder order.
+)
+
+// Choose newer file from two files with equal name and relative path.
+// Return "first", "second", "equal", CompareConflict or CompareTieBreak.
+// File from source with higher precedence always wins regardless of strategy.
+type CompareStrategy func(first, second CustomisationFile) string
+
+// Strategies available in config.
+var CompareStrategies = map[string]CompareStrategy{
+	"version-mtime":   FindNewFile,
+	"mtime":           FindNewFileByMTime,
+	"hash-version":    FindNewFileHashMustMatchVersion,
+	"folder-priority": FindNewFileByFolderPriority,
+}
+
+// Return strategy by name from config.
+func GetCompareStrategy(name string) (CompareStrategy, error) {
+	if name == "" {
+		name = DefaultCompareStrategy
+	}
+	strategy, ok := CompareStrategies[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(CompareStrategies))
+		for strategyName := range CompareStrategies {
+			names = append(names, strategyName)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown compare strategy '%v', available: %v", name, strings.Join(names, ", "))
+	}
+	return strategy, nil
+}
+
+// Only last write time compared, file versions ignored.
+func FindNewFileByMTime(first, second CustomisationFile) string {
+	if result := comparePrecedence(first, second); result != "equal" {
+		return result
+	}
+	return compareLastWriteTime(first, second)
+}
+
+// Version decide which file is newer. Files with equal versions must be identical,
+// otherwise result is conflict.
+func FindNewFileHashMustMatchVersion(first, second CustomisationFile) string {
+	if result := comparePrecedence(first, second); result != "equal" {
+		return result
+	}
+	if result := compareVersion(first, second); result != "equal" {
+		return result
+	}
+	if first.Hash != second.Hash {
+		return CompareConflict
+	}
+	return "equal"
+}
+
+// File from customisation folder later in sort order wins, e.g. "20_Hotfix" over "10_Base".
+// Versions and last write times compared only for files of the same folder.
+func FindNewFileByFolderPriority(first, second CustomisationFile) string {
+	if result := comparePrecedence(first, second); result != "equal" {
+		return result
+	}
+	if result := compareFolderOrder(first, second); result != "equal" {
+		return result
+	}
+	if result := compareVersion(first, second); result != "equal" {
+		return result
+	}
+	return compareLastWriteTime(first, second)
+}
+
+func comparePrecedence(first, second CustomisationFile) string {
+	switch {
+	case first.Precedence > second.Precedence:
+		return "first"
+	case first.Precedence < second.Precedence:
+		return "second"
+	}
+	return "equal"
+}
+
+func compareVersion(first, second CustomisationFile) string {
+	switch {
+	case first.Version.full > second.Version.full:
+		return "first"
+	case first.Version.full < second.Version.full:
+		return "second"
+	}
+	return "equal"
+}
+
+func compareLastWriteTime(first, second CustomisationFile) string {
+	switch {
+	case first.LastWriteTime.After(second.LastWriteTime):
+		return "first"
+	case first.LastWriteTime.Before(second.LastWriteTime):
+		return "second"
+	}
+	return "equal"
+}
+
+// Files with equal not zero version and different content are usually re-signed builds.
+// Their last write times differ between machines, so they must be chosen by stable folder order.
+func isResignedBuild(first, second CustomisationFile) bool {
+	return first.Version.full != 0 && first.Version.full == second.Version.full && first.Hash != second.Hash
+}
+
+// Folder later in case insensitive sort order wins.
+func compareFolderOrder(first, second CustomisationFile) string {
+	firstFolder := strings.ToLower(first.CustomisationFolder)
+	secondFolder := strings.ToLower(second.CustomisationFolder)
+	switch {
+	case firstFolder > secondFolder:
+		return "first"
+	case firstFolder < secondFolder:
+		return "second"
+	}
+	return "equal"
+}
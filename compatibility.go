@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Actions on unsupported WDE version.
+const (
+	CompatibilityActionFail = "fail" // Stop run before anything copied.
+	CompatibilityActionWarn = "warn" // Log warning and continue.
+)
+
+// Supported WDE versions of customisation folders.
+// Empty Folder applies rule to whole release. Min and Max may be partial, e.g. "8.5",
+// Max "8.5" allows any 8.5.x.x version.
+type CompatibilityRule struct {
+	Folder string `yaml:"Folder"` // Customisation folder name pattern, e.g. "Release_851*".
+	Min    string `yaml:"Min"`    // Minimal supported version, no limit if empty.
+	Max    string `yaml:"Max"`    // Maximal supported version, no limit if empty.
+}
+
+// Check installed WDE version against rules matching collected folders.
+// Return descriptions of violations.
+func CheckCompatibility(rules []CompatibilityRule, folders []string, wdeVersion FileVersion) ([]string, error) {
+	violations := make([]string, 0, 2)
+	for _, rule := range rules {
+		scope := "release"
+		if rule.Folder != "" {
+			matched := make([]string, 0, 1)
+			for _, folder := range folders {
+				ok, err := filepath.Match(strings.ToLower(rule.Folder), strings.ToLower(filepath.Base(folder)))
+				if err != nil {
+					return nil, fmt.Errorf("bad compatibility folder pattern '%v' - %v", rule.Folder, err)
+				}
+				if ok {
+					matched = append(matched, filepath.Base(folder))
+				}
+			}
+			if len(matched) == 0 {
+				continue
+			}
+			scope = fmt.Sprintf("folders %v", strings.Join(matched, ", "))
+		}
+		supported, err := rule.Supports(wdeVersion)
+		if err != nil {
+			return nil, err
+		}
+		if wdeVersion.full == 0 {
+			violations = append(violations, fmt.Sprintf("%v require WDE %v, installed version unknown", scope, rule.Range()))
+			continue
+		}
+		if !supported {
+			violations = append(violations, fmt.Sprintf("%v require WDE %v, installed %v", scope, rule.Range(), wdeVersion))
+		}
+	}
+	return violations, nil
+}
+
+// Check if version is in rule range.
+func (cr CompatibilityRule) Supports(version FileVersion) (bool, error) {
+	if cr.Min != "" {
+		compare, err := compareVersionPrefix(version, cr.Min)
+		if err != nil {
+			return false, err
+		}
+		if compare < 0 {
+			return false, nil
+		}
+	}
+	if cr.Max != "" {
+		compare, err := compareVersionPrefix(version, cr.Max)
+		if err != nil {
+			return false, err
+		}
+		if compare > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Return human readable range, e.g. "8.5 - 8.5.999".
+func (cr CompatibilityRule) Range() string {
+	switch {
+	case cr.Min != "" && cr.Max != "":
+		return fmt.Sprint(cr.Min, " - ", cr.Max)
+	case cr.Min != "":
+		return fmt.Sprint(cr.Min, " or newer")
+	case cr.Max != "":
+		return fmt.Sprint(cr.Max, " or older")
+	}
+	return "any version"
+}
+
+// Compare version with partial version, only components present in prefix compared.
+// Return -1, 0 or 1.
+func compareVersionPrefix(version FileVersion, prefix string) (int, error) {
+	parts := strings.Split(prefix, ".")
+	if len(parts) > 4 {
+		return 0, fmt.Errorf("bad version '%v'", prefix)
+	}
+	components := []uint64{version.v1, version.v2, version.v3, version.v4}
+	for id, part := range parts {
+		value, err := strconv.ParseUint(strings.TrimSpace(part), 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("bad version '%v' - %v", prefix, err)
+		}
+		switch {
+		case components[id] < value:
+			return -1, nil
+		case components[id] > value:
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// Check compatibility rules from config and apply configured action.
+// Return error if WDE version unsupported and action is fail.
+func ApplyCompatibility(mainConfig MainCfgYAML, folders []string, wdeVersion FileVersion, events *HistoryEvents, logger *zap.Logger) error {
+	if len(mainConfig.Compatibility.Rules) == 0 {
+		return nil
+	}
+	action := mainConfig.Compatibility.Action
+	switch action {
+	case "":
+		action = CompatibilityActionFail
+	case CompatibilityActionFail, CompatibilityActionWarn:
+	default:
+		return fmt.Errorf("unknown Compatibility.Action '%v'", action)
+	}
+	violations, err := CheckCompatibility(mainConfig.Compatibility.Rules, folders, wdeVersion)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		logger.Info(fmt.Sprintf("Installed WDE version %v supported by customisations", wdeVersion))
+		return nil
+	}
+	for _, violation := range violations {
+		logger.Warn(fmt.Sprint("Unsupported WDE version - ", violation))
+		events.Add("Unsupported WDE version - %v", violation)
+	}
+	if action == CompatibilityActionFail {
+		return fmt.Errorf("installed WDE version not supported, %v violations", len(violations))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Words completed after subcommand.
+type CompletionCommand struct {
+	Words []string // Positional words, e.g. "show" for "history".
+	Flags []string
+}
+
+// Subcommands with their words and flags for shell completion.
+var CompletionCommands = map[string]CompletionCommand{
+	"apply":          {Flags: []string{"-plan"}},
+	"completion":     {Words: []string{"bash", "powershell"}},
+	"digest":         {Flags: []string{"-period", "-out"}},
+	"doctor":         {},
+	"estimate":       {Flags: []string{"-runs"}},
+	"history":        {Words: []string{"show"}, Flags: []string{"-status", "-file", "-limit", "-page"}},
+	"inventory":      {Flags: []string{"-out"}},
+	"migrate":        {Flags: []string{"-config"}},
+	"migrate-data":   {Flags: []string{"-from"}},
+	"plan":           {Flags: []string{"-out"}},
+	"registry":       {Words: []string{"snapshots"}, Flags: []string{"-live"}},
+	"rollback":       {Words: []string{"list"}, Flags: []string{"-registry", "-files"}},
+	"secret":         {Words: []string{"set"}, Flags: []string{"-dpapi", "-machine"}},
+	"status":         {Flags: []string{"-drift"}},
+	"support-bundle": {Flags: []string{"-count", "-out"}},
+	"tray":           {Flags: []string{"-refresh"}},
+	"uninstall":      {Flags: []string{"-yes"}},
+	"whence":         {},
+}
+
+// Prefix of current word argument of "completion complete".
+// Current word passed with prefix because PowerShell drops empty arguments of native commands.
+const completionCurrentPrefix string = "-current="
+
+const bashCompletionScript = `_wdeCustomizationUpdater() {
+    local IFS=$'\n'
+    COMPREPLY=($("${COMP_WORDS[0]}" completion complete "-current=${COMP_WORDS[COMP_CWORD]}" "${COMP_WORDS[@]:1:COMP_CWORD-1}" 2>/dev/null))
+}
+complete -F _wdeCustomizationUpdater %[1]s %[2]s
+`
+
+const powershellCompletionScript = `Register-ArgumentCompleter -Native -CommandName @('%[1]s', '%[2]s') -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $previous = @($commandAst.CommandElements | Select-Object -Skip 1 |
+        Where-Object { $_.Extent.EndOffset -lt $cursorPosition -and $_.ToString() -ne $wordToComplete } |
+        ForEach-Object { $_.ToString() })
+    & $commandAst.CommandElements[0].ToString() completion complete "-current=$wordToComplete" @previous 2>$null |
+        ForEach-Object { [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_) }
+}
+`
+
+// Run "completion" subcommand. Print completion script for shell
+// or candidates for current word when called by script.
+func RunCompletionCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: completion bash|powershell")
+	}
+	executable := filepath.Base(os.Args[0])
+	executableName := strings.TrimSuffix(executable, filepath.Ext(executable))
+	switch args[0] {
+	case "bash":
+		fmt.Printf(bashCompletionScript, executableName, executable)
+	case "powershell":
+		fmt.Printf(powershellCompletionScript, executableName, executable)
+	case "complete":
+		if len(args) < 2 || !strings.HasPrefix(args[1], completionCurrentPrefix) {
+			return fmt.Errorf("usage: completion complete %vWORD [PREVIOUS WORDS]", completionCurrentPrefix)
+		}
+		current := strings.TrimPrefix(args[1], completionCurrentPrefix)
+		for _, candidate := range CompletionCandidates(args[2:], current, mainConfig, programDirectory) {
+			fmt.Println(candidate)
+		}
+	default:
+		return fmt.Errorf("unknown shell '%v', supported bash and powershell", args[0])
+	}
+	return nil
+}
+
+// Return candidates for current word after previous command line words.
+// Subcommands, flags, history run IDs and history statuses completed.
+func CompletionCandidates(previous []string, current string, mainConfig MainCfgYAML, programDirectory string) []string {
+	subcommand := ""
+	positional := 0
+	for _, word := range previous {
+		switch {
+		case strings.HasPrefix(word, "-"):
+		case subcommand == "":
+			if _, ok := CompletionCommands[word]; ok {
+				subcommand = word
+			}
+		default:
+			positional++
+		}
+	}
+	lastWord := ""
+	if len(previous) > 0 {
+		lastWord = previous[len(previous)-1]
+	}
+
+	candidates := make([]string, 0, 16)
+	switch {
+	case subcommand == "":
+		for name := range CompletionCommands {
+			candidates = append(candidates, name)
+		}
+		flag.VisitAll(func(f *flag.Flag) {
+			candidates = append(candidates, fmt.Sprint("-", f.Name))
+		})
+	case subcommand == "history" && lastWord == "-status":
+		for _, status := range FileStatuses {
+			candidates = append(candidates, string(status))
+		}
+	case subcommand == "history" && positional == 1:
+		candidates = append(candidates, "last")
+		historyFilePrefix := HistoryFilePrefix(mainConfig)
+		names, _ := ListHistoryFiles(HistoryFolderPath(mainConfig, programDirectory), historyFilePrefix)
+		for _, name := range names {
+			candidates = append(candidates, strings.TrimSuffix(strings.TrimPrefix(name, historyFilePrefix), ".log"))
+		}
+		candidates = append(candidates, CompletionCommands[subcommand].Flags...)
+	default:
+		if positional == 0 {
+			candidates = append(candidates, CompletionCommands[subcommand].Words...)
+		}
+		candidates = append(candidates, CompletionCommands[subcommand].Flags...)
+	}
+
+	matched := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(current)) {
+			matched = append(matched, candidate)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
@@ -1,43 +1,358 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 )
 
+// Supported configuration file names in lookup order. YAML is the default format.
+var ConfigFileNames = []string{confFile, "config.yml", "config.json", "config.toml"}
+
 // For data from "config.yaml" file.
 type MainCfgYAML struct {
-	WDEInstallationFolder string `yaml:"WDEInstallationFolder"`
-	CustomisationsFolder  string `yaml:"CustomisationsFolder"`
+	ConfigVersion         int                   `yaml:"ConfigVersion"` // Config format version, see CurrentConfigVersion.
+	Include               []string              `yaml:"Include"`       // Base configs applied before this file, paths relative to this file.
+	WDEInstallationFolder string                `yaml:"WDEInstallationFolder"`
+	CustomisationsFolder  string                `yaml:"CustomisationsFolder"`
+	Sources               []CustomisationSource `yaml:"Sources"` // Additional customisation sources.
+	Targets               []TargetConfig        `yaml:"Targets"` // WDE installations updated concurrently instead of WDEInstallationFolder.
 	Log                   struct {
 		Folder  string `yaml:"Folder"`
 		Name    string `yaml:"Name"`
 		Verbose string `yaml:"Verbose"`
+		Redact  struct {
+			Enabled bool     `yaml:"Enabled"` // Mask user names and machine name in log, history and summary.
+			Paths   []string `yaml:"Paths"`   // Additional path fragments masked, e.g. "C:\Users\agent01".
+		} `yaml:"Redact"`
+		Time struct {
+			FileLayout string `yaml:"FileLayout"` // Go time layout for "log", "history" and other file names, default "2006.01.02_150405".
+			LineLayout string `yaml:"LineLayout"` // Go time layout for log lines, default "2006.01.02 15:04:05".
+			Zone       string `yaml:"Zone"`       // "local" (default), "utc" or IANA zone name.
+		} `yaml:"Time"`
 	} `yaml:"Log"`
-	RedundantFiles []string `yaml:"RedundantFiles"`
+	History struct {
+		Folder string `yaml:"Folder"`
+		Name   string `yaml:"Name"`
+	} `yaml:"History"`
+	Mirror struct {
+		Folder string `yaml:"Folder"` // Central share root. Files saved into "<Folder>\<hostname>\".
+	} `yaml:"Mirror"`
+	Run struct {
+		MaxDuration     string `yaml:"MaxDuration"`     // Expected maximum run duration, e.g. "15m".
+		NotifyOnOverrun bool   `yaml:"NotifyOnOverrun"` // Run notification command if MaxDuration exceeded.
+		Parallelism     int    `yaml:"Parallelism"`     // Targets and user hives updated at the same time, by default 4.
+		Retries         int    `yaml:"Retries"`         // Retries of whole run failed by transient error, e.g. unreachable share.
+		RetryDelay      string `yaml:"RetryDelay"`      // Pause before first retry, doubled after each one, by default "1m".
+		RetryMaxDelay   string `yaml:"RetryMaxDelay"`   // Longest pause between retries, by default "15m".
+	} `yaml:"Run"`
+	DM struct {
+		Skip             bool               `yaml:"Skip"`             // Don't run DM or publish command, customisation published later.
+		Command          []string           `yaml:"Command"`          // Publish command with arguments used instead of DM executable.
+		Automation       []DMAutomationStep `yaml:"Automation"`       // Scripted wizard flow. Empty for manual wizard.
+		WindowStyle      string             `yaml:"WindowStyle"`      // "normal" (default), "minimized" or "hidden".
+		Retries          int                `yaml:"Retries"`          // Retries of launch failed to start process, failure after start never retried.
+		RetryDelay       string             `yaml:"RetryDelay"`       // Pause between attempts, e.g. "30s".
+		LogFile          string             `yaml:"LogFile"`          // DM log file scanned for errors after run. Supports %VAR% expansion.
+		LogErrorPatterns []string           `yaml:"LogErrorPatterns"` // Error line patterns. By default "ERROR" and "Exception".
+		FailOnLogErrors  bool               `yaml:"FailOnLogErrors"`  // Treat errors in DM log as failed deployment.
+		Prerequisites    struct {
+			Check            bool   `yaml:"Check"`            // Verify DM executable, config, license and .NET before launch.
+			LicenseFile      string `yaml:"LicenseFile"`      // License file checked, relative to DM folder.
+			MinDotNetRelease int    `yaml:"MinDotNetRelease"` // Minimum .NET Framework "Release" value, by default 378389 (4.5).
+			ConfigTemplate   string `yaml:"ConfigTemplate"`   // Missing DM config restored from this file.
+		} `yaml:"Prerequisites"`
+	} `yaml:"DM"`
+	Workspace struct {
+		Folder string `yaml:"Folder"` // Folder for run-time artifacts, by default "%ProgramData%\WdeCustomizationUpdater". Environment variables "%NAME%" expanded.
+	} `yaml:"Workspace"`
+	Session struct {
+		LockTimeout               string `yaml:"LockTimeout"`               // Maximum wait for run in another session, by default 30m.
+		UserWDEInstallationFolder string `yaml:"UserWDEInstallationFolder"` // WDE installed per user on multi-session host, e.g. "%LOCALAPPDATA%\Genesys".
+	} `yaml:"Session"`
+	Dependencies struct {
+		Disabled            bool     `yaml:"Disabled"`            // Do not check references of deployed assemblies and DLLs.
+		Ignore              []string `yaml:"Ignore"`              // Assembly or DLL names never reported as missing, "*" wildcards allowed.
+		DuplicateAssemblies string   `yaml:"DuplicateAssemblies"` // warn (default) or fail if files share assembly name and version.
+	} `yaml:"Dependencies"`
+	Audit struct {
+		Folder   string `yaml:"Folder"`   // Folder for append-only audit log, by default "Audit" in workspace.
+		Disabled bool   `yaml:"Disabled"` // Do not write audit log.
+	} `yaml:"Audit"`
+	State struct {
+		Folder                 string `yaml:"Folder"`                 // Folder for deployed state file.
+		RemoveOrphans          string `yaml:"RemoveOrphans"`          // "keep" (default), "ask" or "remove" files of removed customisation folders.
+		FullRescan             bool   `yaml:"FullRescan"`             // Hash and read version of all source files, scan cache not used.
+		RemoveEmptyDirectories bool   `yaml:"RemoveEmptyDirectories"` // Remove directories left empty by removed orphaned files.
+	} `yaml:"State"`
+	Cache struct {
+		Folder string `yaml:"Folder"` // Local content-addressed cache. Disabled if empty.
+	} `yaml:"Cache"`
+	Backup struct {
+		Enabled bool   `yaml:"Enabled"` // Save WDE files replaced or removed by run into backup set for "rollback" command.
+		Folder  string `yaml:"Folder"`  // Folder of backup sets, "Backup" in workspace by default.
+		Keep    int    `yaml:"Keep"`    // Backup sets preserved, 5 by default.
+	} `yaml:"Backup"`
+	Copy struct {
+		Engine               string `yaml:"Engine"`               // native (default), copyfile, robocopy or cmd.
+		LargeFileThresholdMB int64  `yaml:"LargeFileThresholdMB"` // Files from this size copied by CopyFileEx with unbuffered IO on Windows.
+		StripStreams         string `yaml:"StripStreams"`         // none (default), blocked (Zone.Identifier of Internet files), zone (Zone.Identifier) or all alternate data streams removed after copy.
+		Attributes           string `yaml:"Attributes"`           // keep (default), preserve source attributes or normalize (clear read-only, hidden, system).
+		OnFileError          string `yaml:"OnFileError"`          // fail-fast (default) or continue-and-report.
+		AVRecheckDelay       string `yaml:"AVRecheckDelay"`       // Check copied files again after delay for antivirus interference, by default 3s, 0 - disabled.
+		OnAVInterference     string `yaml:"OnAVInterference"`     // warn (default) or fail, vanished and changed files handled by OnFileError.
+	} `yaml:"Copy"`
+	FileLocks struct {
+		CloseProcesses []string `yaml:"CloseProcesses"` // Process or service names allowed to be closed and restarted if they lock files.
+	} `yaml:"FileLocks"`
+	StopBeforeUpdate struct {
+		Services    []string         `yaml:"Services"`    // Services stopped before copy and started after Deployment Manager.
+		Processes   []ManagedProcess `yaml:"Processes"`   // Processes stopped before copy and started after Deployment Manager.
+		Timeout     string           `yaml:"Timeout"`     // Timeout for stop or start, by default 60s.
+		FailOnError bool             `yaml:"FailOnError"` // Abort update if something can't be stopped.
+	} `yaml:"StopBeforeUpdate"`
+	Telemetry struct {
+		Enabled  bool   `yaml:"Enabled"`  // Opt-in anonymous usage reporting.
+		Endpoint string `yaml:"Endpoint"` // URL receiving JSON report by POST.
+	} `yaml:"Telemetry"`
+	Watch struct {
+		Interval     string `yaml:"Interval"`     // Pause between runs in watch mode, e.g. "1h".
+		ConfigURL    string `yaml:"ConfigURL"`    // Remote HTTPS config fetched before each run in watch mode, its allowed values override local config.
+		ConfigSecret string `yaml:"ConfigSecret"` // Key of HMAC-SHA256 signature of remote config, required with ConfigURL, secret reference recommended.
+		// Health reporting of watch mode, applied at start only.
+		HealthAddress     string `yaml:"HealthAddress"`     // Local address of health endpoint, e.g. "localhost:9311", disabled if empty.
+		HeartbeatFile     string `yaml:"HeartbeatFile"`     // File rewritten with health report, relative to workspace, disabled if empty.
+		HeartbeatInterval string `yaml:"HeartbeatInterval"` // Rewrite interval of heartbeat file, 1m by default.
+		HealthMaxAge      string `yaml:"HealthMaxAge"`      // Process unhealthy if no successful run for this long, e.g. "3h", never if empty.
+	} `yaml:"Watch"`
+	Digest struct {
+		Folder     string   `yaml:"Folder"`     // Root with "<hostname>\History\" summaries, by default Mirror.Folder.
+		SMTPServer string   `yaml:"SMTPServer"` // "host:port" for digest email, email not sent if empty.
+		Username   string   `yaml:"Username"`   // SMTP user, anonymous if empty.
+		Password   string   `yaml:"Password"`   // SMTP password, secret reference recommended.
+		From       string   `yaml:"From"`
+		To         []string `yaml:"To"`
+		Subject    string   `yaml:"Subject"`
+	} `yaml:"Digest"`
+	Notify struct {
+		Command   []string `yaml:"Command"` // Command with arguments. Summary file path appended as last argument.
+		AgentFile struct {
+			Enabled  bool   `yaml:"Enabled"`  // Write notice file for agents after customisation applied.
+			Folder   string `yaml:"Folder"`   // Folder of notice file, by default WDE folder.
+			FileName string `yaml:"FileName"` // By default "Customizations.json".
+			Version  string `yaml:"Version"`  // Release version written into notice instead of detected one.
+		} `yaml:"AgentFile"`
+	} `yaml:"Notify"`
+	Policy struct {
+		DenyExtensions  []string `yaml:"DenyExtensions"`  // Files with these extensions never deployed.
+		AllowExtensions []string `yaml:"AllowExtensions"` // If set, only files with these extensions deployed.
+		ProtectedFiles  []string `yaml:"ProtectedFiles"`  // WDE files never overwritten, paths relative to WDE folder, wildcards allowed.
+	} `yaml:"Policy"`
+	Coordination struct {
+		Folder       string `yaml:"Folder"`       // Shared folder for staged reports and release gate file.
+		URL          string `yaml:"URL"`          // Coordination endpoint, staged report POSTed to "<URL>/staged", gate open while "<URL>/gate" returns 200 with release key.
+		Timeout      string `yaml:"Timeout"`      // Maximum wait for release gate, by default 4h.
+		PollInterval string `yaml:"PollInterval"` // Release gate check interval, by default 30s.
+	} `yaml:"Coordination"`
+	Approval struct {
+		URL          string `yaml:"URL"`          // Endpoint receiving planned changes by POST before WDE folder changed, run continues only if approved.
+		Secret       string `yaml:"Secret"`       // Shared secret, if set response must contain token signed by it instead of approved flag.
+		Timeout      string `yaml:"Timeout"`      // Maximum wait while approval pending, by default 1h.
+		PollInterval string `yaml:"PollInterval"` // Interval of requests while approval pending, by default 30s.
+	} `yaml:"Approval"`
+	Scanner struct {
+		URL     string `yaml:"URL"`     // Endpoint receiving files to deploy by POST before copy, files without clean verdict not deployed.
+		Token   string `yaml:"Token"`   // Bearer token of scanner, secret reference recommended.
+		Timeout string `yaml:"Timeout"` // Timeout of single request, by default 5m.
+		OnError string `yaml:"OnError"` // fail (default) - run failed if scanner unavailable, allow - files deployed unscanned.
+	} `yaml:"Scanner"`
+	Manifest string `yaml:"Manifest"` // Manifest from "inventory" command. If set, listed files deployed instead of sources scan, hashes verified.
+	Limits   struct {
+		MaxFileSizeMB  int64  `yaml:"MaxFileSizeMB"`  // Maximum size of single deployed file, 0 - no limit.
+		MaxTotalSizeMB int64  `yaml:"MaxTotalSizeMB"` // Maximum total size of deployed files, 0 - no limit.
+		Action         string `yaml:"Action"`         // abort (default) or warn when limit exceeded.
+	} `yaml:"Limits"`
+	Compatibility struct {
+		Rules  []CompatibilityRule `yaml:"Rules"`  // Supported WDE versions of release or customisation folders.
+		Action string              `yaml:"Action"` // fail (default) or warn on unsupported WDE version.
+	} `yaml:"Compatibility"`
+	CustomFiles struct {
+		Mode          string `yaml:"Mode"`          // rebuild (default) or incremental update of "CustomFiles" registry value.
+		Order         string `yaml:"Order"`         // collection (default), path or previous order of entries.
+		WarnSizeKB    int    `yaml:"WarnSizeKB"`    // Warn if value in registry larger, by default 512.
+		Compact       bool   `yaml:"Compact"`       // Omit formatting whitespace.
+		Merge         string `yaml:"Merge"`         // two-way (default, saved data only) or three-way (saved data, live registry and deployed files).
+		OptionsSource string `yaml:"OptionsSource"` // snapshot (default) or live source of manual entry options for two-way merge.
+	} `yaml:"CustomFiles"`
+	Registry struct {
+		ManagedValues    []string                     `yaml:"ManagedValues"`    // Additional DM registry values owned by updater, "CustomFiles" and "AddCustomFile" owned always.
+		RefreshEveryRuns int                          `yaml:"RefreshEveryRuns"` // Read live registry as new baseline after that many runs with saved data, 0 - never.
+		RefreshEveryDays int                          `yaml:"RefreshEveryDays"` // Read live registry as new baseline after that many days, 0 - never.
+		Template         map[string]string            `yaml:"Template"`         // DM registry values rendered every run, "${var:NAME}" replaced by variable of target.
+		Variables        map[string]string            `yaml:"Variables"`        // Template variables common for all targets.
+		Targets          map[string]map[string]string `yaml:"Targets"`          // Template variables per target, override Variables.
+		Target           string                       `yaml:"Target"`           // Target of this machine, usually set in machine config layer.
+		Users            string                       `yaml:"Users"`            // Other users whose DM registry updated: current (default, none) or all loaded user hives.
+		UserSIDs         []string                     `yaml:"UserSIDs"`         // SIDs of users whose DM registry updated, override Users.
+		PublishSummary   bool                         `yaml:"PublishSummary"`   // Write time, run ID and release of last update into UpdaterRegistryDir.
+	} `yaml:"Registry"`
+	CompareStrategy string   `yaml:"CompareStrategy"` // Choose newer of equal files: version-mtime (default), mtime, hash-version or folder-priority.
+	RedundantFiles  []string `yaml:"RedundantFiles"`
+
+	deprecations []ConfigDeprecation // Deprecated keys found while config read.
+}
+
+// Find configuration file in directory by supported names.
+// Return path of default file name if nothing found.
+func FindConfigFile(directory string) string {
+	for _, name := range ConfigFileNames {
+		path := filepath.Join(directory, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(directory, confFile)
 }
 
-// Extract configuration file and unmarshall collected data into config variable.
-func ReadConfigFromYAMLFile(cfgFilePath string) (MainCfgYAML, error) {
-	log.Println("[START   ] ReadConfigFromYAMLFile")
-	file, err := os.Open(cfgFilePath)
+// Read layered configuration. Layers applied in order, later layer override values of previous:
+// files from "Include" list (relative to including file), configuration file itself,
+// machine-specific overlay "<name>.local.<ext>" near configuration file if exists.
+// Format of each file detected by extension: ".json", ".toml", YAML otherwise.
+func ReadConfigFile(cfgFilePath string) (MainCfgYAML, error) {
+	log.Printf("[START   ] ReadConfigFile `%v`", cfgFilePath)
+	var mainConfig MainCfgYAML
+	err := ApplyConfigLayer(cfgFilePath, &mainConfig, make(map[string]bool))
 	if err != nil {
-		log.Println("[FAIL    ] GetCustomisationFoldersList")
+		log.Println("[FAIL    ] ReadConfigFile")
 		return MainCfgYAML{}, err
 	}
-	data, err := ioutil.ReadAll(file)
+	overlayPath := LocalConfigOverlayPath(cfgFilePath)
+	if _, err := os.Stat(overlayPath); err == nil {
+		log.Printf("Apply local config overlay `%v`", overlayPath)
+		err = ApplyConfigLayer(overlayPath, &mainConfig, make(map[string]bool))
+		if err != nil {
+			log.Println("[FAIL    ] ReadConfigFile")
+			return MainCfgYAML{}, err
+		}
+	}
+	mainConfig.WDEInstallationFolder = ResolveWDEInstallationFolder(mainConfig)
+	log.Println("[SUCCESS ] ReadConfigFile")
+	return mainConfig, nil
+}
+
+// Apply configuration file with its includes over config values.
+// Included files applied first, so values of including file take precedence.
+// Lists are not merged, list from later layer replace whole list.
+func ApplyConfigLayer(cfgFilePath string, mainConfig *MainCfgYAML, visited map[string]bool) error {
+	absolutePath, err := filepath.Abs(cfgFilePath)
+	if err != nil {
+		return err
+	}
+	if visited[absolutePath] {
+		return fmt.Errorf("config include cycle on '%v'", cfgFilePath)
+	}
+	visited[absolutePath] = true
+	defer delete(visited, absolutePath)
+
+	data, err := ioutil.ReadFile(cfgFilePath)
 	if err != nil {
-		log.Println("[FAIL    ] GetCustomisationFoldersList")
-		return MainCfgYAML{}, err
+		return err
 	}
-	var mainConfig MainCfgYAML
-	err = yaml.Unmarshal(data, &mainConfig)
+	format := ConfigFormat(cfgFilePath)
+	var layer MainCfgYAML
+	err = UnmarshalConfig(data, format, &layer)
 	if err != nil {
-		log.Println("[FAIL    ] GetCustomisationFoldersList")
-		return MainCfgYAML{}, err
+		return fmt.Errorf("can't parse config '%v' - %v", cfgFilePath, err)
 	}
-	log.Println("[SUCCESS ] ReadConfigFromYAMLFile")
-	return mainConfig, nil
+	for _, include := range layer.Include {
+		include = ExpandWindowsEnv(include)
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(cfgFilePath), include)
+		}
+		log.Printf("Apply included config `%v`", include)
+		err = ApplyConfigLayer(include, mainConfig, visited)
+		if err != nil {
+			return err
+		}
+	}
+	return UnmarshalConfig(data, format, mainConfig)
+}
+
+// Get path of machine-specific overlay for configuration file, e.g. "config.local.yaml" for "config.yaml".
+func LocalConfigOverlayPath(cfgFilePath string) string {
+	extension := filepath.Ext(cfgFilePath)
+	return fmt.Sprint(strings.TrimSuffix(cfgFilePath, extension), ".local", extension)
+}
+
+// Get configuration format by file extension or URL path: "json", "toml" or "yaml".
+func ConfigFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json"
+	case ".toml":
+		return "toml"
+	}
+	return "yaml"
+}
+
+// Unmarshal configuration data of provided format over config values.
+// JSON and TOML converted into YAML first, so the same "yaml" keys used for all formats.
+func UnmarshalConfig(data []byte, format string, mainConfig *MainCfgYAML) error {
+	yamlData := data
+	var err error
+	switch format {
+	case "json":
+		var tree interface{}
+		err = json.Unmarshal(data, &tree)
+		if err != nil {
+			return fmt.Errorf("invalid JSON config - %v", err)
+		}
+		yamlData, err = yaml.Marshal(tree)
+	case "toml":
+		table := make(map[string]interface{})
+		err = toml.Unmarshal(data, &table)
+		if err != nil {
+			return fmt.Errorf("invalid TOML config - %v", err)
+		}
+		yamlData, err = yaml.Marshal(table)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Map deprecated keys to replacements, deprecations logged at run start.
+	var tree yaml.MapSlice
+	err = yaml.Unmarshal(yamlData, &tree)
+	if err != nil {
+		return err
+	}
+	tree, deprecations := MapDeprecatedConfigKeys(tree)
+	if len(deprecations) > 0 {
+		yamlData, err = yaml.Marshal(tree)
+		if err != nil {
+			return err
+		}
+		mainConfig.deprecations = append(mainConfig.deprecations, deprecations...)
+	}
+	return yaml.Unmarshal(yamlData, mainConfig)
+}
+
+// Expand Windows style environment variables like "%APPDATA%" in path.
+// Unknown variables left as is.
+func ExpandWindowsEnv(path string) string {
+	reVariable := regexp.MustCompile(`%([^%]+)%`)
+	return reVariable.ReplaceAllStringFunc(path, func(variable string) string {
+		value, ok := os.LookupEnv(variable[1 : len(variable)-1])
+		if !ok {
+			return variable
+		}
+		return value
+	})
 }
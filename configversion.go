@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+	"strings"
+)
+
+const CurrentConfigVersion = 2 // Config format version of this program version.
+
+// Config key replaced by another key of the same section.
+type DeprecatedConfigKey struct {
+	Key         string              // Dotted path of deprecated key, e.g. "WDEFolder" or "Log.Name".
+	Replacement string              // Name of replacement key in the same section.
+	Since       int                 // Config version where key deprecated.
+	Convert     func(string) string // Optional conversion of string value for replacement key.
+}
+
+// Deprecated keys automatically mapped to replacements while config read.
+var DeprecatedConfigKeys = []DeprecatedConfigKey{
+	{Key: "CustomizationsFolder", Replacement: "CustomisationsFolder", Since: 2},
+	{Key: "WDEFolder", Replacement: "WDEInstallationFolder", Since: 2, Convert: StripWDESubfolder},
+}
+
+// Usage of deprecated key found in config.
+type ConfigDeprecation struct {
+	DeprecatedConfigKey
+	Mapped bool // False if replacement key also set, deprecated value ignored.
+}
+
+// Map deprecated keys of config tree to replacements. Return changed tree and found deprecations.
+func MapDeprecatedConfigKeys(tree yaml.MapSlice) (yaml.MapSlice, []ConfigDeprecation) {
+	var deprecations []ConfigDeprecation
+	for _, key := range DeprecatedConfigKeys {
+		var deprecation *ConfigDeprecation
+		tree, deprecation = mapDeprecatedConfigKey(tree, strings.Split(key.Key, "."), key)
+		if deprecation != nil {
+			deprecations = append(deprecations, *deprecation)
+		}
+	}
+	return tree, deprecations
+}
+
+// Find deprecated key by path in config tree and rename it to replacement.
+func mapDeprecatedConfigKey(tree yaml.MapSlice, path []string, key DeprecatedConfigKey) (yaml.MapSlice, *ConfigDeprecation) {
+	for id, item := range tree {
+		if fmt.Sprint(item.Key) != path[0] {
+			continue
+		}
+		if len(path) > 1 {
+			section, ok := item.Value.(yaml.MapSlice)
+			if !ok {
+				return tree, nil
+			}
+			var deprecation *ConfigDeprecation
+			tree[id].Value, deprecation = mapDeprecatedConfigKey(section, path[1:], key)
+			return tree, deprecation
+		}
+		for _, other := range tree {
+			if fmt.Sprint(other.Key) == key.Replacement {
+				return append(tree[:id:id], tree[id+1:]...), &ConfigDeprecation{DeprecatedConfigKey: key}
+			}
+		}
+		tree[id].Key = key.Replacement
+		if value, ok := item.Value.(string); ok && key.Convert != nil {
+			tree[id].Value = key.Convert(value)
+		}
+		return tree, &ConfigDeprecation{DeprecatedConfigKey: key, Mapped: true}
+	}
+	return tree, nil
+}
+
+// Log config version mismatch and deprecated keys used in config.
+func LogConfigWarnings(mainConfig MainCfgYAML, logger *zap.Logger) {
+	switch {
+	case mainConfig.ConfigVersion == 0:
+		logger.Info(fmt.Sprintf("ConfigVersion not set, current version is %v", CurrentConfigVersion))
+	case mainConfig.ConfigVersion > CurrentConfigVersion:
+		logger.Warn(
+			"Config version is newer than supported by program",
+			zap.Int("configVersion", mainConfig.ConfigVersion),
+			zap.Int("supportedVersion", CurrentConfigVersion),
+		)
+	}
+	for _, deprecation := range mainConfig.deprecations {
+		logger.Warn(
+			"Deprecated config key used",
+			zap.String("key", deprecation.Key),
+			zap.String("replacement", deprecation.Replacement),
+			zap.Int("deprecatedSince", deprecation.Since),
+			zap.Bool("mapped", deprecation.Mapped),
+		)
+	}
+}
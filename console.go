@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// Configure console messages according to command line flags. Log file not affected.
+// Quiet mode silence console. Plain mode write messages into stdout without timestamps
+// for deployment frameworks parsing captured output. Messages order not changed.
+func ConfigureConsole() {
+	switch {
+	case *quietFlag:
+		log.SetOutput(ioutil.Discard)
+	case plainFlag:
+		log.SetOutput(os.Stdout)
+		log.SetFlags(0)
+	}
+}
+
+// Print fatal error and exit with failure code. Error printed into stderr even in quiet mode,
+// so run never fails silently.
+func ExitWithError(v ...interface{}) {
+	if *quietFlag {
+		fmt.Fprintln(os.Stderr, v...)
+	} else {
+		log.Println(v...)
+		log.Println("Program exited")
+	}
+	os.Exit(ExitCodeFailed)
+}
+
+// Print failed run result into stderr in quiet mode, other output silenced.
+func PrintQuietFailure(summary RunSummary) {
+	if !*quietFlag || summary.Result != RunResultFailed {
+		return
+	}
+	if summary.Phase == "" {
+		fmt.Fprintln(os.Stderr, "Run failed -", summary.Error)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Run failed in phase '%v' - %v\n", summary.Phase, summary.Error)
+}
+
+// Print final run result in plain mode for deployment frameworks parsing stdout.
+func PrintPlainResult(summary RunSummary) {
+	if !plainFlag || *quietFlag {
+		return
+	}
+	fmt.Println("Result:", summary.Result)
+	if summary.Result != RunResultSuccess {
+		fmt.Println("Phase:", summary.Phase)
+		fmt.Println("Error:", summary.Error)
+	}
+	fmt.Println("Exit code:", summary.ExitCode())
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	CoordinationStagedFolder        string        = "staged"         // Subfolder of coordination folder for staged reports.
+	CoordinationGateFile            string        = "release"        // Release gate file in coordination folder.
+	CoordinationDefaultTimeout      time.Duration = 4 * time.Hour    // Wait for release gate by default.
+	CoordinationDefaultPollInterval time.Duration = 30 * time.Second // Release gate check interval by default.
+	CoordinationRequestTimeout      time.Duration = 10 * time.Second // Timeout of single request to coordination endpoint.
+)
+
+// Report of machine which collected files to deploy and waits for release gate before changing WDE folder.
+type StagedReport struct {
+	Hostname       string    `json:"hostname"`
+	ProgramVersion string    `json:"programVersion"`
+	StagedTime     time.Time `json:"stagedTime"`
+	Release        string    `json:"release"`            // Release key gate must be opened for.
+	Files          int       `json:"files"`              // Files to deploy into WDE folder.
+	Manifest       string    `json:"manifest,omitempty"` // Pinned manifest if used.
+}
+
+// Return key of staged release: release version if known, otherwise SHA-256 of deployed file set,
+// so gate opened for one release never releases other file set.
+func StagedReleaseKey(release ReleaseInfo, files []CustomisationFile) string {
+	if release.Version != "" {
+		return release.Version
+	}
+	fileSet := make(map[string]string, len(files))
+	for _, file := range files {
+		fileSet[strings.ToLower(filepath.Join(file.RelativePath, file.FileName))] = file.Hash
+	}
+	return fileSetHash(fileSet)
+}
+
+// Report "staged OK" into coordination folder or endpoint.
+// Folder report saved as "<Folder>\staged\<hostname>.json", endpoint report sent by POST to "<URL>/staged".
+func ReportStaged(mainConfig MainCfgYAML, report StagedReport) error {
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if mainConfig.Coordination.Folder != "" {
+		reportFullPath := filepath.Join(mainConfig.Coordination.Folder, CoordinationStagedFolder, fmt.Sprint(report.Hostname, ".json"))
+		err = SaveBytesIntoFile(reportFullPath, reportBytes)
+		if err != nil {
+			return err
+		}
+	}
+	if mainConfig.Coordination.URL != "" {
+		client := http.Client{Timeout: CoordinationRequestTimeout}
+		response, err := client.Post(coordinationEndpoint(mainConfig, "staged"), "application/json", bytes.NewReader(reportBytes))
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		if response.StatusCode >= 300 {
+			return fmt.Errorf("coordination endpoint response status - %v", response.Status)
+		}
+	}
+	return nil
+}
+
+// Check release gate of release key. Gate file in coordination folder must contain release key
+// and GET "<URL>/gate" must return 200 with release key in body if endpoint configured.
+// Gate opened for other release treated as closed.
+func IsReleaseGateOpen(mainConfig MainCfgYAML, releaseKey string) (bool, error) {
+	if mainConfig.Coordination.Folder != "" {
+		gateBytes, err := ioutil.ReadFile(filepath.Join(mainConfig.Coordination.Folder, CoordinationGateFile))
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if strings.TrimSpace(string(gateBytes)) != releaseKey {
+			return false, nil
+		}
+	}
+	if mainConfig.Coordination.URL != "" {
+		client := http.Client{Timeout: CoordinationRequestTimeout}
+		response, err := client.Get(coordinationEndpoint(mainConfig, "gate"))
+		if err != nil {
+			return false, err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return false, nil
+		}
+		gateBytes, err := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+		if err != nil {
+			return false, err
+		}
+		if strings.TrimSpace(string(gateBytes)) != releaseKey {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Wait until release gate opened for release key. Check errors logged and treated as closed gate.
+// Return error if gate not opened within timeout.
+func WaitReleaseGate(mainConfig MainCfgYAML, releaseKey string, timeout, pollInterval time.Duration, logger *zap.Logger) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		open, err := IsReleaseGateOpen(mainConfig, releaseKey)
+		if err != nil {
+			logger.Warn(fmt.Sprint("Can't check release gate - ", err))
+		}
+		if open {
+			return nil
+		}
+		if time.Now().Add(pollInterval).After(deadline) {
+			return fmt.Errorf("release gate of '%v' not opened within %v", releaseKey, timeout)
+		}
+		logger.Debug(fmt.Sprintf("Release gate closed, next check in %v", pollInterval))
+		time.Sleep(pollInterval)
+	}
+}
+
+// Parse timeout and poll interval of coordination from config or return defaults.
+func CoordinationDurations(mainConfig MainCfgYAML) (time.Duration, time.Duration, error) {
+	timeout, pollInterval := CoordinationDefaultTimeout, CoordinationDefaultPollInterval
+	var err error
+	if mainConfig.Coordination.Timeout != "" {
+		timeout, err = time.ParseDuration(mainConfig.Coordination.Timeout)
+		if err != nil {
+			return 0, 0, fmt.Errorf("can't parse Coordination.Timeout - %v", err)
+		}
+	}
+	if mainConfig.Coordination.PollInterval != "" {
+		pollInterval, err = time.ParseDuration(mainConfig.Coordination.PollInterval)
+		if err != nil {
+			return 0, 0, fmt.Errorf("can't parse Coordination.PollInterval - %v", err)
+		}
+	}
+	return timeout, pollInterval, nil
+}
+
+func coordinationEndpoint(mainConfig MainCfgYAML, name string) string {
+	return fmt.Sprint(strings.TrimSuffix(mainConfig.Coordination.URL, "/"), "/", name)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Policies for Copy.OnFileError option.
+const (
+	CopyOnErrorFailFast string = "fail-fast"           // Abort run on first failed file (default).
+	CopyOnErrorContinue string = "continue-and-report" // Copy remaining files, deploy without failed ones, run result "partial".
+)
+
+const (
+	DefaultCopyEngine           string = "native" // Copy engine used if not configured.
+	DefaultLargeFileThresholdMB int64  = 100      // Files from this size copied by large file engine if available.
+)
+
+// Called by copy engine while file copied. Total is -1 if engine not report sizes.
+type CopyProgress func(copied, total int64)
+
+// Method of copy single file into WDE folder.
+// New engines added by implementing interface and registering in NewCopyEngine.
+type CopyEngine interface {
+	Name() string
+	// Copy source file into target file, overwriting existing one.
+	// Progress may be nil, engines without progress support ignore it.
+	Copy(source, target string, progress CopyProgress) error
+}
+
+// Settings of customisation files copy into WDE folder.
+type CopyOptions struct {
+	Engine             CopyEngine
+	LargeFileEngine    CopyEngine // Used for files from LargeFileThreshold bytes if set.
+	LargeFileThreshold int64
+	CloseProcesses     []string        // Processes allowed to close if they lock files.
+	Progress           *ProgressStream // Receive copy progress events, may be nil.
+	StripStreams       string          // Alternate data streams removed from copied files, see StripStreams* constants.
+	Attributes         string          // Attributes handling of copied files, see Attributes* constants.
+	VerifyHash         bool            // Compare hash of copied file with CustomisationFile.Hash, which scanner verdict and manifest refer to.
+	ContinueOnError    bool            // Copy remaining files if one failed.
+	Audit              *AuditLog       // Record copied files, may be nil.
+}
+
+// Prepare copy options from config.
+func NewCopyOptions(mainConfig MainCfgYAML, progress *ProgressStream) (CopyOptions, error) {
+	engine, err := NewCopyEngine(mainConfig.Copy.Engine)
+	if err != nil {
+		return CopyOptions{}, fmt.Errorf("can't select copy engine '%v' - %v", mainConfig.Copy.Engine, err)
+	}
+	stripStreams, attributes, err := ValidateNTFSOptions(mainConfig.Copy.StripStreams, mainConfig.Copy.Attributes)
+	if err != nil {
+		return CopyOptions{}, err
+	}
+	switch mainConfig.Copy.OnFileError {
+	case "", CopyOnErrorFailFast, CopyOnErrorContinue:
+	default:
+		return CopyOptions{}, fmt.Errorf("unknown Copy.OnFileError policy '%v'", mainConfig.Copy.OnFileError)
+	}
+	thresholdMB := DefaultLargeFileThresholdMB
+	if mainConfig.Copy.LargeFileThresholdMB > 0 {
+		thresholdMB = mainConfig.Copy.LargeFileThresholdMB
+	}
+	return CopyOptions{
+		Engine:             engine,
+		LargeFileEngine:    NewLargeFileCopyEngine(),
+		LargeFileThreshold: thresholdMB * 1024 * 1024,
+		CloseProcesses:     mainConfig.FileLocks.CloseProcesses,
+		Progress:           progress,
+		StripStreams:       stripStreams,
+		Attributes:         attributes,
+		VerifyHash:         true,
+		ContinueOnError:    mainConfig.Copy.OnFileError == CopyOnErrorContinue,
+	}, nil
+}
+
+// Return engine for file of provided size.
+func (co CopyOptions) EngineFor(size int64) CopyEngine {
+	if co.LargeFileEngine != nil && size >= co.LargeFileThreshold {
+		return co.LargeFileEngine
+	}
+	return co.Engine
+}
+
+// Return copy engine by name from config: "native", "copyfile", "robocopy" or "cmd".
+func NewCopyEngine(name string) (CopyEngine, error) {
+	switch strings.ToLower(name) {
+	case "", DefaultCopyEngine:
+		return NativeCopyEngine{}, nil
+	}
+	return NewPlatformCopyEngine(strings.ToLower(name))
+}
+
+// Streaming copy by Go runtime, available on all platforms.
+type NativeCopyEngine struct{}
+
+func (NativeCopyEngine) Name() string {
+	return DefaultCopyEngine
+}
+
+func (NativeCopyEngine) Copy(source, target string, progress CopyProgress) error {
+	nBytes, err := copyFile(source, target)
+	if err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(nBytes, nBytes)
+	}
+	return nil
+}
+
+// Check that file copied by external tool exists.
+func checkCopiedFile(target string) error {
+	_, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("copied file not found - %v", err)
+	}
+	return nil
+}
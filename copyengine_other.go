@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+// Only native copy engine available outside Windows.
+func NewPlatformCopyEngine(name string) (CopyEngine, error) {
+	return nil, ErrNotSupportedOnPlatform
+}
+
+// Large files copied by configured engine outside Windows.
+func NewLargeFileCopyEngine() CopyEngine {
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+const (
+	progressContinue     = 0      // PROGRESS_CONTINUE
+	copyFileNoBuffering  = 0x1000 // COPY_FILE_NO_BUFFERING
+	robocopyFailExitCode = 8      // Robocopy exit codes from 8 mean at least one failure.
+)
+
+var (
+	kernel32         = windows.NewLazySystemDLL("kernel32.dll")
+	procCopyFileExW  = kernel32.NewProc("CopyFileExW")
+	currentProgress  CopyProgress
+	copyProgressLock sync.Mutex
+	// Created once, because number of callbacks created by windows.NewCallback is limited.
+	copyProgressCallback = windows.NewCallback(func(totalSize, transferred, streamSize, streamTransferred, streamNumber, reason, sourceFile, targetFile, data uintptr) uintptr {
+		if currentProgress != nil {
+			currentProgress(int64(transferred), int64(totalSize))
+		}
+		return progressContinue
+	})
+)
+
+// Return Windows specific copy engine by name.
+func NewPlatformCopyEngine(name string) (CopyEngine, error) {
+	switch name {
+	case "copyfile":
+		return CopyFileExEngine{}, nil
+	case "robocopy":
+		return RobocopyEngine{}, nil
+	case "cmd":
+		return CmdCopyEngine{}, nil
+	}
+	return nil, fmt.Errorf("unknown copy engine '%v'", name)
+}
+
+// Return CopyFileExW engine with unbuffered IO, which is faster for large files.
+func NewLargeFileCopyEngine() CopyEngine {
+	return CopyFileExEngine{Flags: copyFileNoBuffering}
+}
+
+// Copy by CopyFileExW with progress callback.
+type CopyFileExEngine struct {
+	Flags uint32 // COPY_FILE_* flags.
+}
+
+func (CopyFileExEngine) Name() string {
+	return "copyfile"
+}
+
+func (engine CopyFileExEngine) Copy(source, target string, progress CopyProgress) error {
+	sourcePtr, err := windows.UTF16PtrFromString(source)
+	if err != nil {
+		return err
+	}
+	targetPtr, err := windows.UTF16PtrFromString(target)
+	if err != nil {
+		return err
+	}
+	copyProgressLock.Lock()
+	defer copyProgressLock.Unlock()
+	currentProgress = progress
+	defer func() { currentProgress = nil }()
+	ret, _, err := procCopyFileExW.Call(
+		uintptr(unsafe.Pointer(sourcePtr)),
+		uintptr(unsafe.Pointer(targetPtr)),
+		copyProgressCallback,
+		0,
+		0,
+		uintptr(engine.Flags),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+// Copy by robocopy. Robocopy keep file name, so file with other target name copied into
+// temporary folder next to target and renamed, existing file with source name never touched.
+type RobocopyEngine struct{}
+
+func (RobocopyEngine) Name() string {
+	return "robocopy"
+}
+
+func (RobocopyEngine) Copy(source, target string, progress CopyProgress) error {
+	sourceName := filepath.Base(source)
+	targetDirectory := filepath.Dir(target)
+	if !strings.EqualFold(sourceName, filepath.Base(target)) {
+		tempDirectory, err := ioutil.TempDir(targetDirectory, "robocopy")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tempDirectory)
+		targetDirectory = tempDirectory
+	}
+	command := exec.Command("robocopy", filepath.Dir(source), targetDirectory, sourceName, "/IS", "/IT", "/R:0", "/W:0", "/NJH", "/NJS", "/NP")
+	command.SysProcAttr = HiddenWindowProcAttr()
+	output, err := command.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() < robocopyFailExitCode {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("robocopy failed - %v: %v", err, strings.TrimSpace(string(output)))
+	}
+	copied := filepath.Join(targetDirectory, sourceName)
+	if !strings.EqualFold(copied, target) {
+		err = os.Rename(copied, target)
+		if err != nil {
+			return err
+		}
+	}
+	return checkCopiedFile(target)
+}
+
+// Copy by "copy" command of cmd.
+type CmdCopyEngine struct{}
+
+func (CmdCopyEngine) Name() string {
+	return "cmd"
+}
+
+func (CmdCopyEngine) Copy(source, target string, progress CopyProgress) error {
+	command := exec.Command("cmd", "/C", "copy", "/Y", source, target)
+	command.SysProcAttr = HiddenWindowProcAttr()
+	err := command.Run()
+	if err != nil {
+		return fmt.Errorf("command '%v' failed - %v", command, err)
+	}
+	return checkCopiedFile(target)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+const DefaultCustomFilesWarnSizeKB int = 512 // Size of "CustomFiles" registry value reported by warning.
+
+// Orders for CustomFiles.Order option.
+const (
+	CustomFilesOrderCollection string = "collection" // Order of files collection, may differ between machines (default).
+	CustomFilesOrderPath       string = "path"       // Sorted by RelativePath and FileName, case insensitive.
+	CustomFilesOrderPrevious   string = "previous"   // Order of previous "CustomFiles" value, new files sorted by path at the end.
+)
+
+// Return parsed entries of "CustomFiles" value or ErrCustomFilesNotFound.
+func (rvs RegistryValues) CustomFilesEntries() ([]CustomisationFile, error) {
+	for _, value := range rvs {
+		if value.Name == "CustomFiles" {
+			return ParseOldCustomFilesValue([]byte(value.Data))
+		}
+	}
+	return nil, ErrCustomFilesNotFound
+}
+
+// Check CustomFiles.Mode, Order, Merge and OptionsSource values, so config typo found before anything changed.
+func ValidateCustomFilesConfig(mainConfig MainCfgYAML) error {
+	options := mainConfig.CustomFiles
+	switch options.Mode {
+	case "", CustomFilesRebuild, CustomFilesIncremental:
+	default:
+		return fmt.Errorf("unknown CustomFiles.Mode '%v'", options.Mode)
+	}
+	switch options.Order {
+	case "", CustomFilesOrderCollection, CustomFilesOrderPath, CustomFilesOrderPrevious:
+	default:
+		return fmt.Errorf("unknown CustomFiles.Order '%v'", options.Order)
+	}
+	switch options.Merge {
+	case "", CustomFilesMergeTwoWay, CustomFilesMergeThreeWay:
+	default:
+		return fmt.Errorf("unknown CustomFiles.Merge '%v'", options.Merge)
+	}
+	switch options.OptionsSource {
+	case "", CustomFilesOptionsSnapshot, CustomFilesOptionsLive:
+	default:
+		return fmt.Errorf("unknown CustomFiles.OptionsSource '%v'", options.OptionsSource)
+	}
+	return nil
+}
+
+// Return copy of files list in configured order. Previous entries used only by "previous" order.
+func OrderCustomFiles(files []CustomisationFile, order string, previous []CustomisationFile) ([]CustomisationFile, error) {
+	ordered := make([]CustomisationFile, len(files))
+	copy(ordered, files)
+	switch order {
+	case "", CustomFilesOrderCollection:
+		return ordered, nil
+	case CustomFilesOrderPath:
+		sort.SliceStable(ordered, func(i, j int) bool { return customFileSortKey(ordered[i]) < customFileSortKey(ordered[j]) })
+		return ordered, nil
+	case CustomFilesOrderPrevious:
+		position := make(map[string]int, len(previous))
+		for id, file := range previous {
+			if _, ok := position[customFileSortKey(file)]; !ok {
+				position[customFileSortKey(file)] = id
+			}
+		}
+		sort.SliceStable(ordered, func(i, j int) bool {
+			iPosition, iKnown := position[customFileSortKey(ordered[i])]
+			jPosition, jKnown := position[customFileSortKey(ordered[j])]
+			switch {
+			case iKnown && jKnown:
+				return iPosition < jPosition
+			case iKnown != jKnown:
+				return iKnown
+			}
+			return customFileSortKey(ordered[i]) < customFileSortKey(ordered[j])
+		})
+		return ordered, nil
+	}
+	return nil, fmt.Errorf("unknown CustomFiles.Order '%v'", order)
+}
+
+// Key of file for ordering, RelativePath and FileName in lower case.
+func customFileSortKey(file CustomisationFile) string {
+	return strings.ToLower(filepath.ToSlash(filepath.Join(file.RelativePath, file.FileName)))
+}
+
+// Attributes required in every ApplicationFile entry of "CustomFiles".
+var customFilesRequiredAttributes = []string{"FileName", "RelativePath", "DataFile", "EntryPoint", "IsMainConfigFile", "Optional", "GroupName"}
+
+// Attributes of ApplicationFile entry with "true" or "false" value.
+var customFilesBoolAttributes = []string{"DataFile", "EntryPoint", "IsMainConfigFile", "Optional"}
+
+// Check "CustomFiles" value if present. Return list of problems, empty if value valid.
+func (rvs RegistryValues) ValidateCustomFiles() []string {
+	for _, value := range rvs {
+		if value.Name == "CustomFiles" {
+			return ValidateCustomFilesXML(value.Data)
+		}
+	}
+	return nil
+}
+
+// Check "CustomFiles" XML: well-formed ArrayOfApplicationFile with ApplicationFile entries only,
+// all required attributes present, flags are "true" or "false", FileName and RelativePath pairs unique.
+// Return list of problems, empty if value valid.
+func ValidateCustomFilesXML(data string) []string {
+	decoder := xml.NewDecoder(strings.NewReader(data))
+	decoder.CharsetReader = IdentReader
+	problems := make([]string, 0)
+	seen := make(map[string]int)
+	root, depth, entry := false, 0, 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return append(problems, fmt.Sprintf("malformed XML - %v", err))
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 1 && element.Name.Local == "ArrayOfApplicationFile":
+				root = true
+			case depth == 2 && element.Name.Local == "ApplicationFile":
+				entry++
+				problems = append(problems, checkApplicationFile(entry, element.Attr, seen)...)
+			default:
+				problems = append(problems, fmt.Sprintf("unexpected element '%v' at depth %v", element.Name.Local, depth))
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if !root {
+		problems = append(problems, "root element ArrayOfApplicationFile not found")
+	}
+	return problems
+}
+
+// Check attributes of one ApplicationFile entry. Seen map collect entry numbers by path for duplicates check.
+func checkApplicationFile(entry int, attributes []xml.Attr, seen map[string]int) []string {
+	values := make(map[string]string, len(attributes))
+	for _, attribute := range attributes {
+		values[attribute.Name.Local] = attribute.Value
+	}
+	path := filepath.Join(values["RelativePath"], values["FileName"])
+	problems := make([]string, 0)
+	for _, name := range customFilesRequiredAttributes {
+		if _, ok := values[name]; !ok {
+			problems = append(problems, fmt.Sprintf("entry %v '%v' has no attribute %v", entry, path, name))
+		}
+	}
+	for _, name := range customFilesBoolAttributes {
+		if value, ok := values[name]; ok && !strings.EqualFold(value, "true") && !strings.EqualFold(value, "false") {
+			problems = append(problems, fmt.Sprintf("entry %v '%v' has %v '%v', expected true or false", entry, path, name, value))
+		}
+	}
+	if values["FileName"] == "" {
+		problems = append(problems, fmt.Sprintf("entry %v has empty FileName", entry))
+		return problems
+	}
+	key := strings.ToLower(filepath.ToSlash(path))
+	if first, ok := seen[key]; ok {
+		problems = append(problems, fmt.Sprintf("entry %v '%v' duplicates entry %v", entry, path, first))
+	} else {
+		seen[key] = entry
+	}
+	return problems
+}
+
+// Return size of string stored as REG_SZ value: UTF-16 with terminating zero.
+func RegistryStringSize(data string) int {
+	return (len(utf16.Encode([]rune(data))) + 1) * 2
+}
+
+// Rewrite "CustomFiles" value without line breaks and indentation.
+// All attributes kept, Deployment Manager defaults for omitted attributes not documented.
+func (rvs *RegistryValues) CompactCustomFiles() error {
+	for id, value := range *rvs {
+		if value.Name != "CustomFiles" {
+			continue
+		}
+		decoder := xml.NewDecoder(strings.NewReader(value.Data))
+		decoder.CharsetReader = IdentReader
+		var result strings.Builder
+		result.WriteString(strings.TrimSuffix(RegFilesHeadXML, "\n"))
+		for {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			element, ok := token.(xml.StartElement)
+			if !ok || element.Name.Local != "ApplicationFile" {
+				continue
+			}
+			result.WriteString("<ApplicationFile")
+			for _, attribute := range element.Attr {
+				result.WriteString(fmt.Sprintf(" %v=\"", attribute.Name.Local))
+				xml.EscapeText(&result, []byte(attribute.Value))
+				result.WriteString("\"")
+			}
+			result.WriteString(" />")
+		}
+		result.WriteString(RegFilesEndingXML)
+		(*rvs)[id].Data = result.String()
+	}
+	return nil
+}
+
+// Compact "CustomFiles" value if configured and warn if it larger than configured size.
+func CheckCustomFilesSize(registryData *RegistryValues, mainConfig MainCfgYAML, events *HistoryEvents, logger *zap.Logger) error {
+	if mainConfig.CustomFiles.Compact {
+		err := registryData.CompactCustomFiles()
+		if err != nil {
+			return fmt.Errorf("can't compact \"CustomFiles\" value - %v", err)
+		}
+	}
+	warnSizeKB := DefaultCustomFilesWarnSizeKB
+	if mainConfig.CustomFiles.WarnSizeKB > 0 {
+		warnSizeKB = mainConfig.CustomFiles.WarnSizeKB
+	}
+	for _, value := range *registryData {
+		if value.Name != "CustomFiles" {
+			continue
+		}
+		size := RegistryStringSize(value.Data)
+		logger.Info(fmt.Sprintf("\"CustomFiles\" value size %v bytes", size))
+		if size > warnSizeKB*1024 {
+			logger.Warn(fmt.Sprintf("\"CustomFiles\" value size %v KB exceeds %v KB, registry value limits may be reached, consider CustomFiles.Compact", size/1024, warnSizeKB))
+			events.Add("CustomFiles value size %v KB exceeds %v KB", size/1024, warnSizeKB)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Merges for CustomFiles.Merge option.
+const (
+	CustomFilesMergeTwoWay   string = "two-way"   // Entries and options of saved registry data only (default).
+	CustomFilesMergeThreeWay string = "three-way" // Saved data as base, changes made in live registry since snapshot kept.
+)
+
+// Sources for CustomFiles.OptionsSource option, used by two-way merge.
+const (
+	CustomFilesOptionsSnapshot string = "snapshot" // Manual options from latest saved registry data (default).
+	CustomFilesOptionsLive     string = "live"     // Manual options from live "CustomFiles" value, read every run.
+)
+
+// Merge "CustomFiles" entries of saved snapshot (base) and live registry, deployed files used to report conflicts.
+// Options of live entries win, as snapshot options changed only by updater. Entries removed in live
+// registry while file still deployed restored from base. Return merged entries (live order, restored
+// entries at the end), live changes kept and conflicts, edit of one side lost or overridden.
+func MergeCustomFilesThreeWay(base, live, deployed []CustomisationFile) ([]CustomisationFile, []string, []string) {
+	key := func(file CustomisationFile) string { return filepath.Join(file.RelativePath, file.FileName) }
+	baseFiles := make(map[string]CustomisationFile, len(base))
+	for _, file := range base {
+		baseFiles[key(file)] = file
+	}
+	liveFiles := make(map[string]bool, len(live))
+	for _, file := range live {
+		liveFiles[key(file)] = true
+	}
+	deployedFiles := make(map[string]bool, len(deployed))
+	for _, file := range deployed {
+		deployedFiles[key(file)] = true
+	}
+
+	merged := make([]CustomisationFile, 0, len(live)+len(base))
+	kept := make([]string, 0)
+	conflicts := make([]string, 0)
+	for _, file := range live {
+		baseFile, inBase := baseFiles[key(file)]
+		switch {
+		case !inBase && !deployedFiles[key(file)]:
+			conflicts = append(conflicts, fmt.Sprintf("'%v' added in live registry, but file not deployed, entry dropped", key(file)))
+		case !inBase:
+			kept = append(kept, fmt.Sprintf("'%v' added in live registry", key(file)))
+		case customFileOptions(file) != customFileOptions(baseFile):
+			kept = append(kept, fmt.Sprintf("'%v' options changed in live registry: %v -> %v", key(file), customFileOptions(baseFile), customFileOptions(file)))
+		}
+		merged = append(merged, file)
+	}
+	for _, file := range base {
+		if liveFiles[key(file)] || !deployedFiles[key(file)] {
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprintf("'%v' removed in live registry, but file still deployed, entry restored from saved data", key(file)))
+		merged = append(merged, file)
+		liveFiles[key(file)] = true
+	}
+	return merged, kept, conflicts
+}
+
+// Manually set options of "CustomFiles" entry in readable form.
+func customFileOptions(file CustomisationFile) string {
+	return fmt.Sprintf("DataFile=%v EntryPoint=%v IsMainConfigFile=%v Optional=%v GroupName=%v",
+		file.DataFile, file.EntryPoint, file.IsMainConfigFile, file.Optional, file.GroupName)
+}
@@ -3,17 +3,18 @@ package main
 import (
 	"errors"
 	"fmt"
-	"github.com/gonutz/w32"
 	"go.uber.org/zap"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 )
 
+const CopyTempFileSuffix string = ".wdeu-tmp" // Suffix of temporary file copied next to target before it replaced.
+
 // Store file version in decimal.
 type FileVersion struct {
 	full uint64
@@ -27,16 +28,24 @@ type FileVersion struct {
 // and propagate values for fill "CustomFiles" registry key.
 // Also used for parse data from previously saved "CustomFiles" registry key.
 type CustomisationFile struct {
-	FileName         string      `xml:"FileName,attr"`         // For registry key. File name.
-	RelativePath     string      `xml:"RelativePath,attr"`     // For registry key. Relative path. Contains relative file directory
-	DataFile         string      `xml:"DataFile,attr"`         // For registry key. By default "false". Can be "true" (not implemented).
-	EntryPoint       string      `xml:"EntryPoint,attr"`       // For registry key. By default "false". Can be "true" (not implemented).
-	IsMainConfigFile string      `xml:"IsMainConfigFile,attr"` // For registry key. By default "false". Can be "true" (not implemented).
-	Optional         string      `xml:"Optional,attr"`         // For registry key. By default "false". Can be "true" (not implemented).
-	GroupName        string      `xml:"GroupName,attr"`        // For registry key. Can be custom, also can be empty.
-	SourcePath       string      // Full path to source file.
-	LastWriteTime    time.Time   // Last write time for current file.
-	Version          FileVersion // Version of file. If not collected use zero value.
+	FileName            string        `xml:"FileName,attr"`         // For registry key. File name.
+	RelativePath        string        `xml:"RelativePath,attr"`     // For registry key. Relative path. Contains relative file directory
+	DataFile            string        `xml:"DataFile,attr"`         // For registry key. By default "false". Can be "true" (not implemented).
+	EntryPoint          string        `xml:"EntryPoint,attr"`       // For registry key. By default "false". Can be "true" (not implemented).
+	IsMainConfigFile    string        `xml:"IsMainConfigFile,attr"` // For registry key. By default "false". Can be "true" (not implemented).
+	Optional            string        `xml:"Optional,attr"`         // For registry key. By default "false". Can be "true" (not implemented).
+	GroupName           string        `xml:"GroupName,attr"`        // For registry key. Can be custom, also can be empty.
+	SourcePath          string        // Full path to source file.
+	SourceFolder        string        // Customisation source folder which contains file.
+	Precedence          int           // Precedence of customisation source.
+	CustomisationFolder string        // Customisation folder which contains file.
+	Hash                string        // SHA-256 of file content.
+	Size                int64         // File size in bytes.
+	StagedPath          string        // Path of file copy in local cache. Used for copy instead of SourcePath if set.
+	CopyDuration        time.Duration // Time spent on copy into WDE folder.
+	CopyStatus          FileStatus    // Result of copy into WDE folder, empty if copy not attempted.
+	LastWriteTime       time.Time     // Last write time for current file.
+	Version             FileVersion   // Version of file. If not collected use zero value.
 }
 
 // Implement methods needed by sort.Sort() for custom sort rules.
@@ -55,7 +64,7 @@ func (fis FileInfoSlice) Swap(i, j int) {
 	fis[i], fis[j] = fis[j], fis[i]
 }
 
-// Get all folders in specified directory.
+// Get all folders in specified directory. Hidden and dot-prefixed folders, like ".git" of Git source, skipped.
 func GetCustomisationFoldersList(directory string) ([]string, error) {
 	entries, err := ioutil.ReadDir(directory)
 	if err != nil {
@@ -70,6 +79,7 @@ func GetCustomisationFoldersList(directory string) ([]string, error) {
 			return nil, err
 		}
 		switch mode := fileInfo.Mode(); {
+		case strings.HasPrefix(entryName, ".") || IsHiddenFile(fileInfo):
 		case mode.IsDir():
 			foldersList = append(foldersList, entryName)
 		default:
@@ -82,17 +92,31 @@ func GetCustomisationFoldersList(directory string) ([]string, error) {
 }
 
 // Collect customisation files from provided directory and all subfolders.
-// For each fined file extract all possible CustomisationFile values.
-func CollectCustomisationFiles(path, basePath string) ([]CustomisationFile, error) {
+// Control files in root of base path (like directory manifest) and files excluded by its ".wdeignore" skipped.
+// For each fined file extract all possible CustomisationFile values, unchanged files taken from scan cache if provided.
+func CollectCustomisationFiles(path, basePath string, cache *ScanCache) ([]CustomisationFile, error) {
+	ignoreRules, err := ReadIgnoreFile(basePath)
+	if err != nil {
+		return nil, err
+	}
 	collectedFiles := make([]CustomisationFile, 0, 16)
-	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if IsControlFile(path, basePath) {
+			return nil
+		}
+		if relativePath, err := filepath.Rel(basePath, path); err == nil && relativePath != "." && ignoreRules.Match(relativePath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		if info.IsDir() {
 			return nil
 		}
-		extractedInfo, err := ExtractCustomFileInfo(info, path, basePath)
+		extractedInfo, err := cache.Extract(info, path, basePath)
 		if err != nil {
 			return err
 		}
@@ -108,6 +132,16 @@ func CollectCustomisationFiles(path, basePath string) ([]CustomisationFile, erro
 // Extract all possible CustomisationFile values from provided file info
 // and fill other data with default values.
 func ExtractCustomFileInfo(fileInfo os.FileInfo, fullPath, basePath string) (CustomisationFile, error) {
+	fileVersion, _ := GetFileVersion(fullPath)
+	hash, err := HashFile(fullPath)
+	if err != nil {
+		return CustomisationFile{}, err
+	}
+	return NewCustomFileInfo(fileInfo, fullPath, basePath, fileVersion, hash)
+}
+
+// Fill CustomisationFile values from file info with provided version and hash.
+func NewCustomFileInfo(fileInfo os.FileInfo, fullPath, basePath string, fileVersion FileVersion, hash string) (CustomisationFile, error) {
 	relativePath, err := filepath.Rel(basePath, fullPath)
 	if err != nil {
 		return CustomisationFile{}, err
@@ -116,7 +150,6 @@ func ExtractCustomFileInfo(fileInfo os.FileInfo, fullPath, basePath string) (Cus
 	if relativePath == "." {
 		relativePath = ""
 	}
-	fileVersion, err := GetFileVersion(fullPath)
 	return CustomisationFile{
 		FileName:         fileInfo.Name(),
 		RelativePath:     relativePath,
@@ -127,37 +160,18 @@ func ExtractCustomFileInfo(fileInfo os.FileInfo, fullPath, basePath string) (Cus
 		GroupName:        "",
 		SourcePath:       fullPath,
 		LastWriteTime:    fileInfo.ModTime(),
+		Size:             fileInfo.Size(),
 		Version:          fileVersion,
+		Hash:             hash,
 	}, nil
 }
 
-// Get file version from file info. Typically for .dll.
-func GetFileVersion(path string) (FileVersion, error) {
-	size := w32.GetFileVersionInfoSize(path)
-	if size <= 0 {
-		return FileVersion{}, ErrVersionNotExist
-	}
-	info := make([]byte, size)
-	ok := w32.GetFileVersionInfo(path, info)
-	if !ok {
-		return FileVersion{}, ErrVersionNotExist
-	}
-	fixed, ok := w32.VerQueryValueRoot(info)
-	if !ok {
-		return FileVersion{}, ErrVersionNotExist
-	}
-	version := fixed.FileVersion()
-	v1 := version & 0xFFFF000000000000 >> 48
-	v2 := version & 0x0000FFFF00000000 >> 32
-	v3 := version & 0x00000000FFFF0000 >> 16
-	v4 := version & 0x000000000000FFFF >> 0
-	return FileVersion{version, v1, v2, v3, v4}, nil
-}
-
 // Sort out all redundant files and older if present two or more files with equal FileName and RelativePath.
-func ValidateCollectedFiles(list []CustomisationFile, redundantCFG []string, logger *zap.Logger) ([]CustomisationFile, []string) {
+// Files violating file-type policy blocked.
+// Newer file chosen by provided strategy. If strategy report conflict, file from folder later in sort order used.
+func ValidateCollectedFiles(list []CustomisationFile, redundantCFG []string, policy FilePolicy, strategy CompareStrategy, logger *zap.Logger) ([]CustomisationFile, []FileStatus) {
 	listLength := len(list)
-	statuses := make([]string, listLength)
+	statuses := make([]FileStatus, listLength)
 	resultList := make([]CustomisationFile, 0, listLength)
 	redundancyRegexps := make([]*regexp.Regexp, 0, 16)
 
@@ -175,35 +189,72 @@ func ValidateCollectedFiles(list []CustomisationFile, redundantCFG []string, log
 	logger.Debug(fmt.Sprintf("redundant regexp mandatory - '%+v'", `(?i)readme`))
 	logger.Debug(fmt.Sprintf("redundant regexp mandatory - '%+v'", `(?i)\.pdb$`))
 	logger.Debug(fmt.Sprintf("redundant regexp mandatory - '%+v'", `(?i)\.md$`))
+	logger.Debug(fmt.Sprintf("redundant regexp mandatory - '%+v'", `(?i)^version\.txt$`))
 	redundancyRegexps = append(redundancyRegexps, regexp.MustCompile(`(?i)readme`))
 	redundancyRegexps = append(redundancyRegexps, regexp.MustCompile(`(?i)\.pdb$`))
 	redundancyRegexps = append(redundancyRegexps, regexp.MustCompile(`(?i)\.md$`))
+	redundancyRegexps = append(redundancyRegexps, regexp.MustCompile(`(?i)^version\.txt$`))
 
 	for currentFileIndex, currentFile := range list {
 		if statuses[currentFileIndex] != "" {
 			continue
 		}
 		if CheckRedundancy(currentFile, redundancyRegexps) {
-			statuses[currentFileIndex] = "[REDUNDANT]"
+			statuses[currentFileIndex] = StatusRedundant
+			continue
+		}
+		if reason := policy.Check(currentFile); reason != "" {
+			statuses[currentFileIndex] = StatusBlocked
+			logger.Warn(fmt.Sprintf("File '%v' blocked by policy, %v", currentFile.SourcePath, reason))
+			continue
+		}
+		if policy.Protected(currentFile) {
+			statuses[currentFileIndex] = StatusProtected
+			logger.Warn(fmt.Sprintf("File '%v' not deployed, it would overwrite protected WDE file", currentFile.SourcePath))
 			continue
 		}
 		for compareFileIndex, compareFile := range list {
 			if statuses[compareFileIndex] != "" {
 				continue
 			}
-			if !(currentFile.FileName == compareFile.FileName && currentFile.RelativePath == compareFile.RelativePath) {
+			// WDE folder is case-insensitive, names differing only by case are the same file.
+			if !(strings.EqualFold(currentFile.FileName, compareFile.FileName) && strings.EqualFold(currentFile.RelativePath, compareFile.RelativePath)) {
 				continue
 			}
-			newFile := FindNewFile(currentFile, compareFile)
+			if currentFileIndex != compareFileIndex {
+				if currentFile.Hash == compareFile.Hash {
+					logger.Debug(fmt.Sprintf("Identical copies '%v' and '%v'", currentFile.SourcePath, compareFile.SourcePath))
+				} else {
+					logger.Info(fmt.Sprintf("Conflict, different content of '%v' and '%v'", currentFile.SourcePath, compareFile.SourcePath))
+				}
+			}
+			newFile := strategy(currentFile, compareFile)
+			loserStatus := StatusSkip
+			switch newFile {
+			case CompareConflict:
+				newFile = compareFolderOrder(currentFile, compareFile)
+				loserStatus = StatusConflict
+				logger.Error(fmt.Sprintf("Equal versions but different content of '%v' and '%v', file from folder later in sort order used",
+					currentFile.SourcePath, compareFile.SourcePath))
+			case CompareTieBreak:
+				newFile = compareFolderOrder(currentFile, compareFile)
+				chosen := currentFile
+				if newFile == "second" {
+					chosen = compareFile
+				}
+				logger.Info(fmt.Sprintf("Equal version %v.%v.%v.%v but different content of '%v' and '%v' (re-signed build), '%v' chosen by folder order",
+					currentFile.Version.v1, currentFile.Version.v2, currentFile.Version.v3, currentFile.Version.v4,
+					currentFile.SourcePath, compareFile.SourcePath, chosen.SourcePath))
+			}
 			if newFile == "second" {
-				statuses[currentFileIndex] = "[SKIP     ]"
+				statuses[currentFileIndex] = loserStatus
 				currentFile = compareFile
 				currentFileIndex = compareFileIndex
 				continue
 			}
-			statuses[compareFileIndex] = "[SKIP     ]"
+			statuses[compareFileIndex] = loserStatus
 		}
-		statuses[currentFileIndex] = "[COPIED   ]"
+		statuses[currentFileIndex] = StatusCopied
 		resultList = append(resultList, currentFile)
 	}
 	return resultList, statuses
@@ -219,54 +270,187 @@ func CheckRedundancy(file CustomisationFile, redundancyRegexps []*regexp.Regexp)
 	return false
 }
 
-// Compare two files and return which is newer.
+// Compare two files and return which is newer by version, then by last write time.
+// File from source with higher precedence always wins. Re-signed builds chosen by folder order.
 func FindNewFile(first, second CustomisationFile) string {
-	switch {
-	case first.Version.full > second.Version.full:
-		return "first"
-	case first.Version.full < second.Version.full:
-		return "second"
-	case first.LastWriteTime.After(second.LastWriteTime):
-		return "first"
-	case first.LastWriteTime.Before(second.LastWriteTime):
-		return "second"
-	}
-	return "equal"
+	if result := comparePrecedence(first, second); result != "equal" {
+		return result
+	}
+	if result := compareVersion(first, second); result != "equal" {
+		return result
+	}
+	if isResignedBuild(first, second) {
+		return CompareTieBreak
+	}
+	return compareLastWriteTime(first, second)
+}
+
+// Get WDE folder which receive customisation files.
+func WDETargetFolder(mainConfig MainCfgYAML) string {
+	return filepath.Join(mainConfig.WDEInstallationFolder, WDESubfolder)
 }
 
 // Copy customisation files, from custom folder into WDE folder  with save relative path.
 // Create subfolders if not exists.
-func CopyCustomisationFiles(list []CustomisationFile, targetDirectory string, logger *zap.Logger) error {
-	for _, file := range list {
-		logger.Debug(fmt.Sprintf("Start file '%+v'", file))
-		// Create subfolder if not exist
-		if file.RelativePath != "" {
-			err := os.MkdirAll(filepath.Join(targetDirectory, file.RelativePath), 0755)
-			if err != nil {
-				logger.Error(fmt.Sprintf("While create folder '%+v'", filepath.Join(targetDirectory, file.RelativePath)))
-				return err
-			}
+// Files copied by engine from options, large files by large file engine. If engine failed native copy used.
+// Files downloaded from Internet unblocked if options strip blocked streams.
+// File copied into temporary file and renamed over target only when copy succeeded and hash verified.
+// If target locked, processes which hold it reported and closed if allowed by options.
+// If options require hash verification, copied file must match expected hash, stale staged copy removed.
+// Failed file aborts copy, unless options allow continue, then failed files only marked by FAILED status.
+func CopyCustomisationFiles(list []CustomisationFile, targetDirectory string, options CopyOptions, events *HistoryEvents, logger *zap.Logger) error {
+	failed := 0
+	for id := range list {
+		copyStart := time.Now()
+		err := copyCustomisationFile(&list[id], targetDirectory, options, events, logger)
+		list[id].CopyDuration = time.Since(copyStart)
+		if err == nil {
+			continue
+		}
+		list[id].CopyStatus = StatusFailed
+		if !options.ContinueOnError {
+			return err
+		}
+		failed++
+		filePath := filepath.Join(list[id].RelativePath, list[id].FileName)
+		logger.Error(fmt.Sprintf("Copy of '%v' failed, file excluded from deployment - %v", filePath, err))
+		events.Add("Copy failed '%v' - %v", filePath, err)
+	}
+	if failed > 0 {
+		logger.Warn(fmt.Sprintf("%v of %v files not copied, run continued by Copy.OnFileError policy", failed, len(list)))
+	}
+	return nil
+}
+
+// Copy one customisation file and set its copy status on success.
+func copyCustomisationFile(file *CustomisationFile, targetDirectory string, options CopyOptions, events *HistoryEvents, logger *zap.Logger) error {
+	logger.Debug(fmt.Sprintf("Start file '%+v'", *file))
+	// Create subfolder if not exist
+	if file.RelativePath != "" {
+		err := os.MkdirAll(filepath.Join(targetDirectory, file.RelativePath), 0755)
+		if err != nil {
+			logger.Error(fmt.Sprintf("While create folder '%+v'", filepath.Join(targetDirectory, file.RelativePath)))
+			return err
 		}
+	}
 
-		// TODO - remove cmd copy or make it alternative
-		// Copy file with cmd command.
-		// If copy failed use builtin copy method.
-		targetFile := filepath.Join(targetDirectory, file.RelativePath, file.FileName)
-		winCommand := exec.Command("cmd", "/C", "copy", "/Y", file.SourcePath, targetFile)
-		err := winCommand.Run()
+	targetFile := filepath.Join(targetDirectory, file.RelativePath, file.FileName)
+	sourceFile := file.SourcePath
+	if file.StagedPath != "" {
+		sourceFile = file.StagedPath
+	}
+	// Identical file not copied again, so it not blocked by running WDE.
+	if IsIdenticalFile(targetFile, *file) {
+		logger.Debug(fmt.Sprintf("File '%v' unchanged, copy skipped", targetFile))
+		file.CopyStatus = StatusUnchanged
+		return nil
+	}
+	beforeHash := options.Audit.FileHash(targetFile)
+	// Read-only file, e.g. copied with "Attributes: preserve" by previous run, can't be overwritten.
+	err := ClearReadOnly(targetFile)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Can't clear read-only attribute of '%v' - %v", targetFile, err))
+	}
+	// File copied into temporary file next to target, checked and renamed over target,
+	// so failed copy or hash mismatch never leave truncated or wrong file in WDE folder.
+	tempFile := fmt.Sprint(targetFile, CopyTempFileSuffix)
+	os.Remove(tempFile) // Left by interrupted run.
+	defer os.Remove(tempFile)
+	engine := options.EngineFor(file.Size)
+	err = engine.Copy(sourceFile, tempFile, options.Progress.CopyProgress("copy", filepath.Join(file.RelativePath, file.FileName)))
+	if err != nil && engine.Name() != DefaultCopyEngine {
+		logger.Error(fmt.Sprintf("While copy file '%+v' with engine '%v' - %v", targetFile, engine.Name(), err))
+		logger.Error("Try native copy")
+		_, err = copyFile(sourceFile, tempFile)
+	}
+	if err != nil {
+		logger.Error("Copy failed")
+		return err
+	}
+	// Exact copied bytes must have hash scanned and chosen in collection, source changed since then rejected.
+	if options.VerifyHash && file.Hash != "" {
+		hash, err := HashFile(tempFile)
 		if err != nil {
-			logger.Error(fmt.Sprintf("While copy file '%+v' with command '%+v'", targetFile, winCommand))
-			logger.Error("Try another method")
-			_, err := copyFile(file.SourcePath, targetFile)
-			if err != nil {
-				logger.Error("Another method failed")
-				return err
+			return fmt.Errorf("can't verify hash of '%v' - %v", tempFile, err)
+		}
+		if hash != file.Hash {
+			if file.StagedPath != "" {
+				os.Remove(file.StagedPath)
 			}
+			events.Add("Hash mismatch '%v': expected %v, copied %v", filepath.Join(file.RelativePath, file.FileName), file.Hash, hash)
+			return fmt.Errorf("hash of '%v' copied from '%v' is %v, expected %v, target not replaced", targetFile, sourceFile, hash, file.Hash)
 		}
 	}
+	err = os.Rename(tempFile, targetFile)
+	if err != nil {
+		logger.Error("Replace of target file failed")
+		lockErr := HandleLockedFile(targetFile, func() error {
+			return os.Rename(tempFile, targetFile)
+		}, options.CloseProcesses, events, logger)
+		if lockErr != nil {
+			logger.Warn(fmt.Sprint("Locked file handling failed - ", lockErr))
+			return err
+		}
+	}
+	removedStreams, err := ApplyNTFSOptions(sourceFile, targetFile, options.StripStreams, options.Attributes)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Can't apply streams and attributes options to '%v' - %v", targetFile, err))
+	}
+	for _, stream := range removedStreams {
+		logger.Info(fmt.Sprintf("Stream '%v' removed from '%v'", stream, targetFile))
+	}
+	if options.StripStreams == StripStreamsBlocked && len(removedStreams) > 0 {
+		events.Add("Unblocked '%v'", filepath.Join(file.RelativePath, file.FileName))
+	}
+	file.CopyStatus = StatusCopied
+	afterHash := file.Hash
+	if afterHash == "" {
+		afterHash = options.Audit.FileHash(targetFile)
+	}
+	options.Audit.Record(AuditFileCopied, targetFile, beforeHash, afterHash, fmt.Sprint("source ", file.SourcePath))
 	return nil
 }
 
+// Check if target file already has content of customisation file.
+func IsIdenticalFile(targetFile string, file CustomisationFile) bool {
+	if file.Hash == "" {
+		return false
+	}
+	targetInfo, err := os.Stat(targetFile)
+	if err != nil || !targetInfo.Mode().IsRegular() || targetInfo.Size() != file.Size {
+		return false
+	}
+	hash, err := HashFile(targetFile)
+	return err == nil && hash == file.Hash
+}
+
+// Return files which copied or already unchanged in WDE folder, without failed ones.
+func DeployedFiles(list []CustomisationFile) []CustomisationFile {
+	deployed := make([]CustomisationFile, 0, len(list))
+	for _, file := range list {
+		if file.CopyStatus != StatusFailed {
+			deployed = append(deployed, file)
+		}
+	}
+	return deployed
+}
+
+// Replace validation statuses of deployed files by results of copy.
+// Files matched by source path, files without copy result keep status.
+func ApplyCopyStatuses(rowFiles []CustomisationFile, rowStatuses []FileStatus, finalFiles []CustomisationFile) {
+	copyStatuses := make(map[string]FileStatus, len(finalFiles))
+	for _, file := range finalFiles {
+		if file.CopyStatus != "" {
+			copyStatuses[file.SourcePath] = file.CopyStatus
+		}
+	}
+	for id, file := range rowFiles {
+		if status, ok := copyStatuses[file.SourcePath]; ok && id < len(rowStatuses) {
+			rowStatuses[id] = status
+		}
+	}
+}
+
 // Builtin copy method.
 func copyFile(src, dst string) (int64, error) {
 	sourceFileStat, err := os.Stat(src)
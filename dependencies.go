@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Actions on files with the same assembly identity.
+const (
+	DuplicateAssemblyActionWarn = "warn" // Log warning and continue.
+	DuplicateAssemblyActionFail = "fail" // Stop run before anything copied.
+)
+
+// Framework assemblies installed with .NET, never shipped with customisation.
+var frameworkAssemblyPatterns = []string{
+	"mscorlib", "netstandard", "system", "system.*", "windowsbase", "presentationcore", "presentationframework",
+	"presentationframework.*", "reachframework", "uiautomation*", "accessibility", "microsoft.csharp", "microsoft.visualbasic",
+}
+
+// Reference of customisation file not found in customisation set, WDE folder or system.
+type MissingDependency struct {
+	File      string // Path of customisation file relative to its folder.
+	Reference string // Referenced assembly name or native DLL file name.
+	Native    bool   // Native DLL import, otherwise .NET assembly reference.
+}
+
+func (md MissingDependency) String() string {
+	if md.Native {
+		return fmt.Sprintf("'%v' imports native '%v'", md.File, md.Reference)
+	}
+	return fmt.Sprintf("'%v' references assembly '%v'", md.File, md.Reference)
+}
+
+// Check references of .NET assemblies and imports of native DLLs among files to deploy.
+// Reference satisfied by file of customisation set, file in WDE folder, GAC or framework,
+// native import also by system folder. Native imports checked only on Windows.
+// Files which are not PE images skipped.
+func CheckDependencies(files []CustomisationFile, wdeFolder string, ignore []string) []MissingDependency {
+	available := make(map[string]bool)
+	for _, file := range files {
+		available[dependencyKey(file.FileName)] = true
+	}
+	filepath.Walk(wdeFolder, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			available[dependencyKey(info.Name())] = true
+		}
+		return nil
+	})
+	ignored := append(append(make([]string, 0, len(frameworkAssemblyPatterns)+len(ignore)), frameworkAssemblyPatterns...), ignore...)
+	systemRoot := os.Getenv("SystemRoot")
+
+	missing := make([]MissingDependency, 0)
+	for _, file := range files {
+		extension := strings.ToLower(filepath.Ext(file.FileName))
+		if extension != ".dll" && extension != ".exe" {
+			continue
+		}
+		relativePath := filepath.Join(file.RelativePath, file.FileName)
+		metadata, err := ReadAssemblyMetadata(file.SourcePath)
+		if err == nil {
+			for _, reference := range metadata.References {
+				if !dependencyAvailable(reference.Name, available, ignored) && !inGlobalAssemblyCache(systemRoot, reference.Name) {
+					missing = append(missing, MissingDependency{File: relativePath, Reference: reference.Name})
+				}
+			}
+			continue
+		}
+		if err != ErrNotManagedAssembly || systemRoot == "" {
+			continue
+		}
+		imports, err := ReadNativeImports(file.SourcePath)
+		if err != nil {
+			continue
+		}
+		for _, library := range imports {
+			name := strings.ToLower(library)
+			if strings.HasPrefix(name, "api-ms-") || strings.HasPrefix(name, "ext-ms-") {
+				continue
+			}
+			if !dependencyAvailable(library, available, ignore) && !inSystemFolder(systemRoot, library) {
+				missing = append(missing, MissingDependency{File: relativePath, Reference: library, Native: true})
+			}
+		}
+	}
+	return missing
+}
+
+// Get lower case name without .dll or .exe extension, assembly name and file name match by it.
+func dependencyKey(name string) string {
+	name = strings.ToLower(name)
+	for _, extension := range []string{".dll", ".exe"} {
+		name = strings.TrimSuffix(name, extension)
+	}
+	return name
+}
+
+// Check if reference found among available files or matched by ignore pattern, case insensitive.
+func dependencyAvailable(reference string, available map[string]bool, ignore []string) bool {
+	key := dependencyKey(reference)
+	if available[key] {
+		return true
+	}
+	for _, pattern := range ignore {
+		if matched, _ := filepath.Match(dependencyKey(pattern), key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if assembly installed into .NET 4 or .NET 2 global assembly cache.
+func inGlobalAssemblyCache(systemRoot, assemblyName string) bool {
+	if systemRoot == "" {
+		return false
+	}
+	for _, cache := range []string{
+		`Microsoft.NET\assembly\GAC_MSIL`, `Microsoft.NET\assembly\GAC_32`, `Microsoft.NET\assembly\GAC_64`,
+		`assembly\GAC_MSIL`, `assembly\GAC_32`, `assembly\GAC_64`, `assembly\GAC`,
+	} {
+		if _, err := os.Stat(filepath.Join(systemRoot, cache, assemblyName)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if native DLL present in system folders.
+func inSystemFolder(systemRoot, library string) bool {
+	for _, folder := range []string{"System32", "SysWOW64", ""} {
+		if _, err := os.Stat(filepath.Join(systemRoot, folder, library)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Files of final set with the same .NET assembly identity.
+type DuplicateAssembly struct {
+	Identity AssemblyIdentity
+	Files    []string // Paths relative to WDE folder.
+}
+
+func (da DuplicateAssembly) String() string {
+	return fmt.Sprintf("assembly '%v, Version=%v' in %v files: %v", da.Identity.Name, da.Identity.Version, len(da.Files), strings.Join(da.Files, ", "))
+}
+
+// Find files carrying the same assembly name, version and culture under different names or paths.
+// WDE load such assemblies nondeterministically. Result in order of files.
+func FindDuplicateAssemblies(files []CustomisationFile) []DuplicateAssembly {
+	byIdentity := make(map[AssemblyIdentity]*DuplicateAssembly)
+	order := make([]AssemblyIdentity, 0)
+	for _, file := range files {
+		extension := strings.ToLower(filepath.Ext(file.FileName))
+		if extension != ".dll" && extension != ".exe" {
+			continue
+		}
+		metadata, err := ReadAssemblyMetadata(file.SourcePath)
+		if err != nil {
+			continue
+		}
+		// Assembly names compared case insensitive like .NET loader does.
+		key := metadata.Identity
+		key.Name = strings.ToLower(key.Name)
+		key.Culture = strings.ToLower(key.Culture)
+		if byIdentity[key] == nil {
+			byIdentity[key] = &DuplicateAssembly{Identity: metadata.Identity}
+			order = append(order, key)
+		}
+		byIdentity[key].Files = append(byIdentity[key].Files, filepath.Join(file.RelativePath, file.FileName))
+	}
+	duplicates := make([]DuplicateAssembly, 0)
+	for _, key := range order {
+		if len(byIdentity[key].Files) > 1 {
+			duplicates = append(duplicates, *byIdentity[key])
+		}
+	}
+	return duplicates
+}
+
+// Check final set for duplicate assembly identities and apply configured action.
+// Return error if duplicates found and action is fail.
+func ApplyDuplicateAssemblies(files []CustomisationFile, mainConfig MainCfgYAML, events *HistoryEvents, logger *zap.Logger) error {
+	action := mainConfig.Dependencies.DuplicateAssemblies
+	switch action {
+	case "":
+		action = DuplicateAssemblyActionWarn
+	case DuplicateAssemblyActionWarn, DuplicateAssemblyActionFail:
+	default:
+		return fmt.Errorf("unknown Dependencies.DuplicateAssemblies '%v'", action)
+	}
+	duplicates := FindDuplicateAssemblies(files)
+	if len(duplicates) == 0 {
+		return nil
+	}
+	for _, duplicate := range duplicates {
+		logger.Warn(fmt.Sprint("Duplicate assembly identity - ", duplicate))
+		events.Add("Duplicate assembly identity - %v", duplicate)
+	}
+	if action == DuplicateAssemblyActionFail {
+		return fmt.Errorf("%v assemblies deployed more than once under different names or paths", len(duplicates))
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDigestName    string        = "wde-digest.html"                 // Default output file of "digest" command.
+	DefaultDigestSubject string        = "WDE customisation update digest" // Default digest email subject.
+	DefaultDigestPeriod  time.Duration = 24 * time.Hour                    // Default period of runs in digest.
+)
+
+// Runs of one machine within digest period.
+type DigestHost struct {
+	Hostname string
+	Runs     int
+	Failed   int
+	Last     RunSummary // Latest run of period.
+}
+
+// Aggregated fleet runs for digest page.
+type Digest struct {
+	Since  time.Time
+	Until  time.Time
+	Hosts  []DigestHost // Hosts with failed last run first, then by name.
+	Runs   int
+	Failed int // Failed runs.
+	Broken int // Hosts which last run failed.
+}
+
+var digestTemplate = template.Must(template.New("digest").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>WDE customisation update digest</title>
+<style>
+body { font-family: Segoe UI, sans-serif; font-size: 13px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 3px 8px; text-align: left; }
+tr.failed { background: #fdd; }
+</style></head><body>
+<h2>WDE customisation update digest</h2>
+<p>{{.Since.Format "2006.01.02 15:04"}} - {{.Until.Format "2006.01.02 15:04"}}.
+Machines: {{len .Hosts}}, runs: {{.Runs}}, failed runs: {{.Failed}}, machines with failed last run: <b>{{.Broken}}</b>.</p>
+<table>
+<tr><th>Machine</th><th>Last run</th><th>Result</th><th>Phase</th><th>Error</th><th>Runs</th><th>Failed</th><th>Copied</th><th>Duration</th><th>WDE version</th><th>Program version</th></tr>
+{{range .Hosts}}<tr{{if ne .Last.Result "success"}} class="failed"{{end}}>
+<td>{{.Hostname}}</td><td>{{.Last.StartTime.Format "2006.01.02 15:04:05"}}</td><td>{{.Last.Result}}</td><td>{{if ne .Last.Result "success"}}{{.Last.Phase}}{{end}}</td><td>{{.Last.Error}}</td>
+<td>{{.Runs}}</td><td>{{.Failed}}</td><td>{{.Last.Copied}}</td><td>{{.Last.Duration}}</td><td>{{.Last.Host.WDEVersion}}</td><td>{{.Last.ProgramVersion}}</td>
+</tr>
+{{end}}</table>
+</body></html>
+`))
+
+// Run "digest" subcommand. Aggregate run summaries mirrored by all machines
+// into HTML page and send it by email if SMTP configured.
+func RunDigestCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	flags := flag.NewFlagSet("digest", flag.ContinueOnError)
+	period := flags.Duration("period", DefaultDigestPeriod, "include runs started within period before now")
+	outPath := flags.String("out", filepath.Join(WorkspaceFolderPath(mainConfig, programDirectory), DefaultDigestName), "HTML page written by digest")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	mainConfig, err = ResolveConfigSecrets(mainConfig)
+	if err != nil {
+		return err
+	}
+	summariesRoot := mainConfig.Digest.Folder
+	if summariesRoot == "" {
+		summariesRoot = mainConfig.Mirror.Folder
+	}
+	if summariesRoot == "" {
+		return fmt.Errorf("neither Digest.Folder nor Mirror.Folder configured")
+	}
+
+	until := time.Now()
+	summaries, err := ReadMirroredSummaries(summariesRoot, until.Add(-*period))
+	if err != nil {
+		return err
+	}
+	digest := NewDigest(summaries, until.Add(-*period), until)
+	var page bytes.Buffer
+	err = digestTemplate.Execute(&page, digest)
+	if err != nil {
+		return err
+	}
+	err = SaveBytesIntoFile(*outPath, page.Bytes())
+	if err != nil {
+		return err
+	}
+	log.Printf("Machines: %d, runs: %d, failed runs: %d, machines with failed last run: %d",
+		len(digest.Hosts), digest.Runs, digest.Failed, digest.Broken)
+	log.Println("Digest saved into", *outPath)
+
+	if mainConfig.Digest.SMTPServer == "" {
+		return nil
+	}
+	err = SendDigestMail(mainConfig, digest, page.Bytes())
+	if err != nil {
+		return fmt.Errorf("can't send digest email - %v", err)
+	}
+	log.Println("Digest sent to", strings.Join(mainConfig.Digest.To, ", "))
+	return nil
+}
+
+// Read run summaries from "<root>\<hostname>\History\" folders started since provided time.
+// Unreadable summaries skipped.
+func ReadMirroredSummaries(summariesRoot string, since time.Time) ([]RunSummary, error) {
+	paths, err := filepath.Glob(filepath.Join(summariesRoot, "*", "History", fmt.Sprint(SummaryFileName, "*.json")))
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]RunSummary, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Before(since) {
+			continue
+		}
+		summaryBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Printf("Can't read summary '%v' - %v", path, err)
+			continue
+		}
+		var summary RunSummary
+		err = json.Unmarshal(summaryBytes, &summary)
+		if err != nil {
+			log.Printf("Can't parse summary '%v' - %v", path, err)
+			continue
+		}
+		if summary.StartTime.Before(since) {
+			continue
+		}
+		// Mirror subfolder named by machine, also for redacted summaries.
+		if summary.Hostname == "" || summary.Hostname == RedactedHost {
+			summary.Hostname = filepath.Base(filepath.Dir(filepath.Dir(path)))
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// Aggregate summaries by host.
+func NewDigest(summaries []RunSummary, since, until time.Time) Digest {
+	digest := Digest{Since: since, Until: until}
+	hosts := make(map[string]*DigestHost, len(summaries))
+	for _, summary := range summaries {
+		key := strings.ToLower(summary.Hostname)
+		host, ok := hosts[key]
+		if !ok {
+			host = &DigestHost{Hostname: summary.Hostname, Last: summary}
+			hosts[key] = host
+		}
+		host.Runs++
+		digest.Runs++
+		if summary.Result != RunResultSuccess {
+			host.Failed++
+			digest.Failed++
+		}
+		if summary.StartTime.After(host.Last.StartTime) {
+			host.Last = summary
+		}
+	}
+	for _, host := range hosts {
+		if host.Last.Result != RunResultSuccess {
+			digest.Broken++
+		}
+		digest.Hosts = append(digest.Hosts, *host)
+	}
+	sort.Slice(digest.Hosts, func(i, j int) bool {
+		iFailed := digest.Hosts[i].Last.Result != RunResultSuccess
+		jFailed := digest.Hosts[j].Last.Result != RunResultSuccess
+		if iFailed != jFailed {
+			return iFailed
+		}
+		return strings.ToLower(digest.Hosts[i].Hostname) < strings.ToLower(digest.Hosts[j].Hostname)
+	})
+	return digest
+}
+
+// Send digest page as HTML email.
+func SendDigestMail(mainConfig MainCfgYAML, digest Digest, page []byte) error {
+	if len(mainConfig.Digest.To) == 0 {
+		return fmt.Errorf("digest recipients not configured")
+	}
+	subject := mainConfig.Digest.Subject
+	if subject == "" {
+		subject = DefaultDigestSubject
+	}
+	if digest.Broken > 0 {
+		subject = fmt.Sprintf("%v - %d machines failed", subject, digest.Broken)
+	}
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %v\r\n", mainConfig.Digest.From)
+	fmt.Fprintf(&message, "To: %v\r\n", strings.Join(mainConfig.Digest.To, ", "))
+	fmt.Fprintf(&message, "Subject: %v\r\n", subject)
+	fmt.Fprint(&message, "MIME-Version: 1.0\r\nContent-Type: text/html; charset=\"utf-8\"\r\n\r\n")
+	message.Write(page)
+
+	var auth smtp.Auth
+	if mainConfig.Digest.Username != "" {
+		host := mainConfig.Digest.SMTPServer
+		if colon := strings.LastIndex(host, ":"); colon >= 0 {
+			host = host[:colon]
+		}
+		auth = smtp.PlainAuth("", mainConfig.Digest.Username, mainConfig.Digest.Password, host)
+	}
+	return smtp.SendMail(mainConfig.Digest.SMTPServer, auth, mainConfig.Digest.From, mainConfig.Digest.To, message.Bytes())
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const DirectoryManifestName string = "wde-directories.yaml" // Manifest of directories in root of customisation folder.
+
+// Grant in icacls "/grant" form: user or SID, inheritance flags and simple or specific rights.
+var reIcaclsGrant = regexp.MustCompile(`^[^:]+:(\((OI|CI|IO|NP|I)\))*(N|F|M|RX|R|W|D|\([A-Z]+(,[A-Z]+)*\))$`)
+
+// Files in root of customisation folder which control update and are not deployed.
+var ControlFileNames = []string{DirectoryManifestName, IgnoreFileName}
+
+// Directories which customisation need in WDE folder, including empty ones.
+type DirectoryManifest struct {
+	Directories []ManifestDirectory `yaml:"Directories"`
+}
+
+// Directory created in WDE folder.
+type ManifestDirectory struct {
+	Path  string   `yaml:"Path"`  // Path relative to WDE folder.
+	Grant []string `yaml:"Grant"` // Permissions in icacls "/grant" form, e.g. "*S-1-5-32-545:(OI)(CI)M".
+}
+
+// Check that file in customisation folder root is control file.
+func IsControlFile(path, basePath string) bool {
+	if !strings.EqualFold(filepath.Dir(path), filepath.Clean(basePath)) {
+		return false
+	}
+	for _, name := range ControlFileNames {
+		if strings.EqualFold(filepath.Base(path), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read directory manifest of customisation folder. Return empty manifest if folder has no manifest.
+func ReadDirectoryManifest(customisationFolder string) (DirectoryManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(customisationFolder, DirectoryManifestName))
+	if os.IsNotExist(err) {
+		return DirectoryManifest{}, nil
+	}
+	if err != nil {
+		return DirectoryManifest{}, err
+	}
+	var manifest DirectoryManifest
+	err = yaml.Unmarshal(data, &manifest)
+	if err != nil {
+		return DirectoryManifest{}, fmt.Errorf("invalid directory manifest - %v", err)
+	}
+	return manifest, nil
+}
+
+// Check directory manifests of customisation folders: paths inside WDE folder and grants in icacls form.
+// Called before WDE folder changed, so invalid manifest never leave it half-updated.
+func ValidateDirectoryManifests(customisationFolders []string) error {
+	for _, folder := range customisationFolders {
+		manifest, err := ReadDirectoryManifest(folder)
+		if err != nil {
+			return fmt.Errorf("customisation folder '%v' - %v", folder, err)
+		}
+		for _, directory := range manifest.Directories {
+			err = checkManifestDirectory(directory)
+			if err != nil {
+				return fmt.Errorf("customisation folder '%v' - %v", folder, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Check that directory is inside WDE folder and its grants are in icacls "/grant" form.
+func checkManifestDirectory(directory ManifestDirectory) error {
+	relativePath := filepath.Clean(directory.Path)
+	if directory.Path == "" || filepath.IsAbs(relativePath) || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("directory '%v' is outside WDE folder", directory.Path)
+	}
+	for _, grant := range directory.Grant {
+		if !reIcaclsGrant.MatchString(grant) {
+			return fmt.Errorf("directory '%v' has grant '%v' not in icacls form, e.g. \"*S-1-5-32-545:(OI)(CI)M\"", directory.Path, grant)
+		}
+	}
+	return nil
+}
+
+// Create directories declared by manifests of customisation folders in target folder and grant permissions.
+// Directory outside target folder is error.
+func CreateManifestDirectories(customisationFolders []string, targetDirectory string, events *HistoryEvents, logger *zap.Logger) error {
+	for _, folder := range customisationFolders {
+		manifest, err := ReadDirectoryManifest(folder)
+		if err != nil {
+			return fmt.Errorf("customisation folder '%v' - %v", folder, err)
+		}
+		for _, directory := range manifest.Directories {
+			err = checkManifestDirectory(directory)
+			if err != nil {
+				return fmt.Errorf("customisation folder '%v' - %v", folder, err)
+			}
+			relativePath := filepath.Clean(directory.Path)
+			fullPath := filepath.Join(targetDirectory, relativePath)
+			if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+				err = os.MkdirAll(fullPath, 0755)
+				if err != nil {
+					return err
+				}
+				logger.Info(fmt.Sprintf("Directory '%v' created for '%v'", fullPath, folder))
+				events.Add("Directory '%v' created for customisation '%v'", relativePath, folder)
+			}
+			if len(directory.Grant) == 0 {
+				continue
+			}
+			err = GrantDirectoryPermissions(fullPath, directory.Grant)
+			if err != nil {
+				return fmt.Errorf("can't grant permissions on '%v' - %v", fullPath, err)
+			}
+			logger.Info(fmt.Sprintf("Permissions %v granted on '%v'", directory.Grant, fullPath))
+		}
+	}
+	return nil
+}
+
+// Return lower case relative paths of directories declared by manifests of customisation folders.
+func DeclaredDirectories(customisationFolders []string) (map[string]bool, error) {
+	declared := make(map[string]bool)
+	for _, folder := range customisationFolders {
+		manifest, err := ReadDirectoryManifest(folder)
+		if err != nil {
+			return nil, fmt.Errorf("customisation folder '%v' - %v", folder, err)
+		}
+		for _, directory := range manifest.Directories {
+			declared[strings.ToLower(filepath.Clean(directory.Path))] = true
+		}
+	}
+	return declared, nil
+}
+
+// Remove directories of removed files and their parents inside target folder while they are empty.
+// Directories without removed files never touched, so directories empty before run kept.
+// Return relative paths of removed directories.
+func RemoveEmptyDirectories(removedFiles []string, targetDirectory string, declared map[string]bool, audit *AuditLog, events *HistoryEvents, logger *zap.Logger) []string {
+	targetDirectory = filepath.Clean(targetDirectory)
+	removed := make([]string, 0)
+	for _, file := range removedFiles {
+		for directory := filepath.Dir(file); ; directory = filepath.Dir(directory) {
+			relativePath, err := filepath.Rel(targetDirectory, directory)
+			if err != nil || relativePath == "." || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+				break
+			}
+			if declared[strings.ToLower(relativePath)] {
+				break
+			}
+			// Directory already removed by previous file not exist and stop walk.
+			entries, err := ioutil.ReadDir(directory)
+			if err != nil || len(entries) > 0 {
+				break
+			}
+			err = os.Remove(directory)
+			if err != nil {
+				logger.Warn(fmt.Sprintf("Can't remove empty directory '%v' - %v", directory, err))
+				break
+			}
+			logger.Info(fmt.Sprintf("Empty directory '%v' removed", directory))
+			audit.Record(AuditDirectoryDeleted, directory, "", "", "left empty by removed files")
+			events.Add("Removed empty directory '%v'", relativePath)
+			removed = append(removed, relativePath)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deployment Manager window styles for DM.WindowStyle option.
+const (
+	DMWindowNormal    string = "normal"
+	DMWindowMinimized string = "minimized"
+	DMWindowHidden    string = "hidden"
+)
+
+// Default timeout for find window or control of automation step.
+const DMAutomationDefaultTimeout = 60 * time.Second
+
+// Deployments of concurrent targets serialized, wizard automation and DM log scan expect single DM process.
+var deploymentMutex sync.Mutex
+
+// One step of scripted Deployment Manager wizard flow from config.
+type DMAutomationStep struct {
+	Window  string `yaml:"Window"`  // Part of top level window title. Only windows of started process used.
+	Control string `yaml:"Control"` // Part of child control text. Empty for window itself.
+	Action  string `yaml:"Action"`  // "click", "settext", "select" or "close".
+	Text    string `yaml:"Text"`    // Text for "settext" and "select" actions.
+	Timeout string `yaml:"Timeout"` // Maximum wait for window and control. By default 60s.
+	Delay   string `yaml:"Delay"`   // Pause after step is done, e.g. "2s".
+}
+
+// Deployment Manager or publish command not started, so nothing published and launch can be retried.
+type DMLaunchError struct {
+	Err error
+}
+
+func (le *DMLaunchError) Error() string {
+	return fmt.Sprint("can't start - ", le.Err)
+}
+
+// Run WDE Deployment Manager or publish command from config and wait while it stop.
+// Failed launch retried DM.Retries times with DM.RetryDelay pause. Each attempt recorded into history events.
+// Process failed after start never retried, it may have already published customisation.
+func RunDeploymentPhase(mainConfig MainCfgYAML, summary *RunSummary, events *HistoryEvents, logger *zap.Logger) error {
+	var retryDelay time.Duration
+	if mainConfig.DM.RetryDelay != "" {
+		var err error
+		retryDelay, err = time.ParseDuration(mainConfig.DM.RetryDelay)
+		if err != nil {
+			return err
+		}
+	}
+	attempts := mainConfig.DM.Retries + 1
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		logger.Info(fmt.Sprintf("Deployment attempt %d of %d", attempt, attempts))
+		err = RunDeployment(mainConfig, summary, logger)
+		if err == nil {
+			events.Add("Deployment attempt %d of %d succeeded", attempt, attempts)
+			return nil
+		}
+		logger.Warn(fmt.Sprintf("Deployment attempt %d of %d failed - %v", attempt, attempts, err))
+		events.Add("Deployment attempt %d of %d failed - %v", attempt, attempts, err)
+		var launchErr *DMLaunchError
+		if !errors.As(err, &launchErr) {
+			break
+		}
+		if attempt < attempts {
+			time.Sleep(retryDelay)
+		}
+	}
+	return err
+}
+
+// Single attempt of run WDE Deployment Manager or publish command.
+// After run DM log scanned for errors written while run.
+func RunDeployment(mainConfig MainCfgYAML, summary *RunSummary, logger *zap.Logger) error {
+	deploymentMutex.Lock()
+	defer deploymentMutex.Unlock()
+	dmLogFile := ExpandWindowsEnv(mainConfig.DM.LogFile)
+	dmLogOffset := GetFileSize(dmLogFile)
+	err := RunDeploymentProcess(mainConfig, summary, logger)
+	if err != nil || dmLogFile == "" {
+		return err
+	}
+	logErrors, err := ScanDMLog(dmLogFile, dmLogOffset, mainConfig.DM.LogErrorPatterns)
+	if err != nil {
+		logger.Warn(fmt.Sprint("Can't scan Deployment Manager log - ", err))
+		return nil
+	}
+	for _, line := range logErrors {
+		logger.Warn(fmt.Sprint("(DM log) ", line))
+	}
+	summary.DMLogErrors = append(summary.DMLogErrors, logErrors...)
+	if len(logErrors) > 0 && mainConfig.DM.FailOnLogErrors {
+		return fmt.Errorf("Deployment Manager log contains %d error lines", len(logErrors))
+	}
+	return nil
+}
+
+// Run WDE Deployment Manager or publish command and wait for it stop.
+func RunDeploymentProcess(mainConfig MainCfgYAML, summary *RunSummary, logger *zap.Logger) error {
+	windowStyle := strings.ToLower(mainConfig.DM.WindowStyle)
+	if len(mainConfig.DM.Command) > 0 {
+		logger.Info("Run publish command")
+		exitCode, err := RunPublishCommand(mainConfig.DM.Command, mainConfig.WDEInstallationFolder, windowStyle, logger)
+		summary.PublishExit = &exitCode
+		if err != nil {
+			return fmt.Errorf("publish command exit code %v - %w", exitCode, err)
+		}
+		return nil
+	}
+	logger.Info("Run WDE Deployment Manager")
+	onStart := func(pid int) error {
+		if windowStyle == DMWindowMinimized {
+			go MinimizeProcessWindow(uint32(pid), DMAutomationDefaultTimeout, logger)
+		}
+		if len(mainConfig.DM.Automation) > 0 {
+			return RunDMAutomation(pid, mainConfig.DM.Automation, windowStyle == DMWindowHidden, logger)
+		}
+		return nil
+	}
+	return RunAndWaitStop(filepath.Join(mainConfig.WDEInstallationFolder, DMSubfolder), DMExecutableName, windowStyle, onStart, logger)
+}
+
+// Run publish command from config instead of WDE Deployment Manager and wait for it stop.
+// Command output written into log line by line. Return command exit code and error.
+func RunPublishCommand(command []string, directory, windowStyle string, logger *zap.Logger) (int, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = directory
+	if windowStyle == DMWindowHidden {
+		cmd.SysProcAttr = HiddenWindowProcAttr()
+	}
+	logger.Info(fmt.Sprintf("Run publish command '%+v' from dir '%+v'", cmd.Args, directory))
+	output, err := cmd.CombinedOutput()
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		logger.Info(fmt.Sprint("(publish) ", scanner.Text()))
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), err
+		}
+		return -1, &DMLaunchError{Err: err}
+	}
+	return 0, nil
+}
+
+// Return size of file or zero if file not exists.
+func GetFileSize(path string) int64 {
+	if path == "" {
+		return 0
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fileInfo.Size()
+}
+
+// Read DM log from offset and return lines which contain any of patterns.
+// If log file shrank since offset taken (rotated), it read from beginning.
+// By default lines with "ERROR" and "Exception" returned.
+func ScanDMLog(path string, offset int64, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"ERROR", "Exception"}
+	}
+	logFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+	fileInfo, err := logFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo.Size() < offset {
+		offset = 0
+	}
+	_, err = logFile.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, 8)
+	scanner := bufio.NewScanner(logFile)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, pattern := range patterns {
+			if strings.Contains(line, pattern) {
+				result = append(result, strings.TrimSpace(line))
+				break
+			}
+		}
+	}
+	return result, scanner.Err()
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"go.uber.org/zap"
+	"time"
+)
+
+// Deployment Manager wizard automation available only on Windows.
+func RunDMAutomation(pid int, steps []DMAutomationStep, hidden bool, logger *zap.Logger) error {
+	return ErrNotSupportedOnPlatform
+}
+
+// Windows are not minimized outside Windows.
+func MinimizeProcessWindow(pid uint32, timeout time.Duration, logger *zap.Logger) {
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/sys/windows"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Window messages used for Deployment Manager wizard automation.
+const (
+	wmSetText      = 0x000C
+	wmClose        = 0x0010
+	wmCommand      = 0x0111
+	bmClick        = 0x00F5
+	cbSelectString = 0x014D
+	cbnSelChange   = 1
+
+	swShowMinNoActive = 7
+)
+
+var (
+	user32               = windows.NewLazySystemDLL("user32.dll")
+	procGetWindowTextW   = user32.NewProc("GetWindowTextW")
+	procGetWindowTextLen = user32.NewProc("GetWindowTextLengthW")
+	procSendMessageW     = user32.NewProc("SendMessageW")
+	procPostMessageW     = user32.NewProc("PostMessageW")
+	procGetDlgCtrlID     = user32.NewProc("GetDlgCtrlID")
+	procGetParent        = user32.NewProc("GetParent")
+	procShowWindow       = user32.NewProc("ShowWindow")
+)
+
+// Execute scripted steps against windows of process with provided PID.
+// Each step wait for its window and control until timeout. Windows of process started hidden
+// never become visible, so for hidden launch invisible windows searched too.
+func RunDMAutomation(pid int, steps []DMAutomationStep, hidden bool, logger *zap.Logger) error {
+	for id, step := range steps {
+		logger.Info(fmt.Sprintf("DM automation step %d '%+v'", id+1, step))
+		timeout := DMAutomationDefaultTimeout
+		if step.Timeout != "" {
+			var err error
+			timeout, err = time.ParseDuration(step.Timeout)
+			if err != nil {
+				return err
+			}
+		}
+		hwnd, err := WaitForWindow(uint32(pid), step.Window, step.Control, !hidden, timeout)
+		if err != nil {
+			return fmt.Errorf("step %d - %v", id+1, err)
+		}
+		err = ApplyDMAutomationAction(hwnd, step)
+		if err != nil {
+			return fmt.Errorf("step %d - %v", id+1, err)
+		}
+		if step.Delay != "" {
+			delay, err := time.ParseDuration(step.Delay)
+			if err != nil {
+				return err
+			}
+			time.Sleep(delay)
+		}
+	}
+	return nil
+}
+
+// Send window messages for step action to found window or control.
+func ApplyDMAutomationAction(hwnd windows.HWND, step DMAutomationStep) error {
+	switch strings.ToLower(step.Action) {
+	case "click":
+		// Posted to avoid block if click opens modal dialog.
+		procPostMessageW.Call(uintptr(hwnd), bmClick, 0, 0)
+	case "settext":
+		text, err := windows.UTF16PtrFromString(step.Text)
+		if err != nil {
+			return err
+		}
+		procSendMessageW.Call(uintptr(hwnd), wmSetText, 0, uintptr(unsafe.Pointer(text)))
+	case "select":
+		text, err := windows.UTF16PtrFromString(step.Text)
+		if err != nil {
+			return err
+		}
+		index, _, _ := procSendMessageW.Call(uintptr(hwnd), cbSelectString, ^uintptr(0), uintptr(unsafe.Pointer(text)))
+		if int32(index) < 0 {
+			return fmt.Errorf("item '%v' not found", step.Text)
+		}
+		// CB_SELECTSTRING does not notify parent, so send CBN_SELCHANGE manually.
+		ctrlID, _, _ := procGetDlgCtrlID.Call(uintptr(hwnd))
+		parent, _, _ := procGetParent.Call(uintptr(hwnd))
+		procSendMessageW.Call(parent, wmCommand, cbnSelChange<<16|ctrlID&0xFFFF, uintptr(hwnd))
+	case "close":
+		procPostMessageW.Call(uintptr(hwnd), wmClose, 0, 0)
+	default:
+		return fmt.Errorf("unknown action '%v'", step.Action)
+	}
+	return nil
+}
+
+// Poll windows of process until window with title and its child control found.
+// If visibleOnly set, hidden top level windows skipped.
+func WaitForWindow(pid uint32, title, control string, visibleOnly bool, timeout time.Duration) (windows.HWND, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		window := FindProcessWindow(pid, title, visibleOnly)
+		if window != 0 {
+			if control == "" {
+				return window, nil
+			}
+			child := FindChildWindow(window, control)
+			if child != 0 {
+				return child, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("window '%v' control '%v' not found in %v", title, control, timeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Parameters and result of window enumeration.
+type windowSearch struct {
+	pid         uint32
+	text        string
+	visibleOnly bool
+	found       windows.HWND
+}
+
+// Callbacks for EnumWindows and EnumChildWindows share current search under mutex.
+// Created once, because number of callbacks created by windows.NewCallback is limited.
+var (
+	currentWindowSearch        windowSearch
+	currentWindowSearchMutex   sync.Mutex
+	enumProcessWindowsCallback = windows.NewCallback(func(hwnd windows.HWND, _ uintptr) uintptr {
+		var windowPID uint32
+		windows.GetWindowThreadProcessId(hwnd, &windowPID)
+		if windowPID != currentWindowSearch.pid || (currentWindowSearch.visibleOnly && !windows.IsWindowVisible(hwnd)) {
+			return 1
+		}
+		if strings.Contains(GetWindowText(hwnd), currentWindowSearch.text) {
+			currentWindowSearch.found = hwnd
+			return 0
+		}
+		return 1
+	})
+	enumChildWindowsCallback = windows.NewCallback(func(hwnd windows.HWND, _ uintptr) uintptr {
+		if strings.Contains(GetWindowText(hwnd), currentWindowSearch.text) {
+			currentWindowSearch.found = hwnd
+			return 0
+		}
+		return 1
+	})
+)
+
+// Find top level window of process which title contains provided text, only visible one if visibleOnly set.
+func FindProcessWindow(pid uint32, title string, visibleOnly bool) windows.HWND {
+	currentWindowSearchMutex.Lock()
+	defer currentWindowSearchMutex.Unlock()
+	currentWindowSearch = windowSearch{pid: pid, text: title, visibleOnly: visibleOnly}
+	windows.EnumWindows(enumProcessWindowsCallback, nil)
+	return currentWindowSearch.found
+}
+
+// Find child control of window which text contains provided text.
+func FindChildWindow(parent windows.HWND, text string) windows.HWND {
+	currentWindowSearchMutex.Lock()
+	defer currentWindowSearchMutex.Unlock()
+	currentWindowSearch = windowSearch{text: text}
+	windows.EnumChildWindows(parent, enumChildWindowsCallback, nil)
+	return currentWindowSearch.found
+}
+
+// Get window title or control text.
+func GetWindowText(hwnd windows.HWND) string {
+	length, _, _ := procGetWindowTextLen.Call(uintptr(hwnd))
+	if length == 0 {
+		return ""
+	}
+	buffer := make([]uint16, length+1)
+	procGetWindowTextW.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&buffer[0])), uintptr(len(buffer)))
+	return windows.UTF16ToString(buffer)
+}
+
+// Wait for first window of process and minimize it without activation.
+// Startup info can only hide window, so minimized style applied after window appears.
+func MinimizeProcessWindow(pid uint32, timeout time.Duration, logger *zap.Logger) {
+	hwnd, err := WaitForWindow(pid, "", "", true, timeout)
+	if err != nil {
+		logger.Warn(fmt.Sprint("Can't minimize Deployment Manager window - ", err))
+		return
+	}
+	procShowWindow.Call(uintptr(hwnd), swShowMinNoActive)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+)
+
+const DefaultDotNetRelease int = 378389 // .NET Framework 4.5, "Release" value of NDP\v4\Full registry key.
+
+// Get path of Deployment Manager own config file.
+func DMConfigFilePath(mainConfig MainCfgYAML) string {
+	return filepath.Join(mainConfig.WDEInstallationFolder, DMSubfolder, fmt.Sprint(DMExecutableName, ".config"))
+}
+
+// Check Deployment Manager executable, its config file, license file and .NET Framework version.
+// Return list of missing prerequisites. .NET not checked outside Windows.
+func CheckDMPrerequisites(mainConfig MainCfgYAML) []string {
+	problems := make([]string, 0)
+	dmFolder := filepath.Join(mainConfig.WDEInstallationFolder, DMSubfolder)
+	files := []string{filepath.Join(dmFolder, DMExecutableName), DMConfigFilePath(mainConfig)}
+	if mainConfig.DM.Prerequisites.LicenseFile != "" {
+		licenseFile := ExpandWindowsEnv(mainConfig.DM.Prerequisites.LicenseFile)
+		if !filepath.IsAbs(licenseFile) {
+			licenseFile = filepath.Join(dmFolder, licenseFile)
+		}
+		files = append(files, licenseFile)
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("file '%v' not found", file))
+		} else if info.Size() == 0 {
+			problems = append(problems, fmt.Sprintf("file '%v' is empty", file))
+		}
+	}
+	minRelease := mainConfig.DM.Prerequisites.MinDotNetRelease
+	if minRelease == 0 {
+		minRelease = DefaultDotNetRelease
+	}
+	release, err := DotNetFrameworkRelease()
+	switch {
+	case err == ErrNotSupportedOnPlatform:
+	case err != nil:
+		problems = append(problems, fmt.Sprintf(".NET Framework 4 not installed - %v", err))
+	case release < minRelease:
+		problems = append(problems, fmt.Sprintf(".NET Framework release %v is older than required %v", release, minRelease))
+	}
+	return problems
+}
+
+// Restore missing or empty Deployment Manager config file from template.
+// Return true if config restored.
+func RepairDMConfig(mainConfig MainCfgYAML, logger *zap.Logger) (bool, error) {
+	template := ExpandWindowsEnv(mainConfig.DM.Prerequisites.ConfigTemplate)
+	if template == "" {
+		return false, nil
+	}
+	configPath := DMConfigFilePath(mainConfig)
+	if info, err := os.Stat(configPath); err == nil && info.Size() > 0 {
+		return false, nil
+	}
+	if _, err := os.Stat(filepath.Dir(configPath)); err != nil {
+		return false, fmt.Errorf("Deployment Manager folder not found - %v", err)
+	}
+	_, err := copyFile(template, configPath)
+	if err != nil {
+		return false, fmt.Errorf("can't restore Deployment Manager config from template '%v' - %v", template, err)
+	}
+	logger.Info(fmt.Sprintf("Deployment Manager config '%v' restored from template '%v'", configPath, template))
+	return true, nil
+}
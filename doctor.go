@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Check environment of update: config, folders, WDE installation, running instance.
+// Return report lines for console and support bundle.
+func DoctorReport(mainConfig MainCfgYAML, programDirectory string) []string {
+	lines := make([]string, 0, 32)
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+	wdeVersion, wdeVersionErr := DetectWDEVersion(mainConfig)
+	facts := CollectHostFacts(wdeVersion)
+	add("Program version: %v", programVersion)
+	add("Program directory: %v", programDirectory)
+	add("Workspace: %v", WorkspaceFolderPath(mainConfig, programDirectory))
+	lines = append(lines, facts.HistoryLines()...)
+	if wdeVersionErr != nil {
+		add("[WARN] WDE version not detected - %v", wdeVersionErr)
+	}
+
+	add("Config version: %v (supported %v)", mainConfig.ConfigVersion, CurrentConfigVersion)
+	if mainConfig.ConfigVersion > CurrentConfigVersion {
+		add("[WARN] Config version is newer than supported by program")
+	}
+	for _, deprecation := range mainConfig.deprecations {
+		add("[WARN] Deprecated config key '%v', use '%v'", deprecation.Key, deprecation.Replacement)
+	}
+	_, err := ResolveConfigSecrets(mainConfig)
+	if err != nil {
+		add("[FAIL] Config secrets - %v", err)
+	}
+
+	// Folders created by run itself may be absent.
+	checkFolder := func(title, path string, createdByRun bool) {
+		info, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err) && createdByRun:
+			add("[ -- ] %v '%v' not created yet", title, path)
+		case err != nil:
+			add("[FAIL] %v '%v' - %v", title, path, err)
+		case !info.IsDir():
+			add("[FAIL] %v '%v' is not a folder", title, path)
+		default:
+			add("[ OK ] %v '%v'", title, path)
+		}
+	}
+	checkFolder("WDE folder", WDETargetFolder(mainConfig), false)
+	if err := CheckFolderWriteAccess(WDETargetFolder(mainConfig)); err != nil {
+		add("[FAIL] WDE folder not writable - %v", err)
+		if hint := AccessDeniedHint("copy", err, mainConfig); hint != "" {
+			add("       How to fix: %v", hint)
+		}
+	}
+	checkFolder("Deployment Manager folder", filepath.Join(mainConfig.WDEInstallationFolder, DMSubfolder), false)
+	if len(mainConfig.DM.Command) == 0 {
+		problems := CheckDMPrerequisites(mainConfig)
+		for _, problem := range problems {
+			add("[FAIL] Deployment Manager prerequisite - %v", problem)
+		}
+		if len(problems) == 0 {
+			add("[ OK ] Deployment Manager prerequisites")
+		}
+	}
+	for _, source := range ConfiguredSources(mainConfig) {
+		if source.GitURL != "" {
+			add("[ -- ] Git source '%v' into '%v'", RedactURLCredentials(source.GitURL), source.Folder)
+			continue
+		}
+		checkFolder("Customisation source", source.Folder, false)
+	}
+	checkFolder("Log folder", LogFolderPath(mainConfig, programDirectory), true)
+	checkFolder("History folder", HistoryFolderPath(mainConfig, programDirectory), true)
+	if mainConfig.Mirror.Folder != "" {
+		checkFolder("Mirror folder", mainConfig.Mirror.Folder, false)
+	}
+
+	status, err := QueryStatusPipe()
+	if err == nil && status.Running {
+		add("Update running now, process %v, phase '%v'", status.PID, status.Phase)
+	}
+	summary, err := ReadLastSummary(HistoryFolderPath(mainConfig, programDirectory))
+	if err == nil {
+		add("Last run: %v at %v, phase '%v', error '%v'", summary.Result, FileTimestamp(summary.StartTime), summary.Phase, summary.Error)
+	}
+	return lines
+}
+
+// Run "doctor" subcommand. Print environment report.
+func RunDoctorCommand(mainConfig MainCfgYAML, programDirectory string) error {
+	for _, line := range DoctorReport(mainConfig, programDirectory) {
+		fmt.Println(line)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// .NET Framework available only on Windows.
+func DotNetFrameworkRelease() (int, error) {
+	return 0, ErrNotSupportedOnPlatform
+}
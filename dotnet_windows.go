@@ -0,0 +1,16 @@
+package main
+
+import (
+	"golang.org/x/sys/windows/registry"
+)
+
+// Get "Release" value of installed .NET Framework 4.x.
+func DotNetFrameworkRelease() (int, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\NET Framework Setup\NDP\v4\Full`, registry.QUERY_VALUE)
+	if err != nil {
+		return 0, err
+	}
+	defer key.Close()
+	release, _, err := key.GetIntegerValue("Release")
+	return int(release), err
+}
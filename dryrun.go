@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Replacements of phases in dry run. Phase with nil replacement skipped,
+// phases not listed here only read sources, saved data and registry, so run unchanged.
+var dryRunPhases = map[string]func(state *RunState) error{
+	"lock":              nil, // Lock files created in workspace and WDE folder.
+	"preflight":         nil,
+	"scan":              nil, // External scanner not called.
+	"approval":          nil,
+	"backup":            nil,
+	"orphans":           DryRunOrphans,
+	"cache":             nil,
+	"stop":              nil,
+	"copy":              DryRunCopy,
+	"directories":       DryRunDirectories,
+	"empty-directories": nil,
+	"state":             nil,
+	"provenance":        nil,
+	"release-gate":      nil,
+	"registry-write":    DryRunRegistryWrite,
+	"registry-summary":  nil,
+	"registry-users":    nil,
+	"dm-prerequisites":  nil,
+	"deployment":        nil,
+	"agent-notice":      nil,
+	"release-record":    nil,
+	"snapshot":          nil,
+	"cleanup":           nil, // Old logs and saved registry files kept.
+}
+
+// Return phases of dry run: WDE folder, registry and services not changed,
+// planned changes only logged and added into history.
+func DryRunPhases(phases []Phase) []Phase {
+	dryRun := make([]Phase, 0, len(phases))
+	for _, phase := range phases {
+		replacement, ok := dryRunPhases[phase.Name]
+		switch {
+		case !ok:
+		case replacement == nil:
+			name := phase.Name
+			phase.Run = func(state *RunState) error {
+				state.Logger.Info(fmt.Sprintf("Dry run, phase '%v' skipped", name))
+				return nil
+			}
+		default:
+			phase.Run = replacement
+		}
+		dryRun = append(dryRun, phase)
+	}
+	return dryRun
+}
+
+// Report files which would be copied into WDE folder, files already there with same content reported unchanged.
+func DryRunCopy(state *RunState) error {
+	targetDirectory := WDETargetFolder(state.Config)
+	counts := make(map[string]int, 3)
+	for _, file := range state.FinalFiles {
+		path := filepath.Join(file.RelativePath, file.FileName)
+		targetFile := filepath.Join(targetDirectory, path)
+		action := PlanActionUpdate
+		if _, err := os.Stat(targetFile); os.IsNotExist(err) {
+			action = PlanActionAdd
+		} else if IsIdenticalFile(targetFile, file) {
+			counts["unchanged"]++
+			continue
+		}
+		counts[action]++
+		state.Logger.Info(fmt.Sprintf("Dry run, would %v '%v' from '%v'", action, path, file.SourcePath))
+		state.HistoryEvents.Add("Dry run: would %v '%v' from '%v'", action, path, file.SourcePath)
+	}
+	state.Logger.Info(fmt.Sprintf("Dry run, %v files would be added, %v updated, %v unchanged",
+		counts[PlanActionAdd], counts[PlanActionUpdate], counts["unchanged"]))
+	return nil
+}
+
+// Report orphaned files which would be removed from WDE folder by configured policy.
+func DryRunOrphans(state *RunState) error {
+	previousState, err := ReadDeployedState(filepath.Join(StateFolderPath(state.Config, state.ProgramDirectory), StateFileName))
+	if err != nil {
+		return fmt.Errorf("can't read deployed state - %v", err)
+	}
+	action := "keep"
+	switch strings.ToLower(state.Config.State.RemoveOrphans) {
+	case OrphansRemove:
+		action = "remove"
+	case OrphansAsk:
+		action = "ask to remove"
+	}
+	for _, orphan := range previousState.FindOrphanedFiles(state.Folders, state.FinalFiles) {
+		fullPath := filepath.Join(WDETargetFolder(state.Config), orphan.RelativePath, orphan.FileName)
+		state.Logger.Info(fmt.Sprintf("Dry run, would %v orphaned file '%v' of customisation folder '%v'", action, fullPath, orphan.CustomisationFolder))
+		state.HistoryEvents.Add("Dry run: would %v orphaned file '%v'", action, fullPath)
+	}
+	return nil
+}
+
+// Report directories declared by manifests which would be created in WDE folder.
+func DryRunDirectories(state *RunState) error {
+	declared, err := DeclaredDirectories(state.Folders)
+	if err != nil {
+		return err
+	}
+	directories := make([]string, 0, len(declared))
+	for relativePath := range declared {
+		directories = append(directories, relativePath)
+	}
+	sort.Strings(directories)
+	for _, relativePath := range directories {
+		fullPath := filepath.Join(WDETargetFolder(state.Config), relativePath)
+		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+			state.Logger.Info(fmt.Sprintf("Dry run, would create directory '%v'", fullPath))
+			state.HistoryEvents.Add("Dry run: would create directory '%v'", relativePath)
+		}
+	}
+	return nil
+}
+
+// Report registry values which would be added or changed by write of prepared data.
+// Values not owned by updater and changed by hand reported kept, as by real write.
+func DryRunRegistryWrite(state *RunState) error {
+	problems := state.RegistryData.ValidateCustomFiles()
+	for _, problem := range problems {
+		state.Logger.Error(fmt.Sprint("Invalid \"CustomFiles\" value - ", problem))
+		state.HistoryEvents.Add("Invalid CustomFiles: %v", problem)
+	}
+	if len(problems) > 0 {
+		return ErrInvalidCustomFiles
+	}
+	ownership, err := ReadRegistryOwnership(filepath.Join(StateFolderPath(state.Config, state.ProgramDirectory), OwnershipFileName), ConfiguredManagedValues(state.Config))
+	if err != nil {
+		return fmt.Errorf("can't read registry ownership - %v", err)
+	}
+	liveData, err := state.RegistryStore.Read(DMRegistryDir)
+	if err != nil && err != ErrRegistryKeyNotExist {
+		return fmt.Errorf("can't read registry values - %v", err)
+	}
+	writableData, keptValues, deletedValues := ownership.SelectWritable(state.RegistryData, liveData)
+	for _, name := range keptValues {
+		state.Logger.Info(fmt.Sprintf("Dry run, registry value '%v' would be kept, not owned by updater", name))
+		state.HistoryEvents.Add("Dry run: registry value '%v' would be kept, not owned by updater", name)
+	}
+	for _, name := range deletedValues {
+		state.Logger.Info(fmt.Sprintf("Dry run, registry value '%v' deleted by hand, would not be re-created", name))
+	}
+	diff := DiffRegistryValues(liveData, writableData)
+	for _, name := range diff.Added {
+		state.Logger.Info(fmt.Sprintf("Dry run, registry value '%v\\%v' would be added", DMRegistryDir, name))
+		state.HistoryEvents.Add("Dry run: registry value '%v' would be added", name)
+	}
+	for _, name := range diff.Changed {
+		state.Logger.Info(fmt.Sprintf("Dry run, registry value '%v\\%v' would be changed", DMRegistryDir, name))
+		state.HistoryEvents.Add("Dry run: registry value '%v' would be changed", name)
+	}
+	state.Logger.Info(fmt.Sprintf("Dry run, %v registry values would be added, %v changed", len(diff.Added), len(diff.Changed)))
+	return nil
+}
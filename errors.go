@@ -5,3 +5,10 @@ import "fmt"
 var ErrCustomFilesNotFound = fmt.Errorf("not found CustomFiles key in old registry data \"RegistryValues\"")
 var ErrVersionNotExist = fmt.Errorf("version not exsist")
 var ErrNoFilesFoundInFolderByPattern = fmt.Errorf("folder contains no files")
+var ErrRegistryKeyNotExist = fmt.Errorf("registry key not exist")
+var ErrNotSupportedOnPlatform = fmt.Errorf("not supported on this platform")
+var ErrInvalidCustomFiles = fmt.Errorf("generated CustomFiles value is invalid, registry not written")
+var ErrInteractionNotAllowed = fmt.Errorf("user interaction not allowed in unattended mode")
+var ErrRunLocked = fmt.Errorf("run locked by another process")
+var ErrApprovalDenied = fmt.Errorf("update not approved")
+var ErrProvenanceDamaged = fmt.Errorf("provenance database damaged")
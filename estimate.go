@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const EstimateDefaultRuns int = 10 // Number of last runs used to measure throughput.
+
+// Predicted size and duration of pending update.
+type Estimate struct {
+	Added    int
+	Changed  int
+	Removed  int
+	Bytes    int64         // Total size of added and changed files.
+	Samples  int           // Successful runs used for prediction.
+	Rate     float64       // Copy throughput in bytes per second, 0 if unknown.
+	Copy     time.Duration // Expected copy phase duration.
+	Overhead time.Duration // Average duration of run without file copying.
+}
+
+// Size pending change set. Only added and changed files copied by run, unchanged ones skipped.
+func EstimatePending(manifest Manifest, deployed DeployedState) Estimate {
+	drift := FindDrift(manifest, deployed)
+	estimate := Estimate{Added: len(drift.Added), Changed: len(drift.Changed), Removed: len(drift.Removed)}
+	pending := make(map[string]bool, len(drift.Added)+len(drift.Changed))
+	for _, path := range append(drift.Added, drift.Changed...) {
+		pending[path] = true
+	}
+	for _, file := range manifest.Files {
+		if file.Winner && pending[strings.ToLower(filepath.Join(file.RelativePath, file.FileName))] {
+			estimate.Bytes += file.Size
+		}
+	}
+	return estimate
+}
+
+// Predict durations from copy throughput and the rest of run time of successful runs.
+// Runs without copied bytes used for the rest of run time only.
+func (e *Estimate) Predict(summaries []RunSummary) {
+	var copiedBytes int64
+	var copyTime, overheadTime time.Duration
+	for _, summary := range summaries {
+		if summary.Result != RunResultSuccess {
+			continue
+		}
+		total, err := time.ParseDuration(summary.Duration)
+		if err != nil {
+			continue
+		}
+		copyDuration, _ := time.ParseDuration(summary.CopyTime)
+		e.Samples++
+		overheadTime += total - copyDuration
+		if summary.CopiedBytes > 0 && copyDuration > 0 {
+			copiedBytes += summary.CopiedBytes
+			copyTime += copyDuration
+		}
+	}
+	if e.Samples == 0 {
+		return
+	}
+	e.Overhead = overheadTime / time.Duration(e.Samples)
+	if copyTime > 0 {
+		e.Rate = float64(copiedBytes) / copyTime.Seconds()
+		e.Copy = time.Duration(float64(e.Bytes) / e.Rate * float64(time.Second))
+	}
+}
+
+// Return size in bytes, KB, MB or GB for console output.
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d bytes", bytes)
+	}
+	size := float64(bytes) / unit
+	for _, suffix := range []string{"KB", "MB"} {
+		if size < unit {
+			return fmt.Sprintf("%.1f %v", size, suffix)
+		}
+		size /= unit
+	}
+	return fmt.Sprintf("%.1f GB", size)
+}
+
+// Run "estimate" subcommand. Print size of pending change set and expected run duration.
+func RunEstimateCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	flags := flag.NewFlagSet("estimate", flag.ContinueOnError)
+	runs := flags.Int("runs", EstimateDefaultRuns, "number of last runs used to measure throughput")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *runs < 1 {
+		return fmt.Errorf("number of runs must be positive")
+	}
+	mainConfig, err = ResolveConfigSecrets(mainConfig)
+	if err != nil {
+		return err
+	}
+	deployed, err := ReadDeployedState(filepath.Join(StateFolderPath(mainConfig, programDirectory), StateFileName))
+	if err != nil {
+		return fmt.Errorf("can't read deployed state - %v", err)
+	}
+	manifest, err := CurrentManifest(mainConfig, programDirectory)
+	if err != nil {
+		return err
+	}
+	estimate := EstimatePending(manifest, deployed)
+	fmt.Printf("Pending: %d files (%d added, %d changed), %v\n", estimate.Added+estimate.Changed, estimate.Added, estimate.Changed, FormatSize(estimate.Bytes))
+	if estimate.Removed > 0 {
+		fmt.Printf("Removed from sources: %d files\n", estimate.Removed)
+	}
+
+	summaries, err := ReadRecentSummaries(HistoryFolderPath(mainConfig, programDirectory), *runs)
+	if err != nil {
+		fmt.Println("Expected duration: unknown -", err)
+		return nil
+	}
+	estimate.Predict(summaries)
+	switch {
+	case estimate.Samples == 0:
+		fmt.Printf("Expected duration: unknown, no successful runs among last %d\n", len(summaries))
+	case estimate.Rate == 0:
+		fmt.Printf("Expected duration: more than %v, copy throughput unknown (%d runs copied nothing)\n", estimate.Overhead.Round(time.Second), estimate.Samples)
+	default:
+		fmt.Printf("Throughput: %v/s by %d successful runs\n", FormatSize(int64(estimate.Rate)), estimate.Samples)
+		fmt.Printf("Expected duration: %v (copy %v)\n", (estimate.Overhead + estimate.Copy).Round(time.Second), estimate.Copy.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Status of collected customisation file. Same values used by validation, copy,
+// history ("[COPIED   ]" form), manifest and run summary.
+type FileStatus string
+
+// File statuses.
+const (
+	StatusCopied     FileStatus = "COPIED"      // Chosen for deployment and copied into WDE folder.
+	StatusUnchanged  FileStatus = "UNCHANGED"   // Chosen for deployment, WDE folder already has identical file.
+	StatusSkip       FileStatus = "SKIP"        // Older copy of file chosen in another customisation folder.
+	StatusRedundant  FileStatus = "REDUNDANT"   // Matched by redundant files patterns, e.g. readme or .pdb.
+	StatusBlocked    FileStatus = "BLOCKED"     // Violates file-type policy.
+	StatusConflict   FileStatus = "CONFLICT"    // Equal version but different content, file from another folder chosen.
+	StatusProtected  FileStatus = "PROTECTED"   // Would overwrite WDE file protected by policy.
+	StatusRejected   FileStatus = "REJECTED"    // No clean verdict of external scanner.
+	StatusFailed     FileStatus = "FAILED"      // Copy into WDE folder failed.
+	StatusRolledBack FileStatus = "ROLLED_BACK" // Copied, then previous file restored because run failed.
+)
+
+// All statuses in report order.
+var FileStatuses = []FileStatus{
+	StatusCopied,
+	StatusUnchanged,
+	StatusSkip,
+	StatusRedundant,
+	StatusBlocked,
+	StatusConflict,
+	StatusProtected,
+	StatusRejected,
+	StatusFailed,
+	StatusRolledBack,
+}
+
+// Return status in history form, e.g. "[COPIED   ]".
+func (fs FileStatus) Bracketed() string {
+	return fmt.Sprintf("[%-9v]", string(fs))
+}
+
+// Check if file with status chosen for deployment.
+func (fs FileStatus) Deployed() bool {
+	return fs == StatusCopied || fs == StatusUnchanged
+}
+
+// Parse status in history form or plain, case insensitive.
+func ParseFileStatus(text string) FileStatus {
+	return FileStatus(strings.ToUpper(strings.TrimSpace(strings.Trim(text, "[]"))))
+}
+
+// Count statuses of files.
+func CountFileStatuses(statuses []FileStatus) map[FileStatus]int {
+	counts := make(map[FileStatus]int, len(FileStatuses))
+	for _, status := range statuses {
+		counts[status]++
+	}
+	return counts
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const HealthDefaultHeartbeatInterval = time.Minute // Heartbeat file rewrite interval if not configured.
+
+// Health of watch mode process for monitoring, served by health endpoint and written into heartbeat file.
+type HealthReport struct {
+	Healthy          bool      `json:"healthy"`
+	Reason           string    `json:"reason,omitempty"` // Why process unhealthy.
+	ProgramVersion   string    `json:"programVersion"`
+	PID              int       `json:"pid"`
+	ProcessStartTime time.Time `json:"processStartTime"`
+	Heartbeat        time.Time `json:"heartbeat"` // Time of report.
+	Running          bool      `json:"running"`   // Update run in progress.
+	Phase            string    `json:"phase,omitempty"`
+	LastRunTime      time.Time `json:"lastRunTime,omitempty"`     // End of last finished run.
+	LastRunResult    string    `json:"lastRunResult,omitempty"`   // Outcome category of last finished run.
+	LastSuccessTime  time.Time `json:"lastSuccessTime,omitempty"` // End of last successful run.
+}
+
+// Build health reports from run status of process.
+// Process unhealthy if no successful run finished for MaxAge since last success or process start.
+type HealthMonitor struct {
+	Publisher *RunStatusPublisher
+	StartTime time.Time
+	MaxAge    time.Duration // Zero disable staleness check, process healthy while alive.
+}
+
+// Return health report for provided time.
+func (hm HealthMonitor) Report(now time.Time) HealthReport {
+	status := hm.Publisher.Status()
+	report := HealthReport{
+		Healthy:          true,
+		ProgramVersion:   status.ProgramVersion,
+		PID:              status.PID,
+		ProcessStartTime: hm.StartTime,
+		Heartbeat:        now,
+		Running:          status.Running,
+		Phase:            status.Phase,
+		LastSuccessTime:  status.LastSuccessTime,
+	}
+	if status.LastRun != nil {
+		report.LastRunTime = status.LastRun.EndTime
+		report.LastRunResult = status.LastRun.OutcomeCategory()
+	}
+	if hm.MaxAge <= 0 {
+		return report
+	}
+	lastSuccess := status.LastSuccessTime
+	if lastSuccess.IsZero() {
+		lastSuccess = hm.StartTime
+	}
+	if age := now.Sub(lastSuccess); age > hm.MaxAge {
+		report.Healthy = false
+		report.Reason = fmt.Sprintf("no successful run for %v, allowed %v", age.Round(time.Second), hm.MaxAge)
+	}
+	return report
+}
+
+// Serve "/live" answering OK while process alive and "/health" with health report,
+// status 503 if process unhealthy.
+func (hm HealthMonitor) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("OK\n"))
+	})
+	mux.HandleFunc("/health", func(writer http.ResponseWriter, request *http.Request) {
+		report := hm.Report(TimestampNow())
+		reportBytes, err := json.Marshal(report)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		if !report.Healthy {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+		}
+		writer.Write(reportBytes)
+	})
+	return mux
+}
+
+// Write health report into heartbeat file.
+func (hm HealthMonitor) WriteHeartbeat(heartbeatFullPath string) error {
+	reportBytes, err := json.MarshalIndent(hm.Report(TimestampNow()), "", "  ")
+	if err != nil {
+		return err
+	}
+	return SaveBytesIntoFile(heartbeatFullPath, reportBytes)
+}
+
+// Parse health settings of watch mode from config.
+func HealthDurations(mainConfig MainCfgYAML) (time.Duration, time.Duration, error) {
+	heartbeatInterval, maxAge := HealthDefaultHeartbeatInterval, time.Duration(0)
+	var err error
+	if mainConfig.Watch.HeartbeatInterval != "" {
+		heartbeatInterval, err = time.ParseDuration(mainConfig.Watch.HeartbeatInterval)
+		if err != nil {
+			return 0, 0, fmt.Errorf("can't parse Watch.HeartbeatInterval - %v", err)
+		}
+		if heartbeatInterval <= 0 {
+			return 0, 0, fmt.Errorf("Watch.HeartbeatInterval must be positive")
+		}
+	}
+	if mainConfig.Watch.HealthMaxAge != "" {
+		maxAge, err = time.ParseDuration(mainConfig.Watch.HealthMaxAge)
+		if err != nil {
+			return 0, 0, fmt.Errorf("can't parse Watch.HealthMaxAge - %v", err)
+		}
+	}
+	return heartbeatInterval, maxAge, nil
+}
+
+// Start health endpoint and heartbeat file writing for watch mode if configured.
+// They work in background until process exit, settings changed by config reload ignored.
+func StartHealthReporting(mainConfig MainCfgYAML, programDirectory string, publisher *RunStatusPublisher) error {
+	if mainConfig.Watch.HealthAddress == "" && mainConfig.Watch.HeartbeatFile == "" {
+		return nil
+	}
+	heartbeatInterval, maxAge, err := HealthDurations(mainConfig)
+	if err != nil {
+		return err
+	}
+	monitor := HealthMonitor{Publisher: publisher, StartTime: TimestampNow(), MaxAge: maxAge}
+	if mainConfig.Watch.HealthAddress != "" {
+		listener, err := net.Listen("tcp", mainConfig.Watch.HealthAddress)
+		if err != nil {
+			return fmt.Errorf("can't listen health endpoint - %v", err)
+		}
+		log.Printf("Health endpoint listen on 'http://%v/health'", listener.Addr())
+		go func() {
+			err := http.Serve(listener, monitor.Handler())
+			log.Println("Health endpoint stopped -", err)
+		}()
+	}
+	if mainConfig.Watch.HeartbeatFile != "" {
+		heartbeatFullPath := WorkspaceArtifactPath(mainConfig, programDirectory, mainConfig.Watch.HeartbeatFile, "")
+		err = monitor.WriteHeartbeat(heartbeatFullPath)
+		if err != nil {
+			return fmt.Errorf("can't write heartbeat file - %v", err)
+		}
+		log.Printf("Heartbeat written into `%v` every %v", heartbeatFullPath, heartbeatInterval)
+		go func() {
+			for range time.Tick(heartbeatInterval) {
+				err := monitor.WriteHeartbeat(heartbeatFullPath)
+				if err != nil {
+					log.Println("Can't write heartbeat file -", err)
+				}
+			}
+		}()
+	}
+	return nil
+}
@@ -6,15 +6,20 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
-// Write history file with provided data.
+// Write history file with provided data. Old history files cleared if rotate set.
+// Files statuses appended when run finished, see HistoryStatusLines.
 func WriteHistoryFile(
-	fileList []CustomisationFile,
-	customFilesFolder string,
-	fileStatuses,
 	customisationFolders []string,
-	historyFileFullPath string,
+	facts HostFacts,
+	release ReleaseInfo,
+	labels RunLabels,
+	historyFileFullPath,
+	historyFilePrefix string,
+	rotate bool,
 	endChan chan bool,
 	logger *zap.Logger,
 ) {
@@ -46,53 +51,209 @@ func WriteHistoryFile(
 			currentUserName = CurrentUser.Name
 		}
 	}
-	_, err = historyFile.WriteString(fmt.Sprint(
+	releaseVersionLine := ""
+	if release.Version != "" {
+		releaseVersionLine = fmt.Sprint(HistoryReleaseVersionPrefix, release.Version, "\n")
+	}
+	labelLines := ""
+	for _, line := range labels.HistoryLines() {
+		labelLines += fmt.Sprint(line, "\n")
+	}
+	releaseNotesBlock := ""
+	if lines := ReleaseNotesHistoryLines(release.Notes); len(lines) > 0 {
+		releaseNotesBlock = fmt.Sprint(strings.Join(lines, "\n"), "\n\n")
+	}
+	_, err = historyFile.WriteString(Redact(fmt.Sprint(
 		"Program version: ",
 		programVersion,
 		"\n",
 		"Started by: ",
 		currentUserName,
-		"\n\nCollected folders\n"))
+		"\n",
+		releaseVersionLine,
+		labelLines,
+		strings.Join(facts.HistoryLines(), "\n"),
+		"\n\n",
+		releaseNotesBlock,
+		"Collected folders\n")))
 	if err != nil {
 		logger.Warn(fmt.Sprint("(WriteHistoryFile) History file not written - ", err))
 		return
 	}
 	// Write found customisation folders
 	for _, fName := range customisationFolders {
-		_, err = historyFile.WriteString(fmt.Sprint(fName, "\n"))
+		_, err = historyFile.WriteString(Redact(fmt.Sprint(fName, "\n")))
 		if err != nil {
 			logger.Warn(fmt.Sprint("(WriteHistoryFile) History file not written - ", err))
 			return
 		}
 	}
-	// Write collected files statuses
-	_, err = historyFile.WriteString("\nCollected files statuses\n")
-	if err != nil {
-		logger.Warn(fmt.Sprint("(WriteHistoryFile) History file not written - ", err))
+	logger.Info("(WriteHistoryFile) History file written successfully")
+	if !rotate {
 		return
 	}
+	err = ClearOldFiles(historyFolder, historyFilePrefix, 15)
+	if err != nil {
+		logger.Warn(fmt.Sprint("(WriteHistoryFile) Can't clear old history files - ", err))
+	}
+	return
+}
+
+// Prefixes of subtotal lines in "Collected files statuses" section.
+const (
+	HistoryFolderPrefix string = "Folder: " // Starts files group of one customisation folder.
+	HistoryTotalPrefix  string = "Total: "  // Counts of all collected files.
+)
+
+// Files of one customisation folder in "Collected files statuses" section.
+type HistoryFileGroup struct {
+	Folder string             // Customisation folder path relative to its source.
+	Lines  []string           // Status lines like "[COPIED   ]Folder\File.dll".
+	Counts map[FileStatus]int // Number of files by status.
+}
+
+// Return group header with folder subtotals, e.g. "Folder: Folder01  (3 files: COPIED 2, SKIP 1)".
+func (hfg HistoryFileGroup) Header() string {
+	return fmt.Sprintf("%v%v  (%v)", HistoryFolderPrefix, hfg.Folder, FormatStatusCounts(hfg.Counts, len(hfg.Lines)))
+}
+
+// Group status lines of collected files by customisation folder.
+// Folders ordered by first file, files keep collection order.
+func GroupHistoryFiles(fileList []CustomisationFile, fileStatuses []FileStatus) ([]HistoryFileGroup, error) {
+	groups := make([]HistoryFileGroup, 0)
+	groupIndex := make(map[string]int)
 	for index, file := range fileList {
-		shortFilePath, err := filepath.Rel(customFilesFolder, file.SourcePath)
+		shortFilePath, err := filepath.Rel(file.SourceFolder, file.SourcePath)
 		if err != nil {
-			logger.Warn(fmt.Sprint("(WriteHistoryFile) History file not written - ", err))
-			return
+			return nil, err
+		}
+		folder, err := filepath.Rel(file.SourceFolder, file.CustomisationFolder)
+		if err != nil || file.CustomisationFolder == "" {
+			folder = file.CustomisationFolder
+		}
+		id, ok := groupIndex[folder]
+		if !ok {
+			id = len(groups)
+			groupIndex[folder] = id
+			groups = append(groups, HistoryFileGroup{Folder: folder, Counts: make(map[FileStatus]int)})
+		}
+		groups[id].Lines = append(groups[id].Lines, fmt.Sprint(fileStatuses[index].Bracketed(), shortFilePath))
+		groups[id].Counts[fileStatuses[index]]++
+	}
+	return groups, nil
+}
+
+// Return lines of "Collected files statuses" section: files grouped by customisation folder
+// with folder subtotals and total line at the end.
+func HistoryStatusLines(fileList []CustomisationFile, fileStatuses []FileStatus) ([]string, error) {
+	groups, err := GroupHistoryFiles(fileList, fileStatuses)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, 0, len(fileList)+len(groups)+1)
+	totals := make(map[FileStatus]int)
+	for _, group := range groups {
+		lines = append(lines, group.Header())
+		lines = append(lines, group.Lines...)
+		for status, count := range group.Counts {
+			totals[status] += count
+		}
+	}
+	return append(lines, fmt.Sprint(HistoryTotalPrefix, FormatStatusCounts(totals, len(fileList)))), nil
+}
+
+// Format number of files by status, e.g. "5 files: COPIED 3, SKIP 2".
+// Known statuses go first in FileStatuses order, zero counts omitted.
+func FormatStatusCounts(counts map[FileStatus]int, files int) string {
+	parts := make([]string, 0, len(counts))
+	known := make(map[FileStatus]bool, len(FileStatuses))
+	for _, status := range FileStatuses {
+		known[status] = true
+		if counts[status] > 0 {
+			parts = append(parts, fmt.Sprint(status, " ", counts[status]))
+		}
+	}
+	others := make([]string, 0)
+	for status, count := range counts {
+		if count > 0 && !known[status] {
+			others = append(others, fmt.Sprint(status, " ", count))
+		}
+	}
+	sort.Strings(others)
+	parts = append(parts, others...)
+	if len(parts) == 0 {
+		return fmt.Sprint(files, " files")
+	}
+	return fmt.Sprint(files, " files: ", strings.Join(parts, ", "))
+}
+
+// Store lines describing run events for "Run events" history section.
+type HistoryEvents []string
+
+// Add formatted event line.
+func (he *HistoryEvents) Add(format string, a ...interface{}) {
+	*he = append(*he, fmt.Sprintf(format, a...))
+}
+
+// Section appended to history file after it written.
+type HistorySection struct {
+	Title string
+	Lines []string
+}
+
+// Wait for the history file to finish writing, append sections and run events and mirror history file.
+// Intended to be deferred in main.
+func FinishHistoryFile(historyFileFullPath string, sections []HistorySection, events *HistoryEvents, endChan chan bool, mirrorFolder string, logger *zap.Logger) {
+	logger.Info(fmt.Sprintf("History writing stopped '%v'", <-endChan))
+	if len(*events) > 0 {
+		sections = append(sections, HistorySection{Title: "Run events", Lines: *events})
+	}
+	for _, section := range sections {
+		if len(section.Lines) == 0 {
+			continue
 		}
-		fileStatusString := fmt.Sprint(fileStatuses[index], shortFilePath, "\n")
-		_, err = historyFile.WriteString(fileStatusString)
+		err := AppendHistorySection(historyFileFullPath, section.Title, section.Lines)
 		if err != nil {
-			logger.Warn(fmt.Sprint("(WriteHistoryFile) History file not written - ", err))
-			return
+			logger.Warn(fmt.Sprintf("Can't append '%v' into history file - %v", section.Title, err))
 		}
 	}
-	logger.Info("(WriteHistoryFile) History file written successfully")
-	err = ClearOldFiles(historyFolder, HistoryFileName, 15)
+	MirrorFileWithLog(mirrorFolder, "History", historyFileFullPath, logger)
+}
+
+// Append section with title and lines to the end of existing history file.
+func AppendHistorySection(historyFileFullPath, title string, lines []string) error {
+	historyFile, err := os.OpenFile(historyFileFullPath, os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
-		logger.Warn(fmt.Sprint("(WriteHistoryFile) Can't clear old history files - ", err))
+		return err
 	}
-	return
+	defer historyFile.Close()
+	_, err = historyFile.WriteString(Redact(fmt.Sprint("\n", title, "\n")))
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		_, err = historyFile.WriteString(Redact(fmt.Sprint(line, "\n")))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Wrapper for send data into channel from deffer.
 func DeferChannelSendTrue(endChan chan bool) {
 	endChan <- true
 }
+
+// Get history folder from config or default one, relative folders inside workspace.
+func HistoryFolderPath(mainConfig MainCfgYAML, programDirectory string) string {
+	return WorkspaceArtifactPath(mainConfig, programDirectory, mainConfig.History.Folder, "History")
+}
+
+// Get history file name prefix from config or default one.
+func HistoryFilePrefix(mainConfig MainCfgYAML) string {
+	if mainConfig.History.Name != "" {
+		return mainConfig.History.Name
+	}
+	return HistoryFileName
+}
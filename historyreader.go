@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Store data parsed from one history file.
+type HistoryRecord struct {
+	Name           string             // History file name.
+	StartTime      time.Time          // Run start time parsed from file name.
+	ProgramVersion string             // "Program version" header value.
+	StartedBy      string             // "Started by" header value.
+	ReleaseVersion string             // "Release version" header value, empty if not detected.
+	Labels         RunLabels          // "Tag" and "Ticket" header values.
+	Host           HostFacts          // Host facts header values, empty for runs before they recorded.
+	ReleaseNotes   []string           // "Release notes" header lines, folder lines and indented notes.
+	Folders        []string           // Collected customisation folders.
+	Files          []HistoryFileEntry // Collected files with statuses.
+}
+
+// Store one line of "Collected files statuses" section.
+type HistoryFileEntry struct {
+	Folder string     // Customisation folder of file.
+	Status FileStatus // Status without brackets and spaces, e.g. "COPIED".
+	Path   string     // Path relative to customisations folder.
+}
+
+// Filter for history entries. Empty fields match everything.
+type HistoryFilter struct {
+	Status string
+	File   string
+}
+
+// Check if entry satisfy filter. Status compared case insensitive,
+// file matched case insensitive by any part of the path.
+func (hf HistoryFilter) Match(entry HistoryFileEntry) bool {
+	if hf.Status != "" && ParseFileStatus(hf.Status) != entry.Status {
+		return false
+	}
+	if hf.File != "" && !strings.Contains(strings.ToLower(entry.Path), strings.ToLower(hf.File)) {
+		return false
+	}
+	return true
+}
+
+// Return entries of record which satisfy filter.
+func (hr HistoryRecord) FilteredFiles(filter HistoryFilter) []HistoryFileEntry {
+	result := make([]HistoryFileEntry, 0, len(hr.Files))
+	for _, entry := range hr.Files {
+		if filter.Match(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// Count entries of record by status.
+func (hr HistoryRecord) StatusCounts() map[FileStatus]int {
+	counts := make(map[FileStatus]int)
+	for _, entry := range hr.Files {
+		counts[entry.Status]++
+	}
+	return counts
+}
+
+// Find all history files in folder by prefix. Result sorted from newest to oldest run.
+func ListHistoryFiles(historyFolder, historyFilePrefix string) ([]string, error) {
+	dirContent, err := ioutil.ReadDir(historyFolder)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(dirContent))
+	for _, entity := range dirContent {
+		if entity.IsDir() {
+			continue
+		}
+		if !strings.HasPrefix(entity.Name(), historyFilePrefix) || filepath.Ext(entity.Name()) != ".log" {
+			continue
+		}
+		names = append(names, entity.Name())
+	}
+	// Time layout in file name sort in chronological order as string.
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names, nil
+}
+
+// Parse history file written by WriteHistoryFile.
+func ReadHistoryFile(historyFolder, historyFilePrefix, name string) (HistoryRecord, error) {
+	file, err := os.Open(filepath.Join(historyFolder, name))
+	if err != nil {
+		return HistoryRecord{}, err
+	}
+	defer file.Close()
+
+	record := HistoryRecord{Name: name}
+	timeString := strings.TrimSuffix(strings.TrimPrefix(name, historyFilePrefix), ".log")
+	record.StartTime, _ = ParseFileTimestamp(timeString)
+
+	section, folder := "", ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			section = ""
+		case strings.HasPrefix(line, "Program version: "):
+			record.ProgramVersion = strings.TrimPrefix(line, "Program version: ")
+		case strings.HasPrefix(line, "Started by: "):
+			record.StartedBy = strings.TrimPrefix(line, "Started by: ")
+		case section == "" && strings.HasPrefix(line, HistoryReleaseVersionPrefix):
+			record.ReleaseVersion = strings.TrimPrefix(line, HistoryReleaseVersionPrefix)
+		case section == "" && strings.HasPrefix(line, HistoryTagPrefix):
+			record.Labels.Tag = strings.TrimPrefix(line, HistoryTagPrefix)
+		case section == "" && strings.HasPrefix(line, HistoryTicketPrefix):
+			record.Labels.Ticket = strings.TrimPrefix(line, HistoryTicketPrefix)
+		case section == "" && record.Host.ParseHistoryLine(line):
+		case section == "" && line == HistoryReleaseNotesTitle:
+			section = "notes"
+		case section == "notes":
+			record.ReleaseNotes = append(record.ReleaseNotes, line)
+		case line == "Collected folders":
+			section = "folders"
+		case line == "Collected files statuses":
+			section = "files"
+		case section == "folders":
+			record.Folders = append(record.Folders, line)
+		case section == "files" && strings.HasPrefix(line, HistoryFolderPrefix):
+			folder = strings.TrimPrefix(line, HistoryFolderPrefix)
+			if end := strings.LastIndex(folder, "  ("); end >= 0 {
+				folder = folder[:end]
+			}
+		case section == "files" && strings.HasPrefix(line, HistoryTotalPrefix):
+		case section == "files":
+			entry := ParseHistoryFileEntry(line)
+			entry.Folder = folder
+			if entry.Folder == "" {
+				// History written before grouping, folder is first element of path.
+				entry.Folder = strings.SplitN(filepath.ToSlash(entry.Path), "/", 2)[0]
+			}
+			record.Files = append(record.Files, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return HistoryRecord{}, err
+	}
+	return record, nil
+}
+
+// Split history line like "[COPIED   ]Folder\File.dll" into status and path.
+func ParseHistoryFileEntry(line string) HistoryFileEntry {
+	end := strings.Index(line, "]")
+	if !strings.HasPrefix(line, "[") || end < 0 {
+		return HistoryFileEntry{Path: line}
+	}
+	return HistoryFileEntry{
+		Status: ParseFileStatus(line[:end+1]),
+		Path:   line[end+1:],
+	}
+}
+
+// Process "history" subcommand.
+// Usage: history show [-status STATUS] [-file NAME] [-limit N] [-page N] [last|<run time>]
+// Without run argument list recent runs, otherwise show details of one run.
+func RunHistoryCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	if len(args) == 0 || args[0] != "show" {
+		return fmt.Errorf("usage: history show [-status STATUS] [-file NAME] [-limit N] [-page N] [last|%v]", FileTimeLayout())
+	}
+	flags := flag.NewFlagSet("history show", flag.ContinueOnError)
+	status := flags.String("status", "", "show only files with status, e.g. COPIED, SKIP, CONFLICT, FAILED")
+	file := flags.String("file", "", "show only files which path contains provided text")
+	limit := flags.Int("limit", 20, "number of lines per page")
+	page := flags.Int("page", 1, "page number starting from 1")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	if *limit < 1 || *page < 1 {
+		return fmt.Errorf("limit and page must be positive")
+	}
+	filter := HistoryFilter{Status: *status, File: *file}
+
+	historyFolder := HistoryFolderPath(mainConfig, programDirectory)
+	historyFilePrefix := HistoryFilePrefix(mainConfig)
+	names, err := ListHistoryFiles(historyFolder, historyFilePrefix)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return ErrNoFilesFoundInFolderByPattern
+	}
+
+	if flags.NArg() == 0 {
+		return PrintHistoryRuns(historyFolder, historyFilePrefix, names, filter, *limit, *page)
+	}
+	runName := names[0]
+	if flags.Arg(0) != "last" {
+		runName = fmt.Sprint(historyFilePrefix, flags.Arg(0), ".log")
+	}
+	record, err := ReadHistoryFile(historyFolder, historyFilePrefix, runName)
+	if err != nil {
+		return err
+	}
+	PrintHistoryRecord(record, filter, *limit, *page)
+	return nil
+}
+
+// Print one line per run which contains files matched by filter.
+func PrintHistoryRuns(historyFolder, historyFilePrefix string, names []string, filter HistoryFilter, limit, page int) error {
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		record, err := ReadHistoryFile(historyFolder, historyFilePrefix, name)
+		if err != nil {
+			return err
+		}
+		matched := record.FilteredFiles(filter)
+		if len(matched) == 0 && (filter.Status != "" || filter.File != "") {
+			continue
+		}
+		counts := record.StatusCounts()
+		lines = append(lines, fmt.Sprintf(
+			"%v  version %v  wde %-14v  by %-20v  folders %3d  copied %4d  skip %4d  redundant %4d  blocked %4d  matched %4d",
+			FileTimestamp(record.StartTime),
+			record.ProgramVersion,
+			record.Host.WDEVersion,
+			record.StartedBy,
+			len(record.Folders),
+			counts[StatusCopied],
+			counts[StatusSkip],
+			counts[StatusRedundant],
+			counts[StatusBlocked],
+			len(matched),
+		))
+	}
+	PrintPage(lines, limit, page)
+	return nil
+}
+
+// Print header and filtered files of one run.
+func PrintHistoryRecord(record HistoryRecord, filter HistoryFilter, limit, page int) {
+	fmt.Println("Run:", FileTimestamp(record.StartTime))
+	fmt.Println("Program version:", record.ProgramVersion)
+	fmt.Println("Started by:", record.StartedBy)
+	if record.ReleaseVersion != "" {
+		fmt.Println("Release version:", record.ReleaseVersion)
+	}
+	for _, line := range record.Labels.HistoryLines() {
+		fmt.Println(line)
+	}
+	if record.Host.Hostname != "" {
+		for _, line := range record.Host.HistoryLines() {
+			fmt.Println(line)
+		}
+	}
+	if len(record.ReleaseNotes) > 0 {
+		fmt.Println(HistoryReleaseNotesTitle)
+		for _, line := range record.ReleaseNotes {
+			fmt.Println(line)
+		}
+	}
+	fmt.Println("Collected folders:", strings.Join(record.Folders, ", "))
+	fmt.Println()
+	files := record.FilteredFiles(filter)
+	lines := make([]string, 0, len(files))
+	for start := 0; start < len(files); {
+		end, counts := start, make(map[FileStatus]int)
+		for ; end < len(files) && files[end].Folder == files[start].Folder; end++ {
+			counts[files[end].Status]++
+		}
+		lines = append(lines, fmt.Sprintf("%v  (%v)", files[start].Folder, FormatStatusCounts(counts, end-start)))
+		for _, entry := range files[start:end] {
+			lines = append(lines, fmt.Sprintf("  [%-9v] %v", entry.Status, entry.Path))
+		}
+		start = end
+	}
+	PrintPage(lines, limit, page)
+}
+
+// Print one page of lines with page position footer.
+func PrintPage(lines []string, limit, page int) {
+	first := (page - 1) * limit
+	if first > len(lines) {
+		first = len(lines)
+	}
+	last := first + limit
+	if last > len(lines) {
+		last = len(lines)
+	}
+	for _, line := range lines[first:last] {
+		fmt.Println(line)
+	}
+	pages := (len(lines) + limit - 1) / limit
+	fmt.Printf("\nPage %d of %d (%d lines)\n", page, pages, len(lines))
+}
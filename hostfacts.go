@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+)
+
+// Facts about machine included into run summary and history header.
+// Collected best effort, unknown facts left empty.
+type HostFacts struct {
+	Hostname     string `json:"hostname"`
+	OSVersion    string `json:"osVersion,omitempty"`
+	LoggedInUser string `json:"loggedInUser,omitempty"`      // Interactive console user, may differ from user running program.
+	Domain       string `json:"domain,omitempty"`            // DNS domain of machine.
+	OU           string `json:"ou,omitempty"`                // Organizational unit of machine account.
+	WDEVersion   string `json:"wdeVersion,omitempty"`        // File version of WDE executable.
+	WDEProduct   string `json:"wdeProductVersion,omitempty"` // Product version of WDE executable.
+	Session      string `json:"session,omitempty"`           // Remote Desktop session of process, multi-session host marked.
+}
+
+// Collect facts about this machine and WDE installation.
+func CollectHostFacts(wdeVersion WDEVersion) HostFacts {
+	var facts HostFacts
+	facts.Hostname, _ = os.Hostname()
+	facts.OSVersion, _ = GetOSVersion()
+	facts.LoggedInUser, _ = GetConsoleUserName()
+	facts.Domain, _ = GetMachineDomain()
+	distinguishedName, err := GetMachineDistinguishedName()
+	if err == nil {
+		facts.OU = OUFromDistinguishedName(distinguishedName)
+	}
+	facts.WDEVersion = wdeVersion.File.String()
+	facts.WDEProduct = wdeVersion.Product
+	facts.Session = SessionDescription()
+	return facts
+}
+
+// Return distinguished name without leading machine CN, e.g. "OU=Agents,DC=corp,DC=local".
+func OUFromDistinguishedName(distinguishedName string) string {
+	if !strings.HasPrefix(strings.ToUpper(distinguishedName), "CN=") {
+		return distinguishedName
+	}
+	// Escaped commas ("\,") may be present in CN.
+	for i := 0; i < len(distinguishedName); i++ {
+		switch distinguishedName[i] {
+		case '\\':
+			i++
+		case ',':
+			return distinguishedName[i+1:]
+		}
+	}
+	return ""
+}
+
+// History header titles of facts.
+type hostFactField struct {
+	title string
+	value *string
+}
+
+func (hf *HostFacts) historyFields() []hostFactField {
+	return []hostFactField{
+		{"Hostname", &hf.Hostname},
+		{"OS version", &hf.OSVersion},
+		{"Logged-in user", &hf.LoggedInUser},
+		{"Domain", &hf.Domain},
+		{"OU", &hf.OU},
+		{"WDE version", &hf.WDEVersion},
+		{"WDE product version", &hf.WDEProduct},
+		{"Session", &hf.Session},
+	}
+}
+
+// Return facts as "Title: value" lines for history header.
+func (hf HostFacts) HistoryLines() []string {
+	fields := hf.historyFields()
+	lines := make([]string, 0, len(fields))
+	for _, field := range fields {
+		lines = append(lines, fmt.Sprint(field.title, ": ", *field.value))
+	}
+	return lines
+}
+
+// Fill fact from history header line. Return false if line is not a fact.
+func (hf *HostFacts) ParseHistoryLine(line string) bool {
+	for _, field := range hf.historyFields() {
+		if strings.HasPrefix(line, field.title+": ") {
+			*field.value = strings.TrimPrefix(line, field.title+": ")
+			return true
+		}
+	}
+	return false
+}
+
+// Log facts with structured fields.
+func (hf HostFacts) Log(logger *zap.Logger) {
+	logger.Info("Host facts",
+		zap.String("hostname", hf.Hostname),
+		zap.String("osVersion", hf.OSVersion),
+		zap.String("loggedInUser", hf.LoggedInUser),
+		zap.String("domain", hf.Domain),
+		zap.String("ou", hf.OU),
+		zap.String("wdeVersion", hf.WDEVersion),
+		zap.String("wdeProductVersion", hf.WDEProduct),
+		zap.String("session", hf.Session),
+	)
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+import (
+	"runtime"
+)
+
+// Return operating system name.
+func GetOSVersion() (string, error) {
+	return runtime.GOOS, nil
+}
+
+// Console session user available only on Windows.
+func GetConsoleUserName() (string, error) {
+	return "", ErrNotSupportedOnPlatform
+}
+
+// Machine domain available only on Windows.
+func GetMachineDomain() (string, error) {
+	return "", ErrNotSupportedOnPlatform
+}
+
+// Machine account distinguished name available only on Windows.
+func GetMachineDistinguishedName() (string, error) {
+	return "", ErrNotSupportedOnPlatform
+}
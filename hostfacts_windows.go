@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"unsafe"
+)
+
+// WTS_INFO_CLASS value for WTSQuerySessionInformationW.
+const wtsUserName = 5
+
+var (
+	wtsapi32                        = windows.NewLazySystemDLL("wtsapi32.dll")
+	procWTSQuerySessionInformationW = wtsapi32.NewProc("WTSQuerySessionInformationW")
+)
+
+// Return Windows product name with build, e.g. "Windows 10 Enterprise (10.0.19045.3803)".
+func GetOSVersion() (string, error) {
+	info := windows.RtlGetVersion()
+	version := fmt.Sprintf("%d.%d.%d", info.MajorVersion, info.MinorVersion, info.BuildNumber)
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows NT\CurrentVersion`, registry.QUERY_VALUE)
+	if err != nil {
+		return version, nil
+	}
+	defer key.Close()
+	if ubr, _, err := key.GetIntegerValue("UBR"); err == nil {
+		version = fmt.Sprint(version, ".", ubr)
+	}
+	if productName, _, err := key.GetStringValue("ProductName"); err == nil {
+		version = fmt.Sprintf("%v (%v)", productName, version)
+	}
+	return version, nil
+}
+
+// Return name of user logged in active console session. Empty if nobody logged in.
+func GetConsoleUserName() (string, error) {
+	sessionID := windows.WTSGetActiveConsoleSessionId()
+	if sessionID == 0xFFFFFFFF {
+		return "", nil
+	}
+	var buffer *uint16
+	var size uint32
+	ok, _, err := procWTSQuerySessionInformationW.Call(
+		0, // WTS_CURRENT_SERVER_HANDLE
+		uintptr(sessionID),
+		wtsUserName,
+		uintptr(unsafe.Pointer(&buffer)),
+		uintptr(unsafe.Pointer(&size)),
+	)
+	if ok == 0 {
+		return "", err
+	}
+	defer windows.WTSFreeMemory(uintptr(unsafe.Pointer(buffer)))
+	return windows.UTF16PtrToString(buffer), nil
+}
+
+// Return DNS domain of machine. Empty for workgroup machine.
+func GetMachineDomain() (string, error) {
+	buffer := make([]uint16, 256)
+	size := uint32(len(buffer))
+	err := windows.GetComputerNameEx(windows.ComputerNameDnsDomain, &buffer[0], &size)
+	if err != nil {
+		return "", err
+	}
+	return windows.UTF16ToString(buffer[:size]), nil
+}
+
+// Return distinguished name of machine account saved by Group Policy.
+func GetMachineDistinguishedName() (string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows\CurrentVersion\Group Policy\State\Machine`, registry.QUERY_VALUE)
+	if err != nil {
+		return "", err
+	}
+	defer key.Close()
+	distinguishedName, _, err := key.GetStringValue("Distinguished-Name")
+	return distinguishedName, err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+)
+
+// Actions on exceeded size limit.
+const (
+	LimitActionAbort = "abort" // Stop run before anything copied.
+	LimitActionWarn  = "warn"  // Log warning and continue.
+)
+
+// Check files to deploy against single file and total size limits in bytes, zero limit disabled.
+// Return descriptions of violations.
+func CheckSizeLimits(files []CustomisationFile, maxFileSize, maxTotalSize int64) []string {
+	violations := make([]string, 0, 2)
+	var total int64
+	for _, file := range files {
+		total += file.Size
+		if maxFileSize > 0 && file.Size > maxFileSize {
+			violations = append(violations, fmt.Sprintf("file '%v' size %v bytes exceed limit %v bytes", file.SourcePath, file.Size, maxFileSize))
+		}
+	}
+	if maxTotalSize > 0 && total > maxTotalSize {
+		violations = append(violations, fmt.Sprintf("total size %v bytes exceed limit %v bytes", total, maxTotalSize))
+	}
+	return violations
+}
+
+// Check size limits from config and apply configured action.
+// Return error if limits exceeded and action is abort.
+func ApplySizeLimits(files []CustomisationFile, mainConfig MainCfgYAML, events *HistoryEvents, logger *zap.Logger) error {
+	action := mainConfig.Limits.Action
+	switch action {
+	case "":
+		action = LimitActionAbort
+	case LimitActionAbort, LimitActionWarn:
+	default:
+		return fmt.Errorf("unknown Limits.Action '%v'", action)
+	}
+	violations := CheckSizeLimits(files, mainConfig.Limits.MaxFileSizeMB*1024*1024, mainConfig.Limits.MaxTotalSizeMB*1024*1024)
+	if len(violations) == 0 {
+		return nil
+	}
+	for _, violation := range violations {
+		logger.Warn(fmt.Sprint("Size limit exceeded - ", violation))
+		events.Add("Size limit exceeded - %v", violation)
+	}
+	if action == LimitActionAbort {
+		return fmt.Errorf("size limits exceeded, %v violations", len(violations))
+	}
+	return nil
+}
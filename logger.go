@@ -1,11 +1,32 @@
 package main
 
 import (
+	"fmt"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+const QuietLogLevel string = "error" // Log level used in quiet mode if level not set explicitly.
+
+// Override Log.Verbose by command line flags.
+// Explicit --log-level take precedence over --quiet.
+func ApplyLogOverrides(mainConfig *MainCfgYAML) error {
+	if *logLevelFlag != "" {
+		var logLevel zapcore.Level
+		err := logLevel.UnmarshalText([]byte(*logLevelFlag))
+		if err != nil {
+			return fmt.Errorf("invalid log level '%v' - %v", *logLevelFlag, err)
+		}
+		mainConfig.Log.Verbose = logLevel.String()
+		return nil
+	}
+	if *quietFlag {
+		mainConfig.Log.Verbose = QuietLogLevel
+	}
+	return nil
+}
+
 // Return simple logger with rotation. v1.
 // Take logging level, full path to log file, vax size of log file in MB and number of backup files.
 // Have no time limit for store log files
@@ -20,14 +41,15 @@ func NewZapSimpleLoggerWithRotation(logLevelStr string, logFilePath string, maxS
 	cfg.EncoderConfig.TimeKey = "time"
 	cfg.EncoderConfig.MessageKey = "message"
 	cfg.EncoderConfig.LevelKey = "level"
-	cfg.EncoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout("2006.01.02 15:04:05")
+	cfg.EncoderConfig.EncodeTime = encodeLogTime // Layout and zone configured by ConfigureTimestamps.
 	cfg.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
 
-	writer := zapcore.AddSync(&lumberjack.Logger{
+	// Sensitive values masked if redaction enabled, see ConfigureRedaction.
+	writer := redactingWriter{zapcore.AddSync(&lumberjack.Logger{
 		Filename:   logFilePath,
 		MaxSize:    maxSize, // megabytes
 		MaxBackups: maxBackups,
-	})
+	})}
 
 	core := zapcore.NewCore(
 		zapcore.NewConsoleEncoder(cfg.EncoderConfig),
@@ -38,3 +60,16 @@ func NewZapSimpleLoggerWithRotation(logLevelStr string, logFilePath string, maxS
 
 	return logger
 }
+
+// Get log folder from config or default one, relative folders inside workspace.
+func LogFolderPath(mainConfig MainCfgYAML, programDirectory string) string {
+	return WorkspaceArtifactPath(mainConfig, programDirectory, mainConfig.Log.Folder, "Log")
+}
+
+// Get log file name prefix from config or default one.
+func LogFilePrefix(mainConfig MainCfgYAML) string {
+	if mainConfig.Log.Name != "" {
+		return fmt.Sprint(mainConfig.Log.Name, "_")
+	}
+	return "WdeCustomisationUpdater_"
+}
@@ -2,32 +2,57 @@ package main
 
 import (
 	"encoding/xml"
+	"flag"
 	"fmt"
 	"go.uber.org/zap"
-	"golang.org/x/sys/windows/registry"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"sort"
-	"time"
+	"strings"
 )
 
 const (
-	programVersion   string = "2.0.2.0"                                   // Program version.
-	confFile         string = "config.yaml"                               // Configuration file name.
-	logHistLayout    string = "2006.01.02_150405"                         // Layout for "log" and "history" filenames time appending.
-	WDESubfolder     string = "InteractionWorkspace"                      // WDE subfolder in MainCfgYAML.WDEInstallationFolder.
-	DMSubfolder      string = "InteractionWorkspaceDeploymentManager"     // WDE Deployment Manager subfolder in MainCfgYAML.WDEInstallationFolder.
-	DMExecutableName string = "InteractionWorkspaceDeploymentManager.exe" // WDE Deployment Manager executable.
-	DMRegistryDir    string = `Software\Genesys\DeploymentManager`        // WDE Deployment Manager registry directory.
-	SavedRegFolder   string = "Registry"                                  // Folder name for saved registry data.
-	RegFileName      string = "DM_Registry_values_"                       // Name prefix for saved registry files.
-	HistoryFileName  string = "WDE_History_"                              // Name prefix for history files.
+	programVersion    string = "2.0.2.0"                                   // Program version.
+	confFile          string = "config.yaml"                               // Configuration file name.
+	logHistLayout     string = "2006.01.02_150405"                         // Default layout for "log" and "history" filenames time appending, see Log.Time.
+	WDESubfolder      string = "InteractionWorkspace"                      // WDE subfolder in MainCfgYAML.WDEInstallationFolder.
+	DMSubfolder       string = "InteractionWorkspaceDeploymentManager"     // WDE Deployment Manager subfolder in MainCfgYAML.WDEInstallationFolder.
+	DMExecutableName  string = "InteractionWorkspaceDeploymentManager.exe" // WDE Deployment Manager executable.
+	WDEExecutableName string = "InteractionWorkspace.exe"                  // WDE executable in WDE subfolder.
+	DMRegistryDir     string = `Software\Genesys\DeploymentManager`        // WDE Deployment Manager registry directory.
+	SavedRegFolder    string = "Registry"                                  // Folder name for saved registry data.
+	RegFileName       string = "DM_Registry_values_"                       // Name prefix for saved registry files.
+	HistoryFileName   string = "WDE_History_"                              // Name prefix for history files.
+	StateFileName     string = "DeployedState.json"                        // Name of deployed state file.
+	OwnershipFileName string = "RegistryOwnership.json"                    // Name of file with registry values owned by updater, in state folder.
+	BaselineFileName  string = "RegistryBaseline.json"                     // Name of registry baseline refresh state file, in state folder.
+	SummaryFileName   string = "WDE_Summary_"                              // Name prefix for run summary files.
 )
 
+// Command line flags.
+var (
+	pprofFlag      = flag.Bool("pprof", false, "write CPU and heap profiles into log folder")
+	pprofAddrFlag  = flag.String("pprof-addr", "", "serve pprof endpoints on address, e.g. localhost:6060")
+	simulateFlag   = flag.String("simulate", "", "run full update against fixture directory with in-memory registry and temporary WDE folder")
+	dryRunFlag     = flag.Bool("dry-run", false, "report files which would be copied and registry values which would change, WDE folder and registry not changed")
+	watchFlag      = flag.Bool("watch", false, "run update persistently with interval from config, config changes applied on next run")
+	unattendedFlag = flag.Bool("unattended", false, "never prompt or wait for user, questions answered by policy defaults, fail if interaction unavoidable")
+	manifestFlag   = flag.String("manifest", "", "deploy files listed in manifest from \"inventory\" command instead of scan sources, override config")
+	quietFlag      = flag.Bool("quiet", false, "print nothing to console and log only errors, unless log level set explicitly")
+	logLevelFlag   = flag.String("log-level", "", "log level (debug, info, warn, error), override Log.Verbose in config")
+	tagFlag        = flag.String("tag", "", "run tag recorded in history, summary, audit log and notifications")
+	ticketFlag     = flag.String("ticket", "", "change ticket which authorized run, recorded like tag")
+	plainFlag      bool // Plain console output, see ConfigureConsole.
+)
+
+func init() {
+	flag.BoolVar(&plainFlag, "plain", false, "plain console output for deployment frameworks: stdout, no timestamps, no progress")
+	flag.BoolVar(&plainFlag, "no-color", false, "same as -plain")
+}
+
 // Struct for unmarshal XML from "CustomFiles" key
 type XMLCustomFiles struct {
 	XMLName         xml.Name            `xml:"ArrayOfApplicationFile"`
@@ -35,238 +60,223 @@ type XMLCustomFiles struct {
 }
 
 func main() {
-	// Fill program start information.
-	startTime := time.Now()                            //Save start time.
-	startTimeString := startTime.Format(logHistLayout) //Get string from startTime.
-	programDirectory, _ := os.Getwd()                  //Save program folder.
+	// Flags parsed first so quiet mode covers config reading.
+	flag.Parse()
+	ConfigureConsole()
+
+	programDirectory, _ := os.Getwd()  //Save program folder.
+	confFilePath := FindConfigFile("") //Save config file path for reload in watch mode.
 
 	// Read configuration from file in working directory.
 	// If fail, try get program directory from os.Args.
-	mainConfig, err := ReadConfigFromYAMLFile(confFile)
+	mainConfig, err := ReadConfigFile(confFilePath)
 	if err != nil {
-		log.Printf("Can't read config file in current working directory `%v`", confFile)
+		log.Printf("Can't read config file in current working directory `%v`", confFilePath)
 		log.Println(err)
 		log.Println("Try get program folder from arguments")
-		programDirectory := filepath.Dir(os.Args[0])
-		confFileAbsolutePath := filepath.Join(programDirectory, confFile)
-		mainConfig, err = ReadConfigFromYAMLFile(confFileAbsolutePath)
+		programDirectory = filepath.Dir(os.Args[0])
+		confFileAbsolutePath := FindConfigFile(programDirectory)
+		confFilePath = confFileAbsolutePath
+		mainConfig, err = ReadConfigFile(confFileAbsolutePath)
 		if err != nil {
-			log.Printf("Can't read config file `%v`", confFileAbsolutePath)
-			log.Println(err)
-			log.Println("Program exited")
-			return
+			ExitWithError(fmt.Sprintf("Can't read config file `%v` - %v", confFileAbsolutePath, err))
 		}
 	}
 
-	// Initialisation logging subsystem
-	var logFullPath string
-	var logName string
-	// Process logging options from config and apply default values if need.
-	if mainConfig.Log.Folder != "" {
-		logFullPath = mainConfig.Log.Folder
-	} else {
-		logFullPath = filepath.Join(programDirectory, "Log")
-	}
-	if mainConfig.Log.Name != "" {
-		logName = fmt.Sprint(mainConfig.Log.Name, "_", startTimeString, ".log")
-	} else {
-		logName = fmt.Sprint("WdeCustomisationUpdater_", startTimeString, ".log")
-	}
-	logFullPath = filepath.Join(logFullPath, logName)
-	logger := NewZapSimpleLoggerWithRotation(mainConfig.Log.Verbose, logFullPath, 10, 1)
-	defer logger.Sync()
-
-	// Get customisation folders list.
-	logger.Info("Start collection customisation folders")
-	foldersWithCustomisations, err := GetCustomisationFoldersList(mainConfig.CustomisationsFolder)
+	err = ApplyLogOverrides(&mainConfig)
+	if err == nil {
+		err = CurrentRunLabels().Validate()
+	}
 	if err != nil {
-		logger.Error(fmt.Sprint("Customisation folders collection error - ", err))
-		return
+		ExitWithError(err)
 	}
-	logger.Info("Customisation folders collected")
 
-	// Get all files from  all customisation folders.
-	logger.Info("Start collection customisation files")
-	rowFilesList := make([]CustomisationFile, 0, 128)
-	for _, folder := range foldersWithCustomisations {
-		scanPath := filepath.Join(mainConfig.CustomisationsFolder, folder)
-		tmpFilesList, err := CollectCustomisationFiles(scanPath, scanPath)
+	// Run subcommand instead of customisation update if provided.
+	ConfigureRedaction(mainConfig)
+	err = ConfigureTimestamps(mainConfig)
+	if err != nil {
+		log.Println("Invalid timestamp settings -", err)
+	}
+	if flag.NArg() > 0 {
+		err = RunSubcommand(flag.Args(), mainConfig, programDirectory)
 		if err != nil {
-			logger.Error(fmt.Sprint("Customisation files collection error - ", err))
-			return
+			ExitWithError(err)
 		}
-		rowFilesList = append(rowFilesList, tmpFilesList...)
+		return
 	}
-	logger.Info("Customisation files collected")
 
-	// Filtering redundant and older files.
-	// Get filtered files list and statuses of all original files.
-	logger.Info("Start validation customisation files")
-	finalFilesList, rowFilesStatuses := ValidateCollectedFiles(rowFilesList, mainConfig.RedundantFiles, logger)
-	logger.Info("Customisation files validated")
+	if *manifestFlag != "" {
+		mainConfig.Manifest = *manifestFlag
+	}
 
-	// Write into history file initiator user name, program version
-	// and all original files with statuses.
-	// History file start in parallel process, may fail without affect on main process,
-	// but can prevent close program if write process took longer than main process.
-	historyWritingEnd := make(chan bool)
-	historyFileFullPath := filepath.Join(
-		programDirectory,
-		"History",
-		fmt.Sprint(HistoryFileName, startTimeString, ".log"),
-	)
-	go WriteHistoryFile(
-		rowFilesList,
-		mainConfig.CustomisationsFolder,
-		rowFilesStatuses,
-		foldersWithCustomisations,
-		historyFileFullPath,
-		historyWritingEnd,
-		logger,
-	)
+	// Prepare simulation against fixture directory if requested.
+	registryStore := DefaultRegistryStore()
+	if *simulateFlag != "" {
+		var memoryRegistry *MemoryRegistry
+		programDirectory, memoryRegistry, err = PrepareSimulation(*simulateFlag, &mainConfig)
+		if err != nil {
+			ExitWithError("Can't prepare simulation -", err)
+		}
+		registryStore = memoryRegistry
+		ApplyLogOverrides(&mainConfig) // Fixture config replaced main one, level already validated.
+		log.Printf("Simulation workspace `%v`", programDirectory)
+	}
 
-	// Copy all filtered files into WDE folder.
-	logger.Info("Start copy validated customisation files into WDE folder")
-	err = CopyCustomisationFiles(finalFilesList, filepath.Join(mainConfig.WDEInstallationFolder, WDESubfolder), logger)
-	if err != nil {
-		logger.Error(fmt.Sprint("Fail copy customisation files - ", err))
-		return
+	// Serve run status for tray helper and "status" command in another console.
+	err = ServeStatusPipe(runStatus)
+	if err != nil && err != ErrNotSupportedOnPlatform {
+		log.Println("Can't serve status pipe -", err)
 	}
-	logger.Info("Validated customisation files copied into WDE folder")
 
-	// Read previously saved registry data.
-	// If there are no files to read, save the new registry data to a file and read from it.
-	logger.Info("Prepare registry data")
-	savedRegistryDir := filepath.Join(programDirectory, SavedRegFolder)
-	var regData RegistryValues
-	var RegDataByte []byte
-	logger.Info("Reading previously saved registry data")
-	err = os.MkdirAll(savedRegistryDir, 0755)
-	if err != nil {
-		logger.Warn(fmt.Sprint("Can't create folder for previously saved registry - ", err))
-		return
+	// Profiling endpoint and CPU profile started once for whole process, watch mode iterations
+	// and concurrent targets share them.
+	if *pprofAddrFlag != "" {
+		ServeProfilingEndpoint(*pprofAddrFlag)
 	}
-	RegDataByte, err = ReadPreviouslySavedRegistryData(savedRegistryDir)
-	if err != nil {
-		if err != ErrNoFilesFoundInFolderByPattern {
-			logger.Error(fmt.Sprint("Reading previously saved registry data from file failed - ", err))
-			return
-		}
-		logger.Info("No previously registry data saved. Try read from current user registry data")
-		regData, err = ReadRegistryData(DMRegistryDir)
-		switch err {
-		case nil:
-			logger.Info("Save current user registry data as initialisation data")
-		case registry.ErrNotExist:
-			logger.Info("No data in current user registry. Save zeroed initialisation data")
-			regData = make([]RegistryValue, 0, 32)
-		default:
-			logger.Error(fmt.Sprint("Reading current user registry data error - ", err))
-			return
-		}
-		registryFileFullPath := filepath.Join(
-			programDirectory,
-			SavedRegFolder,
-			fmt.Sprint(RegFileName, "INITIALISATION_", startTimeString, ".yaml"),
-		)
-		logger.Info("Marshal collected registry data")
-		RegDataByte, err = MarshalRegistryData(regData)
-		if err != nil {
-			logger.Error(fmt.Sprint("Can't marshal registry data into YAML - ", err))
-			return
-		}
-		logger.Info("Save Marshaled registry data into file")
-		err = SaveBytesIntoFile(registryFileFullPath, RegDataByte)
-		if err != nil {
-			logger.Error(fmt.Sprint("Can't save registry data into file - ", err))
-			return
-		}
-		logger.Info("Initialisation registry data saved")
-	} else {
-		logger.Info("Unmarshal previously saved registry data")
-		regData, err = UnmarshalRegistryData(RegDataByte)
-		if err != nil {
-			logger.Error(fmt.Sprint("Can't unmarshal registry data from YAML - ", err))
-			return
-		}
+	stopProfiling := func() {}
+	if *pprofFlag || *pprofAddrFlag != "" {
+		stopProfiling = StartProfiling(LogFolderPath(mainConfig, programDirectory), FileTimestamp(TimestampNow()))
 	}
-	logger.Info("Registry data prepared")
 
-	// Update data previously saved from registry and now read from file.
-	logger.Info("Update old registry data with new data")
-	regData.InsertAddCustomFileTrueValue()                // Force set "AddCustomFile" with "True"
-	err = regData.AddManuallyAddedOptions(finalFilesList) // Combine manually added options and new collected files.
-	if err != nil {
-		if err == ErrCustomFilesNotFound {
-			logger.Info("Old registry data contain not \"CustomFiles\" key. Add fully new data for \"CustomFiles\" key")
-			regData.InsertActualCustomFilesValue(ConstructCustomFilesRegistryKey(finalFilesList))
-		} else {
-			logger.Error(fmt.Sprint("Can't update old registry data with new data - ", err))
+	// Run update persistently if requested.
+	if *watchFlag {
+		if *simulateFlag != "" {
+			ExitWithError("Watch mode can't be used with simulation")
 		}
+		RunWatch(confFilePath, mainConfig, programDirectory, registryStore)
+		stopProfiling()
+		return
 	}
 
-	// Write prepared data into registry.
-	logger.Info("Start writing prepared data into registry")
-	err = WriteToRegistry(regData)
-	if err != nil {
-		logger.Error(fmt.Sprint("Can't write into registry - ", err))
+	summary := RunConfiguredUpdate(mainConfig, programDirectory, registryStore, nil)
+	stopProfiling()
+	PrintPlainResult(summary)
+	PrintQuietFailure(summary)
+	os.Exit(summary.ExitCode())
+}
+
+// Run customisation update once and return its finished summary.
+// Config reload result logged at run start if provided.
+func RunUpdate(mainConfig MainCfgYAML, programDirectory string, registryStore RegistryStore, reload *ConfigReload) (summary RunSummary) {
+	// Fill run start information.
+	// Redaction and timestamps reconfigured because config may be reloaded in watch mode.
+	ConfigureRedaction(mainConfig)
+	timestampsErr := ConfigureTimestamps(mainConfig)
+	startTime := TimestampNow()                 //Save start time.
+	startTimeString := FileTimestamp(startTime) //Get string from startTime.
+	summary = NewRunSummary(startTime)          // Failed until pipeline finished, so every early return exits non-zero.
+	var migration WorkspaceMigration
+	var migrateErr error
+	if !*dryRunFlag {
+		migration, migrateErr = MigrateWorkspace(mainConfig, programDirectory, false)
+	}
+
+	// Initialisation logging subsystem.
+	logFullPath := filepath.Join(
+		LogFolderPath(mainConfig, programDirectory),
+		fmt.Sprint(LogFilePrefix(mainConfig), startTimeString, ".log"),
+	)
+	logger := NewZapSimpleLoggerWithRotation(mainConfig.Log.Verbose, logFullPath, 10, 1)
+	defer logger.Sync()
+
+	LogConfigWarnings(mainConfig, logger)
+	for _, moved := range migration.Moved {
+		logger.Info(fmt.Sprint("Artifacts moved from program directory into workspace: ", moved))
+	}
+	for _, kept := range migration.Kept {
+		logger.Warn(fmt.Sprint("Artifact already exists in workspace, left in program directory: ", kept))
+	}
+	if migrateErr != nil {
+		logger.Error(fmt.Sprint("Can't move artifacts from program directory into workspace - ", migrateErr))
 		return
 	}
-	logger.Info("Write into registry successful")
+	if timestampsErr != nil {
+		logger.Warn(fmt.Sprint("Invalid timestamp settings - ", timestampsErr))
+	}
 
-	// Run WDE Deployment Manager and wait while it stop.
-	logger.Info("Run WDE Deployment Manager")
-	err = RunAndWaitStop(filepath.Join(mainConfig.WDEInstallationFolder, DMSubfolder), DMExecutableName, logger)
+	// Replace secret references in config by values from Credential Manager or DPAPI.
+	mainConfig, err := ResolveConfigSecrets(mainConfig)
 	if err != nil {
-		logger.Error(fmt.Sprint("WDE deployment manager error - ", err))
+		logger.Error(fmt.Sprint("Can't resolve config secrets - ", err))
 		return
 	}
 
-	logger.Info("WDE Deployment Manager stopped")
+	// Prepare run summary. Summary saved on any exit from run.
+	summary.RunLabels = CurrentRunLabels()
+	if summary.Tag != "" || summary.Ticket != "" {
+		logger.Info(fmt.Sprintf("Run tag '%v', ticket '%v'", summary.Tag, summary.Ticket))
+	}
+	wdeVersion, wdeVersionErr := DetectWDEVersion(mainConfig)
+	LogWDEVersion(wdeVersion, wdeVersionErr, logger)
+	summary.Host = CollectHostFacts(wdeVersion)
+	summary.Host.Log(logger)
+	logger = logger.WithOptions(zap.Hooks(summary.LogHook))
+	summaryFileFullPath := filepath.Join(
+		HistoryFolderPath(mainConfig, programDirectory),
+		fmt.Sprint(SummaryFileName, startTimeString, ".json"),
+	)
+	historyEvents := make(HistoryEvents, 0, 8)
+	if *dryRunFlag {
+		summary.DryRun = true
+		logger.Info("Dry run, WDE folder and registry not changed")
+		historyEvents.Add("Dry run, WDE folder and registry not changed")
+	}
+	state := &RunState{
+		Config:           mainConfig,
+		ProgramDirectory: programDirectory,
+		StartTime:        startTime,
+		StartTimeString:  startTimeString,
+		Simulate:         *simulateFlag != "",
+		DryRun:           *dryRunFlag,
+		RegistryStore:    registryStore,
+		WDEVersion:       wdeVersion,
+		Summary:          &summary,
+		HistoryEvents:    &historyEvents,
+		Progress:         NewProgressStream(),
+		Audit:            NewAuditLog(mainConfig, programDirectory, startTimeString, summary.RunLabels, logger),
+		Logger:           logger,
+	}
+	state.Progress.Subscribe(LogProgress(logger, ProgressLogStepPercent))
+	state.Progress.Subscribe(runStatus.Update)
+	runStatus.Start(startTime)
+	defer func() { runStatus.Finish(summary) }() // Deferred before FinishRun to get finished summary.
+	defer FinishRun(&summary, mainConfig, summaryFileFullPath, &state.CopyDurations, logger)
+	reload.Log(logger)
 
-	// Save actual registry data into file.
-	logger.Info("Save actual registry data into file")
-	regData, err = ReadRegistryData(DMRegistryDir)
+	// Run update phases.
+	phases := UpdatePhases()
+	if state.DryRun {
+		phases = DryRunPhases(phases)
+	}
+	err = ValidatePipeline(phases, InitialRunStateFields)
 	if err != nil {
-		logger.Error(fmt.Sprint("Can't save registry data after WDE Deployment Manager - ", err))
+		logger.Error(fmt.Sprint("Invalid update pipeline - ", err))
 		return
 	}
-	registryBytes, err := MarshalRegistryData(regData)
+	err = RunPipeline(phases, state)
 	if err != nil {
-		logger.Error(fmt.Sprint("Can't marshal registry data into YAML - ", err))
 		return
 	}
-	registryFileFullPath := filepath.Join(
-		programDirectory,
-		SavedRegFolder,
-		fmt.Sprint(RegFileName, startTimeString, ".yaml"),
-	)
-	err = SaveBytesIntoFile(registryFileFullPath, registryBytes)
-	if err != nil {
-		logger.Error(fmt.Sprint("Can't save registry data into file - ", err))
+	if summary.Statuses[StatusFailed] > 0 {
+		summary.Result = RunResultPartial
+		logger.Warn(fmt.Sprintf("WDE customisation updated partially, %v files failed.", summary.Statuses[StatusFailed]))
 		return
 	}
-	logger.Info("Write data into file successful")
-
-	// Clean old registry files. Preserve last 5 files for backup purposes.
-	logger.Info("Delete old registry files")
-	err = ClearOldFiles(filepath.Join(programDirectory, SavedRegFolder), RegFileName, 15)
-	if err != nil {
-		logger.Error(fmt.Sprint("Can't delete old registry files - ", err))
+	if summary.Statuses[StatusRejected] > 0 {
+		summary.Result = RunResultPartial
+		logger.Warn(fmt.Sprintf("WDE customisation updated partially, %v files rejected by scanner.", summary.Statuses[StatusRejected]))
+		return
 	}
-	logger.Info("Delete old log files")
-	err = ClearOldFiles(filepath.Join(programDirectory, SavedRegFolder), RegFileName, 15)
-	if err != nil {
-		logger.Error(fmt.Sprint("Can't delete old log files - ", err))
+	summary.Result = RunResultSuccess
+	if state.DryRun {
+		logger.Info("Dry run finished, WDE customisation not changed.")
+		return
 	}
-	logger.Info("Old files cleared")
-
-	// Wait for the history file to finish writing end exit program.
-	logger.Info(fmt.Sprintf("History writing stopped '%v'", <-historyWritingEnd))
 	logger.Info("WDE customisation updated successful.")
+	return
 }
 
-// Clear files in specified directory by specified name mask.
+// Clear files in specified directory by specified name prefix.
 // Preserve last N files by modified time.
 // Return error if can't read directory or delete file.
 func ClearOldFiles(directory, filePrefix string, maxFiles int) error {
@@ -279,12 +289,11 @@ func ClearOldFiles(directory, filePrefix string, maxFiles int) error {
 		return nil
 	}
 	validFiles := make(FileInfoSlice, 0, 16)
-	rePrefix := regexp.MustCompile(filePrefix)
 	for _, entity := range dirContent {
 		if entity.IsDir() {
 			continue
 		}
-		if !rePrefix.MatchString(entity.Name()) {
+		if !strings.HasPrefix(entity.Name(), filePrefix) {
 			continue
 		}
 		validFiles = append(validFiles, entity)
@@ -300,9 +309,7 @@ func ClearOldFiles(directory, filePrefix string, maxFiles int) error {
 	}
 	for _, vf := range validFiles[:last] {
 		fullPath := filepath.Join(directory, vf.Name())
-		// Execute windows delete command
-		winCommand := exec.Command("cmd", "/C", "del", fullPath)
-		err = winCommand.Run()
+		err = os.Remove(fullPath)
 		if err != nil {
 			return err
 		}
@@ -331,14 +338,27 @@ func SaveBytesIntoFile(fullPath string, bytesData []byte) error {
 }
 
 // Run executable file provided by full path and wait for it stop.
-func RunAndWaitStop(directory, fileName string, logger *zap.Logger) error {
+// Window of process started hidden if windowStyle is DMWindowHidden.
+// If onStart provided, it called with PID of started process. On onStart error process killed.
+func RunAndWaitStop(directory, fileName, windowStyle string, onStart func(pid int) error, logger *zap.Logger) error {
 	fileName = fmt.Sprint("./", fileName)
 	cmd := exec.Command(fileName)
 	cmd.Dir = directory
+	if windowStyle == DMWindowHidden {
+		cmd.SysProcAttr = HiddenWindowProcAttr()
+	}
 	logger.Debug(fmt.Sprintf("Run file '%+v' from dir '%+v'", fileName, directory))
 	err := cmd.Start()
 	if err != nil {
-		return err
+		return &DMLaunchError{Err: err}
+	}
+	if onStart != nil {
+		err = onStart(cmd.Process.Pid)
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return err
+		}
 	}
 	err = cmd.Wait()
 	if err != nil {
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const DefaultManifestName string = "wde-manifest.json" // Default output file of "inventory" command.
+
+// Manifest of customisation sources produced by "inventory" command.
+type Manifest struct {
+	ProgramVersion  string         `json:"programVersion"`
+	Hostname        string         `json:"hostname"` // Machine which scanned sources.
+	CreatedTime     time.Time      `json:"createdTime"`
+	CompareStrategy string         `json:"compareStrategy"`          // Strategy used to choose winners.
+	Folders         []string       `json:"folders"`                  // Collected customisation folders in sort order.
+	Files           []ManifestFile `json:"files"`                    // All collected files with statuses.
+	Violations      []string       `json:"violations,omitempty"`     // Size limits exceeded by files to deploy.
+	ReleaseVersion  string         `json:"releaseVersion,omitempty"` // Customisation release version, from version marker if not set by hand.
+}
+
+// One collected file of manifest.
+type ManifestFile struct {
+	FileName            string     `json:"fileName"`
+	RelativePath        string     `json:"relativePath"`
+	CustomisationFolder string     `json:"customisationFolder"`
+	SourceFolder        string     `json:"sourceFolder"`
+	Precedence          int        `json:"precedence"`
+	SourcePath          string     `json:"sourcePath"`
+	Size                int64      `json:"size"`
+	Hash                string     `json:"hash"`              // SHA-256 of file content.
+	Version             string     `json:"version,omitempty"` // File version "1.2.3.4" if present.
+	LastWriteTime       time.Time  `json:"lastWriteTime"`
+	Status              FileStatus `json:"status"` // Validation status, e.g. "COPIED".
+	Winner              bool       `json:"winner"` // File chosen for deployment.
+}
+
+// Parse version in "1.2.3.4" form. Empty string is zero version.
+func ParseFileVersion(version string) (FileVersion, error) {
+	if version == "" {
+		return FileVersion{}, nil
+	}
+	var fv FileVersion
+	_, err := fmt.Sscanf(version, "%d.%d.%d.%d", &fv.v1, &fv.v2, &fv.v3, &fv.v4)
+	if err != nil {
+		return FileVersion{}, fmt.Errorf("can't parse file version '%v' - %v", version, err)
+	}
+	fv.full = fv.v1<<48 | fv.v2<<32 | fv.v3<<16 | fv.v4
+	return fv, nil
+}
+
+// Return version in "1.2.3.4" form or empty string for zero version.
+func (fv FileVersion) String() string {
+	if fv.full == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d.%d.%d", fv.v1, fv.v2, fv.v3, fv.v4)
+}
+
+// Construct manifest from collected files and their validation statuses.
+func NewManifest(folders []string, files []CustomisationFile, statuses []FileStatus, strategy string) Manifest {
+	hostname, _ := os.Hostname()
+	if strategy == "" {
+		strategy = DefaultCompareStrategy
+	}
+	manifest := Manifest{
+		ProgramVersion:  programVersion,
+		Hostname:        hostname,
+		CreatedTime:     TimestampNow(),
+		CompareStrategy: strategy,
+		Folders:         folders,
+		Files:           make([]ManifestFile, 0, len(files)),
+	}
+	for id, file := range files {
+		status := statuses[id]
+		manifest.Files = append(manifest.Files, ManifestFile{
+			FileName:            file.FileName,
+			RelativePath:        file.RelativePath,
+			CustomisationFolder: file.CustomisationFolder,
+			SourceFolder:        file.SourceFolder,
+			Precedence:          file.Precedence,
+			SourcePath:          file.SourcePath,
+			Size:                file.Size,
+			Hash:                file.Hash,
+			Version:             file.Version.String(),
+			LastWriteTime:       file.LastWriteTime,
+			Status:              status,
+			Winner:              status.Deployed(),
+		})
+	}
+	return manifest
+}
+
+// Save manifest as JSON into provided file.
+func (m Manifest) Save(manifestFullPath string) error {
+	manifestBytes, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return SaveBytesIntoFile(manifestFullPath, manifestBytes)
+}
+
+// Read manifest from JSON file.
+func ReadManifest(manifestFullPath string) (Manifest, error) {
+	manifestBytes, err := ioutil.ReadFile(manifestFullPath)
+	if err != nil {
+		return Manifest{}, err
+	}
+	var manifest Manifest
+	err = json.Unmarshal(manifestBytes, &manifest)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("can't parse manifest '%v' - %v", manifestFullPath, err)
+	}
+	return manifest, nil
+}
+
+// Return all manifest files with validation statuses and files chosen for deployment.
+func (m Manifest) CustomisationFiles() ([]CustomisationFile, []FileStatus, []CustomisationFile, error) {
+	files := make([]CustomisationFile, 0, len(m.Files))
+	statuses := make([]FileStatus, 0, len(m.Files))
+	finalFiles := make([]CustomisationFile, 0, len(m.Files))
+	for _, file := range m.Files {
+		version, err := ParseFileVersion(file.Version)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if file.Winner && file.Hash == "" {
+			return nil, nil, nil, fmt.Errorf("file '%v' has no hash", file.SourcePath)
+		}
+		customisationFile := CustomisationFile{
+			FileName:            file.FileName,
+			RelativePath:        file.RelativePath,
+			DataFile:            "false",
+			EntryPoint:          "false",
+			IsMainConfigFile:    "false",
+			Optional:            "false",
+			SourcePath:          file.SourcePath,
+			SourceFolder:        file.SourceFolder,
+			Precedence:          file.Precedence,
+			CustomisationFolder: file.CustomisationFolder,
+			Hash:                file.Hash,
+			Size:                file.Size,
+			LastWriteTime:       file.LastWriteTime,
+			Version:             version,
+		}
+		files = append(files, customisationFile)
+		statuses = append(statuses, ParseFileStatus(string(file.Status)))
+		if file.Winner {
+			finalFiles = append(finalFiles, customisationFile)
+		}
+	}
+	return files, statuses, finalFiles, nil
+}
+
+// Run "inventory" subcommand. Collect and validate customisation sources
+// and write manifest without any change on this machine.
+func RunInventoryCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	flags := flag.NewFlagSet("inventory", flag.ContinueOnError)
+	outPath := flags.String("out", filepath.Join(WorkspaceFolderPath(mainConfig, programDirectory), DefaultManifestName), "manifest file written by scan")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	mainConfig, err = ResolveConfigSecrets(mainConfig)
+	if err != nil {
+		return err
+	}
+
+	logFullPath := filepath.Join(
+		LogFolderPath(mainConfig, programDirectory),
+		fmt.Sprint(LogFilePrefix(mainConfig), FileTimestamp(time.Now()), ".log"),
+	)
+	logger := NewZapSimpleLoggerWithRotation(mainConfig.Log.Verbose, logFullPath, 10, 1)
+	defer logger.Sync()
+	logger.Info("Inventory of customisation sources started")
+
+	manifest, err := ScanSources(mainConfig, nil, logger)
+	if err != nil {
+		logger.Error(fmt.Sprint("Inventory failed - ", err))
+		return err
+	}
+	err = manifest.Save(*outPath)
+	if err != nil {
+		logger.Error(fmt.Sprint("Can't save manifest - ", err))
+		return err
+	}
+
+	winners := 0
+	for _, file := range manifest.Files {
+		if file.Winner {
+			winners++
+		}
+	}
+	log.Printf("Folders: %d, files: %d, to deploy: %d", len(manifest.Folders), len(manifest.Files), winners)
+	for _, violation := range manifest.Violations {
+		log.Println("Size limit exceeded -", violation)
+	}
+	log.Println("Manifest saved into", *outPath)
+	logger.Info(fmt.Sprint("Inventory finished, manifest saved into ", *outPath))
+	return nil
+}
+
+// Collect and validate files from configured sources same way as update run and construct manifest.
+// Hashes of unchanged files taken from scan cache if provided, nil cache hash all files.
+func ScanSources(mainConfig MainCfgYAML, cache *ScanCache, logger *zap.Logger) (Manifest, error) {
+	strategy, err := GetCompareStrategy(mainConfig.CompareStrategy)
+	if err != nil {
+		return Manifest{}, err
+	}
+	folders, files, err := CollectFromSources(ConfiguredSources(mainConfig), cache, logger)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("customisation files collection error - %v", err)
+	}
+	finalFiles, statuses := ValidateCollectedFiles(
+		files,
+		mainConfig.RedundantFiles,
+		NewFilePolicy(mainConfig.Policy.DenyExtensions, mainConfig.Policy.AllowExtensions, mainConfig.Policy.ProtectedFiles),
+		strategy,
+		logger,
+	)
+	manifest := NewManifest(folders, files, statuses, mainConfig.CompareStrategy)
+	manifest.Violations = CheckSizeLimits(finalFiles, mainConfig.Limits.MaxFileSizeMB*1024*1024, mainConfig.Limits.MaxTotalSizeMB*1024*1024)
+	manifest.ReleaseVersion = ReadReleaseVersion(files, logger)
+	return manifest, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	LegacyRegFolder      string = "Rgistry"   // Folder name for saved registry data in 1.x.
+	ConfigBackupSuffix   string = ".v1.bak"   // Suffix of config backup created by migration.
+	MigratedRegFileLabel string = "MIGRATED_" // Inserted into name of migrated registry files without current prefix.
+)
+
+var reConfigTopLevelKey = regexp.MustCompile(`^([A-Za-z]+)(\s*:)(.*)$`)
+var reConfigVersionLine = regexp.MustCompile(`(?m)^ConfigVersion\s*:`)
+
+// Run "migrate" subcommand. Convert 1.x config keys and registry snapshots layout
+// into current format and record migration in history.
+func RunMigrateCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	configPath := flags.String("config", FindConfigFile(programDirectory), "1.x YAML config file converted in place, backup saved near it")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	startTime := TimestampNow()
+	startTimeString := FileTimestamp(startTime)
+	logFullPath := filepath.Join(
+		LogFolderPath(mainConfig, programDirectory),
+		fmt.Sprint(LogFilePrefix(mainConfig), startTimeString, ".log"),
+	)
+	logger := NewZapSimpleLoggerWithRotation(mainConfig.Log.Verbose, logFullPath, 10, 1)
+	defer logger.Sync()
+	logger.Info("Migration from 1.x layout started")
+
+	wdeVersion, _ := DetectWDEVersion(mainConfig)
+	events := make(HistoryEvents, 0, 8)
+	configChanges, err := MigrateConfigFile(*configPath, logger)
+	if err != nil {
+		logger.Error(fmt.Sprint("Config migration failed - ", err))
+		return err
+	}
+	for _, change := range configChanges {
+		events.Add("Config '%v': %v", *configPath, change)
+	}
+	movedFiles, err := MigrateRegistrySnapshots(mainConfig, programDirectory, logger)
+	if err != nil {
+		logger.Error(fmt.Sprint("Registry snapshots migration failed - ", err))
+		return err
+	}
+	for _, moved := range movedFiles {
+		events.Add("Registry snapshot moved: %v", moved)
+	}
+	if len(events) == 0 {
+		logger.Info("Nothing to migrate")
+		log.Println("Nothing to migrate")
+		return nil
+	}
+
+	// Record migration in history file without collected files.
+	historyWritingEnd := make(chan bool, 1)
+	historyName := HistoryFilePrefix(mainConfig)
+	historyFileFullPath := filepath.Join(
+		HistoryFolderPath(mainConfig, programDirectory),
+		fmt.Sprint(historyName, startTimeString, ".log"),
+	)
+	events.Add("Migrated from 1.x layout")
+	WriteHistoryFile(nil, CollectHostFacts(wdeVersion), ReleaseInfo{}, CurrentRunLabels(), historyFileFullPath, historyName, true, historyWritingEnd, logger)
+	FinishHistoryFile(historyFileFullPath, nil, &events, historyWritingEnd, mainConfig.Mirror.Folder, logger)
+
+	for _, event := range events {
+		log.Println(event)
+	}
+	logger.Info("Migration from 1.x layout finished")
+	return nil
+}
+
+// Rename deprecated top level keys of 1.x in YAML config file and set ConfigVersion.
+// Comments and formatting preserved.
+// Original file saved with ConfigBackupSuffix. Return list of changes.
+func MigrateConfigFile(cfgFilePath string, logger *zap.Logger) ([]string, error) {
+	if ConfigFormat(cfgFilePath) != "yaml" {
+		logger.Info(fmt.Sprintf("Config '%v' is not YAML, 1.x used only YAML configs", cfgFilePath))
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(cfgFilePath)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	changes := make([]string, 0, len(DeprecatedConfigKeys))
+	for id, line := range lines {
+		match := reConfigTopLevelKey.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		for _, key := range DeprecatedConfigKeys {
+			if key.Key != match[1] {
+				continue
+			}
+			value := match[3]
+			if key.Convert != nil {
+				value = ConvertConfigLineValue(value, key.Convert)
+			}
+			lines[id] = fmt.Sprint(key.Replacement, match[2], value)
+			changes = append(changes, fmt.Sprintf("'%v' replaced by '%v'", strings.TrimSpace(line), strings.TrimSpace(lines[id])))
+			logger.Info(fmt.Sprintf("Config key '%v' renamed to '%v'", key.Key, key.Replacement))
+		}
+	}
+	if !reConfigVersionLine.MatchString(string(data)) {
+		lines = append([]string{fmt.Sprint("ConfigVersion: ", CurrentConfigVersion)}, lines...)
+		changes = append(changes, fmt.Sprintf("'ConfigVersion: %v' added", CurrentConfigVersion))
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	err = ioutil.WriteFile(fmt.Sprint(cfgFilePath, ConfigBackupSuffix), data, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("can't save config backup - %v", err)
+	}
+	err = ioutil.WriteFile(cfgFilePath, []byte(strings.Join(lines, "\n")), 0644)
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Convert value of YAML line, trailing comment preserved.
+func ConvertConfigLineValue(value string, convert func(string) string) string {
+	path, comment := value, ""
+	if commentStart := strings.Index(value, " #"); commentStart >= 0 {
+		path, comment = value[:commentStart], value[commentStart:]
+	}
+	return fmt.Sprint(" ", convert(strings.TrimSpace(path)), comment)
+}
+
+// Remove trailing WDE subfolder from path. 1.x "WDEFolder" pointed to WDE subfolder itself.
+func StripWDESubfolder(path string) string {
+	trimmed := strings.TrimRight(path, `\/`)
+	for _, separator := range []string{`\`, "/"} {
+		if strings.HasSuffix(trimmed, fmt.Sprint(separator, WDESubfolder)) {
+			return strings.TrimSuffix(trimmed, fmt.Sprint(separator, WDESubfolder))
+		}
+	}
+	return path
+}
+
+// Move registry snapshots from 1.x folder of program directory into current one in workspace.
+// Files without current name prefix renamed with prefix and modification time.
+// Return list of moves.
+func MigrateRegistrySnapshots(mainConfig MainCfgYAML, programDirectory string, logger *zap.Logger) ([]string, error) {
+	legacyFolder := filepath.Join(programDirectory, LegacyRegFolder)
+	dirContent, err := ioutil.ReadDir(legacyFolder)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	savedRegistryDir := SavedRegistryFolderPath(mainConfig, programDirectory)
+	err = os.MkdirAll(savedRegistryDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	moved := make([]string, 0, len(dirContent))
+	for _, file := range dirContent {
+		if file.IsDir() {
+			continue
+		}
+		newName := file.Name()
+		if !strings.HasPrefix(newName, RegFileName) {
+			newName = fmt.Sprint(RegFileName, MigratedRegFileLabel, FileTimestamp(file.ModTime()), filepath.Ext(newName))
+		}
+		target := filepath.Join(savedRegistryDir, newName)
+		if _, err := os.Stat(target); err == nil {
+			logger.Warn(fmt.Sprintf("Registry snapshot '%v' already exists, '%v' not moved", target, file.Name()))
+			continue
+		}
+		err = os.Rename(filepath.Join(legacyFolder, file.Name()), target)
+		if err != nil {
+			return moved, err
+		}
+		logger.Info(fmt.Sprintf("Registry snapshot '%v' moved into '%v'", file.Name(), target))
+		moved = append(moved, fmt.Sprintf("'%v' -> '%v'", file.Name(), target))
+	}
+	remaining, err := ioutil.ReadDir(legacyFolder)
+	if err == nil && len(remaining) == 0 {
+		os.Remove(legacyFolder)
+	}
+	return moved, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+)
+
+// Copy provided file into per-machine subfolder of the central share.
+// Result path is "<mirrorRoot>\<hostname>\<subfolder>\<file name>", see MirrorHostFolder.
+// Do nothing if mirror root not configured.
+func MirrorFile(mirrorRoot, subfolder, sourcePath string) error {
+	if mirrorRoot == "" {
+		return nil
+	}
+	hostFolder, err := MirrorHostFolder()
+	if err != nil {
+		return err
+	}
+	targetFolder := filepath.Join(mirrorRoot, hostFolder, subfolder)
+	err = os.MkdirAll(targetFolder, 0755)
+	if err != nil {
+		return err
+	}
+	_, err = copyFile(sourcePath, filepath.Join(targetFolder, filepath.Base(sourcePath)))
+	return err
+}
+
+// Wrapper for MirrorFile which only log failure.
+// Mirroring is optional and must not affect main process.
+func MirrorFileWithLog(mirrorRoot, subfolder, sourcePath string, logger *zap.Logger) {
+	if mirrorRoot == "" {
+		return
+	}
+	logger.Debug(fmt.Sprintf("Mirror file '%+v' into '%+v'", sourcePath, mirrorRoot))
+	err := MirrorFile(mirrorRoot, subfolder, sourcePath)
+	if err != nil {
+		logger.Warn(fmt.Sprint("Can't mirror file into central share - ", err))
+	}
+}
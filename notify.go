@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"os/exec"
+)
+
+// Run configured notification command with summary file path as last argument.
+// Run labels passed in WDE_RUN_TAG and WDE_RUN_TICKET environment variables.
+// Notification failure only logged.
+func RunNotifyCommand(command []string, summaryFileFullPath string, labels RunLabels, logger *zap.Logger) {
+	if len(command) == 0 {
+		return
+	}
+	args := append(append(make([]string, 0, len(command)), command[1:]...), summaryFileFullPath)
+	cmd := exec.Command(command[0], args...)
+	cmd.Env = append(os.Environ(), labels.Environment()...)
+	logger.Info(fmt.Sprintf("Run notification command '%+v'", cmd.Args))
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		logger.Debug(fmt.Sprintf("Notification command output '%s'", output))
+	}
+	if err != nil {
+		logger.Warn(fmt.Sprint("Notification command failed - ", err))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Alternate data streams removed from copied files.
+const (
+	StripStreamsNone    = "none"    // Keep streams as copied by engine.
+	StripStreamsBlocked = "blocked" // Remove "Zone.Identifier" of files from Internet or untrusted zone, like Unblock-File.
+	StripStreamsZone    = "zone"    // Remove only "Zone.Identifier" (Mark-of-the-Web).
+	StripStreamsAll     = "all"     // Remove all alternate data streams.
+)
+
+// Attributes of copied files.
+const (
+	AttributesKeep      = "keep"      // Leave attributes set by copy engine.
+	AttributesPreserve  = "preserve"  // Set attributes of source file explicitly.
+	AttributesNormalize = "normalize" // Clear read-only, hidden and system attributes.
+)
+
+const (
+	ZoneIdentifierStream string = "Zone.Identifier" // Stream with Mark-of-the-Web.
+	BlockedZoneID        int    = 3                 // Files from this zone (3 Internet, 4 Untrusted) blocked by .NET and SmartScreen.
+)
+
+var reZoneID = regexp.MustCompile(`(?m)^ZoneId=(\d+)`)
+
+// Get zone from content of "Zone.Identifier" stream. Return -1 if zone not found.
+func ParseZoneIdentifier(content string) int {
+	match := reZoneID.FindStringSubmatch(content)
+	if match == nil {
+		return -1
+	}
+	zone, err := strconv.Atoi(match[1])
+	if err != nil {
+		return -1
+	}
+	return zone
+}
+
+// Check stream and attribute options from config and apply defaults.
+func ValidateNTFSOptions(stripStreams, attributes string) (string, string, error) {
+	switch stripStreams {
+	case "":
+		stripStreams = StripStreamsNone
+	case StripStreamsNone, StripStreamsBlocked, StripStreamsZone, StripStreamsAll:
+	default:
+		return "", "", fmt.Errorf("unknown StripStreams value '%v'", stripStreams)
+	}
+	switch attributes {
+	case "":
+		attributes = AttributesKeep
+	case AttributesKeep, AttributesPreserve, AttributesNormalize:
+	default:
+		return "", "", fmt.Errorf("unknown Attributes value '%v'", attributes)
+	}
+	return stripStreams, attributes, nil
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+)
+
+// Hidden attribute exists only on Windows.
+func IsHiddenFile(info os.FileInfo) bool {
+	return false
+}
+
+// Add owner write permission to existing file, so it can be overwritten. Missing file ignored.
+func ClearReadOnly(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0200 != 0 {
+		return nil
+	}
+	return os.Chmod(path, info.Mode().Perm()|0200)
+}
+
+// Alternate data streams and NTFS attributes handled only on Windows.
+func ApplyNTFSOptions(source, target, stripStreams, attributes string) ([]string, error) {
+	if stripStreams == StripStreamsNone && attributes == AttributesKeep {
+		return nil, nil
+	}
+	return nil, ErrNotSupportedOnPlatform
+}
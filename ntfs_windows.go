@@ -0,0 +1,160 @@
+package main
+
+import (
+	"golang.org/x/sys/windows"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	findStreamInfoStandard = 0 // FindStreamInfoStandard
+	maxStreamNameLength    = windows.MAX_PATH + 36
+	// Attributes which can be set by SetFileAttributesW.
+	settableFileAttributes = windows.FILE_ATTRIBUTE_READONLY | windows.FILE_ATTRIBUTE_HIDDEN |
+		windows.FILE_ATTRIBUTE_SYSTEM | windows.FILE_ATTRIBUTE_ARCHIVE | windows.FILE_ATTRIBUTE_NOT_CONTENT_INDEXED
+	normalizedClearAttributes = windows.FILE_ATTRIBUTE_READONLY | windows.FILE_ATTRIBUTE_HIDDEN | windows.FILE_ATTRIBUTE_SYSTEM
+)
+
+var (
+	procFindFirstStreamW = kernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = kernel32.NewProc("FindNextStreamW")
+)
+
+// WIN32_FIND_STREAM_DATA structure.
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [maxStreamNameLength]uint16
+}
+
+// List alternate data stream names of file, e.g. "Zone.Identifier". Main stream not included.
+func ListAlternateStreams(path string) ([]string, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	var data win32FindStreamData
+	handle, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(pathPtr)), findStreamInfoStandard, uintptr(unsafe.Pointer(&data)), 0)
+	if windows.Handle(handle) == windows.InvalidHandle {
+		if err == windows.ERROR_HANDLE_EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer windows.FindClose(windows.Handle(handle))
+	streams := make([]string, 0, 2)
+	for {
+		// Stream name has form ":name:$DATA", main stream is "::$DATA".
+		name := strings.TrimSuffix(strings.TrimPrefix(windows.UTF16ToString(data.StreamName[:]), ":"), ":$DATA")
+		if name != "" {
+			streams = append(streams, name)
+		}
+		ret, _, err := procFindNextStreamW.Call(handle, uintptr(unsafe.Pointer(&data)))
+		if ret == 0 {
+			if err == windows.ERROR_HANDLE_EOF {
+				return streams, nil
+			}
+			return streams, err
+		}
+	}
+}
+
+// Remove alternate data streams and set attributes of copied file according to options.
+// Return names of removed streams.
+func ApplyNTFSOptions(source, target, stripStreams, attributes string) ([]string, error) {
+	removed := make([]string, 0, 1)
+	switch stripStreams {
+	case StripStreamsBlocked:
+		content, err := ioutil.ReadFile(target + ":" + ZoneIdentifierStream)
+		if os.IsNotExist(err) || err == nil && ParseZoneIdentifier(string(content)) < BlockedZoneID {
+			break
+		}
+		if err != nil {
+			return removed, err
+		}
+		fallthrough
+	case StripStreamsZone:
+		err := os.Remove(target + ":" + ZoneIdentifierStream)
+		if err == nil {
+			removed = append(removed, ZoneIdentifierStream)
+		} else if !os.IsNotExist(err) {
+			return removed, err
+		}
+	case StripStreamsAll:
+		streams, err := ListAlternateStreams(target)
+		if err != nil {
+			return removed, err
+		}
+		for _, stream := range streams {
+			err = os.Remove(target + ":" + stream)
+			if err != nil {
+				return removed, err
+			}
+			removed = append(removed, stream)
+		}
+	}
+
+	switch attributes {
+	case AttributesPreserve:
+		sourceAttributes, err := getFileAttributes(source)
+		if err != nil {
+			return removed, err
+		}
+		targetAttributes, err := getFileAttributes(target)
+		if err != nil {
+			return removed, err
+		}
+		return removed, setFileAttributes(target, targetAttributes&^settableFileAttributes|sourceAttributes&settableFileAttributes)
+	case AttributesNormalize:
+		targetAttributes, err := getFileAttributes(target)
+		if err != nil {
+			return removed, err
+		}
+		return removed, setFileAttributes(target, targetAttributes&^normalizedClearAttributes)
+	}
+	return removed, nil
+}
+
+// Clear read-only attribute of existing file, so it can be overwritten. Missing file ignored.
+func ClearReadOnly(path string) error {
+	attributes, err := getFileAttributes(path)
+	if err == windows.ERROR_FILE_NOT_FOUND || err == windows.ERROR_PATH_NOT_FOUND {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if attributes&windows.FILE_ATTRIBUTE_READONLY == 0 {
+		return nil
+	}
+	return setFileAttributes(path, attributes&^windows.FILE_ATTRIBUTE_READONLY)
+}
+
+// Check hidden attribute of file or folder.
+func IsHiddenFile(info os.FileInfo) bool {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return ok && data.FileAttributes&windows.FILE_ATTRIBUTE_HIDDEN != 0
+}
+
+func getFileAttributes(path string) (uint32, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	return windows.GetFileAttributes(pathPtr)
+}
+
+func setFileAttributes(path string, attributes uint32) error {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	// Only settable attributes accepted, zero means FILE_ATTRIBUTE_NORMAL.
+	attributes &= settableFileAttributes
+	if attributes == 0 {
+		attributes = windows.FILE_ATTRIBUTE_NORMAL
+	}
+	return windows.SetFileAttributes(pathPtr, attributes)
+}
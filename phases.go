@@ -0,0 +1,1035 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Initial RunState fields available for all phases.
+var InitialRunStateFields = []string{"Config", "ProgramDirectory", "StartTime", "RegistryStore", "WDEVersion"}
+
+// Return phases of customisation update in execution order.
+func UpdatePhases() []Phase {
+	return []Phase{
+		{Name: "lock", Inputs: []string{"Config", "ProgramDirectory"}, Run: PhaseLock},
+		{Name: "preflight", Inputs: []string{"Config"}, Run: PhasePreflight},
+		{Name: "dm-prerequisites", Inputs: []string{"Config"}, Run: PhaseDMPrerequisites},
+		{Name: "collection", Inputs: []string{"Config"}, Outputs: []string{"Folders", "RowFiles"}, Run: PhaseCollection},
+		{Name: "validation", Inputs: []string{"Config", "Folders", "RowFiles"}, Outputs: []string{"FinalFiles", "RowStatuses"}, Run: PhaseValidation},
+		{Name: "directory-manifests", Inputs: []string{"Folders"}, Run: PhaseDirectoryManifests},
+		{Name: "scan", Inputs: []string{"Config", "FinalFiles", "RowFiles", "RowStatuses"}, Outputs: []string{"FinalFiles", "RowStatuses"}, Run: PhaseScan},
+		{Name: "release", Inputs: []string{"RowFiles"}, Outputs: []string{"Release"}, Run: PhaseRelease},
+		{Name: "history", Inputs: []string{"RowFiles", "RowStatuses", "Folders", "Release"}, Outputs: []string{"HistoryFileFullPath"}, Run: PhaseHistory},
+		{Name: "limits", Inputs: []string{"Config", "FinalFiles"}, Run: PhaseLimits},
+		{Name: "compatibility", Inputs: []string{"Config", "Folders", "WDEVersion"}, Run: PhaseCompatibility},
+		{Name: "dependencies", Inputs: []string{"Config", "FinalFiles"}, Run: PhaseDependencies},
+		{Name: "approval", Inputs: []string{"Config", "FinalFiles", "Release"}, Run: PhaseApproval},
+		{Name: "release-gate", Inputs: []string{"Config", "FinalFiles", "Release"}, Run: PhaseReleaseGate},
+		{Name: "backup", Inputs: []string{"Config", "Folders", "FinalFiles"}, Run: PhaseBackup},
+		{Name: "cache", Inputs: []string{"FinalFiles"}, Run: PhaseCache},
+		{Name: "stop", Inputs: []string{"Config"}, Run: PhaseStop},
+		{Name: "copy", Inputs: []string{"FinalFiles"}, Outputs: []string{"FinalFiles", "CopyDurations"}, Run: PhaseCopy},
+		{Name: "orphans", Inputs: []string{"Folders", "FinalFiles", "RowFiles", "RowStatuses"}, Outputs: []string{"RemovedFiles", "RetainedOrphans"}, Optional: true, Run: PhaseOrphans},
+		{Name: "directories", Inputs: []string{"Folders"}, Run: PhaseDirectories},
+		{Name: "empty-directories", Inputs: []string{"Config", "Folders", "RemovedFiles"}, Optional: true, Run: PhaseEmptyDirectories},
+		{Name: "state", Inputs: []string{"FinalFiles", "RetainedOrphans"}, Optional: true, Run: PhaseState},
+		{Name: "provenance", Inputs: []string{"Config", "FinalFiles", "Release", "RemovedFiles"}, Optional: true, Run: PhaseProvenance},
+		{Name: "registry-prepare", Inputs: []string{"Config", "RegistryStore"}, Outputs: []string{"RegistryData"}, Run: PhaseRegistryPrepare},
+		{Name: "registry-merge", Inputs: []string{"Config", "RegistryData", "FinalFiles", "RegistryStore"}, Outputs: []string{"RegistryData"}, Run: PhaseRegistryMerge},
+		{Name: "registry-write", Inputs: []string{"Config", "RegistryData", "RegistryStore"}, Run: PhaseRegistryWrite},
+		{Name: "registry-summary", Inputs: []string{"Config", "RegistryStore", "Release"}, Optional: true, Run: PhaseRegistrySummary},
+		{Name: "registry-users", Inputs: []string{"Config", "RegistryData"}, Run: PhaseRegistryUsers},
+		{Name: "deployment", Inputs: []string{"Config"}, Run: PhaseDeployment},
+		{Name: "agent-notice", Inputs: []string{"Config", "FinalFiles", "Release"}, Optional: true, Run: PhaseAgentNotice},
+		{Name: "release-record", Inputs: []string{"Release", "RegistryStore"}, Optional: true, Run: PhaseReleaseRecord},
+		{Name: "snapshot", Inputs: []string{"RegistryStore"}, Run: PhaseSnapshot},
+		{Name: "cleanup", Inputs: []string{"ProgramDirectory"}, Optional: true, Run: PhaseCleanup},
+	}
+}
+
+// Take machine-wide locks of workspace and WDE folder, released when run finished.
+func PhaseLock(state *RunState) error {
+	release, err := AcquireRunLock(state.Config, state.ProgramDirectory, state.Logger)
+	if err != nil {
+		return err
+	}
+	state.Defer(release)
+	return nil
+}
+
+// Check that run can finish without user in unattended mode, registry and copy options valid
+// and WDE folder writable before anything changed.
+func PhasePreflight(state *RunState) error {
+	err := CheckUnattended(state.Config, state.Simulate)
+	if err != nil {
+		return err
+	}
+	err = ValidateRegistryUsers(state.Config)
+	if err != nil {
+		return err
+	}
+	err = ValidateCustomFilesConfig(state.Config)
+	if err != nil {
+		return err
+	}
+	err = ValidateRegistryTemplate(state.Config)
+	if err != nil {
+		return err
+	}
+	_, err = NewCopyOptions(state.Config, nil) // Copy options checked before services stopped.
+	if err != nil {
+		return err
+	}
+	return CheckFolderWriteAccess(WDETargetFolder(state.Config))
+}
+
+// Get customisation folders and all files from all customisation sources.
+// If manifest pinned, files taken from it instead of sources.
+func PhaseCollection(state *RunState) error {
+	if state.Config.Manifest != "" {
+		state.Logger.Info(fmt.Sprintf("Take customisation files from manifest '%v'", state.Config.Manifest))
+		manifest, err := ReadManifest(state.Config.Manifest)
+		if err != nil {
+			state.Summary.Transient = IsTransientError(err)
+			return fmt.Errorf("can't read pinned manifest - %v", err)
+		}
+		state.Manifest = &manifest
+		state.Folders = manifest.Folders
+		state.RowFiles, _, _, err = manifest.CustomisationFiles()
+		if err != nil {
+			return fmt.Errorf("invalid pinned manifest - %v", err)
+		}
+		state.HistoryEvents.Add("Files pinned by manifest '%v' created %v on '%v'",
+			state.Config.Manifest, manifest.CreatedTime.Format(time.RFC3339), manifest.Hostname)
+		state.Summary.Folders = len(state.Folders)
+		state.Summary.Files = len(state.RowFiles)
+		return nil
+	}
+	state.Logger.Info("Start collection customisation folders and files")
+	var cache *ScanCache
+	cacheFullPath := ScanCacheFilePath(state.Config, state.ProgramDirectory)
+	if !state.Config.State.FullRescan {
+		var err error
+		cache, err = ReadScanCache(cacheFullPath)
+		if err != nil {
+			state.Logger.Warn(fmt.Sprint("Can't read scan cache, all files rescanned - ", err))
+		}
+	}
+	folders, files, err := CollectFromSources(ConfiguredSources(state.Config), cache, state.Logger)
+	if err != nil {
+		state.Summary.Transient = IsTransientError(err)
+		return fmt.Errorf("customisation files collection error - %v", err)
+	}
+	if cache != nil {
+		state.Logger.Info(fmt.Sprintf("Scan cache: %v files unchanged, %v hashed", cache.Reused, cache.Rescanned))
+		err = cache.Save(cacheFullPath)
+		if err != nil {
+			state.Logger.Warn(fmt.Sprint("Can't save scan cache - ", err))
+		}
+	}
+	state.Folders = folders
+	state.RowFiles = files
+	state.Logger.Info("Customisation folders and files collected")
+	state.Summary.Folders = len(folders)
+	state.Summary.Files = len(files)
+	return nil
+}
+
+// Filtering redundant and older files.
+// Get filtered files list and statuses of all original files.
+// Pinned manifest already contains them.
+func PhaseValidation(state *RunState) error {
+	if state.Manifest != nil {
+		_, state.RowStatuses, state.FinalFiles, _ = state.Manifest.CustomisationFiles()
+		state.Summary.FolderStats = CollectionStats(state.Folders, state.RowFiles, state.RowStatuses)
+		state.Summary.Statuses = CountFileStatuses(state.RowStatuses)
+		LogCollectionStats(state.Summary.FolderStats, state.Logger)
+		return checkCollectedCustomFiles(state)
+	}
+	strategy, err := GetCompareStrategy(state.Config.CompareStrategy)
+	if err != nil {
+		return err
+	}
+	state.Logger.Info("Start validation customisation files")
+	state.FinalFiles, state.RowStatuses = ValidateCollectedFiles(
+		state.RowFiles,
+		state.Config.RedundantFiles,
+		NewFilePolicy(state.Config.Policy.DenyExtensions, state.Config.Policy.AllowExtensions, state.Config.Policy.ProtectedFiles),
+		strategy,
+		state.Logger,
+	)
+	state.Logger.Info("Customisation files validated")
+	state.Summary.FolderStats = CollectionStats(state.Folders, state.RowFiles, state.RowStatuses)
+	state.Summary.Statuses = CountFileStatuses(state.RowStatuses)
+	LogCollectionStats(state.Summary.FolderStats, state.Logger)
+	return checkCollectedCustomFiles(state)
+}
+
+// Check "CustomFiles" value built from files to deploy, so invalid value found before WDE folder changed.
+func checkCollectedCustomFiles(state *RunState) error {
+	problems := ValidateCustomFilesXML(ConstructCustomFilesRegistryKey(state.FinalFiles))
+	for _, problem := range problems {
+		state.Logger.Error(fmt.Sprint("Invalid \"CustomFiles\" value of collected files - ", problem))
+		state.HistoryEvents.Add("Invalid CustomFiles: %v", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("\"CustomFiles\" value of collected files is invalid, nothing changed")
+	}
+	return nil
+}
+
+// Check directory manifests of customisation folders before anything changed.
+func PhaseDirectoryManifests(state *RunState) error {
+	return ValidateDirectoryManifests(state.Folders)
+}
+
+// Submit files to deploy to external scanner if configured. Files without clean verdict
+// marked rejected and not deployed.
+func PhaseScan(state *RunState) error {
+	if state.Config.Scanner.URL == "" || len(state.FinalFiles) == 0 {
+		return nil
+	}
+	onError := strings.ToLower(state.Config.Scanner.OnError)
+	if onError != "" && onError != ScannerOnErrorFail && onError != ScannerOnErrorAllow {
+		return fmt.Errorf("unknown Scanner.OnError '%v'", state.Config.Scanner.OnError)
+	}
+	state.Logger.Info(fmt.Sprintf("Submit %v files to scanner", len(state.FinalFiles)))
+	verdicts, err := RequestScanVerdicts(state.Config, state.FinalFiles, state.StartTimeString)
+	if err != nil {
+		if onError != ScannerOnErrorAllow {
+			return fmt.Errorf("scanner request failed - %v", err)
+		}
+		state.Logger.Warn(fmt.Sprint("Scanner request failed, files deployed unscanned by Scanner.OnError policy - ", err))
+		state.HistoryEvents.Add("Scanner unavailable, files deployed unscanned - %v", err)
+		return nil
+	}
+	state.FinalFiles = ApplyScanVerdicts(state.FinalFiles, state.RowFiles, state.RowStatuses, verdicts, state.HistoryEvents, state.Logger)
+	state.Summary.Statuses = CountFileStatuses(state.RowStatuses)
+	state.Logger.Info(fmt.Sprintf("Scanner verdicts applied, %v files allowed for deployment", len(state.FinalFiles)))
+	return nil
+}
+
+// Read release version and release notes of customisation folders. Version of pinned manifest
+// preferred. Markers and notes filtered as redundant and not deployed,
+// but recorded into history header and run summary passed to notification command.
+func PhaseRelease(state *RunState) error {
+	state.Release.Version = ReadReleaseVersion(state.RowFiles, state.Logger)
+	if state.Manifest != nil && state.Manifest.ReleaseVersion != "" {
+		state.Release.Version = state.Manifest.ReleaseVersion
+	}
+	if state.Release.Version != "" {
+		state.Logger.Info(fmt.Sprintf("Customisation release version '%v'", state.Release.Version))
+	}
+	state.Release.Notes = ReadReleaseNotes(state.RowFiles, state.Logger)
+	state.Summary.ReleaseVersion = state.Release.Version
+	state.Summary.ReleaseNotes = state.Release.Notes
+	return nil
+}
+
+// Write into history file initiator user name, program version, host facts
+// and all original files with statuses. Statuses written when pipeline finished, so they include copy results.
+// History file written in parallel process, may fail without affect on main process.
+// Run events appended to it when pipeline finished, also for failed run.
+func PhaseHistory(state *RunState) error {
+	historyWritingEnd := make(chan bool)
+	historyName := HistoryFilePrefix(state.Config)
+	state.HistoryFileFullPath = filepath.Join(
+		HistoryFolderPath(state.Config, state.ProgramDirectory),
+		fmt.Sprint(historyName, state.StartTimeString, ".log"),
+	)
+	historyFileFullPath := state.HistoryFileFullPath
+	state.Defer(func() {
+		statusLines, err := HistoryStatusLines(state.RowFiles, state.RowStatuses)
+		if err != nil {
+			state.Logger.Warn(fmt.Sprint("Can't write files statuses into history - ", err))
+		}
+		sections := []HistorySection{
+			{Title: "Collected files statuses", Lines: statusLines},
+			{Title: "Collection statistics", Lines: FormatCollectionStats(state.Summary.FolderStats)},
+		}
+		FinishHistoryFile(historyFileFullPath, sections, state.HistoryEvents, historyWritingEnd, state.Config.Mirror.Folder, state.Logger)
+	})
+	go WriteHistoryFile(
+		state.Folders,
+		state.Summary.Host,
+		state.Release,
+		state.Summary.RunLabels,
+		historyFileFullPath,
+		historyName,
+		!state.DryRun, // Old history files kept in dry run.
+		historyWritingEnd,
+		state.Logger,
+	)
+	return nil
+}
+
+// Warn about assemblies and native DLLs referenced by files to deploy but missing
+// in customisation set and WDE installation, so agents not crash at WDE startup.
+// Assemblies deployed more than once under different names or paths reported by configured action.
+func PhaseDependencies(state *RunState) error {
+	err := ApplyDuplicateAssemblies(state.FinalFiles, state.Config, state.HistoryEvents, state.Logger)
+	if err != nil {
+		return err
+	}
+	if state.Config.Dependencies.Disabled {
+		return nil
+	}
+	missing := CheckDependencies(state.FinalFiles, WDETargetFolder(state.Config), state.Config.Dependencies.Ignore)
+	for _, dependency := range missing {
+		state.Logger.Warn(fmt.Sprint("Missing dependency - ", dependency))
+		state.HistoryEvents.Add("Missing dependency: %v", dependency)
+	}
+	return nil
+}
+
+// Check size of files to deploy against configured limits before anything copied.
+func PhaseLimits(state *RunState) error {
+	return ApplySizeLimits(state.FinalFiles, state.Config, state.HistoryEvents, state.Logger)
+}
+
+// Check installed WDE version against compatibility matrix before anything copied.
+func PhaseCompatibility(state *RunState) error {
+	return ApplyCompatibility(state.Config, state.Folders, state.WDEVersion.File, state.HistoryEvents, state.Logger)
+}
+
+// Ask approval endpoint for permission before WDE folder changed.
+func PhaseApproval(state *RunState) error {
+	if state.Config.Approval.URL == "" {
+		return nil
+	}
+	deployed, err := ReadDeployedState(filepath.Join(StateFolderPath(state.Config, state.ProgramDirectory), StateFileName))
+	if err != nil {
+		return fmt.Errorf("can't read deployed state - %v", err)
+	}
+	request := NewApprovalRequest(state.FinalFiles, deployed, state.Release, state.StartTimeString, state.Summary.RunLabels)
+	state.Logger.Info(fmt.Sprintf("Request approval of %d added, %d changed, %d removed files, plan %v", request.AddedCount, request.ChangedCount, request.RemovedCount, request.PlanHash))
+	err = WaitApproval(state.Config, request, state.Logger)
+	if err != nil {
+		return err
+	}
+	state.Logger.Info("Update approved")
+	state.HistoryEvents.Add("Update approved, plan %v", request.PlanHash)
+	return nil
+}
+
+// Save WDE files which copy would replace and orphaned files into backup set if configured.
+func PhaseBackup(state *RunState) error {
+	if !state.Config.Backup.Enabled {
+		return nil
+	}
+	previousState, err := ReadDeployedState(filepath.Join(StateFolderPath(state.Config, state.ProgramDirectory), StateFileName))
+	if err != nil {
+		return fmt.Errorf("can't read deployed state - %v", err)
+	}
+	// Files failed to copy excluded from final files, but their path still used by customisation.
+	currentFiles := append([]CustomisationFile{}, state.FinalFiles...)
+	for id, status := range state.RowStatuses {
+		if status == StatusFailed {
+			currentFiles = append(currentFiles, state.RowFiles[id])
+		}
+	}
+	orphans := previousState.FindOrphanedFiles(state.Folders, currentFiles)
+	backupFolder := BackupFolderPath(state.Config, state.ProgramDirectory)
+	// Latest snapshot is previous registry data of this run.
+	registrySnapshot := ""
+	snapshots, err := ListRegistrySnapshots(SavedRegistryFolderPath(state.Config, state.ProgramDirectory))
+	if err == nil {
+		if snapshot, err := FindRegistrySnapshot(snapshots, "last"); err == nil {
+			registrySnapshot = snapshot.ID
+		}
+	}
+	set, err := CreateBackupSet(backupFolder, state.StartTimeString, WDETargetFolder(state.Config), registrySnapshot, state.FinalFiles, orphans, previousState)
+	if err != nil {
+		return fmt.Errorf("can't save backup set - %v", err)
+	}
+	state.Logger.Info(fmt.Sprintf("Backup set '%v' saved, %v files", set.ID, len(set.Files)))
+	state.HistoryEvents.Add("Backup set '%v' saved, %v files", set.ID, len(set.Files))
+	keep := state.Config.Backup.Keep
+	if keep <= 0 {
+		keep = BackupDefaultKeep
+	}
+	err = ClearOldBackupSets(backupFolder, keep)
+	if err != nil {
+		state.Logger.Warn(fmt.Sprint("Can't delete old backup sets - ", err))
+	}
+	return nil
+}
+
+// Find files deployed by previous runs from customisation folders removed from sources.
+// Runs after stop and copy, so files removed only while WDE processes stopped and never if copy failed.
+func PhaseOrphans(state *RunState) error {
+	stateFileFullPath := filepath.Join(StateFolderPath(state.Config, state.ProgramDirectory), StateFileName)
+	previousState, err := ReadDeployedState(stateFileFullPath)
+	if err != nil {
+		return fmt.Errorf("can't read deployed state - %v", err)
+	}
+	orphans := previousState.FindOrphanedFiles(state.Folders, state.FinalFiles)
+	state.RemovedFiles, state.RetainedOrphans = RemoveOrphanedFiles(orphans, WDETargetFolder(state.Config), state.Config.State.RemoveOrphans, state.Audit, state.HistoryEvents, state.Logger)
+	return nil
+}
+
+// Stage files in local cache, so identical files transferred from source only once.
+func PhaseCache(state *RunState) error {
+	if state.Config.Cache.Folder == "" {
+		return nil
+	}
+	state.Logger.Info("Stage validated customisation files in local cache")
+	err := StageFilesInCache(state.FinalFiles, state.Config.Cache.Folder, state.Logger)
+	if err != nil {
+		return fmt.Errorf("fail stage customisation files in cache - %v", err)
+	}
+	return nil
+}
+
+// Stop configured services and processes. They started again when pipeline finished, after Deployment Manager.
+func PhaseStop(state *RunState) error {
+	start, err := StopConfiguredServices(state.Config, state.HistoryEvents, state.Logger)
+	state.Defer(start)
+	return err
+}
+
+// Copy all filtered files into WDE folder.
+// If failed files tolerated, they removed from files deployed by next phases.
+func PhaseCopy(state *RunState) error {
+	options, err := NewCopyOptions(state.Config, state.Progress)
+	if err != nil {
+		return err
+	}
+	options.Audit = state.Audit
+	recheckDelay, err := AVRecheckDelay(state.Config)
+	if err != nil {
+		return err
+	}
+	state.Logger.Info(fmt.Sprintf("Start copy validated customisation files into WDE folder with '%v' engine", options.Engine.Name()))
+	err = CopyCustomisationFiles(state.FinalFiles, WDETargetFolder(state.Config), options, state.HistoryEvents, state.Logger)
+	ApplyCopyStatuses(state.RowFiles, state.RowStatuses, state.FinalFiles)
+	state.Summary.Statuses = CountFileStatuses(state.RowStatuses)
+	state.Summary.Copied = state.Summary.Statuses[StatusCopied] + state.Summary.Statuses[StatusUnchanged]
+	if err != nil {
+		return fmt.Errorf("fail copy customisation files - %v", err)
+	}
+	var copyTime time.Duration
+	for _, file := range state.FinalFiles {
+		state.CopyDurations = append(state.CopyDurations, file.CopyDuration)
+		if file.CopyStatus == StatusCopied {
+			state.Summary.CopiedBytes += file.Size
+			copyTime += file.CopyDuration
+		}
+	}
+	state.Summary.CopyTime = copyTime.String()
+	if recheckDelay > 0 && state.Summary.Statuses[StatusCopied] > 0 {
+		state.Logger.Info(fmt.Sprintf("Check copied files again after %v for antivirus interference", recheckDelay))
+		suspects := RecheckCopiedFiles(state.FinalFiles, WDETargetFolder(state.Config), recheckDelay)
+		LogAVSuspects(suspects, state.HistoryEvents, state.Logger)
+		if strings.ToLower(state.Config.Copy.OnAVInterference) == AVInterferenceFail {
+			err = failAVSuspects(state, suspects, options.ContinueOnError)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	state.FinalFiles = DeployedFiles(state.FinalFiles)
+	if state.Summary.Statuses[StatusFailed] > 0 {
+		state.Logger.Warn("Validated customisation files copied into WDE folder partially, failed files excluded from deployment")
+		return nil
+	}
+	state.Logger.Info("Validated customisation files copied into WDE folder")
+	return nil
+}
+
+// Mark files vanished or changed after copy as failed, they can't be deployed.
+// Return error unless failed files tolerated.
+func failAVSuspects(state *RunState, suspects []AVSuspect, continueOnError bool) error {
+	failed := make(map[string]bool, len(suspects))
+	for _, suspect := range suspects {
+		if suspect.Reason != AVReasonSlow {
+			failed[suspect.Path] = true
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	if !continueOnError {
+		return fmt.Errorf("%v copied files vanished or changed after copy, possible antivirus interference", len(failed))
+	}
+	for id, file := range state.FinalFiles {
+		if failed[filepath.Join(WDETargetFolder(state.Config), file.RelativePath, file.FileName)] {
+			state.FinalFiles[id].CopyStatus = StatusFailed
+		}
+	}
+	ApplyCopyStatuses(state.RowFiles, state.RowStatuses, state.FinalFiles)
+	state.Summary.Statuses = CountFileStatuses(state.RowStatuses)
+	state.Summary.Copied = state.Summary.Statuses[StatusCopied] + state.Summary.Statuses[StatusUnchanged]
+	return nil
+}
+
+// Create directories declared by customisation folders manifests, including empty ones.
+func PhaseDirectories(state *RunState) error {
+	return CreateManifestDirectories(state.Folders, WDETargetFolder(state.Config), state.HistoryEvents, state.Logger)
+}
+
+// Remove directories left empty by files removed in this run if configured.
+// Directories declared by directory manifests kept.
+func PhaseEmptyDirectories(state *RunState) error {
+	if !state.Config.State.RemoveEmptyDirectories || len(state.RemovedFiles) == 0 {
+		return nil
+	}
+	declared, err := DeclaredDirectories(state.Folders)
+	if err != nil {
+		return err
+	}
+	removed := RemoveEmptyDirectories(state.RemovedFiles, WDETargetFolder(state.Config), declared, state.Audit, state.HistoryEvents, state.Logger)
+	state.Logger.Info(fmt.Sprintf("%v empty directories removed from WDE folder", len(removed)))
+	return nil
+}
+
+// Save deployed state for next runs.
+func PhaseState(state *RunState) error {
+	deployedState, err := NewDeployedState(state.StartTime, state.FinalFiles, state.RetainedOrphans)
+	if err != nil {
+		return fmt.Errorf("can't save deployed state - %v", err)
+	}
+	err = deployedState.Save(filepath.Join(StateFolderPath(state.Config, state.ProgramDirectory), StateFileName))
+	if err != nil {
+		return fmt.Errorf("can't save deployed state - %v", err)
+	}
+	return nil
+}
+
+// Record source folder, release, hash and run of deployed and removed files for "whence" command.
+func PhaseProvenance(state *RunState) error {
+	provenanceFullPath := ProvenanceFilePath(state.Config, state.ProgramDirectory)
+	db, err := ReadProvenance(provenanceFullPath)
+	switch {
+	case errors.Is(err, ErrProvenanceDamaged):
+		// Damaged database kept aside, so its history can be recovered by hand.
+		damagedFullPath := fmt.Sprint(provenanceFullPath, ".damaged_", state.StartTimeString)
+		renameErr := os.Rename(provenanceFullPath, damagedFullPath)
+		if renameErr != nil {
+			return fmt.Errorf("can't back up damaged provenance database - %v", renameErr)
+		}
+		state.Logger.Warn(fmt.Sprintf("Provenance database damaged, kept as '%v' and recorded from scratch - %v", damagedFullPath, err))
+		state.HistoryEvents.Add("Damaged provenance database kept as '%v'", damagedFullPath)
+	case err != nil:
+		return fmt.Errorf("can't read provenance database, not overwritten - %v", err)
+	}
+	added := db.Update(state.FinalFiles, state.RemovedFiles, WDETargetFolder(state.Config), state.StartTimeString, state.Release, state.Summary.RunLabels, state.StartTime)
+	err = db.Save(provenanceFullPath)
+	if err != nil {
+		return fmt.Errorf("can't save provenance database - %v", err)
+	}
+	state.Logger.Info(fmt.Sprintf("Provenance of %v files recorded", added))
+	return nil
+}
+
+// Report files to deploy as staged and wait for release gate of this release
+// before services stopped and WDE folder changed. Skipped if coordination not configured.
+func PhaseReleaseGate(state *RunState) error {
+	if state.Config.Coordination.Folder == "" && state.Config.Coordination.URL == "" {
+		return nil
+	}
+	timeout, pollInterval, err := CoordinationDurations(state.Config)
+	if err != nil {
+		return err
+	}
+	releaseKey := StagedReleaseKey(state.Release, state.FinalFiles)
+	err = ReportStaged(state.Config, StagedReport{
+		Hostname:       state.Summary.Hostname,
+		ProgramVersion: programVersion,
+		StagedTime:     TimestampNow(),
+		Release:        releaseKey,
+		Files:          len(state.FinalFiles),
+		Manifest:       state.Config.Manifest,
+	})
+	if err != nil {
+		return fmt.Errorf("can't report staged files - %v", err)
+	}
+	state.HistoryEvents.Add("Staged OK reported for release '%v'", releaseKey)
+	state.Logger.Info(fmt.Sprintf("Staged OK reported for release '%v', wait for release gate up to %v", releaseKey, timeout))
+	err = WaitReleaseGate(state.Config, releaseKey, timeout, pollInterval, state.Logger)
+	if err != nil {
+		return err
+	}
+	state.HistoryEvents.Add("Release gate opened for release '%v'", releaseKey)
+	state.Logger.Info(fmt.Sprintf("Release gate opened for release '%v'", releaseKey))
+	return nil
+}
+
+// Read previously saved registry data.
+// If there are no files to read, save the current registry data to a file and use it.
+// In dry run nothing written: folder not created, current registry data and baseline state not saved, invalid files not quarantined.
+func PhaseRegistryPrepare(state *RunState) error {
+	logger := state.Logger
+	logger.Info("Prepare registry data")
+	savedRegistryDir := SavedRegistryFolderPath(state.Config, state.ProgramDirectory)
+	logger.Info("Reading previously saved registry data")
+	if !state.DryRun {
+		err := os.MkdirAll(savedRegistryDir, 0755)
+		if err != nil {
+			return fmt.Errorf("can't create folder for previously saved registry - %v", err)
+		}
+	}
+	baselineFileFullPath := filepath.Join(StateFolderPath(state.Config, state.ProgramDirectory), BaselineFileName)
+	baseline, err := ReadRegistryBaseline(baselineFileFullPath)
+	if err != nil {
+		logger.Error(fmt.Sprint("Can't read registry baseline state, refresh counted from now - ", err))
+	}
+	if baseline.RefreshTime.IsZero() {
+		baseline.RefreshTime = state.StartTime
+	}
+	regDataByte, invalid, err := ReadPreviouslySavedRegistryData(savedRegistryDir, !state.DryRun)
+	for _, name := range invalid {
+		if state.DryRun {
+			logger.Warn(fmt.Sprintf("Saved registry file '%v' has invalid structure, dry run, not quarantined", name))
+			continue
+		}
+		logger.Warn(fmt.Sprintf("Saved registry file '%v' has invalid structure, moved into '%v'", name, RegQuarantineFolder))
+		state.HistoryEvents.Add("Invalid saved registry file '%v' quarantined", name)
+	}
+	if err == nil && !baseline.Due(state.StartTime, state.Config.Registry.RefreshEveryRuns, state.Config.Registry.RefreshEveryDays) {
+		logger.Info("Unmarshal previously saved registry data")
+		state.RegistryData, err = UnmarshalRegistryData(regDataByte)
+		if err != nil {
+			return fmt.Errorf("can't unmarshal registry data from YAML - %v", err)
+		}
+		baseline.Runs++
+		if !state.DryRun {
+			saveRegistryBaseline(baseline, baselineFileFullPath, logger)
+		}
+		logger.Info("Registry data prepared")
+		return nil
+	}
+	label := "INITIALISATION_"
+	switch {
+	case err == nil:
+		logger.Info(fmt.Sprintf("Registry baseline refresh due (last refresh %v, %v runs since), read current user registry data", FileTimestamp(baseline.RefreshTime), baseline.Runs))
+		state.HistoryEvents.Add("Registry baseline refreshed from live registry, last refresh %v", FileTimestamp(baseline.RefreshTime))
+		label = "BASELINE_"
+	case err == ErrNoFilesFoundInFolderByPattern:
+		logger.Info("No previously registry data saved. Try read from current user registry data")
+	default:
+		return fmt.Errorf("reading previously saved registry data from file failed - %v", err)
+	}
+	baseline = RegistryBaseline{RefreshTime: state.StartTime}
+	if !state.DryRun {
+		saveRegistryBaseline(baseline, baselineFileFullPath, logger)
+	}
+
+	regData, err := state.RegistryStore.Read(DMRegistryDir)
+	switch err {
+	case nil:
+		logger.Info("Save current user registry data as initialisation data")
+	case ErrRegistryKeyNotExist:
+		logger.Info("No data in current user registry. Save zeroed initialisation data")
+		regData = make([]RegistryValue, 0, 32)
+	default:
+		return fmt.Errorf("reading current user registry data error - %v", err)
+	}
+	if state.DryRun {
+		logger.Info("Dry run, initialisation registry data not saved")
+		state.RegistryData = regData
+		return nil
+	}
+	registryFileFullPath := filepath.Join(
+		savedRegistryDir,
+		fmt.Sprint(RegFileName, label, state.StartTimeString, ".yaml"),
+	)
+	logger.Info("Marshal collected registry data")
+	regDataByte, err = MarshalRegistryData(regData)
+	if err != nil {
+		return fmt.Errorf("can't marshal registry data into YAML - %v", err)
+	}
+	logger.Info("Save Marshaled registry data into file")
+	err = SaveBytesIntoFile(registryFileFullPath, regDataByte)
+	if err != nil {
+		return fmt.Errorf("can't save registry data into file - %v", err)
+	}
+	MirrorFileWithLog(state.Config.Mirror.Folder, SavedRegFolder, registryFileFullPath, logger)
+	logger.Info("Initialisation registry data saved")
+	state.RegistryData = regData
+	logger.Info("Registry data prepared")
+	return nil
+}
+
+// Update data previously saved from registry with new files list.
+func PhaseRegistryMerge(state *RunState) error {
+	state.Logger.Info("Update old registry data with new data")
+	state.RegistryData.InsertAddCustomFileTrueValue() // Force set "AddCustomFile" with "True"
+	if mode := state.Config.CustomFiles.Mode; mode != "" && mode != CustomFilesRebuild && mode != CustomFilesIncremental {
+		return fmt.Errorf("unknown CustomFiles.Mode '%v'", mode)
+	}
+	if source := state.Config.CustomFiles.OptionsSource; source != "" && source != CustomFilesOptionsSnapshot && source != CustomFilesOptionsLive {
+		return fmt.Errorf("unknown CustomFiles.OptionsSource '%v'", source)
+	}
+	previousFiles, err := state.RegistryData.CustomFilesEntries()
+	if err != nil && err != ErrCustomFilesNotFound {
+		return fmt.Errorf("can't update old registry data with new data - %v", err)
+	}
+	switch state.Config.CustomFiles.Merge {
+	case "", CustomFilesMergeTwoWay:
+		if state.Config.CustomFiles.OptionsSource != CustomFilesOptionsLive {
+			break
+		}
+		liveFiles, liveErr := readLiveCustomFiles(state.RegistryStore)
+		switch liveErr {
+		case nil:
+			state.Logger.Info("Manual options of \"CustomFiles\" entries taken from live registry")
+			previousFiles, err = liveFiles, nil
+		case ErrCustomFilesNotFound:
+			state.Logger.Info("Live registry contain no \"CustomFiles\" value, manual options taken from saved data")
+		default:
+			return liveErr
+		}
+	case CustomFilesMergeThreeWay:
+		previousFiles, err = mergeLiveCustomFiles(state, previousFiles, err)
+		if err != nil && err != ErrCustomFilesNotFound {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown CustomFiles.Merge '%v'", state.Config.CustomFiles.Merge)
+	}
+	orderedFiles, orderErr := OrderCustomFiles(state.FinalFiles, state.Config.CustomFiles.Order, previousFiles)
+	if orderErr != nil {
+		return orderErr
+	}
+	switch {
+	case err == ErrCustomFilesNotFound:
+		state.Logger.Info("Old registry data contain not \"CustomFiles\" key. Add fully new data for \"CustomFiles\" key")
+		state.RegistryData.InsertActualCustomFilesValue(ConstructCustomFilesRegistryKey(orderedFiles))
+	case state.Config.CustomFiles.Mode == CustomFilesIncremental:
+		added, removed := state.RegistryData.UpdateCustomFilesIncremental(orderedFiles, previousFiles)
+		state.Logger.Info(fmt.Sprintf("\"CustomFiles\" updated incrementally, %v entries added, %v removed", len(added), len(removed)))
+		for _, path := range added {
+			state.HistoryEvents.Add("CustomFiles entry added '%v'", path)
+		}
+		for _, path := range removed {
+			state.HistoryEvents.Add("CustomFiles entry removed '%v'", path)
+		}
+	default:
+		state.RegistryData.AddManuallyAddedOptions(orderedFiles, previousFiles) // Combine manually added options and new collected files.
+	}
+	templated, err := ApplyRegistryTemplate(&state.RegistryData, state.Config)
+	if err != nil {
+		return fmt.Errorf("can't render registry template - %v", err)
+	}
+	if len(templated) > 0 {
+		state.Logger.Info(fmt.Sprintf("Registry values rendered from template for target '%v': %v", state.Config.Registry.Target, strings.Join(templated, ", ")))
+	}
+	return CheckCustomFilesSize(&state.RegistryData, state.Config, state.HistoryEvents, state.Logger)
+}
+
+// Read entries of "CustomFiles" value from live registry or ErrCustomFilesNotFound.
+func readLiveCustomFiles(store RegistryStore) ([]CustomisationFile, error) {
+	liveData, err := store.Read(DMRegistryDir)
+	if err == ErrRegistryKeyNotExist {
+		return nil, ErrCustomFilesNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read live registry - %v", err)
+	}
+	liveFiles, err := RegistryValues(liveData).CustomFilesEntries()
+	if err != nil && err != ErrCustomFilesNotFound {
+		return nil, fmt.Errorf("can't parse live \"CustomFiles\" value - %v", err)
+	}
+	return liveFiles, err
+}
+
+// Merge saved "CustomFiles" entries with live registry value, report kept live changes and conflicts.
+// Saved entries and error of their read returned unchanged if live registry contain no "CustomFiles" value.
+func mergeLiveCustomFiles(state *RunState, savedFiles []CustomisationFile, savedErr error) ([]CustomisationFile, error) {
+	liveFiles, err := readLiveCustomFiles(state.RegistryStore)
+	if err == ErrCustomFilesNotFound {
+		return savedFiles, savedErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	merged, kept, conflicts := MergeCustomFilesThreeWay(savedFiles, liveFiles, state.FinalFiles)
+	for _, change := range kept {
+		state.Logger.Info(fmt.Sprint("Live registry change kept - ", change))
+		state.HistoryEvents.Add("CustomFiles live change kept: %v", change)
+	}
+	for _, conflict := range conflicts {
+		state.Logger.Warn(fmt.Sprint("\"CustomFiles\" merge conflict - ", conflict))
+		state.HistoryEvents.Add("CustomFiles merge conflict: %v", conflict)
+	}
+	return merged, nil
+}
+
+// Write prepared data into registry. Invalid "CustomFiles" value never written.
+// Values not owned by updater and present in registry left unchanged.
+// Written values verified, on failure previous values restored.
+func PhaseRegistryWrite(state *RunState) error {
+	problems := state.RegistryData.ValidateCustomFiles()
+	for _, problem := range problems {
+		state.Logger.Error(fmt.Sprint("Invalid \"CustomFiles\" value - ", problem))
+		state.HistoryEvents.Add("Invalid CustomFiles: %v", problem)
+	}
+	if len(problems) > 0 {
+		return ErrInvalidCustomFiles
+	}
+	backupFullPath, err := BackupRegistryDir(state.RegistryStore, DMRegistryDir, SavedRegistryFolderPath(state.Config, state.ProgramDirectory), state.StartTimeString)
+	if err != nil {
+		return fmt.Errorf("can't backup registry before write, registry not written - %v", err)
+	}
+	if backupFullPath != "" {
+		state.Logger.Info(fmt.Sprintf("Live registry data exported into '%v'", backupFullPath))
+		state.Audit.Record(AuditFileBackedUp, backupFullPath, "", state.Audit.FileHash(backupFullPath), fmt.Sprint("registry ", DMRegistryDir))
+	}
+	ownershipFileFullPath := filepath.Join(StateFolderPath(state.Config, state.ProgramDirectory), OwnershipFileName)
+	ownership, err := ReadRegistryOwnership(ownershipFileFullPath, ConfiguredManagedValues(state.Config))
+	if err != nil {
+		return fmt.Errorf("can't read registry ownership - %v", err)
+	}
+	liveData, err := state.RegistryStore.Read(DMRegistryDir)
+	if err != nil && err != ErrRegistryKeyNotExist {
+		return fmt.Errorf("can't read registry values before write - %v", err)
+	}
+	writableData, keptValues, deletedValues := ownership.SelectWritable(state.RegistryData, liveData)
+	for _, name := range keptValues {
+		state.Logger.Info(fmt.Sprintf("Registry value '%v' not owned by updater and changed by hand, kept unchanged", name))
+		state.HistoryEvents.Add("Registry value '%v' kept, not owned by updater", name)
+	}
+	for _, name := range deletedValues {
+		state.Logger.Info(fmt.Sprintf("Registry value '%v' deleted by hand, not re-created", name))
+		state.HistoryEvents.Add("Registry value '%v' deleted by hand, not re-created", name)
+	}
+	state.Logger.Info("Start writing prepared data into registry")
+	err = WriteRegistryVerified(state.RegistryStore, DMRegistryDir, writableData)
+	if writeErr, ok := err.(*RegistryWriteError); ok {
+		state.Audit.Record(AuditRegistryRolledBack, DMRegistryDir, "", "", writeErr.Error())
+		if writeErr.RolledBack() {
+			state.HistoryEvents.Add("Registry write failed, previous values restored - %v", writeErr)
+			return fmt.Errorf("registry write rolled back - %v", writeErr)
+		}
+		state.HistoryEvents.Add("Registry write failed and NOT rolled back, restore from '%v' - %v", backupFullPath, writeErr)
+		return fmt.Errorf("registry write failed, rollback failed, restore from backup '%v' - %v", backupFullPath, writeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("can't write into registry - %v", err)
+	}
+	state.Logger.Info("Write into registry successful, values verified")
+	liveHashes := make(map[string]string, len(liveData))
+	for _, value := range liveData {
+		liveHashes[value.Name] = HashRegistryData(value.Data)
+	}
+	for _, value := range writableData {
+		state.Audit.Record(AuditRegistryWritten, fmt.Sprint(DMRegistryDir, `\`, value.Name), liveHashes[value.Name], HashRegistryData(value.Data), value.Type)
+	}
+	writtenNames := make([]string, 0, len(writableData))
+	for _, value := range writableData {
+		writtenNames = append(writtenNames, value.Name)
+	}
+	ownership.Remove(deletedValues)
+	ownership.Add(writtenNames)
+	err = ownership.Save(ownershipFileFullPath)
+	if err != nil {
+		state.Logger.Error(fmt.Sprint("Can't save registry ownership - ", err))
+	}
+	return nil
+}
+
+// Apply prepared "CustomFiles" to DM registry of other users on shared host.
+// Users without DM registry key skipped, failure of one user not stop others.
+func PhaseRegistryUsers(state *RunState) error {
+	sids, err := UserRegistrySIDs(state.Config)
+	if err != nil {
+		return err
+	}
+	if len(sids) == 0 {
+		return nil
+	}
+	if state.Simulate {
+		state.Logger.Info(fmt.Sprintf("Simulation, DM registry of %v other users not changed", len(sids)))
+		return nil
+	}
+	values := state.RegistryData.UserValues()
+	savedRegistryDir := SavedRegistryFolderPath(state.Config, state.ProgramDirectory)
+	parallelism := state.Config.Run.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultTargetParallelism
+	}
+	// Hives written concurrently, events collected per user and added in order of SIDs.
+	results := make([]error, len(sids))
+	written := make([]bool, len(sids))
+	slots := make(chan struct{}, parallelism)
+	var wait sync.WaitGroup
+	for id, sid := range sids {
+		wait.Add(1)
+		go func(id int, sid string) {
+			defer wait.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			written[id], results[id] = writeUserRegistry(sid, values, savedRegistryDir, state.StartTimeString, state.Audit, state.Logger)
+		}(id, sid)
+	}
+	wait.Wait()
+	failed := make([]string, 0)
+	for id, sid := range sids {
+		switch {
+		case results[id] != nil:
+			state.HistoryEvents.Add("DM registry of user '%v' not written - %v", sid, results[id])
+			failed = append(failed, sid)
+		case written[id]:
+			state.HistoryEvents.Add("DM registry of user '%v' written", sid)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("DM registry of %v users not written: %v", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Restore missing Deployment Manager config from template and check DM prerequisites if configured.
+// Run before services stopped and files copied, so machine without prerequisites left unchanged.
+// Publish command used instead of DM not checked.
+func PhaseDMPrerequisites(state *RunState) error {
+	prerequisites := state.Config.DM.Prerequisites
+	if state.Config.DM.Skip || len(state.Config.DM.Command) > 0 || (!prerequisites.Check && prerequisites.ConfigTemplate == "") {
+		return nil
+	}
+	if state.Simulate {
+		state.Logger.Info("Simulation, Deployment Manager prerequisites not checked")
+		return nil
+	}
+	repaired, err := RepairDMConfig(state.Config, state.Logger)
+	if err != nil {
+		return err
+	}
+	if repaired {
+		state.HistoryEvents.Add("Deployment Manager config restored from template '%v'", prerequisites.ConfigTemplate)
+		state.Audit.Record(AuditFileCopied, DMConfigFilePath(state.Config), "", state.Audit.FileHash(DMConfigFilePath(state.Config)), "restored from template")
+	}
+	if !prerequisites.Check {
+		return nil
+	}
+	problems := CheckDMPrerequisites(state.Config)
+	for _, problem := range problems {
+		state.Logger.Error(fmt.Sprint("Deployment Manager prerequisite missing - ", problem))
+		state.HistoryEvents.Add("Deployment Manager prerequisite missing: %v", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Deployment Manager prerequisites missing: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Run WDE Deployment Manager or publish command from config and wait while it stop.
+func PhaseDeployment(state *RunState) error {
+	if state.Simulate {
+		state.Logger.Info("Simulation, WDE Deployment Manager not started")
+		return nil
+	}
+	if state.Config.DM.Skip {
+		state.Logger.Info("DM.Skip set, WDE Deployment Manager not started")
+		state.HistoryEvents.Add("WDE Deployment Manager not started, customisation published later")
+		return nil
+	}
+	err := RunDeploymentPhase(state.Config, state.Summary, state.HistoryEvents, state.Logger)
+	if err != nil {
+		return fmt.Errorf("WDE deployment manager error - %v", err)
+	}
+	state.Logger.Info("WDE Deployment Manager stopped")
+	return nil
+}
+
+// Write notice file for agents with version and time of applied customisation if configured.
+func PhaseAgentNotice(state *RunState) error {
+	if !state.Config.Notify.AgentFile.Enabled {
+		return nil
+	}
+	hostname, _ := os.Hostname()
+	notice := AgentNotice{
+		Version:        state.Release.Version,
+		AppliedTime:    TimestampNow(),
+		Hostname:       hostname,
+		Files:          len(state.FinalFiles),
+		FailedFiles:    state.Summary.Statuses[StatusFailed],
+		ProgramVersion: programVersion,
+	}
+	if state.Config.Notify.AgentFile.Version != "" {
+		notice.Version = state.Config.Notify.AgentFile.Version
+	}
+	noticeFullPath := AgentNoticeFilePath(state.Config)
+	err := WriteAgentNotice(noticeFullPath, notice)
+	if err != nil {
+		return fmt.Errorf("can't write agent notice '%v' - %v", noticeFullPath, err)
+	}
+	state.Logger.Info(fmt.Sprintf("Agent notice written into '%v'", noticeFullPath))
+	state.Audit.Record(AuditFileCopied, noticeFullPath, "", state.Audit.FileHash(noticeFullPath), "agent notice")
+	return nil
+}
+
+// Record applied release version in updater registry directory.
+func PhaseReleaseRecord(state *RunState) error {
+	if state.Release.Version == "" {
+		return nil
+	}
+	err := RecordReleaseVersion(state.RegistryStore, state.Release.Version, TimestampNow())
+	if err != nil {
+		return fmt.Errorf("can't record release version in registry - %v", err)
+	}
+	state.Logger.Info(fmt.Sprintf("Release version '%v' recorded in registry '%v'", state.Release.Version, UpdaterRegistryDir))
+	return nil
+}
+
+// Publish run values in updater registry directory if configured.
+func PhaseRegistrySummary(state *RunState) error {
+	if !state.Config.Registry.PublishSummary {
+		return nil
+	}
+	values, err := PublishRegistrySummary(state.RegistryStore, state.StartTime, state.StartTimeString, state.Release.Version, state.Summary.RunLabels)
+	if err != nil {
+		return fmt.Errorf("can't publish run summary in registry - %v", err)
+	}
+	for _, value := range values {
+		state.Audit.Record(AuditRegistryWritten, fmt.Sprint(UpdaterRegistryDir, `\`, value.Name), "", HashRegistryData(value.Data), value.Type)
+	}
+	state.Logger.Info(fmt.Sprintf("Run summary published in registry '%v'", UpdaterRegistryDir))
+	return nil
+}
+
+// Save actual registry data into file.
+func PhaseSnapshot(state *RunState) error {
+	state.Logger.Info("Save actual registry data into file")
+	regData, err := state.RegistryStore.Read(DMRegistryDir)
+	if err != nil {
+		return fmt.Errorf("can't save registry data after WDE Deployment Manager - %v", err)
+	}
+	registryBytes, err := MarshalRegistryData(regData)
+	if err != nil {
+		return fmt.Errorf("can't marshal registry data into YAML - %v", err)
+	}
+	registryFileFullPath := filepath.Join(
+		SavedRegistryFolderPath(state.Config, state.ProgramDirectory),
+		fmt.Sprint(RegFileName, state.StartTimeString, ".yaml"),
+	)
+	err = SaveBytesIntoFile(registryFileFullPath, registryBytes)
+	if err != nil {
+		return fmt.Errorf("can't save registry data into file - %v", err)
+	}
+	state.Logger.Info("Write data into file successful")
+	MirrorFileWithLog(state.Config.Mirror.Folder, SavedRegFolder, registryFileFullPath, state.Logger)
+	return nil
+}
+
+// Clean old registry and log files. Preserve last files for backup purposes.
+func PhaseCleanup(state *RunState) error {
+	state.Logger.Info("Delete old registry files")
+	err := ClearOldFiles(SavedRegistryFolderPath(state.Config, state.ProgramDirectory), RegFileName, 15)
+	if err != nil {
+		return fmt.Errorf("can't delete old registry files - %v", err)
+	}
+	state.Logger.Info("Delete old log files")
+	err = ClearOldFiles(LogFolderPath(state.Config, state.ProgramDirectory), LogFilePrefix(state.Config), 15)
+	if err != nil {
+		return fmt.Errorf("can't delete old log files - %v", err)
+	}
+	state.Logger.Info("Old files cleared")
+	return nil
+}
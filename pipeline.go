@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+// Shared state of update run. Phases read their inputs and write outputs here.
+type RunState struct {
+	// Initial data available for all phases.
+	Config           MainCfgYAML
+	ProgramDirectory string
+	StartTime        time.Time
+	StartTimeString  string
+	Simulate         bool
+	DryRun           bool // Changes only reported, see DryRunPhases.
+	RegistryStore    RegistryStore
+	WDEVersion       WDEVersion
+	Summary          *RunSummary
+	HistoryEvents    *HistoryEvents
+	Progress         *ProgressStream
+	Audit            *AuditLog // Nil if audit disabled.
+	Logger           *zap.Logger
+
+	// Phase outputs.
+	Manifest            *Manifest           // "collection", pinned manifest if configured
+	Folders             []string            // "collection"
+	RowFiles            []CustomisationFile // "collection"
+	RowStatuses         []FileStatus        // "validation", "copy"
+	FinalFiles          []CustomisationFile // "validation"
+	HistoryFileFullPath string              // "history"
+	CopyDurations       []time.Duration     // "copy"
+	RegistryData        RegistryValues      // "registry-prepare", "registry-merge"
+	Release             ReleaseInfo         // "release"
+	RemovedFiles        []string            // "orphans", full paths of files removed from WDE folder
+	RetainedOrphans     []DeployedStateFile // "orphans", orphaned files left in WDE folder
+
+	cleanups []func()
+}
+
+// Register function executed when pipeline finished, regardless of result.
+// Cleanups executed in reverse order.
+func (rs *RunState) Defer(cleanup func()) {
+	rs.cleanups = append(rs.cleanups, cleanup)
+}
+
+// Named step of update run.
+// Inputs and Outputs declare RunState fields used and filled by phase, they checked by ValidatePipeline.
+type Phase struct {
+	Name     string
+	Inputs   []string
+	Outputs  []string
+	Optional bool // Error of optional phase logged as warning and run continues.
+	Run      func(state *RunState) error
+}
+
+// Check that every phase input is provided by initial state or by one of previous phases.
+func ValidatePipeline(phases []Phase, initial []string) error {
+	available := make(map[string]bool, len(initial))
+	for _, name := range initial {
+		available[name] = true
+	}
+	for _, phase := range phases {
+		for _, input := range phase.Inputs {
+			if !available[input] {
+				return fmt.Errorf("phase '%v' input '%v' not provided by previous phases", phase.Name, input)
+			}
+		}
+		for _, output := range phase.Outputs {
+			available[output] = true
+		}
+	}
+	return nil
+}
+
+// Execute phases one by one. Stop on first error of not optional phase, remediation hint logged for denied access.
+// Start of each phase published into progress stream as event without item.
+// Registered cleanups executed before return.
+func RunPipeline(phases []Phase, state *RunState) error {
+	defer func() {
+		for i := len(state.cleanups) - 1; i >= 0; i-- {
+			state.cleanups[i]()
+		}
+	}()
+	for _, phase := range phases {
+		state.Summary.StartPhase(phase.Name)
+		state.Progress.Publish(ProgressEvent{Time: time.Now(), Phase: phase.Name, Total: -1})
+		state.Logger.Debug(fmt.Sprintf("Phase '%v' started", phase.Name))
+		err := phase.Run(state)
+		if err != nil {
+			if phase.Optional {
+				state.Logger.Warn(fmt.Sprintf("Phase '%v' failed - %v", phase.Name, err))
+				continue
+			}
+			state.Logger.Error(fmt.Sprintf("Phase '%v' failed - %v", phase.Name, err))
+			if hint := AccessDeniedHint(phase.Name, err, state.Config); hint != "" {
+				state.Logger.Error(fmt.Sprint("Access denied, how to fix - ", hint))
+				state.HistoryEvents.Add("Access denied in phase '%v', how to fix: %v", phase.Name, hint)
+			}
+			return err
+		}
+		state.Logger.Debug(fmt.Sprintf("Phase '%v' finished", phase.Name))
+	}
+	return nil
+}
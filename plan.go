@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const DefaultPlanName string = "wde-plan.json" // Default output file of "plan" command.
+
+// Reviewable plan of update written by "plan" command and executed by "apply".
+// Manifest embedded, so plan file also accepted by --manifest.
+type Plan struct {
+	Manifest
+	SourceHash     string              `json:"sourceHash"`     // Hash of files to deploy, apply refused if sources changed.
+	DeployedHash   string              `json:"deployedHash"`   // Hash of deployed state, apply refused if another run changed WDE folder.
+	Copy           []PlanFile          `json:"copy"`           // Files copied into WDE folder, unchanged ones skipped.
+	Delete         []string            `json:"delete"`         // Orphaned files removed from WDE folder.
+	KeptOrphans    []string            `json:"keptOrphans"`    // Orphaned files left in WDE folder by State.RemoveOrphans policy.
+	RegistryAdd    []string            `json:"registryAdd"`    // Entries added into "CustomFiles" registry value.
+	RegistryRemove []string            `json:"registryRemove"` // Entries absent in "CustomFiles" value after run.
+	RegistryHash   string              `json:"registryHash"`   // Hash of live DM registry, apply refused if registry changed.
+	RegistryValues []PlanRegistryValue `json:"registryValues"` // All values written into DM registry.
+	RegistryUsers  []string            `json:"registryUsers"`  // SIDs of other users whose DM registry also written.
+}
+
+// Registry value written by plan.
+type PlanRegistryValue struct {
+	Name   string `json:"name"`
+	Type   string `json:"type,omitempty"`
+	Data   string `json:"data"`
+	Action string `json:"action"` // "add", "update" or "unchanged".
+}
+
+// File copied by plan.
+type PlanFile struct {
+	Path   string `json:"path"` // Path relative to WDE folder.
+	Action string `json:"action"`
+	Hash   string `json:"hash"`
+	Size   int64  `json:"size"`
+}
+
+// Actions of plan files and registry values.
+const (
+	PlanActionAdd       string = "add"       // File not deployed yet.
+	PlanActionUpdate    string = "update"    // Deployed file content differs.
+	PlanActionUnchanged string = "unchanged" // Registry value written with same data.
+)
+
+// Compare scanned sources with deployed state and live "CustomFiles" value.
+func NewPlan(mainConfig MainCfgYAML, manifest Manifest, deployed DeployedState, liveEntries []CustomisationFile) (Plan, error) {
+	_, _, finalFiles, err := manifest.CustomisationFiles()
+	if err != nil {
+		return Plan{}, err
+	}
+	drift := FindDrift(manifest, deployed)
+	plan := Plan{
+		Manifest:       manifest,
+		SourceHash:     drift.SourceHash,
+		DeployedHash:   drift.DeployedHash,
+		Copy:           make([]PlanFile, 0, len(drift.Added)+len(drift.Changed)),
+		Delete:         make([]string, 0),
+		KeptOrphans:    make([]string, 0),
+		RegistryAdd:    make([]string, 0),
+		RegistryRemove: make([]string, 0),
+	}
+	actions := make(map[string]string, len(drift.Added)+len(drift.Changed))
+	for _, path := range drift.Added {
+		actions[path] = PlanActionAdd
+	}
+	for _, path := range drift.Changed {
+		actions[path] = PlanActionUpdate
+	}
+	planned := make(map[string]bool, len(finalFiles))
+	for _, file := range finalFiles {
+		path := filepath.Join(file.RelativePath, file.FileName)
+		planned[strings.ToLower(path)] = true
+		if action, ok := actions[strings.ToLower(path)]; ok {
+			plan.Copy = append(plan.Copy, PlanFile{Path: path, Action: action, Hash: file.Hash, Size: file.Size})
+		}
+	}
+
+	for _, orphan := range deployed.FindOrphanedFiles(manifest.Folders, finalFiles) {
+		path := filepath.Join(orphan.RelativePath, orphan.FileName)
+		if strings.ToLower(mainConfig.State.RemoveOrphans) == OrphansRemove {
+			plan.Delete = append(plan.Delete, path)
+		} else {
+			plan.KeptOrphans = append(plan.KeptOrphans, path)
+		}
+	}
+
+	live := make(map[string]bool, len(liveEntries))
+	for _, entry := range liveEntries {
+		path := filepath.Join(entry.RelativePath, entry.FileName)
+		live[strings.ToLower(path)] = true
+		if !planned[strings.ToLower(path)] {
+			plan.RegistryRemove = append(plan.RegistryRemove, path)
+		}
+	}
+	for _, file := range finalFiles {
+		path := filepath.Join(file.RelativePath, file.FileName)
+		if !live[strings.ToLower(path)] {
+			plan.RegistryAdd = append(plan.RegistryAdd, path)
+		}
+	}
+	return plan, nil
+}
+
+// Save plan as JSON into provided file.
+func (p Plan) Save(planFullPath string) error {
+	planBytes, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return SaveBytesIntoFile(planFullPath, planBytes)
+}
+
+// Read plan from JSON file.
+func ReadPlan(planFullPath string) (Plan, error) {
+	planBytes, err := ioutil.ReadFile(planFullPath)
+	if err != nil {
+		return Plan{}, err
+	}
+	var plan Plan
+	err = json.Unmarshal(planBytes, &plan)
+	if err != nil {
+		return Plan{}, fmt.Errorf("can't parse plan '%v' - %v", planFullPath, err)
+	}
+	if plan.SourceHash == "" {
+		return Plan{}, fmt.Errorf("'%v' is not a plan file", planFullPath)
+	}
+	return plan, nil
+}
+
+// Scan sources and compare them with deployed state and live registry.
+func CurrentPlan(mainConfig MainCfgYAML, programDirectory string, logger *zap.Logger) (Plan, error) {
+	if len(mainConfig.Targets) > 0 {
+		return Plan{}, fmt.Errorf("plan not supported with Targets, configure single WDE installation")
+	}
+	manifest, err := ScanSources(mainConfig, nil, logger)
+	if err != nil {
+		return Plan{}, err
+	}
+	deployed, err := ReadDeployedState(filepath.Join(StateFolderPath(mainConfig, programDirectory), StateFileName))
+	if err != nil {
+		return Plan{}, fmt.Errorf("can't read deployed state - %v", err)
+	}
+	store := DefaultRegistryStore()
+	liveEntries, err := readLiveCustomFiles(store)
+	if err != nil && err != ErrCustomFilesNotFound {
+		return Plan{}, err
+	}
+	plan, err := NewPlan(mainConfig, manifest, deployed, liveEntries)
+	if err != nil {
+		return Plan{}, err
+	}
+	_, _, finalFiles, err := manifest.CustomisationFiles()
+	if err != nil {
+		return Plan{}, err
+	}
+	err = planRegistry(&plan, mainConfig, programDirectory, finalFiles, store, logger)
+	if err != nil {
+		return Plan{}, err
+	}
+	return plan, nil
+}
+
+// Fill registry values which run would write: saved data merged with files to deploy by registry phases
+// in dry run mode, so nothing saved. Values not owned by updater and changed or deleted by hand not written.
+func planRegistry(plan *Plan, mainConfig MainCfgYAML, programDirectory string, finalFiles []CustomisationFile, store RegistryStore, logger *zap.Logger) error {
+	events := make(HistoryEvents, 0, 8)
+	state := &RunState{
+		Config:           mainConfig,
+		ProgramDirectory: programDirectory,
+		StartTime:        TimestampNow(),
+		StartTimeString:  FileTimestamp(TimestampNow()),
+		DryRun:           true,
+		RegistryStore:    store,
+		HistoryEvents:    &events,
+		Logger:           logger,
+		FinalFiles:       finalFiles,
+	}
+	err := PhaseRegistryPrepare(state)
+	if err != nil {
+		return err
+	}
+	err = PhaseRegistryMerge(state)
+	if err != nil {
+		return err
+	}
+	liveData, err := store.Read(DMRegistryDir)
+	if err != nil && err != ErrRegistryKeyNotExist {
+		return fmt.Errorf("can't read registry values - %v", err)
+	}
+	ownership, err := ReadRegistryOwnership(filepath.Join(StateFolderPath(mainConfig, programDirectory), OwnershipFileName), ConfiguredManagedValues(mainConfig))
+	if err != nil {
+		return fmt.Errorf("can't read registry ownership - %v", err)
+	}
+	writable, _, _ := ownership.SelectWritable(state.RegistryData, liveData)
+	diff := DiffRegistryValues(liveData, writable)
+	actions := make(map[string]string, len(diff.Added)+len(diff.Changed))
+	for _, name := range diff.Added {
+		actions[name] = PlanActionAdd
+	}
+	for _, name := range diff.Changed {
+		actions[name] = PlanActionUpdate
+	}
+	plan.RegistryHash = registryValuesHash(liveData)
+	plan.RegistryValues = make([]PlanRegistryValue, 0, len(writable))
+	for _, value := range writable {
+		action, ok := actions[value.Name]
+		if !ok {
+			action = PlanActionUnchanged
+		}
+		plan.RegistryValues = append(plan.RegistryValues, PlanRegistryValue{Name: value.Name, Type: value.Type, Data: value.Data, Action: action})
+	}
+	sids, err := UserRegistrySIDs(mainConfig)
+	if err != nil {
+		return err
+	}
+	plan.RegistryUsers = append(make([]string, 0, len(sids)), sids...)
+	sort.Strings(plan.RegistryUsers)
+	return nil
+}
+
+// Return hash of registry values by name, type and data.
+func registryValuesHash(values []RegistryValue) string {
+	hashes := make(map[string]string, len(values))
+	for _, value := range values {
+		hashes[value.Name] = fmt.Sprint(value.Type, " ", HashRegistryData(value.Data))
+	}
+	return fileSetHash(hashes)
+}
+
+// Return first difference of current plan from saved one, empty if plan can be applied exactly.
+func (p Plan) Mismatch(current Plan) string {
+	written := func(values []PlanRegistryValue) string {
+		lines := make([]string, 0, len(values))
+		for _, value := range values {
+			lines = append(lines, fmt.Sprint(value.Name, " ", value.Type, " ", HashRegistryData(value.Data)))
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, "\n")
+	}
+	sorted := func(paths []string) string {
+		paths = append([]string{}, paths...)
+		sort.Strings(paths)
+		return strings.ToLower(strings.Join(paths, "\n"))
+	}
+	switch {
+	case current.SourceHash != p.SourceHash:
+		return "sources changed since planning"
+	case current.DeployedHash != p.DeployedHash:
+		return "WDE folder updated since planning"
+	case sorted(current.Delete) != sorted(p.Delete) || sorted(current.KeptOrphans) != sorted(p.KeptOrphans):
+		return "orphaned files changed since planning"
+	case current.RegistryHash != p.RegistryHash:
+		return "DM registry changed since planning"
+	case written(current.RegistryValues) != written(p.RegistryValues):
+		return "registry values to write changed since planning"
+	case sorted(current.RegistryUsers) != sorted(p.RegistryUsers):
+		return "users with DM registry changed since planning"
+	}
+	return ""
+}
+
+// Run "plan" subcommand. Write plan of update without changing anything.
+func RunPlanCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	flags := flag.NewFlagSet("plan", flag.ContinueOnError)
+	outPath := flags.String("out", filepath.Join(WorkspaceFolderPath(mainConfig, programDirectory), DefaultPlanName), "plan file")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	mainConfig, err = ResolveConfigSecrets(mainConfig)
+	if err != nil {
+		return err
+	}
+	plan, err := CurrentPlan(mainConfig, programDirectory, zap.NewNop())
+	if err != nil {
+		return err
+	}
+	err = plan.Save(*outPath)
+	if err != nil {
+		return err
+	}
+	for _, file := range plan.Copy {
+		fmt.Printf("  %-7v %v\n", file.Action, file.Path)
+	}
+	for _, path := range plan.Delete {
+		fmt.Printf("  %-7v %v\n", "delete", path)
+	}
+	changedValues := 0
+	for _, value := range plan.RegistryValues {
+		if value.Action != PlanActionUnchanged {
+			fmt.Printf("  %-7v registry value %v\n", value.Action, value.Name)
+			changedValues++
+		}
+	}
+	fmt.Printf("Plan: %d to copy, %d to delete, %d orphans kept, CustomFiles +%d -%d entries, %d of %d registry values changed, %d other users\n",
+		len(plan.Copy), len(plan.Delete), len(plan.KeptOrphans), len(plan.RegistryAdd), len(plan.RegistryRemove), changedValues, len(plan.RegistryValues), len(plan.RegistryUsers))
+	for _, violation := range plan.Violations {
+		fmt.Println("Size limit exceeded -", violation)
+	}
+	log.Printf("Plan saved into '%v', execute it by \"apply -plan\"", *outPath)
+	return nil
+}
+
+// Run "apply" subcommand. Execute plan if sources, deployed state, orphans and registry not changed since planning,
+// so run does exactly what plan lists.
+func RunApplyCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	planPath := flags.String("plan", "", "plan file written by \"plan\" command")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *planPath == "" {
+		return fmt.Errorf("plan file not provided")
+	}
+	plan, err := ReadPlan(*planPath)
+	if err != nil {
+		return err
+	}
+	resolvedConfig, err := ResolveConfigSecrets(mainConfig)
+	if err != nil {
+		return err
+	}
+	current, err := CurrentPlan(resolvedConfig, programDirectory, zap.NewNop())
+	if err != nil {
+		return err
+	}
+	if plan.RegistryHash == "" {
+		return fmt.Errorf("plan has no registry values, created by older version, create new plan")
+	}
+	if mismatch := plan.Mismatch(current); mismatch != "" {
+		return fmt.Errorf("%v, create new plan", mismatch)
+	}
+
+	// Files pinned by plan with hash check, orphans handled as planned without questions.
+	mainConfig.Manifest = *planPath
+	mainConfig.State.RemoveOrphans = OrphansKeep
+	if len(plan.Delete) > 0 {
+		mainConfig.State.RemoveOrphans = OrphansRemove
+	}
+	summary := RunUpdate(mainConfig, programDirectory, DefaultRegistryStore(), nil)
+	PrintPlainResult(summary)
+	if summary.Result != RunResultSuccess {
+		return fmt.Errorf("plan applied with result '%v' - %v", summary.Result, summary.Error)
+	}
+	return nil
+}
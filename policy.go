@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// File-type policy by extensions. Blocked files never deployed and reported as "[BLOCKED  ]".
+// Files which would overwrite protected WDE files reported as "[PROTECTED]".
+type FilePolicy struct {
+	deny      map[string]bool
+	allow     map[string]bool // If not empty only these extensions allowed.
+	protected []string        // Lower case slash separated patterns relative to WDE folder.
+}
+
+// Create policy from extension lists and protected files of config. Extensions case insensitive, leading dot optional.
+// WDE executable always protected.
+func NewFilePolicy(denyExtensions, allowExtensions, protectedFiles []string) FilePolicy {
+	protected := []string{strings.ToLower(WDEExecutableName)}
+	for _, pattern := range protectedFiles {
+		pattern = strings.ToLower(strings.TrimSpace(filepath.ToSlash(strings.ReplaceAll(pattern, `\`, "/"))))
+		if pattern != "" {
+			protected = append(protected, pattern)
+		}
+	}
+	return FilePolicy{
+		deny:      extensionSet(denyExtensions),
+		allow:     extensionSet(allowExtensions),
+		protected: protected,
+	}
+}
+
+func extensionSet(extensions []string) map[string]bool {
+	set := make(map[string]bool, len(extensions))
+	for _, extension := range extensions {
+		extension = strings.ToLower(strings.TrimSpace(extension))
+		if extension == "" {
+			continue
+		}
+		if !strings.HasPrefix(extension, ".") {
+			extension = fmt.Sprint(".", extension)
+		}
+		set[extension] = true
+	}
+	return set
+}
+
+// Check file against policy. Return reason if file blocked, empty string otherwise.
+func (fp FilePolicy) Check(file CustomisationFile) string {
+	extension := strings.ToLower(filepath.Ext(file.FileName))
+	if fp.deny[extension] {
+		return fmt.Sprintf("extension '%v' denied", extension)
+	}
+	if len(fp.allow) > 0 && !fp.allow[extension] {
+		return fmt.Sprintf("extension '%v' not allowed", extension)
+	}
+	return ""
+}
+
+// Check if file would overwrite protected WDE file.
+func (fp FilePolicy) Protected(file CustomisationFile) bool {
+	target := strings.ToLower(filepath.ToSlash(filepath.Join(file.RelativePath, file.FileName)))
+	target = strings.ReplaceAll(target, `\`, "/")
+	for _, pattern := range fp.protected {
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
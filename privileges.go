@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// Check if error caused by denied access, also when original error only formatted into text.
+func IsAccessDenied(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return true
+	}
+	text := strings.ToLower(err.Error())
+	return strings.Contains(text, "access is denied") || strings.Contains(text, "permission denied")
+}
+
+// Return remediation hint for access denied error of phase, empty if error has other cause.
+// Registry phases diagnosed for DM registry key, other phases for WDE folder.
+func AccessDeniedHint(phaseName string, err error, mainConfig MainCfgYAML) string {
+	if !IsAccessDenied(err) {
+		return ""
+	}
+	if strings.HasPrefix(phaseName, "registry") || phaseName == "snapshot" {
+		return registryAccessHint()
+	}
+	return folderAccessHint(WDETargetFolder(mainConfig))
+}
+
+// Diagnose denied access to DM registry key of current user.
+func registryAccessHint() string {
+	if profile, temporary := temporaryProfile(); temporary {
+		return fmt.Sprintf("user profile '%v' is temporary, registry of user not loaded correctly: log off, check \"User Profile Service\" events and roaming profile share, then run again", profile)
+	}
+	return fmt.Sprintf("permissions of key 'HKEY_CURRENT_USER\\%v' deny write for '%v', probably changed by group policy: grant Full Control to the user in regedit (Permissions) or exclude key from the policy", DMRegistryDir, currentUserName())
+}
+
+// Diagnose denied access to folder: missing admin rights, roaming profile or folder ACL.
+func folderAccessHint(folder string) string {
+	if profile, temporary := temporaryProfile(); temporary && isInsideFolder(folder, profile) {
+		return fmt.Sprintf("user profile '%v' is temporary: log off, check \"User Profile Service\" events and roaming profile share, then run again", profile)
+	}
+	for _, variable := range []string{"APPDATA", "USERPROFILE"} {
+		profileFolder := os.Getenv(variable)
+		if strings.HasPrefix(profileFolder, `\\`) && isInsideFolder(folder, profileFolder) {
+			return fmt.Sprintf("folder '%v' is inside roaming or redirected profile on network share '%v': check share permissions and offline files state, or set WDEInstallationFolder to local folder", folder, profileFolder)
+		}
+	}
+	if strings.HasPrefix(folder, `\\`) {
+		return fmt.Sprintf("folder '%v' is on network share: grant Modify to '%v' on share and folder", folder, currentUserName())
+	}
+	if !IsProcessElevated() && isProtectedFolder(folder) {
+		return fmt.Sprintf("folder '%v' can be changed only by administrators: run elevated (\"Run as administrator\") or from scheduled task as SYSTEM", folder)
+	}
+	return fmt.Sprintf("permissions of folder '%v' deny write for '%v': grant Modify to user or its group, e.g. icacls \"%v\" /grant \"%v:(OI)(CI)M\"", folder, currentUserName(), folder, currentUserName())
+}
+
+// Check write access to existing folder by creating and removing temporary file.
+// Missing folder not checked, it created later.
+func CheckFolderWriteAccess(folder string) error {
+	if _, err := os.Stat(folder); os.IsNotExist(err) {
+		return nil
+	}
+	probe, err := ioutil.TempFile(folder, ".wdeUpdaterAccessCheck_")
+	if err != nil {
+		return fmt.Errorf("no write access to '%v' - %v", folder, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// Return profile folder and true if user logged on with temporary profile.
+func temporaryProfile() (string, bool) {
+	profile := os.Getenv("USERPROFILE")
+	name := strings.ToUpper(filepath.Base(profile))
+	return profile, name == "TEMP" || strings.HasPrefix(name, "TEMP.")
+}
+
+// Check if folder is system location writable only by administrators.
+func isProtectedFolder(folder string) bool {
+	for _, variable := range []string{"ProgramFiles", "ProgramFiles(x86)", "ProgramW6432", "SystemRoot"} {
+		if location := os.Getenv(variable); location != "" && isInsideFolder(folder, location) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if path is inside folder or equal, case insensitive.
+func isInsideFolder(path, folder string) bool {
+	relative, err := filepath.Rel(strings.ToLower(folder), strings.ToLower(path))
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, fmt.Sprint("..", string(filepath.Separator)))
+}
+
+// Return name of user running program.
+func currentUserName() string {
+	current, err := user.Current()
+	if err != nil {
+		return os.Getenv("USERNAME")
+	}
+	return current.Username
+}
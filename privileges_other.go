@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+)
+
+// Check if process runs as root.
+func IsProcessElevated() bool {
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// Check if process runs with elevated administrator token.
+func IsProcessElevated() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import (
+	"syscall"
+)
+
+// Windows are not hidden outside Windows.
+func HiddenWindowProcAttr() *syscall.SysProcAttr {
+	return nil
+}
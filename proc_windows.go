@@ -0,0 +1,10 @@
+package main
+
+import (
+	"syscall"
+)
+
+// Return process attributes for start process with hidden window.
+func HiddenWindowProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{HideWindow: true}
+}
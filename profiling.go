@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+)
+
+// Serve pprof HTTP endpoint on address in background. Started once per process,
+// so watch mode iterations never fail to listen on address already in use.
+func ServeProfilingEndpoint(httpAddress string) {
+	go func() {
+		log.Printf("pprof endpoint listen on 'http://%v/debug/pprof/'", httpAddress)
+		err := http.ListenAndServe(httpAddress, nil)
+		if err != nil {
+			log.Println("pprof endpoint stopped -", err)
+		}
+	}()
+}
+
+// Start CPU profile of whole process written into folder. Started once per process,
+// because CPU profile is process-wide and concurrent targets can't start their own.
+// Returned function stop CPU profile and write heap profile. Profiling errors only logged.
+func StartProfiling(folder, startTimeString string) func() {
+	err := os.MkdirAll(folder, 0755)
+	if err != nil {
+		log.Println("Can't create profiles folder -", err)
+		return func() {}
+	}
+	cpuProfilePath := filepath.Join(folder, fmt.Sprint("cpu_", startTimeString, ".prof"))
+	cpuProfile, err := os.Create(cpuProfilePath)
+	if err != nil {
+		log.Println("Can't create CPU profile -", err)
+		return func() {}
+	}
+	err = pprof.StartCPUProfile(cpuProfile)
+	if err != nil {
+		log.Println("Can't start CPU profile -", err)
+		cpuProfile.Close()
+		return func() {}
+	}
+	log.Printf("CPU profile written into '%v'", cpuProfilePath)
+
+	return func() {
+		pprof.StopCPUProfile()
+		cpuProfile.Close()
+		heapProfilePath := filepath.Join(folder, fmt.Sprint("heap_", startTimeString, ".prof"))
+		heapProfile, err := os.Create(heapProfilePath)
+		if err != nil {
+			log.Println("Can't create heap profile -", err)
+			return
+		}
+		defer heapProfile.Close()
+		err = pprof.WriteHeapProfile(heapProfile)
+		if err != nil {
+			log.Println("Can't write heap profile -", err)
+			return
+		}
+		log.Printf("Heap profile written into '%v'", heapProfilePath)
+	}
+}
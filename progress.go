@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const ProgressLogStepPercent int64 = 10 // Progress logged each N percents.
+
+// Progress of long operation on single item, e.g. copy of large file.
+type ProgressEvent struct {
+	Time  time.Time `json:"time"`
+	Phase string    `json:"phase"`
+	Item  string    `json:"item"` // Empty for phase start event.
+	Done  int64     `json:"done"`
+	Total int64     `json:"total"` // -1 if total unknown.
+}
+
+// Deliver progress events to all subscribers. Methods of nil stream do nothing.
+type ProgressStream struct {
+	mutex       sync.Mutex
+	subscribers []func(ProgressEvent)
+}
+
+// Create progress stream without subscribers.
+func NewProgressStream() *ProgressStream {
+	return &ProgressStream{}
+}
+
+// Add function called for every published event.
+func (ps *ProgressStream) Subscribe(subscriber func(ProgressEvent)) {
+	if ps == nil {
+		return
+	}
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.subscribers = append(ps.subscribers, subscriber)
+}
+
+// Send event to all subscribers.
+func (ps *ProgressStream) Publish(event ProgressEvent) {
+	if ps == nil {
+		return
+	}
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	for _, subscriber := range ps.subscribers {
+		subscriber(event)
+	}
+}
+
+// Return copy progress callback which publish events for item.
+func (ps *ProgressStream) CopyProgress(phase, item string) CopyProgress {
+	if ps == nil {
+		return nil
+	}
+	return func(copied, total int64) {
+		ps.Publish(ProgressEvent{Time: time.Now(), Phase: phase, Item: item, Done: copied, Total: total})
+	}
+}
+
+// Return subscriber which log progress each stepPercent percents.
+// Items finished by single event (small files) not logged.
+func LogProgress(logger *zap.Logger, stepPercent int64) func(ProgressEvent) {
+	lastLogged := make(map[string]int64)
+	return func(event ProgressEvent) {
+		if event.Total <= 0 {
+			return
+		}
+		percent := event.Done * 100 / event.Total
+		last, started := lastLogged[event.Item]
+		if percent >= 100 {
+			delete(lastLogged, event.Item)
+			if !started {
+				return
+			}
+		} else {
+			if started && percent < last+stepPercent {
+				return
+			}
+			lastLogged[event.Item] = percent - percent%stepPercent
+		}
+		logger.Info(fmt.Sprintf("(%v) '%v' %v%% (%v of %v bytes)", event.Phase, event.Item, percent, event.Done, event.Total))
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Report unattended mode. In unattended mode nothing asked or waited from user.
+func Unattended() bool {
+	return *unattendedFlag
+}
+
+// Return error if user interaction required for action, but not allowed.
+func CheckUnattended(mainConfig MainCfgYAML, simulate bool) error {
+	if !Unattended() || simulate {
+		return nil
+	}
+	if len(mainConfig.DM.Command) == 0 && len(mainConfig.DM.Automation) == 0 {
+		return fmt.Errorf("%v - Deployment Manager wizard requires user, configure DM.Automation or DM.Command", ErrInteractionNotAllowed)
+	}
+	return nil
+}
+
+// Ask question in console and wait for answer. Only "y" and "yes" treated as agreement.
+// In unattended mode nothing asked and answer is "no".
+func AskYesNo(question string) bool {
+	if Unattended() {
+		return false
+	}
+	fmt.Printf("%v [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
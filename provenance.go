@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ProvenanceFileName    string = "Provenance.json" // Name of provenance database file in state folder.
+	ProvenanceKeepRecords int    = 10                // Records kept per file, older ones dropped.
+)
+
+// Per-machine record of where each file in WDE folder came from.
+type ProvenanceDB struct {
+	Files map[string][]ProvenanceRecord `json:"files"` // Key is lower case relative path in WDE folder, latest record first.
+}
+
+// Deployment or removal of file by one run.
+type ProvenanceRecord struct {
+	RelativePath        string    `json:"relativePath"` // Path in WDE folder.
+	SourcePath          string    `json:"sourcePath,omitempty"`
+	CustomisationFolder string    `json:"customisationFolder,omitempty"`
+	ReleaseVersion      string    `json:"releaseVersion,omitempty"`
+	Hash                string    `json:"hash,omitempty"` // SHA-256 of deployed file.
+	FileVersion         string    `json:"fileVersion,omitempty"`
+	RunID               string    `json:"runId"` // Start time string of run, as in log and history file names.
+	RunLabels                     // Tag and change ticket of run.
+	Time                time.Time `json:"time"`
+	Removed             bool      `json:"removed,omitempty"`    // File removed from WDE folder as orphan, by uninstall or rollback.
+	RolledBack          string    `json:"rolledBack,omitempty"` // ID of backup set restored by rollback.
+}
+
+// Get provenance database file path from config.
+func ProvenanceFilePath(mainConfig MainCfgYAML, programDirectory string) string {
+	return filepath.Join(StateFolderPath(mainConfig, programDirectory), ProvenanceFileName)
+}
+
+// Read provenance database. Return empty database if file not exists.
+// Content which can't be parsed returned as ErrProvenanceDamaged.
+func ReadProvenance(provenanceFullPath string) (ProvenanceDB, error) {
+	db := ProvenanceDB{Files: make(map[string][]ProvenanceRecord)}
+	dbBytes, err := ioutil.ReadFile(provenanceFullPath)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return db, err
+	}
+	err = json.Unmarshal(dbBytes, &db)
+	if db.Files == nil {
+		db.Files = make(map[string][]ProvenanceRecord)
+	}
+	if err != nil {
+		return db, fmt.Errorf("%w - %v", ErrProvenanceDamaged, err)
+	}
+	return db, nil
+}
+
+// Save provenance database as JSON.
+func (pdb ProvenanceDB) Save(provenanceFullPath string) error {
+	dbBytes, err := json.MarshalIndent(pdb, "", "  ")
+	if err != nil {
+		return err
+	}
+	return SaveBytesIntoFile(provenanceFullPath, dbBytes)
+}
+
+// Add record as latest for its file. Record equal by content to latest not removed one not added,
+// so file left unchanged by run keeps run which deployed it. Return true if added.
+func (pdb ProvenanceDB) Add(record ProvenanceRecord) bool {
+	key := strings.ToLower(record.RelativePath)
+	records := pdb.Files[key]
+	if len(records) > 0 && records[0].Removed == record.Removed && records[0].Hash == record.Hash {
+		return false
+	}
+	records = append([]ProvenanceRecord{record}, records...)
+	if len(records) > ProvenanceKeepRecords {
+		records = records[:ProvenanceKeepRecords]
+	}
+	pdb.Files[key] = records
+	return true
+}
+
+// Record deployed files and files removed from WDE folder by run. Return number of added records.
+func (pdb ProvenanceDB) Update(deployed []CustomisationFile, removed []string, targetDirectory, runID string, release ReleaseInfo, labels RunLabels, runTime time.Time) int {
+	added := 0
+	for _, file := range deployed {
+		record := ProvenanceRecord{
+			RelativePath:        filepath.Join(file.RelativePath, file.FileName),
+			SourcePath:          file.SourcePath,
+			CustomisationFolder: file.CustomisationFolder,
+			ReleaseVersion:      release.Version,
+			Hash:                file.Hash,
+			FileVersion:         file.Version.String(),
+			RunID:               runID,
+			RunLabels:           labels,
+			Time:                runTime,
+		}
+		if pdb.Add(record) {
+			added++
+		}
+	}
+	for _, fullPath := range removed {
+		relativePath, err := filepath.Rel(targetDirectory, fullPath)
+		if err != nil {
+			continue
+		}
+		if pdb.Add(ProvenanceRecord{RelativePath: relativePath, RunID: runID, RunLabels: labels, Time: runTime, Removed: true}) {
+			added++
+		}
+	}
+	return added
+}
+
+// Record files restored and removed by rollback to backup set. Return number of added records.
+func (pdb ProvenanceDB) RecordRollback(set BackupSet, restored, removed []BackupSetFile, runID string, labels RunLabels, runTime time.Time) int {
+	added := 0
+	for _, file := range restored {
+		if pdb.Add(ProvenanceRecord{RelativePath: file.RelativePath, Hash: file.Hash, RunID: runID, RunLabels: labels, Time: runTime, RolledBack: set.ID}) {
+			added++
+		}
+	}
+	for _, file := range removed {
+		if pdb.Add(ProvenanceRecord{RelativePath: file.RelativePath, RunID: runID, RunLabels: labels, Time: runTime, Removed: true, RolledBack: set.ID}) {
+			added++
+		}
+	}
+	return added
+}
+
+// Find records of file by path in WDE folder, full or relative. If no such path recorded,
+// files with same name in any folder returned. Keys of found files returned sorted.
+func (pdb ProvenanceDB) Find(path, targetDirectory string) []string {
+	if filepath.IsAbs(path) {
+		if relativePath, err := filepath.Rel(targetDirectory, path); err == nil && !strings.HasPrefix(relativePath, "..") {
+			path = relativePath
+		}
+	}
+	key := strings.ToLower(filepath.Clean(path))
+	if _, ok := pdb.Files[key]; ok {
+		return []string{key}
+	}
+	found := make([]string, 0, 2)
+	name := strings.ToLower(filepath.Base(path))
+	for fileKey := range pdb.Files {
+		if filepath.Base(fileKey) == name {
+			found = append(found, fileKey)
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// Run "whence" subcommand. Print where file in WDE folder came from and earlier deployments of it.
+// Usage: whence <file>
+func RunWhenceCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: whence <file in WDE folder>")
+	}
+	db, err := ReadProvenance(ProvenanceFilePath(mainConfig, programDirectory))
+	if err != nil {
+		return fmt.Errorf("can't read provenance database - %v", err)
+	}
+	targetDirectory := WDETargetFolder(mainConfig)
+	keys := db.Find(args[0], targetDirectory)
+	if len(keys) == 0 {
+		return fmt.Errorf("no provenance recorded for '%v', file not deployed by updater", args[0])
+	}
+	for _, key := range keys {
+		records := db.Files[key]
+		latest := records[0]
+		fmt.Println(latest.RelativePath)
+		currentFile := filepath.Join(targetDirectory, latest.RelativePath)
+		hash, err := HashFile(currentFile)
+		switch {
+		case os.IsNotExist(err):
+			fmt.Println("  WDE folder: file absent")
+		case err != nil:
+			fmt.Println("  WDE folder: can't read file -", err)
+		case latest.Removed:
+			fmt.Println("  WDE folder: file present, but removed by updater, placed by hand")
+		case hash == latest.Hash:
+			fmt.Println("  WDE folder: content matches last deployment")
+		default:
+			fmt.Println("  WDE folder: content CHANGED since last deployment, hash", hash)
+		}
+		for _, record := range records {
+			if record.RolledBack != "" {
+				action := "restored"
+				if record.Removed {
+					action = "removed"
+				}
+				fmt.Printf("  %v %v by rollback %v to backup set %v%v\n", FileTimestamp(record.Time), action, record.RunID, record.RolledBack, formatProvenanceLabels(record.RunLabels))
+				if !record.Removed {
+					fmt.Printf("    hash %v\n", record.Hash)
+				}
+				continue
+			}
+			if record.Removed {
+				fmt.Printf("  %v removed by run %v%v\n", FileTimestamp(record.Time), record.RunID, formatProvenanceLabels(record.RunLabels))
+				continue
+			}
+			fmt.Printf("  %v deployed by run %v%v\n", FileTimestamp(record.Time), record.RunID, formatProvenanceLabels(record.RunLabels))
+			fmt.Printf("    from '%v'\n", record.SourcePath)
+			fmt.Printf("    customisation folder '%v', release '%v', file version '%v'\n", record.CustomisationFolder, record.ReleaseVersion, record.FileVersion)
+			fmt.Printf("    hash %v\n", record.Hash)
+		}
+	}
+	return nil
+}
+
+// Format run tag and ticket for whence output.
+func formatProvenanceLabels(labels RunLabels) string {
+	text := ""
+	if labels.Tag != "" {
+		text = fmt.Sprint(text, ", tag ", labels.Tag)
+	}
+	if labels.Ticket != "" {
+		text = fmt.Sprint(text, ", ticket ", labels.Ticket)
+	}
+	return text
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Replacements of redacted values.
+const (
+	RedactedUser = "<user>"
+	RedactedHost = "<host>"
+	RedactedPath = "<path>"
+)
+
+// Mask user names, machine name and configured path fragments in text.
+// Matching is case insensitive and only whole words matched, so "adm" not masked inside "administrator".
+// Methods of nil redactor return text unchanged.
+type Redactor struct {
+	rules []redactRule
+}
+
+type redactRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+var (
+	activeRedactor      *Redactor
+	activeRedactorMutex sync.RWMutex
+)
+
+// Create redactor for current user, console user, this machine and provided path fragments.
+func NewRedactor(pathFragments []string) *Redactor {
+	redactor := &Redactor{}
+	redactor.add(pathFragments, RedactedPath)
+	userNames := []string{os.Getenv("USERNAME")}
+	if currentUser, err := user.Current(); err == nil {
+		userNames = append(userNames, currentUser.Username, currentUser.Name)
+	}
+	if consoleUser, err := GetConsoleUserName(); err == nil {
+		userNames = append(userNames, consoleUser)
+	}
+	// "DOMAIN\user" also redacted as "user" alone.
+	for _, name := range userNames {
+		if slash := strings.LastIndex(name, `\`); slash >= 0 {
+			userNames = append(userNames, name[slash+1:])
+		}
+	}
+	redactor.add(userNames, RedactedUser)
+	hostname, _ := os.Hostname()
+	redactor.add([]string{hostname}, RedactedHost)
+	return redactor
+}
+
+// Add rule for values. Values shorter than 2 characters ignored. Longer values replaced first.
+// JSON escaped form of values with backslashes also matched.
+func (r *Redactor) add(values []string, replacement string) {
+	alternatives := make([]string, 0, len(values)*2)
+	for _, value := range values {
+		if len(value) < 2 {
+			continue
+		}
+		alternatives = append(alternatives, regexp.QuoteMeta(value))
+		if strings.Contains(value, `\`) {
+			alternatives = append(alternatives, regexp.QuoteMeta(strings.ReplaceAll(value, `\`, `\\`)))
+		}
+	}
+	if len(alternatives) == 0 {
+		return
+	}
+	// Longest alternative first, so "DOMAIN\user" replaced before "user".
+	for i := 1; i < len(alternatives); i++ {
+		for j := i; j > 0 && len(alternatives[j]) > len(alternatives[j-1]); j-- {
+			alternatives[j], alternatives[j-1] = alternatives[j-1], alternatives[j]
+		}
+	}
+	r.rules = append(r.rules, redactRule{
+		pattern:     regexp.MustCompile("(?i)" + strings.Join(alternatives, "|")),
+		replacement: replacement,
+	})
+}
+
+// Return text with sensitive values masked.
+func (r *Redactor) Redact(text string) string {
+	if r == nil {
+		return text
+	}
+	for _, rule := range r.rules {
+		text = rule.replace(text)
+	}
+	return text
+}
+
+// Replace matches of rule not adjoined by letters or digits.
+func (rule redactRule) replace(text string) string {
+	matches := rule.pattern.FindAllStringIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+	var builder strings.Builder
+	builder.Grow(len(text))
+	last := 0
+	for _, match := range matches {
+		before, _ := utf8.DecodeLastRuneInString(text[:match[0]])
+		after, _ := utf8.DecodeRuneInString(text[match[1]:])
+		if isWordRune(before) || isWordRune(after) {
+			continue
+		}
+		builder.WriteString(text[last:match[0]])
+		builder.WriteString(rule.replacement)
+		last = match[1]
+	}
+	builder.WriteString(text[last:])
+	return builder.String()
+}
+
+// Check if rune is part of word. Runes of empty text are not.
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+}
+
+// Enable redaction of log, history and summary by config or disable it.
+func ConfigureRedaction(mainConfig MainCfgYAML) {
+	var redactor *Redactor
+	if mainConfig.Log.Redact.Enabled {
+		redactor = NewRedactor(mainConfig.Log.Redact.Paths)
+	}
+	activeRedactorMutex.Lock()
+	defer activeRedactorMutex.Unlock()
+	activeRedactor = redactor
+}
+
+// Check if redaction enabled by config.
+func RedactionEnabled() bool {
+	activeRedactorMutex.RLock()
+	defer activeRedactorMutex.RUnlock()
+	return activeRedactor != nil
+}
+
+// Return name of machine folder in central share: host name, or its stable pseudonym
+// "host-<hash>" if redaction enabled, so reports of machine still grouped together.
+func MirrorHostFolder() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	if !RedactionEnabled() {
+		return hostname, nil
+	}
+	hash := sha256.Sum256([]byte(strings.ToLower(hostname)))
+	return "host-" + hex.EncodeToString(hash[:6]), nil
+}
+
+// Mask sensitive values in text if redaction enabled.
+func Redact(text string) string {
+	activeRedactorMutex.RLock()
+	defer activeRedactorMutex.RUnlock()
+	return activeRedactor.Redact(text)
+}
+
+// Log writer which mask sensitive values before write.
+type redactingWriter struct {
+	zapcore.WriteSyncer
+}
+
+func (rw redactingWriter) Write(p []byte) (int, error) {
+	_, err := rw.WriteSyncer.Write([]byte(Redact(string(p))))
+	return len(p), err
+}
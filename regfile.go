@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	RegBackupFileName   string = "DM_Registry_backup_"                  // Name prefix for .reg backups of live registry key.
+	RegUserBackupPrefix string = "DM_Registry_user_backup_"             // Name prefix for .reg backups of other users keys, followed by SID.
+	RegBackupKeepFiles  int    = 15                                     // Number of .reg backups preserved.
+	regFileHeader       string = "Windows Registry Editor Version 5.00" // First line of .reg file.
+	regFileRootKey      string = "HKEY_CURRENT_USER"                    // Root of registry directories used by RegistryStore.
+	regFileHexLineWidth int    = 76                                     // Line width of hex values, as regedit export.
+)
+
+// Format registry directory values as .reg file importable by double-click.
+// File encoded in UTF-16 LE with BOM like regedit export. Values with line breaks written as hex(1),
+// REG_EXPAND_SZ values as hex(2).
+func FormatRegFile(registryDir string, values []RegistryValue) []byte {
+	return formatRegFileWithRoot(regFileRootKey, registryDir, values)
+}
+
+// Format registry directory values as .reg file with provided root key, e.g. "HKEY_USERS\<SID>".
+func formatRegFileWithRoot(rootKey, registryDir string, values []RegistryValue) []byte {
+	var text strings.Builder
+	text.WriteString(fmt.Sprint(regFileHeader, "\r\n\r\n"))
+	text.WriteString(fmt.Sprintf("[%v\\%v]\r\n", rootKey, registryDir))
+	for _, value := range values {
+		name := fmt.Sprintf("\"%v\"=", escapeRegString(value.Name))
+		switch {
+		case value.Type == RegistryTypeExpandString:
+			text.WriteString(formatRegHexString(name, "hex(2):", value.Data))
+		case strings.ContainsAny(value.Data, "\r\n"):
+			text.WriteString(formatRegHexString(name, "hex(1):", value.Data))
+		default:
+			text.WriteString(fmt.Sprintf("%v\"%v\"", name, escapeRegString(value.Data)))
+		}
+		text.WriteString("\r\n")
+	}
+	text.WriteString("\r\n")
+
+	var result bytes.Buffer
+	binary.Write(&result, binary.LittleEndian, utf16.Encode([]rune(fmt.Sprint("\ufeff", text.String()))))
+	return result.Bytes()
+}
+
+// Escape backslashes and quotes of .reg string.
+func escapeRegString(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text)
+}
+
+// Format string value as hex bytes of type ("hex(1):" for REG_SZ, "hex(2):" for REG_EXPAND_SZ):
+// UTF-16 LE with terminating zero, lines wrapped by "\".
+func formatRegHexString(name, hexType, data string) string {
+	var encoded bytes.Buffer
+	binary.Write(&encoded, binary.LittleEndian, append(utf16.Encode([]rune(data)), 0))
+	var text strings.Builder
+	line := fmt.Sprint(name, hexType)
+	for id, b := range encoded.Bytes() {
+		part := fmt.Sprintf("%02x", b)
+		if id < encoded.Len()-1 {
+			part = fmt.Sprint(part, ",")
+		}
+		if len(line)+len(part) > regFileHexLineWidth {
+			text.WriteString(fmt.Sprint(line, "\\\r\n"))
+			line = "  "
+		}
+		line = fmt.Sprint(line, part)
+	}
+	text.WriteString(line)
+	return text.String()
+}
+
+// Export live registry directory into timestamped .reg file in saved registry folder before it changed.
+// Return path of backup, empty if directory not exist yet.
+func BackupRegistryDir(store RegistryStore, registryDir, savedRegistryDir, timeString string) (string, error) {
+	values, err := store.Read(registryDir)
+	if err == ErrRegistryKeyNotExist {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	backupFullPath := filepath.Join(savedRegistryDir, fmt.Sprint(RegBackupFileName, timeString, ".reg"))
+	err = SaveBytesIntoFile(backupFullPath, FormatRegFile(registryDir, values))
+	if err != nil {
+		return "", err
+	}
+	return backupFullPath, ClearOldFiles(savedRegistryDir, RegBackupFileName, RegBackupKeepFiles)
+}
+
+// Save values of DM registry directory of other user into timestamped .reg file in saved registry folder
+// before they changed. Backups kept per user.
+func BackupUserRegistryValues(sid, registryDir string, values []RegistryValue, savedRegistryDir, timeString string) (string, error) {
+	prefix := fmt.Sprint(RegUserBackupPrefix, sid, "_")
+	backupFullPath := filepath.Join(savedRegistryDir, fmt.Sprint(prefix, timeString, ".reg"))
+	err := SaveBytesIntoFile(backupFullPath, formatRegFileWithRoot(fmt.Sprint("HKEY_USERS\\", sid), registryDir, values))
+	if err != nil {
+		return "", err
+	}
+	return backupFullPath, ClearOldFiles(savedRegistryDir, prefix, RegBackupKeepFiles)
+}
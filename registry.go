@@ -4,13 +4,13 @@ import (
 	"bytes"
 	"encoding/xml"
 	"fmt"
-	"golang.org/x/sys/windows/registry"
 	"gopkg.in/yaml.v2"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
+	"strings"
 )
 
 // Initialization of the constants for construction "CustomFiles" registry key
@@ -27,6 +27,15 @@ const (
 	RegFilesEndingXML           = "</ArrayOfApplicationFile>"
 )
 
+// Modes for CustomFiles.Mode option.
+const (
+	CustomFilesRebuild     string = "rebuild"     // List rebuilt from deployed files in collection order (default).
+	CustomFilesIncremental string = "incremental" // Existing entries keep position, only added and removed files changed.
+)
+
+// Registry value types other than REG_SZ preserved in RegistryValue.Type.
+const RegistryTypeExpandString string = "REG_EXPAND_SZ" // String with unexpanded environment variables like %ProgramFiles%.
+
 // Store slice of registry kes and implement methods to interact with Windows registry.
 type RegistryValues []RegistryValue
 
@@ -35,6 +44,7 @@ type RegistryValues []RegistryValue
 type RegistryValue struct {
 	Name string `yaml:"name"`
 	Data string `yaml:"data"`
+	Type string `yaml:"type,omitempty"` // Empty for REG_SZ or RegistryTypeExpandString, data kept unexpanded.
 }
 
 // Insert actual "CustomFiles" value into registry data slice.
@@ -53,46 +63,27 @@ func (rvs *RegistryValues) InsertActualCustomFilesValue(customFiles string) {
 
 // Change or insert key "AddCustomFile" with value "True"
 func (rvs *RegistryValues) InsertAddCustomFileTrueValue() {
+	rvs.InsertAddCustomFileValue("True")
+}
+
+// Change or insert key "AddCustomFile" with provided value, "True" or "False"
+func (rvs *RegistryValues) InsertAddCustomFileValue(data string) {
 	for id, value := range *rvs {
 		if value.Name == "AddCustomFile" {
-			(*rvs)[id].Data = "True"
+			(*rvs)[id].Data = data
 			return
 		}
 	}
 	*rvs = append(*rvs, RegistryValue{
 		Name: "AddCustomFile",
-		Data: "True",
+		Data: data,
 	})
 }
 
-// Compare new and old registry data in key "CustomFiles" by FileName and RelativePath.
+// Compare new and old entries of "CustomFiles" by FileName and RelativePath.
 // If both equal, copy DataFile, EntryPoint, IsMainConfigFile, Optional and GroupName fields
-// from old data to new data.
-func (rvs *RegistryValues) AddManuallyAddedOptions(finalFilesList []CustomisationFile) error {
-	// Get old data from XML
-	oldFilesList := make([]CustomisationFile, 0, 128)
-	findKey := false
-	CFKeyID := 0
-	for id, value := range *rvs {
-		if value.Name != "CustomFiles" {
-			continue
-		}
-		findKey = true
-		var err error
-		oldFilesList, err = ParseOldCustomFilesValue([]byte(value.Data))
-		if err != nil {
-			return err
-		}
-		CFKeyID = id
-		break
-	}
-
-	// TODO - maybe replace custom error with just append "CustomFiles" key/value
-	// Use default values if key "CustomFiles" not find in old data
-	if !findKey {
-		return ErrCustomFilesNotFound
-	}
-
+// from old data to new data. Old entries taken from CustomFilesEntries or merge.
+func (rvs *RegistryValues) AddManuallyAddedOptions(finalFilesList, oldFilesList []CustomisationFile) {
 	// Compare data
 	for id, newFile := range finalFilesList {
 		for _, oldFile := range oldFilesList {
@@ -108,53 +99,115 @@ func (rvs *RegistryValues) AddManuallyAddedOptions(finalFilesList []Customisatio
 	}
 
 	// Construct and save new XML value for "CustomFiles" key
-	(*rvs)[CFKeyID].Data = ConstructCustomFilesRegistryKey(finalFilesList)
-	return nil
+	rvs.InsertActualCustomFilesValue(ConstructCustomFilesRegistryKey(finalFilesList))
+}
+
+// Update "CustomFiles" entries in place by deployed files, compared by FileName and RelativePath.
+// Entries of deployed files keep their position and options, entries of files not deployed anymore removed,
+// new files appended in order of list. Return paths of added and removed files.
+func (rvs *RegistryValues) UpdateCustomFilesIncremental(finalFilesList, oldFilesList []CustomisationFile) ([]string, []string) {
+	deployed := make(map[string]bool, len(finalFilesList))
+	for _, file := range finalFilesList {
+		deployed[filepath.Join(file.RelativePath, file.FileName)] = true
+	}
+	resultList := make([]CustomisationFile, 0, len(finalFilesList))
+	existing := make(map[string]bool, len(oldFilesList))
+	removed := make([]string, 0)
+	for _, oldFile := range oldFilesList {
+		key := filepath.Join(oldFile.RelativePath, oldFile.FileName)
+		if !deployed[key] || existing[key] {
+			removed = append(removed, key)
+			continue
+		}
+		existing[key] = true
+		resultList = append(resultList, oldFile)
+	}
+	added := make([]string, 0)
+	for _, newFile := range finalFilesList {
+		key := filepath.Join(newFile.RelativePath, newFile.FileName)
+		if existing[key] {
+			continue
+		}
+		existing[key] = true
+		added = append(added, key)
+		resultList = append(resultList, newFile)
+	}
+
+	rvs.InsertActualCustomFilesValue(ConstructCustomFilesRegistryKey(resultList))
+	return added, removed
 }
 
 // Read previously saved registry key/value data from file.
-// Automatically find latest .yaml file by name mask.
-func ReadPreviouslySavedRegistryData(savedRegistryDirectory string) ([]byte, error) {
-	// Read dir content.
+// Latest by modification time "RegFileName*.yaml" file with valid structure used, other files ignored.
+// Files which can't be parsed moved into quarantine subfolder if quarantine set, only skipped otherwise (dry run), return their names.
+func ReadPreviouslySavedRegistryData(savedRegistryDirectory string, quarantine bool) ([]byte, []string, error) {
+	// Read dir content. Missing folder has no saved files.
 	dirContent, err := ioutil.ReadDir(savedRegistryDirectory)
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNoFilesFoundInFolderByPattern
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	var lastRegFile os.FileInfo
 
-	// Sort out folders and not yaml files and find newer file.
-	lastRegFile = nil
-	reYAML := regexp.MustCompile(`.yaml$`)
+	// Sort out folders and not saved registry files, newer files first.
+	regFiles := make([]os.FileInfo, 0, len(dirContent))
 	for _, file := range dirContent {
-		if file.IsDir() {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), RegFileName) || filepath.Ext(file.Name()) != ".yaml" {
 			continue
 		}
-		if !reYAML.MatchString(file.Name()) {
-			continue
+		regFiles = append(regFiles, file)
+	}
+	sort.SliceStable(regFiles, func(i, j int) bool { return regFiles[j].ModTime().Before(regFiles[i].ModTime()) })
+
+	// Read data from newest valid file, quarantine invalid.
+	quarantined := make([]string, 0)
+	for _, file := range regFiles {
+		fullFilePath := filepath.Join(savedRegistryDirectory, file.Name())
+		regBytes, err := ioutil.ReadFile(fullFilePath)
+		if err != nil {
+			return nil, quarantined, err
 		}
-		if lastRegFile == nil {
-			lastRegFile = file
+		if ValidateRegistryData(regBytes) == nil {
+			return regBytes, quarantined, nil
+		}
+		if !quarantine {
+			quarantined = append(quarantined, file.Name())
 			continue
 		}
-		if lastRegFile.ModTime().Before(file.ModTime()) {
-			lastRegFile = file
+		err = os.MkdirAll(filepath.Join(savedRegistryDirectory, RegQuarantineFolder), 0755)
+		if err == nil {
+			err = os.Rename(fullFilePath, filepath.Join(savedRegistryDirectory, RegQuarantineFolder, file.Name()))
 		}
+		if err != nil {
+			return nil, quarantined, fmt.Errorf("can't quarantine invalid '%v' - %v", file.Name(), err)
+		}
+		quarantined = append(quarantined, file.Name())
 	}
-	if lastRegFile == nil {
-		return nil, ErrNoFilesFoundInFolderByPattern
-	}
+	return nil, quarantined, ErrNoFilesFoundInFolderByPattern
+}
 
-	// Read data from file and unmarshal yaml.
-	fullFilePath := filepath.Join(savedRegistryDirectory, lastRegFile.Name())
-	regFile, err := os.Open(fullFilePath)
+// Check saved registry data structure: list of values with unique non-empty names, supported types and no unknown fields.
+func ValidateRegistryData(regBytes []byte) error {
+	registryData := make([]RegistryValue, 0, 32)
+	err := yaml.UnmarshalStrict(regBytes, &registryData)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	regBytes, err := ioutil.ReadAll(regFile)
-	if err != nil {
-		return nil, err
+	names := make(map[string]bool, len(registryData))
+	for _, value := range registryData {
+		if value.Name == "" {
+			return fmt.Errorf("registry value without name")
+		}
+		if names[value.Name] {
+			return fmt.Errorf("duplicate registry value '%v'", value.Name)
+		}
+		if value.Type != "" && value.Type != RegistryTypeExpandString {
+			return fmt.Errorf("registry value '%v' has unsupported type '%v'", value.Name, value.Type)
+		}
+		names[value.Name] = true
 	}
-	return regBytes, nil
+	return nil
 }
 
 // Unmarshal yaml row text into []RegistryValue
@@ -167,27 +220,6 @@ func UnmarshalRegistryData(regBytes []byte) ([]RegistryValue, error) {
 	return registryData, nil
 }
 
-// Save keys/value pairs from registry into []RegistryValue.
-func ReadRegistryData(registryDir string) ([]RegistryValue, error) {
-	keyDir, err := registry.OpenKey(registry.CURRENT_USER, registryDir, registry.ENUMERATE_SUB_KEYS|registry.QUERY_VALUE)
-	if err != nil {
-		return nil, err
-	}
-	valueNames, err := keyDir.ReadValueNames(-1)
-	if err != nil {
-		return nil, err
-	}
-	regValues := make([]RegistryValue, 0, 32)
-	for _, name := range valueNames {
-		value, _, err := keyDir.GetStringValue(name)
-		if err != nil {
-			return nil, err
-		}
-		regValues = append(regValues, RegistryValue{Name: name, Data: value})
-	}
-	return regValues, nil
-}
-
 // Marshal registry data for save into file.
 func MarshalRegistryData(regValues []RegistryValue) ([]byte, error) {
 	registryBytes, err := yaml.Marshal(regValues)
@@ -199,6 +231,7 @@ func MarshalRegistryData(regValues []RegistryValue) ([]byte, error) {
 
 // Unmarshal XML from string and return CustomisationFile slice with filled
 // FileName, RelativePath, DataFile, EntryPoint, IsMainConfigFile, Optional and GroupName values.
+// Flags omitted in compact value filled with "false".
 func ParseOldCustomFilesValue(oldCustomFiles []byte) ([]CustomisationFile, error) {
 	var oldData XMLCustomFiles
 	decoderXML := xml.NewDecoder(bytes.NewReader(oldCustomFiles))
@@ -207,6 +240,14 @@ func ParseOldCustomFilesValue(oldCustomFiles []byte) ([]CustomisationFile, error
 	if err != nil {
 		return []CustomisationFile{}, err
 	}
+	for id := range oldData.ApplicationFile {
+		file := &oldData.ApplicationFile[id]
+		for _, flag := range []*string{&file.DataFile, &file.EntryPoint, &file.IsMainConfigFile, &file.Optional} {
+			if *flag == "" {
+				*flag = "false"
+			}
+		}
+	}
 	return oldData.ApplicationFile, nil
 }
 
@@ -216,50 +257,76 @@ func IdentReader(encoding string, input io.Reader) (io.Reader, error) {
 }
 
 // Construct XML with format valid for DM WDE.
+// Buffer sized before writing, so construction is linear for large file sets.
 func ConstructCustomFilesRegistryKey(customFilesList []CustomisationFile) string {
-	result := RegFilesHeadXML
+	size := len(RegFilesHeadXML) + len(RegFilesEndingXML)
+	for _, file := range customFilesList {
+		size += customFilesLineLength(file)
+	}
+	var result strings.Builder
+	result.Grow(size)
+	result.WriteString(RegFilesHeadXML)
 	for _, file := range customFilesList {
-		result = fmt.Sprint(result, ConstructLineForCustomFilesRegistryKey(file))
+		writeLineForCustomFilesRegistryKey(&result, file)
 	}
-	return fmt.Sprint(result, RegFilesEndingXML)
+	result.WriteString(RegFilesEndingXML)
+	return result.String()
 }
 
 // Convert variable of CustomisationFile type into string for registry key.
 func ConstructLineForCustomFilesRegistryKey(cf CustomisationFile) string {
-	return fmt.Sprint(
+	var line strings.Builder
+	line.Grow(customFilesLineLength(cf))
+	writeLineForCustomFilesRegistryKey(&line, cf)
+	return line.String()
+}
+
+// Escape characters not allowed in XML attribute value.
+var customFilesAttributeEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"\"", "&quot;",
+	"'", "&apos;",
+	"\t", "&#x9;",
+	"\n", "&#xA;",
+	"\r", "&#xD;",
+)
+
+// Fields of registry key line in write order, shared by writer and length calculation.
+// Values escaped, so file names with "&" or quotes keep XML well-formed.
+func customFilesLineParts(cf CustomisationFile) [15]string {
+	return [15]string{
 		RegFilesFileNameXML,
-		cf.FileName,
+		customFilesAttributeEscaper.Replace(cf.FileName),
 		RegFilesRelativePathXML,
-		cf.RelativePath,
+		customFilesAttributeEscaper.Replace(cf.RelativePath),
 		RegFilesDataFileXML,
-		cf.EntryPoint,
+		customFilesAttributeEscaper.Replace(cf.DataFile),
 		RegFilesEntryPointXML,
-		cf.IsMainConfigFile,
+		customFilesAttributeEscaper.Replace(cf.EntryPoint),
 		RegFilesIsMainConfigFileXML,
-		cf.IsMainConfigFile,
+		customFilesAttributeEscaper.Replace(cf.IsMainConfigFile),
 		RegFilesOptionalXML,
-		cf.Optional,
+		customFilesAttributeEscaper.Replace(cf.Optional),
 		RegFilesGroupNameXML,
-		cf.GroupName,
+		customFilesAttributeEscaper.Replace(cf.GroupName),
 		RegFilesTailXML,
-	)
+	}
 }
 
-// Write data into registry.
-func WriteToRegistry(registryData []RegistryValue) error {
-	// Open directory key DMRegistryDir with write privileges.
-	keyDir, _, err := registry.CreateKey(registry.CURRENT_USER, DMRegistryDir, registry.QUERY_VALUE|registry.SET_VALUE)
-	if err != nil {
-		return err
-	}
-	// Write or rewrite child keys values
-	for _, key := range registryData {
-		if err := keyDir.SetStringValue(key.Name, key.Data); err != nil {
-			return err
-		}
+// Return length of registry key line of file.
+func customFilesLineLength(cf CustomisationFile) int {
+	length := 0
+	for _, part := range customFilesLineParts(cf) {
+		length += len(part)
 	}
-	if err := keyDir.Close(); err != nil {
-		return err
+	return length
+}
+
+// Append registry key line of file to builder.
+func writeLineForCustomFilesRegistryKey(builder *strings.Builder, cf CustomisationFile) {
+	for _, part := range customFilesLineParts(cf) {
+		builder.WriteString(part)
 	}
-	return nil
 }
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+// There is no registry outside Windows, so in-memory store used.
+func DefaultRegistryStore() RegistryStore {
+	return NewMemoryRegistry()
+}
+
+// There is no registry outside Windows, so in-memory store used.
+func NewUserRegistryStore(sid string) RegistryStore {
+	return NewMemoryRegistry()
+}
+
+// There is no HKEY_USERS outside Windows.
+func ListUserRegistryHives() ([]string, error) {
+	return nil, ErrNotSupportedOnPlatform
+}
+
+// There are no SIDs outside Windows.
+func CurrentUserSID() string {
+	return ""
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestCustomFilesRegistryKeyRoundTrip(t *testing.T) {
+	// Every attribute has distinct value, so value written under wrong attribute found.
+	files := []CustomisationFile{
+		{FileName: "Company.Wde.Module.dll", RelativePath: "", DataFile: "false", EntryPoint: "true", IsMainConfigFile: "false", Optional: "false", GroupName: "Main"},
+		{FileName: "R&D <\"it's\">.xml", RelativePath: `Languages\en-US`, DataFile: "true", EntryPoint: "false", IsMainConfigFile: "true", Optional: "true", GroupName: "Lang & Co"},
+	}
+	parsed, err := ParseOldCustomFilesValue([]byte(ConstructCustomFilesRegistryKey(files)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(files) {
+		t.Fatalf("%v entries parsed, want %v", len(parsed), len(files))
+	}
+	for id, want := range files {
+		got := parsed[id]
+		fields := [][3]string{
+			{"FileName", got.FileName, want.FileName},
+			{"RelativePath", got.RelativePath, want.RelativePath},
+			{"DataFile", got.DataFile, want.DataFile},
+			{"EntryPoint", got.EntryPoint, want.EntryPoint},
+			{"IsMainConfigFile", got.IsMainConfigFile, want.IsMainConfigFile},
+			{"Optional", got.Optional, want.Optional},
+			{"GroupName", got.GroupName, want.GroupName},
+		}
+		for _, field := range fields {
+			if field[1] != field[2] {
+				t.Errorf("entry %v %v '%v', want '%v'", id, field[0], field[1], field[2])
+			}
+		}
+	}
+}
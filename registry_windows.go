@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"strings"
+)
+
+// RegistryStore implementation for current user registry.
+type LiveRegistry struct{}
+
+// Return live registry as default store.
+func DefaultRegistryStore() RegistryStore {
+	return LiveRegistry{}
+}
+
+// Read values from current user registry directory.
+func (LiveRegistry) Read(registryDir string) ([]RegistryValue, error) {
+	regValues, err := ReadRegistryData(registryDir)
+	if err == registry.ErrNotExist {
+		return nil, ErrRegistryKeyNotExist
+	}
+	return regValues, err
+}
+
+// Write values into current user registry directory.
+func (LiveRegistry) Write(registryDir string, registryData []RegistryValue) error {
+	return WriteToRegistry(registryDir, registryData)
+}
+
+// Delete values from current user registry directory, missing values ignored.
+func (LiveRegistry) Delete(registryDir string, names []string) error {
+	return deleteRegistryValues(registry.CURRENT_USER, registryDir, names)
+}
+
+// RegistryStore implementation for registry hive of other user loaded into HKEY_USERS.
+type UserRegistry struct {
+	SID string
+}
+
+// Return store for hive of user with SID.
+func NewUserRegistryStore(sid string) RegistryStore {
+	return UserRegistry{SID: sid}
+}
+
+// Read values from user registry directory.
+func (ur UserRegistry) Read(registryDir string) ([]RegistryValue, error) {
+	regValues, err := readRegistryKey(registry.USERS, ur.keyPath(registryDir))
+	if err == registry.ErrNotExist {
+		return nil, ErrRegistryKeyNotExist
+	}
+	return regValues, err
+}
+
+// Write values into user registry directory.
+func (ur UserRegistry) Write(registryDir string, registryData []RegistryValue) error {
+	return writeRegistryKey(registry.USERS, ur.keyPath(registryDir), registryData)
+}
+
+// Delete values from user registry directory, missing values ignored.
+func (ur UserRegistry) Delete(registryDir string, names []string) error {
+	return deleteRegistryValues(registry.USERS, ur.keyPath(registryDir), names)
+}
+
+// Get path of registry directory inside HKEY_USERS.
+func (ur UserRegistry) keyPath(registryDir string) string {
+	return fmt.Sprint(ur.SID, `\`, registryDir)
+}
+
+// List SIDs of user hives loaded into HKEY_USERS. Service accounts and "_Classes" hives excluded.
+func ListUserRegistryHives() ([]string, error) {
+	users, err := registry.OpenKey(registry.USERS, "", registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return nil, err
+	}
+	defer users.Close()
+	names, err := users.ReadSubKeyNames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sids := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.HasPrefix(name, UserSIDPrefix) && !strings.HasSuffix(name, "_Classes") {
+			sids = append(sids, name)
+		}
+	}
+	return sids, nil
+}
+
+// Get SID of user running process, empty if unknown.
+func CurrentUserSID() string {
+	tokenUser, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return ""
+	}
+	return tokenUser.User.Sid.String()
+}
+
+// Delete values from registry directory, missing values ignored.
+func deleteRegistryValues(root registry.Key, registryDir string, names []string) error {
+	keyDir, err := registry.OpenKey(root, registryDir, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer keyDir.Close()
+	for _, name := range names {
+		err := keyDir.DeleteValue(name)
+		if err != nil && err != registry.ErrNotExist {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save keys/value pairs from registry into []RegistryValue. REG_EXPAND_SZ values kept unexpanded.
+func ReadRegistryData(registryDir string) ([]RegistryValue, error) {
+	return readRegistryKey(registry.CURRENT_USER, registryDir)
+}
+
+// Save keys/value pairs from registry directory under root key.
+func readRegistryKey(root registry.Key, registryDir string) ([]RegistryValue, error) {
+	keyDir, err := registry.OpenKey(root, registryDir, registry.ENUMERATE_SUB_KEYS|registry.QUERY_VALUE)
+	if err != nil {
+		return nil, err
+	}
+	defer keyDir.Close()
+	valueNames, err := keyDir.ReadValueNames(-1)
+	if err != nil {
+		return nil, err
+	}
+	regValues := make([]RegistryValue, 0, 32)
+	for _, name := range valueNames {
+		value, valueType, err := keyDir.GetStringValue(name)
+		if err != nil {
+			return nil, err
+		}
+		regValue := RegistryValue{Name: name, Data: value}
+		if valueType == registry.EXPAND_SZ {
+			regValue.Type = RegistryTypeExpandString
+		}
+		regValues = append(regValues, regValue)
+	}
+	return regValues, nil
+}
+
+// Write data into registry, REG_EXPAND_SZ type preserved.
+func WriteToRegistry(registryDir string, registryData []RegistryValue) error {
+	return writeRegistryKey(registry.CURRENT_USER, registryDir, registryData)
+}
+
+// Write data into registry directory under root key.
+func writeRegistryKey(root registry.Key, registryDir string, registryData []RegistryValue) error {
+	// Open directory key with write privileges.
+	keyDir, _, err := registry.CreateKey(root, registryDir, registry.QUERY_VALUE|registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	// Write or rewrite child keys values
+	for _, key := range registryData {
+		setValue := keyDir.SetStringValue
+		if key.Type == RegistryTypeExpandString {
+			setValue = keyDir.SetExpandStringValue
+		}
+		if err := setValue(key.Name, key.Data); err != nil {
+			keyDir.Close()
+			return err
+		}
+	}
+	if err := keyDir.Close(); err != nil {
+		return err
+	}
+	return nil
+}
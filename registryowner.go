@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Registry values always managed by updater.
+var ManagedRegistryValues = []string{"CustomFiles", "AddCustomFile"}
+
+// Names of Deployment Manager registry values owned by updater.
+// Values not listed there created by hand (e.g. for other DM features) and never overwritten or deleted.
+type RegistryOwnership struct {
+	Values  []string `json:"values"`
+	managed []string // Values managed by updater and configuration, written even if missing.
+}
+
+// Return values managed by configuration: Registry.ManagedValues and values of Registry.Template.
+func ConfiguredManagedValues(mainConfig MainCfgYAML) []string {
+	managedValues := append([]string{}, mainConfig.Registry.ManagedValues...)
+	for name := range mainConfig.Registry.Template {
+		managedValues = append(managedValues, name)
+	}
+	return managedValues
+}
+
+// Read ownership from file. Return ownership of always managed values if file not exists.
+func ReadRegistryOwnership(ownershipFileFullPath string, extraValues []string) (RegistryOwnership, error) {
+	ownership := RegistryOwnership{managed: append(append([]string{}, ManagedRegistryValues...), extraValues...)}
+	ownershipBytes, err := ioutil.ReadFile(ownershipFileFullPath)
+	if err != nil && !os.IsNotExist(err) {
+		return ownership, err
+	}
+	if err == nil {
+		err = json.Unmarshal(ownershipBytes, &ownership)
+		if err != nil {
+			return ownership, err
+		}
+	}
+	ownership.Add(ManagedRegistryValues)
+	ownership.Add(extraValues)
+	return ownership, nil
+}
+
+// Save ownership into file.
+func (ro RegistryOwnership) Save(ownershipFileFullPath string) error {
+	ownershipBytes, err := json.MarshalIndent(ro, "", "  ")
+	if err != nil {
+		return err
+	}
+	return SaveBytesIntoFile(ownershipFileFullPath, ownershipBytes)
+}
+
+// Check if value owned by updater.
+func (ro RegistryOwnership) Owns(name string) bool {
+	return containsString(ro.Values, name)
+}
+
+// Remove value names from ownership, managed values stay owned.
+func (ro *RegistryOwnership) Remove(names []string) {
+	values := make([]string, 0, len(ro.Values))
+	for _, owned := range ro.Values {
+		if !containsString(names, owned) || containsString(ro.managed, owned) {
+			values = append(values, owned)
+		}
+	}
+	ro.Values = values
+}
+
+// Add value names into ownership.
+func (ro *RegistryOwnership) Add(names []string) {
+	for _, name := range names {
+		if !ro.Owns(name) {
+			ro.Values = append(ro.Values, name)
+		}
+	}
+	sort.Strings(ro.Values)
+}
+
+// Select prepared values allowed to write. Managed values written always, other owned values only if
+// they still exist in live registry. Values missing in live registry deleted by hand and not re-created.
+// Return values to write, names of not owned live values kept unchanged though prepared data differ
+// and names of values deleted by hand.
+func (ro RegistryOwnership) SelectWritable(prepared, live []RegistryValue) ([]RegistryValue, []string, []string) {
+	liveData := make(map[string]RegistryValue, len(live))
+	for _, value := range live {
+		liveData[value.Name] = value
+	}
+	writable := make([]RegistryValue, 0, len(prepared))
+	kept := make([]string, 0)
+	deleted := make([]string, 0)
+	for _, value := range prepared {
+		liveValue, exist := liveData[value.Name]
+		switch {
+		case containsString(ro.managed, value.Name):
+			writable = append(writable, value)
+		case !exist:
+			deleted = append(deleted, value.Name)
+		case ro.Owns(value.Name):
+			writable = append(writable, value)
+		case liveValue != value:
+			kept = append(kept, value.Name)
+		}
+	}
+	return writable, kept, deleted
+}
+
+// Check if list contains string.
+func containsString(list []string, text string) bool {
+	for _, item := range list {
+		if item == text {
+			return true
+		}
+	}
+	return false
+}
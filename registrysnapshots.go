@@ -0,0 +1,291 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	RestoredRegFileLabel string = "RESTORED_"  // Label of saved registry file restored from snapshot.
+	RegQuarantineFolder  string = "Quarantine" // Subfolder of saved registry folder for files with invalid structure.
+)
+
+// Saved registry data file with summary.
+type RegistrySnapshot struct {
+	ID          string // File name without RegFileName prefix and extension, e.g. "2006.01.02_150405" or "INITIALISATION_...".
+	FileName    string
+	Values      []RegistryValue
+	CustomFiles int   // Number of "CustomFiles" entries, -1 if value absent or invalid.
+	Invalid     error // Structure error, file quarantined by next run.
+}
+
+// Difference between two sets of registry values by name.
+type RegistryValuesDiff struct {
+	Added   []string // Present only in second set.
+	Changed []string
+	Removed []string // Present only in first set.
+}
+
+// List saved registry data files ordered by modification time, latest (used by next run) at the end.
+func ListRegistrySnapshots(savedRegistryDir string) ([]RegistrySnapshot, error) {
+	dirContent, err := ioutil.ReadDir(savedRegistryDir)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(dirContent, func(i, j int) bool { return dirContent[i].ModTime().Before(dirContent[j].ModTime()) })
+	snapshots := make([]RegistrySnapshot, 0, len(dirContent))
+	for _, file := range dirContent {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), RegFileName) || filepath.Ext(file.Name()) != ".yaml" {
+			continue
+		}
+		snapshot := RegistrySnapshot{
+			ID:          strings.TrimSuffix(strings.TrimPrefix(file.Name(), RegFileName), ".yaml"),
+			FileName:    file.Name(),
+			CustomFiles: -1,
+		}
+		regBytes, err := ioutil.ReadFile(filepath.Join(savedRegistryDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Invalid = ValidateRegistryData(regBytes)
+		if snapshot.Invalid != nil {
+			snapshots = append(snapshots, snapshot)
+			continue
+		}
+		snapshot.Values, err = UnmarshalRegistryData(regBytes)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse '%v' - %v", file.Name(), err)
+		}
+		if entries, err := RegistryValues(snapshot.Values).CustomFilesEntries(); err == nil {
+			snapshot.CustomFiles = len(entries)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// Find valid snapshot by ID or "last" valid, used by next run.
+func FindRegistrySnapshot(snapshots []RegistrySnapshot, id string) (RegistrySnapshot, error) {
+	for position := len(snapshots) - 1; position >= 0; position-- {
+		snapshot := snapshots[position]
+		if id != "last" && snapshot.ID != id && snapshot.FileName != id {
+			continue
+		}
+		if snapshot.Invalid != nil {
+			if id == "last" {
+				continue
+			}
+			return RegistrySnapshot{}, fmt.Errorf("registry snapshot '%v' invalid - %v", id, snapshot.Invalid)
+		}
+		return snapshot, nil
+	}
+	return RegistrySnapshot{}, fmt.Errorf("registry snapshot '%v' not found", id)
+}
+
+// Compare registry values by name.
+func DiffRegistryValues(from, to []RegistryValue) RegistryValuesDiff {
+	fromData := make(map[string]RegistryValue, len(from))
+	for _, value := range from {
+		fromData[value.Name] = value
+	}
+	toNames := make(map[string]bool, len(to))
+	diff := RegistryValuesDiff{}
+	for _, value := range to {
+		toNames[value.Name] = true
+		fromValue, ok := fromData[value.Name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, value.Name)
+		case fromValue != value:
+			diff.Changed = append(diff.Changed, value.Name)
+		}
+	}
+	for _, value := range from {
+		if !toNames[value.Name] {
+			diff.Removed = append(diff.Removed, value.Name)
+		}
+	}
+	return diff
+}
+
+// Run "registry" subcommand.
+// Usage: registry snapshots list | registry snapshots show <snapshot> | registry snapshots restore [-live] <snapshot>
+func RunRegistryCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	usage := fmt.Errorf("usage: registry snapshots list|show <snapshot>|restore [-live] <snapshot>")
+	if len(args) < 2 || args[0] != "snapshots" {
+		return usage
+	}
+	savedRegistryDir := SavedRegistryFolderPath(mainConfig, programDirectory)
+	snapshots, err := ListRegistrySnapshots(savedRegistryDir)
+	if err != nil {
+		return err
+	}
+
+	switch args[1] {
+	case "list":
+		next, _ := FindRegistrySnapshot(snapshots, "last")
+		for _, snapshot := range snapshots {
+			if snapshot.Invalid != nil {
+				fmt.Printf("%v\tINVALID, quarantined by next run - %v\n", snapshot.ID, snapshot.Invalid)
+				continue
+			}
+			marker := ""
+			if snapshot.FileName == next.FileName {
+				marker = " (used by next run)"
+			}
+			customFiles := "no CustomFiles"
+			if snapshot.CustomFiles >= 0 {
+				customFiles = fmt.Sprintf("%v CustomFiles entries", snapshot.CustomFiles)
+			}
+			fmt.Printf("%v\t%v values\t%v%v\n", snapshot.ID, len(snapshot.Values), customFiles, marker)
+		}
+		return nil
+	case "show":
+		if len(args) != 3 {
+			return usage
+		}
+		snapshot, err := FindRegistrySnapshot(snapshots, args[2])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Snapshot: %v\nValues: %v\n", snapshot.FileName, len(snapshot.Values))
+		if snapshot.CustomFiles >= 0 {
+			fmt.Printf("CustomFiles entries: %v\n", snapshot.CustomFiles)
+		}
+		live, err := DefaultRegistryStore().Read(DMRegistryDir)
+		if err != nil && err != ErrRegistryKeyNotExist {
+			return err
+		}
+		diff := DiffRegistryValues(live, snapshot.Values)
+		if len(diff.Added)+len(diff.Changed)+len(diff.Removed) == 0 {
+			fmt.Println("Live registry: EQUAL")
+			return nil
+		}
+		fmt.Println("Live registry: DIFFER (restore changes)")
+		for _, name := range diff.Added {
+			fmt.Println("  +", name)
+		}
+		for _, name := range diff.Changed {
+			fmt.Println("  ~", name)
+		}
+		for _, name := range diff.Removed {
+			fmt.Println("  -", name, "(not in snapshot, kept in registry)")
+		}
+		return nil
+	case "restore":
+		flags := flag.NewFlagSet("registry snapshots restore", flag.ContinueOnError)
+		live := flags.Bool("live", false, "also write snapshot values into live registry")
+		err := flags.Parse(args[2:])
+		if err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return usage
+		}
+		snapshot, err := FindRegistrySnapshot(snapshots, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		if !*live {
+			return RestoreRegistrySnapshot(snapshot, mainConfig, programDirectory, false, nil)
+		}
+		timeString := FileTimestamp(TimestampNow())
+		logFullPath := filepath.Join(
+			LogFolderPath(mainConfig, programDirectory),
+			fmt.Sprint(LogFilePrefix(mainConfig), timeString, ".log"),
+		)
+		logger := NewZapSimpleLoggerWithRotation(mainConfig.Log.Verbose, logFullPath, 10, 1)
+		defer logger.Sync()
+		logger.Info(fmt.Sprintf("Restore of registry snapshot '%v' into live registry started", snapshot.ID))
+		// Scheduled run never writes registry concurrently.
+		release, err := AcquireRunLock(mainConfig, programDirectory, logger)
+		if err != nil {
+			return err
+		}
+		defer release()
+		audit := NewAuditLog(mainConfig, programDirectory, timeString, CurrentRunLabels(), logger)
+		err = RestoreRegistrySnapshot(snapshot, mainConfig, programDirectory, true, audit)
+		if err != nil {
+			logger.Error(fmt.Sprint("Restore of registry snapshot failed - ", err))
+			return err
+		}
+		logger.Info(fmt.Sprintf("Registry snapshot '%v' written into '%v'", snapshot.ID, DMRegistryDir))
+		return nil
+	}
+	return usage
+}
+
+// Save snapshot as latest saved registry data, so used by next run. If live set, write it into registry too,
+// previous values exported into .reg backup before write. Live write respects ownership: values not owned
+// by updater and changed by hand kept, values deleted by hand not re-created. Caller holds run lock.
+// Backup and written values recorded in audit log.
+func RestoreRegistrySnapshot(snapshot RegistrySnapshot, mainConfig MainCfgYAML, programDirectory string, live bool, audit *AuditLog) error {
+	savedRegistryDir := SavedRegistryFolderPath(mainConfig, programDirectory)
+	timeString := FileTimestamp(TimestampNow())
+	regBytes, err := MarshalRegistryData(snapshot.Values)
+	if err != nil {
+		return err
+	}
+	restoredFullPath := filepath.Join(savedRegistryDir, fmt.Sprint(RegFileName, RestoredRegFileLabel, timeString, ".yaml"))
+	err = SaveBytesIntoFile(restoredFullPath, regBytes)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot '%v' restored as '%v', used by next run\n", snapshot.ID, filepath.Base(restoredFullPath))
+	if !live {
+		return nil
+	}
+
+	store := DefaultRegistryStore()
+	liveData, err := store.Read(DMRegistryDir)
+	if err != nil && err != ErrRegistryKeyNotExist {
+		return fmt.Errorf("can't read registry values before write - %v", err)
+	}
+	liveHashes := make(map[string]string, len(liveData))
+	for _, value := range liveData {
+		liveHashes[value.Name] = HashRegistryData(value.Data)
+	}
+	ownershipFileFullPath := filepath.Join(StateFolderPath(mainConfig, programDirectory), OwnershipFileName)
+	ownership, err := ReadRegistryOwnership(ownershipFileFullPath, ConfiguredManagedValues(mainConfig))
+	if err != nil {
+		return fmt.Errorf("can't read registry ownership - %v", err)
+	}
+	writable, kept, deleted := ownership.SelectWritable(snapshot.Values, liveData)
+	for _, name := range kept {
+		fmt.Printf("Value '%v' not owned by updater and changed by hand, kept unchanged\n", name)
+	}
+	for _, name := range deleted {
+		fmt.Printf("Value '%v' deleted by hand, not re-created\n", name)
+	}
+	backupFullPath, err := BackupRegistryDir(store, DMRegistryDir, savedRegistryDir, timeString)
+	if err != nil {
+		return fmt.Errorf("can't backup registry before write, registry not written - %v", err)
+	}
+	if backupFullPath != "" {
+		audit.Record(AuditFileBackedUp, backupFullPath, "", audit.FileHash(backupFullPath), fmt.Sprint("registry ", DMRegistryDir))
+	}
+	err = WriteRegistryVerified(store, DMRegistryDir, writable)
+	if err != nil {
+		return fmt.Errorf("can't write snapshot into registry - %v", err)
+	}
+	written := make([]string, 0, len(writable))
+	for _, value := range writable {
+		audit.Record(AuditRegistryWritten, fmt.Sprint(DMRegistryDir, `\`, value.Name), liveHashes[value.Name], HashRegistryData(value.Data), fmt.Sprint("snapshot ", snapshot.ID))
+		written = append(written, value.Name)
+	}
+	ownership.Remove(deleted)
+	ownership.Add(written)
+	err = ownership.Save(ownershipFileFullPath)
+	if err != nil {
+		fmt.Println("Can't save registry ownership -", err)
+	}
+	if backupFullPath != "" {
+		fmt.Printf("Previous registry values exported into '%v'\n", backupFullPath)
+	}
+	fmt.Println("Snapshot written into registry")
+	return nil
+}
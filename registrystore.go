@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+)
+
+// Access to registry directories with string values.
+// Implemented by live Windows registry and in-memory store used for simulation.
+type RegistryStore interface {
+	Read(registryDir string) ([]RegistryValue, error)
+	Write(registryDir string, registryData []RegistryValue) error
+	Delete(registryDir string, names []string) error
+}
+
+// RegistryStore implementation which keep values in memory.
+type MemoryRegistry struct {
+	mutex sync.Mutex
+	dirs  map[string][]RegistryValue
+}
+
+// Return empty in-memory registry.
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{dirs: make(map[string][]RegistryValue)}
+}
+
+// Read copy of directory values.
+func (mr *MemoryRegistry) Read(registryDir string) ([]RegistryValue, error) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	values, ok := mr.dirs[registryDir]
+	if !ok {
+		return nil, ErrRegistryKeyNotExist
+	}
+	return append(make([]RegistryValue, 0, len(values)), values...), nil
+}
+
+// Write or rewrite values by name and type like registry SetStringValue and SetExpandStringValue do.
+func (mr *MemoryRegistry) Write(registryDir string, registryData []RegistryValue) error {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	values := mr.dirs[registryDir]
+	for _, newValue := range registryData {
+		found := false
+		for id, value := range values {
+			if value.Name == newValue.Name {
+				values[id] = newValue
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, newValue)
+		}
+	}
+	mr.dirs[registryDir] = values
+	return nil
+}
+
+// Delete values by name, missing values ignored.
+func (mr *MemoryRegistry) Delete(registryDir string, names []string) error {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	deleted := make(map[string]bool, len(names))
+	for _, name := range names {
+		deleted[name] = true
+	}
+	values := make([]RegistryValue, 0, len(mr.dirs[registryDir]))
+	for _, value := range mr.dirs[registryDir] {
+		if !deleted[value.Name] {
+			values = append(values, value)
+		}
+	}
+	mr.dirs[registryDir] = values
+	return nil
+}
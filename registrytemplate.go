@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Variable reference in Registry.Template values: "${var:NAME}".
+var reTemplateVariable = regexp.MustCompile(`\$\{var:([^}]+)\}`)
+
+// Return variables of configured target: Registry.Variables overridden by Registry.Targets[Registry.Target].
+func RegistryTargetVariables(mainConfig MainCfgYAML) (map[string]string, error) {
+	variables := make(map[string]string, len(mainConfig.Registry.Variables))
+	for name, value := range mainConfig.Registry.Variables {
+		variables[name] = value
+	}
+	if mainConfig.Registry.Target == "" {
+		return variables, nil
+	}
+	target, ok := mainConfig.Registry.Targets[mainConfig.Registry.Target]
+	if !ok {
+		return nil, fmt.Errorf("registry target '%v' not found in Registry.Targets", mainConfig.Registry.Target)
+	}
+	for name, value := range target {
+		variables[name] = value
+	}
+	return variables, nil
+}
+
+// Render registry values from template, variable references replaced by values. Values sorted by name.
+// "CustomFiles" and "AddCustomFile" built by updater and can't be templated.
+func RenderRegistryTemplate(template, variables map[string]string) ([]RegistryValue, error) {
+	names := make([]string, 0, len(template))
+	for name := range template {
+		for _, managed := range ManagedRegistryValues {
+			if name == managed {
+				return nil, fmt.Errorf("registry value '%v' can't be templated", name)
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rendered := make([]RegistryValue, 0, len(names))
+	for _, name := range names {
+		var renderErr error
+		data := reTemplateVariable.ReplaceAllStringFunc(template[name], func(reference string) string {
+			variable := reTemplateVariable.FindStringSubmatch(reference)[1]
+			value, ok := variables[variable]
+			if !ok && renderErr == nil {
+				renderErr = fmt.Errorf("registry value '%v' use undefined variable '%v'", name, variable)
+			}
+			return value
+		})
+		if renderErr != nil {
+			return nil, renderErr
+		}
+		rendered = append(rendered, RegistryValue{Name: name, Data: data})
+	}
+	return rendered, nil
+}
+
+// Set registry value, add if not exist.
+func (rvs *RegistryValues) SetValue(name, data string) {
+	for id, value := range *rvs {
+		if value.Name == name {
+			(*rvs)[id].Data = data
+			return
+		}
+	}
+	*rvs = append(*rvs, RegistryValue{Name: name, Data: data})
+}
+
+// Render Registry.Template for configured target and set values in registry data.
+// Return names of templated values, they owned by updater.
+func ApplyRegistryTemplate(registryData *RegistryValues, mainConfig MainCfgYAML) ([]string, error) {
+	if len(mainConfig.Registry.Template) == 0 {
+		return nil, nil
+	}
+	variables, err := RegistryTargetVariables(mainConfig)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := RenderRegistryTemplate(mainConfig.Registry.Template, variables)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(rendered))
+	for _, value := range rendered {
+		registryData.SetValue(value.Name, value.Data)
+		names = append(names, value.Name)
+	}
+	return names, nil
+}
+
+// Check that Registry.Template renders for configured target, so template errors found before anything changed.
+func ValidateRegistryTemplate(mainConfig MainCfgYAML) error {
+	_, err := ApplyRegistryTemplate(&RegistryValues{}, mainConfig)
+	if err != nil {
+		return fmt.Errorf("invalid Registry.Template - %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Failed verified registry write. Previous values restored unless RollbackErr set.
+type RegistryWriteError struct {
+	WriteErr    error    // Error of write, nil if all values written.
+	Mismatches  []string // Names of values which differ from written data after write.
+	RollbackErr error    // Error of restore previous values, nil if restored.
+}
+
+func (rwe *RegistryWriteError) Error() string {
+	reason := fmt.Sprint("values not match after write: ", strings.Join(rwe.Mismatches, ", "))
+	if rwe.WriteErr != nil {
+		reason = fmt.Sprint("write failed - ", rwe.WriteErr)
+	}
+	if rwe.RollbackErr != nil {
+		return fmt.Sprintf("%v, rollback failed - %v", reason, rwe.RollbackErr)
+	}
+	return fmt.Sprint(reason, ", previous values restored")
+}
+
+// Check if previous values restored after failed write.
+func (rwe *RegistryWriteError) RolledBack() bool {
+	return rwe.RollbackErr == nil
+}
+
+// Write values into registry directory and verify them by read back.
+// On write error or mismatch values existed before write restored and values created by write deleted.
+// Return *RegistryWriteError if write failed.
+func WriteRegistryVerified(store RegistryStore, registryDir string, registryData []RegistryValue) error {
+	previous, err := store.Read(registryDir)
+	if err != nil && err != ErrRegistryKeyNotExist {
+		return fmt.Errorf("can't read registry values before write - %v", err)
+	}
+
+	writeErr := store.Write(registryDir, registryData)
+	var mismatches []string
+	if writeErr == nil {
+		mismatches, writeErr = verifyRegistryValues(store, registryDir, registryData)
+	}
+	if writeErr == nil && len(mismatches) == 0 {
+		return nil
+	}
+	return &RegistryWriteError{
+		WriteErr:    writeErr,
+		Mismatches:  mismatches,
+		RollbackErr: restoreRegistryValues(store, registryDir, previous, registryData),
+	}
+}
+
+// Return names of values which differ from expected data.
+func verifyRegistryValues(store RegistryStore, registryDir string, expected []RegistryValue) ([]string, error) {
+	actual, err := store.Read(registryDir)
+	if err != nil {
+		return nil, fmt.Errorf("can't read registry values back - %v", err)
+	}
+	actualData := make(map[string]RegistryValue, len(actual))
+	for _, value := range actual {
+		actualData[value.Name] = value
+	}
+	mismatches := make([]string, 0)
+	for _, value := range expected {
+		if actualValue, ok := actualData[value.Name]; !ok || actualValue != value {
+			mismatches = append(mismatches, value.Name)
+		}
+	}
+	return mismatches, nil
+}
+
+// Write previous values back and delete values which not existed before write.
+func restoreRegistryValues(store RegistryStore, registryDir string, previous, written []RegistryValue) error {
+	existed := make(map[string]bool, len(previous))
+	for _, value := range previous {
+		existed[value.Name] = true
+	}
+	created := make([]string, 0)
+	for _, value := range written {
+		if !existed[value.Name] {
+			created = append(created, value.Name)
+		}
+	}
+	if len(created) > 0 {
+		err := store.Delete(registryDir, created)
+		if err != nil {
+			return err
+		}
+	}
+	if len(previous) == 0 {
+		return nil
+	}
+	err := store.Write(registryDir, previous)
+	if err != nil {
+		return err
+	}
+	mismatches, err := verifyRegistryValues(store, registryDir, previous)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("restored values not match: %v", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"regexp"
+)
+
+const (
+	RegistryUsersCurrent string = "current"   // Write DM registry of user running updater only (default).
+	RegistryUsersAll     string = "all"       // Also write DM registry of every loaded user hive with DM key.
+	UserSIDPrefix        string = "S-1-5-21-" // Prefix of SIDs of domain and local user accounts.
+)
+
+// DM registry values applied to hives of other users.
+var UserRegistryValues = []string{"CustomFiles", "AddCustomFile"}
+
+// SID of domain or local user account: prefix followed by domain identifier and RID.
+var reUserSID = regexp.MustCompile(`^S-1-5-21-\d+-\d+-\d+-\d+$`)
+
+// Check Registry.Users mode and Registry.UserSIDs list, so hive of wrong account never written.
+func ValidateRegistryUsers(mainConfig MainCfgYAML) error {
+	switch mainConfig.Registry.Users {
+	case "", RegistryUsersCurrent, RegistryUsersAll:
+	default:
+		return fmt.Errorf("unknown Registry.Users value '%v'", mainConfig.Registry.Users)
+	}
+	seen := make(map[string]bool, len(mainConfig.Registry.UserSIDs))
+	for _, sid := range mainConfig.Registry.UserSIDs {
+		if !reUserSID.MatchString(sid) {
+			return fmt.Errorf("Registry.UserSIDs entry '%v' is not user account SID", sid)
+		}
+		if seen[sid] {
+			return fmt.Errorf("Registry.UserSIDs entry '%v' listed twice", sid)
+		}
+		seen[sid] = true
+	}
+	return nil
+}
+
+// Get SIDs of other users whose DM registry updated. Configured list used if set,
+// otherwise all loaded hives in "all" mode. User running updater excluded.
+func UserRegistrySIDs(mainConfig MainCfgYAML) ([]string, error) {
+	err := ValidateRegistryUsers(mainConfig)
+	if err != nil {
+		return nil, err
+	}
+	sids := mainConfig.Registry.UserSIDs
+	if len(sids) == 0 {
+		if mainConfig.Registry.Users != RegistryUsersAll {
+			return nil, nil
+		}
+		sids, err = ListUserRegistryHives()
+		if err != nil {
+			return nil, fmt.Errorf("can't list user hives - %v", err)
+		}
+	}
+	currentSID := CurrentUserSID()
+	selected := make([]string, 0, len(sids))
+	for _, sid := range sids {
+		if sid != currentSID {
+			selected = append(selected, sid)
+		}
+	}
+	return selected, nil
+}
+
+// Select prepared values applied to hives of other users.
+func (rv RegistryValues) UserValues() []RegistryValue {
+	values := make([]RegistryValue, 0, len(UserRegistryValues))
+	for _, value := range rv {
+		for _, name := range UserRegistryValues {
+			if value.Name == name {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// Write DM registry values of user. Return false without error if hive not loaded or has no DM key.
+// Previous values saved into .reg backup first, nothing written if backup failed.
+func writeUserRegistry(sid string, values []RegistryValue, savedRegistryDir, timeString string, audit *AuditLog, logger *zap.Logger) (bool, error) {
+	store := NewUserRegistryStore(sid)
+	liveData, err := store.Read(DMRegistryDir)
+	if err == ErrRegistryKeyNotExist {
+		logger.Info(fmt.Sprintf("User '%v' hive not loaded or has no DM registry key, skipped", sid))
+		return false, nil
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can't read DM registry of user '%v' - %v", sid, err))
+		return false, fmt.Errorf("can't read - %v", err)
+	}
+	backupFullPath, err := BackupUserRegistryValues(sid, DMRegistryDir, liveData, savedRegistryDir, timeString)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can't backup DM registry of user '%v', nothing written - %v", sid, err))
+		return false, fmt.Errorf("can't backup, nothing written - %v", err)
+	}
+	logger.Info(fmt.Sprintf("DM registry of user '%v' exported into '%v'", sid, backupFullPath))
+	audit.Record(AuditFileBackedUp, backupFullPath, "", audit.FileHash(backupFullPath), fmt.Sprint("registry HKEY_USERS\\", sid, `\`, DMRegistryDir))
+	err = WriteRegistryVerified(store, DMRegistryDir, values)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Can't write DM registry of user '%v' - %v", sid, err))
+		return false, err
+	}
+	liveHashes := make(map[string]string, len(liveData))
+	for _, value := range liveData {
+		liveHashes[value.Name] = HashRegistryData(value.Data)
+	}
+	for _, value := range values {
+		audit.Record(AuditRegistryWritten, fmt.Sprint("HKEY_USERS\\", sid, `\`, DMRegistryDir, `\`, value.Name), liveHashes[value.Name], HashRegistryData(value.Data), value.Type)
+	}
+	logger.Info(fmt.Sprintf("DM registry of user '%v' written", sid))
+	return true, nil
+}
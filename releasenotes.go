@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ReleaseNotesFileName     string = "RELEASENOTES.md" // Release notes of customisation drop, recorded instead of deployed.
+	ReleaseNotesMaxBytes     int64  = 32 * 1024         // Longer release notes truncated.
+	HistoryReleaseNotesTitle string = "Release notes"   // History header block with release notes.
+)
+
+// Release notes of one customisation folder.
+type ReleaseNote struct {
+	Folder string `json:"folder"` // Customisation folder relative to its source.
+	Text   string `json:"text"`
+}
+
+// Read release notes files found among collected files in collection order.
+// Unreadable file logged and skipped.
+func ReadReleaseNotes(files []CustomisationFile, logger *zap.Logger) []ReleaseNote {
+	notes := make([]ReleaseNote, 0)
+	for _, file := range files {
+		if !strings.EqualFold(file.FileName, ReleaseNotesFileName) {
+			continue
+		}
+		text, err := readReleaseNotesFile(file.SourcePath)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Can't read release notes '%v' - %v", file.SourcePath, err))
+			continue
+		}
+		folder, err := filepath.Rel(file.SourceFolder, file.CustomisationFolder)
+		if err != nil || file.CustomisationFolder == "" {
+			folder = file.CustomisationFolder
+		}
+		notes = append(notes, ReleaseNote{Folder: folder, Text: text})
+		logger.Info(fmt.Sprintf("Release notes found in '%v'", file.SourcePath))
+	}
+	return notes
+}
+
+// Read release notes text, truncated to ReleaseNotesMaxBytes.
+func readReleaseNotesFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(file, ReleaseNotesMaxBytes+1))
+	if err != nil {
+		return "", err
+	}
+	truncated := int64(len(data)) > ReleaseNotesMaxBytes
+	if truncated {
+		data = data[:ReleaseNotesMaxBytes]
+	}
+	text := strings.TrimSpace(strings.ReplaceAll(string(data), "\r\n", "\n"))
+	if truncated {
+		text = fmt.Sprint(text, "\n...")
+	}
+	return text, nil
+}
+
+// Return history header block: title, folder lines and notes indented,
+// so notes text never mixed with other header lines.
+func ReleaseNotesHistoryLines(notes []ReleaseNote) []string {
+	if len(notes) == 0 {
+		return nil
+	}
+	lines := []string{HistoryReleaseNotesTitle}
+	for _, note := range notes {
+		lines = append(lines, fmt.Sprint(HistoryFolderPrefix, note.Folder))
+		for _, line := range strings.Split(note.Text, "\n") {
+			lines = append(lines, fmt.Sprint("  ", line))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ReleaseVersionFileName      string = "version.txt"                      // Release version marker of customisation drop, first line is version.
+	UpdaterRegistryDir          string = `Software\WdeCustomizationUpdater` // Informational values of updater in current user registry.
+	HistoryReleaseVersionPrefix string = "Release version: "                // History header line with release version.
+)
+
+// Customisation release applied by run.
+type ReleaseInfo struct {
+	Version string        // Release version from version marker or pinned manifest.
+	Notes   []ReleaseNote // Release notes of customisation folders.
+}
+
+// Read release version from version markers found among collected files.
+// Folders collected in order, so marker of last folder wins. Unreadable marker logged and skipped.
+func ReadReleaseVersion(files []CustomisationFile, logger *zap.Logger) string {
+	version := ""
+	for _, file := range files {
+		if !strings.EqualFold(file.FileName, ReleaseVersionFileName) {
+			continue
+		}
+		markerVersion, err := readReleaseVersionFile(file.SourcePath)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Can't read release version marker '%v' - %v", file.SourcePath, err))
+			continue
+		}
+		if markerVersion == "" {
+			logger.Warn(fmt.Sprintf("Release version marker '%v' is empty", file.SourcePath))
+			continue
+		}
+		if version != "" && version != markerVersion {
+			logger.Info(fmt.Sprintf("Release version '%v' replaced by '%v' from '%v'", version, markerVersion, file.SourcePath))
+		}
+		version = markerVersion
+	}
+	return version
+}
+
+// Read first non-empty line of version marker.
+func readReleaseVersionFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\uFEFF"))
+		if line != "" {
+			return line, nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// Write release version and apply time into updater registry directory for inventory tools.
+func RecordReleaseVersion(store RegistryStore, version string, appliedTime time.Time) error {
+	return store.Write(UpdaterRegistryDir, []RegistryValue{
+		{Name: "ReleaseVersion", Data: version},
+		{Name: "ReleaseAppliedTime", Data: appliedTime.Format(time.RFC3339)},
+	})
+}
+
+// Write when and by which run "CustomFiles" last generated into updater registry directory, so it visible in regedit.
+func PublishRegistrySummary(store RegistryStore, runTime time.Time, runID, releaseVersion string, labels RunLabels) ([]RegistryValue, error) {
+	values := []RegistryValue{
+		{Name: "LastUpdateRun", Data: runTime.Format(time.RFC3339)},
+		{Name: "LastUpdateRunID", Data: runID},
+		{Name: "LastUpdateVersion", Data: releaseVersion},
+		{Name: "LastUpdateProgramVersion", Data: programVersion},
+		{Name: "LastUpdateTag", Data: labels.Tag},
+		{Name: "LastUpdateTicket", Data: labels.Ticket},
+	}
+	return values, store.Write(UpdaterRegistryDir, values)
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import (
+	"go.uber.org/zap"
+)
+
+// Restart Manager available only on Windows.
+func HandleLockedFile(targetFile string, retryCopy func() error, closeProcesses []string, events *HistoryEvents, logger *zap.Logger) error {
+	return ErrNotSupportedOnPlatform
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/sys/windows"
+	"strings"
+	"unsafe"
+)
+
+// Restart Manager constants.
+const (
+	rmSessionKeyLength = 32 // CCH_RM_SESSION_KEY
+	rmMaxAppName       = 255
+	rmMaxSvcName       = 63
+	rmErrorMoreData    = 234
+)
+
+var (
+	rstrtmgr                = windows.NewLazySystemDLL("rstrtmgr.dll")
+	procRmStartSession      = rstrtmgr.NewProc("RmStartSession")
+	procRmRegisterResources = rstrtmgr.NewProc("RmRegisterResources")
+	procRmGetList           = rstrtmgr.NewProc("RmGetList")
+	procRmShutdown          = rstrtmgr.NewProc("RmShutdown")
+	procRmRestart           = rstrtmgr.NewProc("RmRestart")
+	procRmEndSession        = rstrtmgr.NewProc("RmEndSession")
+)
+
+// RM_UNIQUE_PROCESS structure.
+type rmUniqueProcess struct {
+	ProcessID        uint32
+	ProcessStartTime windows.Filetime
+}
+
+// RM_PROCESS_INFO structure.
+type rmProcessInfo struct {
+	Process          rmUniqueProcess
+	AppName          [rmMaxAppName + 1]uint16
+	ServiceShortName [rmMaxSvcName + 1]uint16
+	ApplicationType  uint32
+	AppStatus        uint32
+	TSSessionID      uint32
+	Restartable      int32
+}
+
+// Process which holds file reported by Restart Manager.
+type LockingProcess struct {
+	PID     uint32
+	Name    string
+	Service string
+}
+
+// Restart Manager session with registered file.
+type RestartManagerSession struct {
+	handle uint32
+}
+
+// Start Restart Manager session and register file in it.
+func NewRestartManagerSession(path string) (*RestartManagerSession, error) {
+	var handle uint32
+	var sessionKey [rmSessionKeyLength + 1]uint16
+	ret, _, _ := procRmStartSession.Call(uintptr(unsafe.Pointer(&handle)), 0, uintptr(unsafe.Pointer(&sessionKey[0])))
+	if ret != 0 {
+		return nil, windows.Errno(ret)
+	}
+	session := &RestartManagerSession{handle: handle}
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		session.End()
+		return nil, err
+	}
+	ret, _, _ = procRmRegisterResources.Call(uintptr(handle), 1, uintptr(unsafe.Pointer(&pathPtr)), 0, 0, 0, 0)
+	if ret != 0 {
+		session.End()
+		return nil, windows.Errno(ret)
+	}
+	return session, nil
+}
+
+// Get processes which use registered file.
+func (rms *RestartManagerSession) Lockers() ([]LockingProcess, error) {
+	var needed, count, rebootReasons uint32
+	infos := make([]rmProcessInfo, 0)
+	for {
+		count = uint32(len(infos))
+		var infosPtr uintptr
+		if count > 0 {
+			infosPtr = uintptr(unsafe.Pointer(&infos[0]))
+		}
+		ret, _, _ := procRmGetList.Call(
+			uintptr(rms.handle),
+			uintptr(unsafe.Pointer(&needed)),
+			uintptr(unsafe.Pointer(&count)),
+			infosPtr,
+			uintptr(unsafe.Pointer(&rebootReasons)),
+		)
+		if ret == rmErrorMoreData {
+			infos = make([]rmProcessInfo, needed)
+			continue
+		}
+		if ret != 0 {
+			return nil, windows.Errno(ret)
+		}
+		break
+	}
+	lockers := make([]LockingProcess, 0, count)
+	for _, info := range infos[:count] {
+		lockers = append(lockers, LockingProcess{
+			PID:     info.Process.ProcessID,
+			Name:    windows.UTF16ToString(info.AppName[:]),
+			Service: windows.UTF16ToString(info.ServiceShortName[:]),
+		})
+	}
+	return lockers, nil
+}
+
+// Gracefully shut down processes which use registered file.
+func (rms *RestartManagerSession) Shutdown() error {
+	ret, _, _ := procRmShutdown.Call(uintptr(rms.handle), 0, 0)
+	if ret != 0 {
+		return windows.Errno(ret)
+	}
+	return nil
+}
+
+// Restart processes stopped by Shutdown.
+func (rms *RestartManagerSession) Restart() error {
+	ret, _, _ := procRmRestart.Call(uintptr(rms.handle), 0, 0)
+	if ret != 0 {
+		return windows.Errno(ret)
+	}
+	return nil
+}
+
+// End Restart Manager session.
+func (rms *RestartManagerSession) End() {
+	procRmEndSession.Call(uintptr(rms.handle))
+}
+
+// Format lockers list for log and history.
+func FormatLockingProcesses(lockers []LockingProcess) string {
+	names := make([]string, 0, len(lockers))
+	for _, locker := range lockers {
+		name := fmt.Sprintf("%v (PID %v)", locker.Name, locker.PID)
+		if locker.Service != "" {
+			name = fmt.Sprintf("%v (service %v, PID %v)", locker.Name, locker.Service, locker.PID)
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Check if every locker name contains one of allowed names (case insensitive).
+func AllLockersAllowed(lockers []LockingProcess, allowed []string) bool {
+	if len(lockers) == 0 || len(allowed) == 0 {
+		return false
+	}
+	for _, locker := range lockers {
+		found := false
+		for _, name := range allowed {
+			if strings.Contains(strings.ToLower(locker.Name), strings.ToLower(name)) ||
+				strings.EqualFold(locker.Service, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Handle file which can't be replaced. Report processes which hold it into log and history.
+// If all of them allowed by closeProcesses, they closed, copy retried and processes restarted.
+// Return nil if retried copy succeeded.
+func HandleLockedFile(targetFile string, retryCopy func() error, closeProcesses []string, events *HistoryEvents, logger *zap.Logger) error {
+	session, err := NewRestartManagerSession(targetFile)
+	if err != nil {
+		return fmt.Errorf("can't start Restart Manager session - %v", err)
+	}
+	defer session.End()
+	lockers, err := session.Lockers()
+	if err != nil {
+		return fmt.Errorf("can't get locking processes - %v", err)
+	}
+	if len(lockers) == 0 {
+		return fmt.Errorf("no locking processes found for '%v'", targetFile)
+	}
+	lockersText := FormatLockingProcesses(lockers)
+	logger.Warn(fmt.Sprintf("File '%v' locked by %v", targetFile, lockersText))
+	events.Add("File '%v' locked by %v", targetFile, lockersText)
+	if !AllLockersAllowed(lockers, closeProcesses) {
+		return fmt.Errorf("file '%v' locked by %v", targetFile, lockersText)
+	}
+
+	logger.Info(fmt.Sprint("Close locking processes ", lockersText))
+	err = session.Shutdown()
+	if err != nil {
+		return fmt.Errorf("can't close locking processes - %v", err)
+	}
+	events.Add("Closed %v to replace '%v'", lockersText, targetFile)
+	copyErr := retryCopy()
+	err = session.Restart()
+	if err != nil {
+		logger.Warn(fmt.Sprint("Can't restart closed processes - ", err))
+		events.Add("Can't restart %v - %v", lockersText, err)
+	}
+	return copyErr
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	RetryDefaultDelay    time.Duration = time.Minute      // Pause before first retry of whole run by default.
+	RetryDefaultMaxDelay time.Duration = 15 * time.Minute // Longest pause between retries by default.
+)
+
+// Check if error caused by transient infrastructure failure, e.g. share temporarily unreachable.
+func IsTransientError(err error) bool {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return transientErrnos[errno] || errno.Timeout()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Parse pauses between retries of whole run from config.
+func RetryDelays(mainConfig MainCfgYAML) (time.Duration, time.Duration, error) {
+	delay, maxDelay := RetryDefaultDelay, RetryDefaultMaxDelay
+	var err error
+	if mainConfig.Run.RetryDelay != "" {
+		delay, err = time.ParseDuration(mainConfig.Run.RetryDelay)
+		if err != nil {
+			return 0, 0, fmt.Errorf("can't parse Run.RetryDelay - %v", err)
+		}
+	}
+	if mainConfig.Run.RetryMaxDelay != "" {
+		maxDelay, err = time.ParseDuration(mainConfig.Run.RetryMaxDelay)
+		if err != nil {
+			return 0, 0, fmt.Errorf("can't parse Run.RetryMaxDelay - %v", err)
+		}
+	}
+	if delay < 0 || maxDelay < delay {
+		return 0, 0, fmt.Errorf("Run.RetryDelay must not be negative or exceed Run.RetryMaxDelay")
+	}
+	return delay, maxDelay, nil
+}
+
+// Return pause before retry after provided number of failed retries. Pause doubled after each retry up to maxDelay,
+// then random part of its second half taken, so machines failed by the same outage not retry at the same time.
+func RetryPause(delay, maxDelay time.Duration, retry int, random *rand.Rand) time.Duration {
+	pause := delay
+	for i := 0; i < retry && pause < maxDelay; i++ {
+		pause *= 2
+	}
+	if pause > maxDelay {
+		pause = maxDelay
+	}
+	return pause/2 + time.Duration(random.Int63n(int64(pause/2)+1))
+}
+
+// Run update and retry whole run up to Run.Retries times while it failed by transient error.
+// Failure which will be retried not notified, summary of last attempt returned.
+func RunUpdateWithRetries(mainConfig MainCfgYAML, programDirectory string, registryStore RegistryStore, reload *ConfigReload) RunSummary {
+	retries := mainConfig.Run.Retries
+	delay, maxDelay, err := RetryDelays(mainConfig)
+	if err != nil {
+		log.Println("Invalid retry settings, run not retried -", err)
+		retries = 0
+	}
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for retry := 0; ; retry++ {
+		attemptConfig := mainConfig
+		attemptConfig.Run.Retries = retries - retry // Retries left, checked when run finished.
+		summary := RunUpdate(attemptConfig, programDirectory, registryStore, reload)
+		if !summary.Retrying {
+			return summary
+		}
+		pause := RetryPause(delay, maxDelay, retry, random)
+		log.Printf("Run failed by transient error in phase '%v', retry %v of %v in %v - %v", summary.Phase, retry+1, retries, pause.Round(time.Second), summary.Error)
+		time.Sleep(pause)
+		reload = nil // Config reload already logged by first attempt.
+	}
+}
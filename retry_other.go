@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"syscall"
+)
+
+// Network errors of unreachable or dropped mount, expected to pass by themselves.
+var transientErrnos = map[syscall.Errno]bool{
+	syscall.ENETDOWN:     true,
+	syscall.ENETUNREACH:  true,
+	syscall.EHOSTDOWN:    true,
+	syscall.EHOSTUNREACH: true,
+	syscall.ECONNRESET:   true,
+	syscall.ECONNREFUSED: true,
+	syscall.ETIMEDOUT:    true,
+	syscall.ESTALE:       true,
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// Network errors of unreachable or dropped share, expected to pass by themselves.
+var transientErrnos = map[syscall.Errno]bool{
+	windows.ERROR_BAD_NETPATH:         true,
+	windows.ERROR_NETWORK_BUSY:        true,
+	windows.ERROR_UNEXP_NET_ERR:       true,
+	windows.ERROR_NETNAME_DELETED:     true,
+	windows.ERROR_BAD_NET_NAME:        true,
+	windows.ERROR_SEM_TIMEOUT:         true,
+	windows.ERROR_NO_NET_OR_BAD_PATH:  true,
+	windows.ERROR_NETWORK_UNREACHABLE: true,
+	windows.ERROR_HOST_UNREACHABLE:    true,
+	windows.ERROR_CONNECTION_ABORTED:  true,
+	windows.ERROR_NOT_CONNECTED:       true,
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// History header lines with run labels.
+const (
+	HistoryTagPrefix    string = "Tag: "    // Run tag from --tag flag.
+	HistoryTicketPrefix string = "Ticket: " // Change ticket from --ticket flag.
+	MaxRunLabelLength   int    = 128        // Maximum length of tag and ticket.
+)
+
+// Labels tying run to change management, e.g. tag "wave-2" and ticket "CHG0012345".
+type RunLabels struct {
+	Tag    string `json:"tag,omitempty"`
+	Ticket string `json:"ticket,omitempty"`
+}
+
+// Return labels provided by command line flags.
+func CurrentRunLabels() RunLabels {
+	return RunLabels{Tag: strings.TrimSpace(*tagFlag), Ticket: strings.TrimSpace(*ticketFlag)}
+}
+
+// Check labels fit in one line of history header.
+func (rl RunLabels) Validate() error {
+	for _, label := range [][2]string{{"tag", rl.Tag}, {"ticket", rl.Ticket}} {
+		name, value := label[0], label[1]
+		if len(value) > MaxRunLabelLength {
+			return fmt.Errorf("%v longer than %v characters", name, MaxRunLabelLength)
+		}
+		if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return fmt.Errorf("%v contains control characters", name)
+		}
+	}
+	return nil
+}
+
+// Return history header lines of provided labels.
+func (rl RunLabels) HistoryLines() []string {
+	lines := make([]string, 0, 2)
+	if rl.Tag != "" {
+		lines = append(lines, fmt.Sprint(HistoryTagPrefix, rl.Tag))
+	}
+	if rl.Ticket != "" {
+		lines = append(lines, fmt.Sprint(HistoryTicketPrefix, rl.Ticket))
+	}
+	return lines
+}
+
+// Return environment variables with labels for notification command.
+func (rl RunLabels) Environment() []string {
+	return []string{fmt.Sprint("WDE_RUN_TAG=", rl.Tag), fmt.Sprint("WDE_RUN_TICKET=", rl.Ticket)}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+const StatusPipeName string = `\\.\pipe\wdeCustomizationUpdater` // Named pipe serving RunStatus JSON.
+
+// Status of update process for local clients: tray helper and "status" command.
+type RunStatus struct {
+	ProgramVersion  string         `json:"programVersion"`
+	PID             int            `json:"pid"`
+	Running         bool           `json:"running"`                   // Update run in progress.
+	StartTime       time.Time      `json:"startTime,omitempty"`       // Start of current run.
+	Phase           string         `json:"phase,omitempty"`           // Current phase of running update.
+	Progress        *ProgressEvent `json:"progress,omitempty"`        // Last progress event of current run.
+	LastRun         *RunSummary    `json:"lastRun,omitempty"`         // Summary of last finished run of this process.
+	LastSuccessTime time.Time      `json:"lastSuccessTime,omitempty"` // End of last successful run of this process.
+}
+
+// Thread safe holder of status shared with status pipe server.
+type RunStatusPublisher struct {
+	mutex  sync.Mutex
+	status RunStatus
+	active int // Runs in progress, targets updated concurrently.
+}
+
+// Status of this process.
+var runStatus = NewRunStatusPublisher()
+
+// Create publisher for current process without runs.
+func NewRunStatusPublisher() *RunStatusPublisher {
+	return &RunStatusPublisher{status: RunStatus{ProgramVersion: programVersion, PID: os.Getpid()}}
+}
+
+// Mark run started. Start time of first concurrent run kept.
+func (rsp *RunStatusPublisher) Start(startTime time.Time) {
+	rsp.mutex.Lock()
+	defer rsp.mutex.Unlock()
+	rsp.active++
+	rsp.status.Running = true
+	if rsp.active > 1 {
+		return
+	}
+	rsp.status.StartTime = startTime
+	rsp.status.Phase = ""
+	rsp.status.Progress = nil
+}
+
+// Progress stream subscriber. Phase start events have empty item.
+func (rsp *RunStatusPublisher) Update(event ProgressEvent) {
+	rsp.mutex.Lock()
+	defer rsp.mutex.Unlock()
+	rsp.status.Phase = event.Phase
+	if event.Item == "" {
+		rsp.status.Progress = nil
+		return
+	}
+	rsp.status.Progress = &event
+}
+
+// Mark run finished with provided summary. Process still running while other concurrent runs not finished.
+func (rsp *RunStatusPublisher) Finish(summary RunSummary) {
+	rsp.mutex.Lock()
+	defer rsp.mutex.Unlock()
+	rsp.status.LastRun = &summary
+	if summary.Result == RunResultSuccess {
+		rsp.status.LastSuccessTime = summary.EndTime
+	}
+	if rsp.active > 1 {
+		rsp.active--
+		return
+	}
+	rsp.active = 0
+	rsp.status.Running = false
+	rsp.status.Phase = ""
+	rsp.status.Progress = nil
+}
+
+// Return copy of current status.
+func (rsp *RunStatusPublisher) Status() RunStatus {
+	rsp.mutex.Lock()
+	defer rsp.mutex.Unlock()
+	return rsp.status
+}
+
+// Return current status as JSON.
+func (rsp *RunStatusPublisher) JSON() ([]byte, error) {
+	rsp.mutex.Lock()
+	defer rsp.mutex.Unlock()
+	return json.Marshal(rsp.status)
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+// Named pipes available only on Windows.
+func ServeStatusPipe(publisher *RunStatusPublisher) error {
+	return ErrNotSupportedOnPlatform
+}
+
+// Named pipes available only on Windows.
+func QueryStatusPipe() (RunStatus, error) {
+	return RunStatus{}, ErrNotSupportedOnPlatform
+}
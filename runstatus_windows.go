@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"io/ioutil"
+	"os"
+	"unsafe"
+)
+
+// Pipe available to SYSTEM and Administrators, readable by interactive owner (placeholder for SID),
+// so tray helper of agent can query update running as SYSTEM.
+const statusPipeSDDL = "D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GR;;;%v)"
+
+// Get SID of user logged on console, so tray helper of that user can read pipe of update running as SYSTEM.
+// User running process if console user unknown or process run not as SYSTEM.
+func statusPipeOwnerSID() string {
+	var token windows.Token
+	err := windows.WTSQueryUserToken(windows.WTSGetActiveConsoleSessionId(), &token)
+	if err != nil {
+		return CurrentUserSID()
+	}
+	defer token.Close()
+	tokenUser, err := token.GetTokenUser()
+	if err != nil {
+		return CurrentUserSID()
+	}
+	return tokenUser.User.Sid.String()
+}
+
+// Serve status JSON on named pipe in background, one client at a time.
+// Fail if pipe already served by another instance.
+func ServeStatusPipe(publisher *RunStatusPublisher) error {
+	ownerSID := statusPipeOwnerSID()
+	if ownerSID == "" {
+		ownerSID = "SY"
+	}
+	securityDescriptor, err := windows.SecurityDescriptorFromString(fmt.Sprintf(statusPipeSDDL, ownerSID))
+	if err != nil {
+		return err
+	}
+	securityAttributes := &windows.SecurityAttributes{SecurityDescriptor: securityDescriptor}
+	securityAttributes.Length = uint32(unsafe.Sizeof(*securityAttributes))
+	pipeName, err := windows.UTF16PtrFromString(StatusPipeName)
+	if err != nil {
+		return err
+	}
+	pipe, err := windows.CreateNamedPipe(
+		pipeName,
+		windows.PIPE_ACCESS_OUTBOUND|windows.FILE_FLAG_FIRST_PIPE_INSTANCE,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_WAIT,
+		1, 4096, 0, 0,
+		securityAttributes,
+	)
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer windows.CloseHandle(pipe)
+		for {
+			err := windows.ConnectNamedPipe(pipe, nil)
+			if err != nil && err != windows.ERROR_PIPE_CONNECTED {
+				return
+			}
+			statusBytes, err := publisher.JSON()
+			if err == nil {
+				var written uint32
+				windows.WriteFile(pipe, statusBytes, &written, nil)
+				windows.FlushFileBuffers(pipe)
+			}
+			windows.DisconnectNamedPipe(pipe)
+		}
+	}()
+	return nil
+}
+
+// Read status of running update process from named pipe.
+func QueryStatusPipe() (RunStatus, error) {
+	pipe, err := os.Open(StatusPipeName)
+	if err != nil {
+		return RunStatus{}, err
+	}
+	defer pipe.Close()
+	statusBytes, err := ioutil.ReadAll(pipe)
+	if err != nil {
+		return RunStatus{}, err
+	}
+	var status RunStatus
+	err = json.Unmarshal(statusBytes, &status)
+	return status, err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const ScanCacheFileName string = "ScanCache.json" // Name of collection scan cache file in state folder.
+
+// Hash and version of source files from previous collection.
+// Files with unchanged size and modification time not re-read.
+type ScanCache struct {
+	Files     map[string]ScanCacheEntry `json:"files"` // Key is lower case full path of source file.
+	seen      map[string]ScanCacheEntry // Entries of files collected by current run, only they saved.
+	Reused    int                       `json:"-"`
+	Rescanned int                       `json:"-"`
+}
+
+// Collected data of one source file.
+type ScanCacheEntry struct {
+	Size          int64     `json:"size"`
+	LastWriteTime time.Time `json:"lastWriteTime"`
+	Hash          string    `json:"hash"`
+	Version       string    `json:"version,omitempty"`
+}
+
+// Get scan cache file path from config.
+func ScanCacheFilePath(mainConfig MainCfgYAML, programDirectory string) string {
+	return filepath.Join(StateFolderPath(mainConfig, programDirectory), ScanCacheFileName)
+}
+
+// Read scan cache from file. Return empty cache if file not exists or damaged, all files rescanned then.
+func ReadScanCache(cacheFullPath string) (*ScanCache, error) {
+	cache := &ScanCache{Files: make(map[string]ScanCacheEntry), seen: make(map[string]ScanCacheEntry)}
+	cacheBytes, err := ioutil.ReadFile(cacheFullPath)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return cache, err
+	}
+	err = json.Unmarshal(cacheBytes, cache)
+	if err != nil || cache.Files == nil {
+		cache.Files = make(map[string]ScanCacheEntry)
+	}
+	return cache, err
+}
+
+// Extract file info as ExtractCustomFileInfo, but take hash and version from cache
+// if size and modification time of file not changed. Nil cache extract everything.
+func (sc *ScanCache) Extract(fileInfo os.FileInfo, fullPath, basePath string) (CustomisationFile, error) {
+	if sc == nil {
+		return ExtractCustomFileInfo(fileInfo, fullPath, basePath)
+	}
+	key := strings.ToLower(filepath.Clean(fullPath))
+	entry, ok := sc.Files[key]
+	if ok && entry.Size == fileInfo.Size() && entry.LastWriteTime.Equal(fileInfo.ModTime()) {
+		version, err := ParseFileVersion(entry.Version)
+		if err == nil {
+			file, err := NewCustomFileInfo(fileInfo, fullPath, basePath, version, entry.Hash)
+			if err != nil {
+				return CustomisationFile{}, err
+			}
+			sc.seen[key] = entry
+			sc.Reused++
+			return file, nil
+		}
+	}
+	file, err := ExtractCustomFileInfo(fileInfo, fullPath, basePath)
+	if err != nil {
+		return CustomisationFile{}, err
+	}
+	sc.seen[key] = ScanCacheEntry{Size: file.Size, LastWriteTime: file.LastWriteTime, Hash: file.Hash, Version: file.Version.String()}
+	sc.Rescanned++
+	return file, nil
+}
+
+// Save entries of files collected by current run, removed files dropped from cache.
+func (sc *ScanCache) Save(cacheFullPath string) error {
+	cacheBytes, err := json.Marshal(ScanCache{Files: sc.seen})
+	if err != nil {
+		return err
+	}
+	return SaveBytesIntoFile(cacheFullPath, cacheBytes)
+}
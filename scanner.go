@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ScannerDefaultTimeout time.Duration = 5 * time.Minute // Timeout of single scanner request by default.
+	ScannerBatchSize      int           = 500             // Files in one scanner request.
+	ScannerVerdictClean   string        = "clean"         // Only verdict which allows deployment.
+	ScannerOnErrorFail    string        = "fail"          // Scanner unavailable, run failed before copy.
+	ScannerOnErrorAllow   string        = "allow"         // Scanner unavailable, files deployed unscanned.
+)
+
+// Files to deploy sent by POST to external scanner or allowlist service.
+type ScanRequest struct {
+	Hostname string            `json:"hostname"`
+	RunID    string            `json:"runId"` // Start time string of run, as in log and history file names.
+	Files    []ScanRequestFile `json:"files"`
+}
+
+// File submitted for scan. Path is source path readable by scanner, hash is SHA-256 of content.
+type ScanRequestFile struct {
+	Path         string `json:"path"`
+	RelativePath string `json:"relativePath"` // Path inside WDE folder.
+	Hash         string `json:"hash"`
+	Size         int64  `json:"size"`
+}
+
+// Response of scanner with verdict for each submitted file, matched by hash.
+type ScanResponse struct {
+	Verdicts []ScanVerdict `json:"verdicts"`
+}
+
+// Verdict of scanner for one file. Files without verdict treated as not clean.
+type ScanVerdict struct {
+	Hash    string `json:"hash"`
+	Verdict string `json:"verdict"` // "clean" or anything else, e.g. "malicious", "unknown".
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Send files to scanner in batches. Return verdicts by lower case hash.
+// Copy verifies hash of copied bytes, so only content with verdict hash deployed.
+func RequestScanVerdicts(mainConfig MainCfgYAML, files []CustomisationFile, runID string) (map[string]ScanVerdict, error) {
+	timeout := ScannerDefaultTimeout
+	if mainConfig.Scanner.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(mainConfig.Scanner.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse Scanner.Timeout - %v", err)
+		}
+	}
+	hostname, _ := os.Hostname()
+	client := http.Client{Timeout: timeout}
+	verdicts := make(map[string]ScanVerdict, len(files))
+	for start := 0; start < len(files); start += ScannerBatchSize {
+		end := start + ScannerBatchSize
+		if end > len(files) {
+			end = len(files)
+		}
+		request := ScanRequest{Hostname: hostname, RunID: runID, Files: make([]ScanRequestFile, 0, end-start)}
+		for _, file := range files[start:end] {
+			request.Files = append(request.Files, ScanRequestFile{
+				Path:         file.SourcePath,
+				RelativePath: filepath.Join(file.RelativePath, file.FileName),
+				Hash:         file.Hash,
+				Size:         file.Size,
+			})
+		}
+		requestBytes, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+		httpRequest, err := http.NewRequest(http.MethodPost, mainConfig.Scanner.URL, bytes.NewReader(requestBytes))
+		if err != nil {
+			return nil, err
+		}
+		httpRequest.Header.Set("Content-Type", "application/json")
+		if mainConfig.Scanner.Token != "" {
+			httpRequest.Header.Set("Authorization", fmt.Sprint("Bearer ", mainConfig.Scanner.Token))
+		}
+		response, err := client.Do(httpRequest)
+		if err != nil {
+			return nil, err
+		}
+		responseBytes, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("scanner response status - %v", response.Status)
+		}
+		var scanResponse ScanResponse
+		err = json.Unmarshal(responseBytes, &scanResponse)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse scanner response - %v", err)
+		}
+		for _, verdict := range scanResponse.Verdicts {
+			verdicts[strings.ToLower(verdict.Hash)] = verdict
+		}
+	}
+	return verdicts, nil
+}
+
+// Mark files without clean verdict as rejected and return files allowed for deployment.
+// Verdicts of rejected files recorded in history, clean ones only counted.
+func ApplyScanVerdicts(finalFiles, rowFiles []CustomisationFile, rowStatuses []FileStatus, verdicts map[string]ScanVerdict, events *HistoryEvents, logger *zap.Logger) []CustomisationFile {
+	allowed := make([]CustomisationFile, 0, len(finalFiles))
+	rejected := make(map[string]bool)
+	for _, file := range finalFiles {
+		path := filepath.Join(file.RelativePath, file.FileName)
+		verdict, ok := verdicts[strings.ToLower(file.Hash)]
+		if !ok {
+			verdict = ScanVerdict{Hash: file.Hash, Verdict: "missing", Reason: "no verdict returned by scanner"}
+		}
+		if strings.EqualFold(verdict.Verdict, ScannerVerdictClean) {
+			allowed = append(allowed, file)
+			continue
+		}
+		rejected[file.SourcePath] = true
+		logger.Warn(fmt.Sprintf("File '%v' from '%v' rejected by scanner, verdict '%v' - %v", path, file.SourcePath, verdict.Verdict, verdict.Reason))
+		events.Add("Scanner verdict '%v' for '%v' (%v), not deployed - %v", verdict.Verdict, path, file.SourcePath, verdict.Reason)
+	}
+	for id, file := range rowFiles {
+		if rejected[file.SourcePath] && id < len(rowStatuses) {
+			rowStatuses[id] = StatusRejected
+		}
+	}
+	events.Add("Scanner checked %v files, %v clean, %v rejected", len(finalFiles), len(allowed), len(finalFiles)-len(allowed))
+	return allowed
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const (
+	CredentialTargetPrefix string = "wdeUpdater/" // Prefix of Windows Credential Manager entries used by "${cred:NAME}".
+)
+
+// Secret reference in config string values:
+// "${cred:NAME}" - password of generic Windows Credential Manager entry "wdeUpdater/NAME";
+// "${dpapi:BASE64}" - DPAPI encrypted blob, as printed by "secret set -dpapi".
+var reSecretReference = regexp.MustCompile(`\$\{(cred|dpapi):([^}]+)\}`)
+
+// Replace secret references in all config string values by secret values.
+// Config with references kept for logging, resolved config used only for run.
+func ResolveConfigSecrets(mainConfig MainCfgYAML) (MainCfgYAML, error) {
+	resolved := mainConfig
+	err := resolveSecretsValue(reflect.ValueOf(&resolved).Elem())
+	if err != nil {
+		return mainConfig, err
+	}
+	return resolved, nil
+}
+
+// Recursively resolve secret references in strings of struct, slice or string value.
+// Slices copied before change, so original config not affected.
+func resolveSecretsValue(value reflect.Value) error {
+	switch value.Kind() {
+	case reflect.String:
+		resolved, err := ResolveSecretReferences(value.String())
+		if err != nil {
+			return err
+		}
+		value.SetString(resolved)
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if !value.Field(i).CanSet() {
+				continue
+			}
+			err := resolveSecretsValue(value.Field(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if value.IsNil() {
+			return nil
+		}
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		reflect.Copy(copied, value)
+		for i := 0; i < copied.Len(); i++ {
+			err := resolveSecretsValue(copied.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		value.Set(copied)
+	}
+	return nil
+}
+
+// Replace secret references in text by secret values.
+func ResolveSecretReferences(text string) (string, error) {
+	if !strings.Contains(text, "${") {
+		return text, nil
+	}
+	var resolveErr error
+	resolved := reSecretReference.ReplaceAllStringFunc(text, func(reference string) string {
+		match := reSecretReference.FindStringSubmatch(reference)
+		var secret string
+		var err error
+		switch match[1] {
+		case "cred":
+			secret, err = ReadCredential(fmt.Sprint(CredentialTargetPrefix, match[2]))
+		case "dpapi":
+			var blob []byte
+			blob, err = base64.StdEncoding.DecodeString(match[2])
+			if err == nil {
+				var data []byte
+				data, err = DPAPIDecrypt(blob)
+				secret = string(data)
+			}
+		}
+		if err != nil && resolveErr == nil {
+			resolveErr = fmt.Errorf("can't resolve secret '${%v:...}' - %v", match[1], err)
+		}
+		return secret
+	})
+	if resolveErr != nil {
+		return text, resolveErr
+	}
+	return resolved, nil
+}
+
+// Run "secret" subcommand.
+// "secret set NAME" save secret into Windows Credential Manager for "${cred:NAME}" reference.
+// "secret set -dpapi [-machine]" print "${dpapi:...}" reference with encrypted secret.
+func RunSecretCommand(args []string) error {
+	usage := fmt.Errorf("usage: secret set NAME | secret set -dpapi [-machine]")
+	if len(args) == 0 || args[0] != "set" {
+		return usage
+	}
+	flags := flag.NewFlagSet("secret set", flag.ContinueOnError)
+	dpapi := flags.Bool("dpapi", false, "print DPAPI encrypted reference instead of saving into Credential Manager")
+	machine := flags.Bool("machine", false, "DPAPI blob can be decrypted by any user of this machine")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	if *dpapi == (flags.NArg() == 1) || flags.NArg() > 1 {
+		return usage
+	}
+
+	if Unattended() {
+		return ErrInteractionNotAllowed
+	}
+	fmt.Print("Secret value: ")
+	secret, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	secret = strings.TrimRight(secret, "\r\n")
+	if secret == "" {
+		return fmt.Errorf("empty secret")
+	}
+
+	if *dpapi {
+		blob, err := DPAPIEncrypt([]byte(secret), *machine)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("${dpapi:%v}\n", base64.StdEncoding.EncodeToString(blob))
+		return nil
+	}
+	name := flags.Arg(0)
+	err = WriteCredential(fmt.Sprint(CredentialTargetPrefix, name), secret)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Secret saved, use ${cred:%v} in config\n", name)
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+// Windows Credential Manager available only on Windows.
+func ReadCredential(target string) (string, error) {
+	return "", ErrNotSupportedOnPlatform
+}
+
+// Windows Credential Manager available only on Windows.
+func WriteCredential(target, secret string) error {
+	return ErrNotSupportedOnPlatform
+}
+
+// DPAPI available only on Windows.
+func DPAPIEncrypt(data []byte, machine bool) ([]byte, error) {
+	return nil, ErrNotSupportedOnPlatform
+}
+
+// DPAPI available only on Windows.
+func DPAPIDecrypt(blob []byte) ([]byte, error) {
+	return nil, ErrNotSupportedOnPlatform
+}
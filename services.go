@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// Default timeout for stop and start of service or process.
+const StopStartDefaultTimeout = 60 * time.Second
+
+// Process stopped before update and started again after Deployment Manager.
+type ManagedProcess struct {
+	Name           string   `yaml:"Name"`           // Executable name, e.g. "KioskLauncher.exe".
+	RestartCommand []string `yaml:"RestartCommand"` // Command with arguments to start process again. Not restarted if empty.
+}
+
+// Services and processes actually stopped by StopServicesAndProcesses.
+type StoppedItems struct {
+	Services  []string
+	Processes []ManagedProcess
+}
+
+// Services and processes stopped for concurrent runs of process, e.g. targets. Stopped by first run,
+// started when last run finished, so one target never starts them while another changes its WDE folder.
+var sharedStop struct {
+	mutex   sync.Mutex
+	runs    int
+	stopped StoppedItems
+	timeout time.Duration
+	failure error // Stop failure of first run, returned to runs joined it.
+}
+
+// Stop services and processes from StopBeforeUpdate before WDE folder changed.
+// Return function which starts stopped items again, it must be called also on error.
+// Runs of process share stop: items already stopped by another running run not stopped again
+// and started only by last run. Stop failure returned only if StopBeforeUpdate.FailOnError set, otherwise logged.
+func StopConfiguredServices(mainConfig MainCfgYAML, events *HistoryEvents, logger *zap.Logger) (func(), error) {
+	stopStartTimeout := StopStartDefaultTimeout
+	if mainConfig.StopBeforeUpdate.Timeout != "" {
+		var err error
+		stopStartTimeout, err = time.ParseDuration(mainConfig.StopBeforeUpdate.Timeout)
+		if err != nil {
+			return func() {}, fmt.Errorf("can't parse StopBeforeUpdate.Timeout - %v", err)
+		}
+	}
+	sharedStop.mutex.Lock()
+	defer sharedStop.mutex.Unlock()
+	var released sync.Once
+	start := func() {
+		released.Do(func() {
+			sharedStop.mutex.Lock()
+			defer sharedStop.mutex.Unlock()
+			sharedStop.runs--
+			if sharedStop.runs == 0 {
+				StartStoppedItems(sharedStop.stopped, sharedStop.timeout, events, logger)
+				sharedStop.stopped = StoppedItems{}
+			}
+		})
+	}
+	if sharedStop.runs > 0 {
+		sharedStop.runs++
+		logger.Info("Services and processes already stopped by another run")
+		if sharedStop.failure != nil && mainConfig.StopBeforeUpdate.FailOnError {
+			return start, fmt.Errorf("fail stop services and processes - %v", sharedStop.failure)
+		}
+		return start, nil
+	}
+	stoppedItems, err := StopServicesAndProcesses(
+		mainConfig.StopBeforeUpdate.Services,
+		mainConfig.StopBeforeUpdate.Processes,
+		stopStartTimeout,
+		events,
+		logger,
+	)
+	sharedStop.runs = 1
+	sharedStop.stopped = stoppedItems
+	sharedStop.timeout = stopStartTimeout
+	sharedStop.failure = err
+	if err != nil {
+		if mainConfig.StopBeforeUpdate.FailOnError {
+			return start, fmt.Errorf("fail stop services and processes - %v", err)
+		}
+		logger.Warn(fmt.Sprint("Fail stop services and processes - ", err))
+	}
+	return start, nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"go.uber.org/zap"
+	"time"
+)
+
+// Services and processes can be stopped only on Windows.
+func StopServicesAndProcesses(services []string, processes []ManagedProcess, timeout time.Duration, events *HistoryEvents, logger *zap.Logger) (StoppedItems, error) {
+	if len(services) > 0 || len(processes) > 0 {
+		return StoppedItems{}, ErrNotSupportedOnPlatform
+	}
+	return StoppedItems{}, nil
+}
+
+// Nothing stopped outside Windows.
+func StartStoppedItems(stopped StoppedItems, timeout time.Duration, events *HistoryEvents, logger *zap.Logger) {
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+	"os/exec"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// Stop configured services and processes. Only running ones stopped and returned for later start.
+// Process at first asked to close and killed if it not exited in half of timeout.
+// Failure of one item not stop the rest. Service asked to stop returned even if it not stopped in time,
+// so it started again after update. Errors of all failed items returned together.
+func StopServicesAndProcesses(services []string, processes []ManagedProcess, timeout time.Duration, events *HistoryEvents, logger *zap.Logger) (StoppedItems, error) {
+	var stopped StoppedItems
+	failures := make([]string, 0)
+	if len(services) > 0 {
+		manager, err := mgr.Connect()
+		if err != nil {
+			failures = append(failures, fmt.Sprint("can't connect to service manager - ", err))
+		} else {
+			stopped.Services, failures = stopServices(manager, services, timeout, events, logger)
+			manager.Disconnect()
+		}
+	}
+	for _, process := range processes {
+		if !IsProcessRunning(process.Name) {
+			continue
+		}
+		exec.Command("taskkill", "/IM", process.Name).Run()
+		if !WaitProcessExit(process.Name, timeout/2) {
+			logger.Warn(fmt.Sprintf("Process '%v' not closed gracefully, kill it", process.Name))
+			exec.Command("taskkill", "/F", "/IM", process.Name).Run()
+			if !WaitProcessExit(process.Name, timeout/2) {
+				logger.Error(fmt.Sprintf("Can't stop process '%v' in %v", process.Name, timeout))
+				events.Add("Process '%v' not stopped before update", process.Name)
+				failures = append(failures, fmt.Sprintf("can't stop process '%v' in %v", process.Name, timeout))
+				continue
+			}
+		}
+		logger.Info(fmt.Sprintf("Process '%v' stopped", process.Name))
+		events.Add("Process '%v' stopped before update", process.Name)
+		stopped.Processes = append(stopped.Processes, process)
+	}
+	if len(failures) > 0 {
+		return stopped, fmt.Errorf("%v", strings.Join(failures, "; "))
+	}
+	return stopped, nil
+}
+
+// Stop running services from list. Return services asked to stop and failures.
+func stopServices(manager *mgr.Mgr, services []string, timeout time.Duration, events *HistoryEvents, logger *zap.Logger) ([]string, []string) {
+	stopped := make([]string, 0, len(services))
+	failures := make([]string, 0)
+	for _, name := range services {
+		wasRunning, err := StopService(manager, name, timeout)
+		if wasRunning {
+			stopped = append(stopped, name)
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf("Can't stop service '%v' - %v", name, err))
+			events.Add("Service '%v' not stopped before update - %v", name, err)
+			failures = append(failures, fmt.Sprintf("can't stop service '%v' - %v", name, err))
+			continue
+		}
+		if wasRunning {
+			logger.Info(fmt.Sprintf("Service '%v' stopped", name))
+			events.Add("Service '%v' stopped before update", name)
+		}
+	}
+	return stopped, failures
+}
+
+// Start services and processes stopped before update. Failures only logged.
+func StartStoppedItems(stopped StoppedItems, timeout time.Duration, events *HistoryEvents, logger *zap.Logger) {
+	if len(stopped.Services) > 0 {
+		manager, err := mgr.Connect()
+		if err != nil {
+			logger.Error(fmt.Sprint("Can't connect to service manager to start services - ", err))
+		} else {
+			for _, name := range stopped.Services {
+				err = StartService(manager, name, timeout)
+				if err != nil {
+					logger.Error(fmt.Sprintf("Can't start service '%v' - %v", name, err))
+					events.Add("Service '%v' not started after update - %v", name, err)
+					continue
+				}
+				logger.Info(fmt.Sprintf("Service '%v' started", name))
+				events.Add("Service '%v' started after update", name)
+			}
+			manager.Disconnect()
+		}
+	}
+	for _, process := range stopped.Processes {
+		if len(process.RestartCommand) == 0 {
+			continue
+		}
+		err := exec.Command(process.RestartCommand[0], process.RestartCommand[1:]...).Start()
+		if err != nil {
+			logger.Error(fmt.Sprintf("Can't start process '%v' - %v", process.Name, err))
+			events.Add("Process '%v' not started after update - %v", process.Name, err)
+			continue
+		}
+		logger.Info(fmt.Sprintf("Process '%v' started", process.Name))
+		events.Add("Process '%v' started after update", process.Name)
+	}
+}
+
+// Stop service and wait until it stopped. Return false if service was not running.
+func StopService(manager *mgr.Mgr, name string, timeout time.Duration) (bool, error) {
+	service, err := manager.OpenService(name)
+	if err != nil {
+		return false, err
+	}
+	defer service.Close()
+	status, err := service.Query()
+	if err != nil {
+		return false, err
+	}
+	if status.State == svc.Stopped {
+		return false, nil
+	}
+	_, err = service.Control(svc.Stop)
+	if err != nil {
+		return true, err
+	}
+	return true, WaitServiceState(service, svc.Stopped, timeout)
+}
+
+// Start service and wait until it running.
+func StartService(manager *mgr.Mgr, name string, timeout time.Duration) error {
+	service, err := manager.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+	err = service.Start()
+	if err != nil {
+		return err
+	}
+	return WaitServiceState(service, svc.Running, timeout)
+}
+
+// Poll service status until it reach provided state.
+func WaitServiceState(service *mgr.Service, state svc.State, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := service.Query()
+		if err != nil {
+			return err
+		}
+		if status.State == state {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout %v waiting for service state %v", timeout, state)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Check if process with provided executable name running.
+func IsProcessRunning(name string) bool {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(snapshot)
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		if strings.EqualFold(windows.UTF16ToString(entry.ExeFile[:]), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Wait until process exited. Return false on timeout.
+func WaitProcessExit(name string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for IsProcessRunning(name) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return true
+}
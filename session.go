@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	SessionLockFileName       string        = "WdeCustomizationUpdater.lock" // Machine-wide run lock in workspace and WDE installation folder.
+	DefaultSessionLockTimeout time.Duration = 30 * time.Minute               // Wait for run in another session by default.
+	SessionLockPollInterval   time.Duration = 5 * time.Second                // Run lock check interval.
+)
+
+// Resolve WDE installation folder of user running updater.
+// Environment variables expanded, so installation per user can be set like "%LOCALAPPDATA%\Genesys".
+// On multi-session host Session.UserWDEInstallationFolder used if WDE installed there.
+func ResolveWDEInstallationFolder(mainConfig MainCfgYAML) string {
+	if mainConfig.Session.UserWDEInstallationFolder != "" && IsMultiSessionHost() {
+		userFolder := ExpandWindowsEnv(mainConfig.Session.UserWDEInstallationFolder)
+		if info, err := os.Stat(filepath.Join(userFolder, WDESubfolder)); err == nil && info.IsDir() {
+			return userFolder
+		}
+	}
+	return ExpandWindowsEnv(mainConfig.WDEInstallationFolder)
+}
+
+// Describe session of process for host facts, e.g. "3 (multi-session host)".
+func SessionDescription() string {
+	sessionID, err := CurrentSessionID()
+	if err != nil {
+		return ""
+	}
+	if IsMultiSessionHost() {
+		return fmt.Sprint(sessionID, " (multi-session host)")
+	}
+	return fmt.Sprint(sessionID)
+}
+
+// Get maximum wait for run in another session.
+func SessionLockTimeout(mainConfig MainCfgYAML) (time.Duration, error) {
+	if mainConfig.Session.LockTimeout == "" {
+		return DefaultSessionLockTimeout, nil
+	}
+	timeout, err := time.ParseDuration(mainConfig.Session.LockTimeout)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid Session.LockTimeout '%v'", mainConfig.Session.LockTimeout)
+	}
+	return timeout, nil
+}
+
+// Take machine-wide locks of workspace and WDE installation folder, so runs from different sessions
+// never change the same registry snapshots, state, ownership, baseline or WDE folder together.
+// Workspace always locked first. Wait while lock held by another run.
+// Missing installation folder not locked. Return function releasing locks.
+func AcquireRunLock(mainConfig MainCfgYAML, programDirectory string, logger *zap.Logger) (func(), error) {
+	timeout, err := SessionLockTimeout(mainConfig)
+	if err != nil {
+		return nil, err
+	}
+	workspace := WorkspaceFolderPath(mainConfig, programDirectory)
+	err = os.MkdirAll(workspace, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("can't create workspace folder '%v' - %v", workspace, err)
+	}
+	releaseWorkspace, err := waitLockFile(filepath.Join(workspace, SessionLockFileName), "workspace", timeout, logger)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(mainConfig.WDEInstallationFolder); os.IsNotExist(err) {
+		logger.Warn(fmt.Sprintf("WDE installation folder '%v' not exist, only workspace locked", mainConfig.WDEInstallationFolder))
+		return releaseWorkspace, nil
+	}
+	if filepath.Clean(mainConfig.WDEInstallationFolder) == filepath.Clean(workspace) {
+		return releaseWorkspace, nil
+	}
+	releaseFolder, err := waitLockFile(filepath.Join(mainConfig.WDEInstallationFolder, SessionLockFileName), "WDE folder", timeout, logger)
+	if err != nil {
+		releaseWorkspace()
+		return nil, err
+	}
+	return func() {
+		releaseFolder()
+		releaseWorkspace()
+	}, nil
+}
+
+// Take exclusive lock file, waiting up to timeout while it held by another run.
+func waitLockFile(lockPath, description string, timeout time.Duration, logger *zap.Logger) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		release, err := lockFileExclusive(lockPath)
+		if err != ErrRunLocked {
+			return release, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, fmt.Errorf("%v locked by run in another session longer than %v, lock file '%v'", description, timeout, lockPath)
+		}
+		if remaining > SessionLockPollInterval {
+			remaining = SessionLockPollInterval
+		}
+		logger.Info(fmt.Sprintf("Lock file '%v' held by run in another session, wait %v", lockPath, remaining))
+		time.Sleep(remaining)
+	}
+}
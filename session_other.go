@@ -0,0 +1,36 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// There are no Remote Desktop sessions outside Windows.
+func IsMultiSessionHost() bool {
+	return false
+}
+
+// Remote Desktop session available only on Windows.
+func CurrentSessionID() (uint32, error) {
+	return 0, ErrNotSupportedOnPlatform
+}
+
+// Lock file by flock, lock released by system if process terminated.
+func lockFileExclusive(lockPath string) (func(), error) {
+	lockFile, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		lockFile.Close()
+		return nil, ErrRunLocked
+	}
+	if err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+	return func() { lockFile.Close() }, nil
+}
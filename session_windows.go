@@ -0,0 +1,50 @@
+package main
+
+import (
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// Check if Windows runs as Remote Desktop Session Host (RDS, Citrix) or multi-session client edition.
+func IsMultiSessionHost() bool {
+	terminalServer, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\Terminal Server`, registry.QUERY_VALUE)
+	if err == nil {
+		appCompat, _, err := terminalServer.GetIntegerValue("TSAppCompat")
+		terminalServer.Close()
+		if err == nil && appCompat == 1 {
+			return true
+		}
+	}
+	currentVersion, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows NT\CurrentVersion`, registry.QUERY_VALUE)
+	if err != nil {
+		return false
+	}
+	defer currentVersion.Close()
+	edition, _, err := currentVersion.GetStringValue("EditionID")
+	return err == nil && edition == "ServerRdsh"
+}
+
+// Get Remote Desktop session ID of process.
+func CurrentSessionID() (uint32, error) {
+	var sessionID uint32
+	err := windows.ProcessIdToSessionId(windows.GetCurrentProcessId(), &sessionID)
+	return sessionID, err
+}
+
+// Open lock file without sharing, so it can't be opened by process in any session until closed.
+// Lock released by system if process terminated.
+func lockFileExclusive(lockPath string) (func(), error) {
+	pathPointer, err := windows.UTF16PtrFromString(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := windows.CreateFile(pathPointer, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil,
+		windows.OPEN_ALWAYS, windows.FILE_ATTRIBUTE_NORMAL|windows.FILE_FLAG_DELETE_ON_CLOSE, 0)
+	if err == windows.ERROR_SHARING_VIOLATION {
+		return nil, ErrRunLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() { windows.CloseHandle(handle) }, nil
+}
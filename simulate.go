@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Fixture directory layout for simulation.
+const (
+	SimulationCustomisations   string = "Customisations" // Customisation folders, used as CustomisationsFolder.
+	SimulationRegistrySeedFile string = "registry.yaml"  // Optional initial Deployment Manager registry values.
+	SimulationWorkspacePrefix  string = "wdeUpdaterSimulation_"
+	SimulationWDEFolder        string = "WDE"
+)
+
+// Prepare configuration and in-memory registry for simulation run seeded from fixture directory.
+// All artifacts (WDE folder, log, history, registry snapshots, state) redirected into new
+// temporary workspace, so machine is not affected. Return workspace used as program directory.
+func PrepareSimulation(fixtureDirectory string, mainConfig *MainCfgYAML) (string, *MemoryRegistry, error) {
+	fixtureConfig := FindConfigFile(fixtureDirectory)
+	if _, err := os.Stat(fixtureConfig); err == nil {
+		config, err := ReadConfigFile(fixtureConfig)
+		if err != nil {
+			return "", nil, err
+		}
+		*mainConfig = config
+	}
+	workspace, err := ioutil.TempDir("", SimulationWorkspacePrefix)
+	if err != nil {
+		return "", nil, err
+	}
+	mainConfig.CustomisationsFolder = filepath.Join(fixtureDirectory, SimulationCustomisations)
+	mainConfig.WDEInstallationFolder = filepath.Join(workspace, SimulationWDEFolder)
+	mainConfig.Workspace.Folder = workspace
+	mainConfig.Log.Folder = ""
+	mainConfig.History.Folder = ""
+	mainConfig.State.Folder = ""
+	mainConfig.Audit.Folder = ""
+	mainConfig.Cache.Folder = ""
+	mainConfig.Mirror.Folder = ""
+	mainConfig.Coordination.Folder = ""
+	mainConfig.Coordination.URL = ""
+	mainConfig.Approval.URL = ""
+	mainConfig.Targets = nil
+	mainConfig.Notify.Command = nil
+	mainConfig.StopBeforeUpdate.Services = nil
+	mainConfig.StopBeforeUpdate.Processes = nil
+	for id, source := range mainConfig.Sources {
+		if !filepath.IsAbs(source.Folder) {
+			mainConfig.Sources[id].Folder = filepath.Join(fixtureDirectory, source.Folder)
+		}
+		mainConfig.Sources[id].GitURL = ""
+	}
+
+	registryStore := NewMemoryRegistry()
+	seedBytes, err := ioutil.ReadFile(filepath.Join(fixtureDirectory, SimulationRegistrySeedFile))
+	if err == nil {
+		seed, err := UnmarshalRegistryData(seedBytes)
+		if err != nil {
+			return "", nil, fmt.Errorf("can't parse registry seed - %v", err)
+		}
+		err = registryStore.Write(DMRegistryDir, seed)
+		if err != nil {
+			return "", nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return "", nil, err
+	}
+	return workspace, registryStore, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// Customisation source from config. Each source contains customisation subfolders.
+// When files from different sources collide, file from source with higher precedence wins
+// before version comparison.
+type CustomisationSource struct {
+	Folder     string `yaml:"Folder"`     // Folder with customisation subfolders.
+	Precedence int    `yaml:"Precedence"` // Higher value wins.
+	GitURL     string `yaml:"GitURL"`     // If set, Folder cloned or pulled from Git repository before collection.
+	GitBranch  string `yaml:"GitBranch"`  // Branch for clone. Remote default branch if empty.
+}
+
+// Return all configured sources sorted by precedence descending.
+// CustomisationsFolder used as source with zero precedence.
+func ConfiguredSources(mainConfig MainCfgYAML) []CustomisationSource {
+	sources := make([]CustomisationSource, 0, len(mainConfig.Sources)+1)
+	if mainConfig.CustomisationsFolder != "" {
+		sources = append(sources, CustomisationSource{Folder: mainConfig.CustomisationsFolder})
+	}
+	sources = append(sources, mainConfig.Sources...)
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].Precedence > sources[j].Precedence
+	})
+	return sources
+}
+
+// Collect customisation folders and files from all sources.
+// Unavailable source fails collection, otherwise its deployed files look removed from sources
+// and orphans removed. Source error wrapped, so transient errors retried.
+// Return folders in "<source>\<folder>" form and all collected files.
+// Hash and version of unchanged files taken from scan cache if provided.
+func CollectFromSources(sources []CustomisationSource, cache *ScanCache, logger *zap.Logger) ([]string, []CustomisationFile, error) {
+	if len(sources) == 0 {
+		return nil, nil, fmt.Errorf("no customisation sources configured")
+	}
+	folders := make([]string, 0, 32)
+	files := make([]CustomisationFile, 0, 128)
+	for _, source := range sources {
+		logger.Info(fmt.Sprintf("Collect source '%v' with precedence %v", source.Folder, source.Precedence))
+		if source.GitURL != "" {
+			err := SyncGitSource(source, logger)
+			if err != nil {
+				logger.Warn(fmt.Sprint("Can't sync Git source, use existing content - ", err))
+			}
+		}
+		sourceFolders, err := GetCustomisationFoldersList(source.Folder)
+		if err != nil {
+			if len(sources) == 1 {
+				return nil, nil, err
+			}
+			return nil, nil, fmt.Errorf("source '%v' unavailable - %w", source.Folder, err)
+		}
+		for _, folder := range sourceFolders {
+			scanPath := filepath.Join(source.Folder, folder)
+			folderFiles, err := CollectCustomisationFiles(scanPath, scanPath, cache)
+			if err != nil {
+				return nil, nil, err
+			}
+			for id := range folderFiles {
+				folderFiles[id].SourceFolder = source.Folder
+				folderFiles[id].Precedence = source.Precedence
+				folderFiles[id].CustomisationFolder = scanPath
+			}
+			files = append(files, folderFiles...)
+			folders = append(folders, scanPath)
+		}
+	}
+	if len(folders) == 0 {
+		return nil, nil, fmt.Errorf("no customisation folders found in any source")
+	}
+	return folders, files, nil
+}
+
+// Clone Git source into its folder or pull changes if already cloned.
+func SyncGitSource(source CustomisationSource, logger *zap.Logger) error {
+	var cmd *exec.Cmd
+	if _, err := os.Stat(filepath.Join(source.Folder, ".git")); err == nil {
+		cmd = exec.Command("git", "-C", source.Folder, "pull", "--ff-only")
+	} else {
+		args := []string{"clone", "--depth", "1"}
+		if source.GitBranch != "" {
+			args = append(args, "--branch", source.GitBranch)
+		}
+		cmd = exec.Command("git", append(args, source.GitURL, source.Folder)...)
+	}
+	logger.Debug(fmt.Sprintf("Run '%+v'", cmd.Args))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v - %s", err, output)
+	}
+	return nil
+}
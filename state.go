@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Policies for State.RemoveOrphans option.
+const (
+	OrphansKeep   string = "keep"   // Leave previously deployed files (default).
+	OrphansAsk    string = "ask"    // Ask in console before remove.
+	OrphansRemove string = "remove" // Remove without asking.
+)
+
+// Per-machine state of the last successful deployment.
+type DeployedState struct {
+	RunTime time.Time           `json:"runTime"`
+	Files   []DeployedStateFile `json:"files"`
+}
+
+// One file deployed into WDE folder.
+type DeployedStateFile struct {
+	FileName            string `json:"fileName"`
+	RelativePath        string `json:"relativePath"`
+	Hash                string `json:"hash"`                // SHA-256 of deployed file.
+	CustomisationFolder string `json:"customisationFolder"` // Source customisation folder.
+}
+
+// Get state folder from config or default one, relative folders inside workspace.
+func StateFolderPath(mainConfig MainCfgYAML, programDirectory string) string {
+	return WorkspaceArtifactPath(mainConfig, programDirectory, mainConfig.State.Folder, "State")
+}
+
+// Read deployed state from file. Return empty state if file not exists.
+func ReadDeployedState(stateFileFullPath string) (DeployedState, error) {
+	stateBytes, err := ioutil.ReadFile(stateFileFullPath)
+	if os.IsNotExist(err) {
+		return DeployedState{}, nil
+	}
+	if err != nil {
+		return DeployedState{}, err
+	}
+	var state DeployedState
+	err = json.Unmarshal(stateBytes, &state)
+	if err != nil {
+		return DeployedState{}, err
+	}
+	return state, nil
+}
+
+// Construct state from deployed files list. Orphaned files left in WDE folder carried forward,
+// so they offered for removal again by next runs.
+func NewDeployedState(runTime time.Time, deployedFiles []CustomisationFile, retainedOrphans []DeployedStateFile) (DeployedState, error) {
+	state := DeployedState{
+		RunTime: runTime,
+		Files:   make([]DeployedStateFile, 0, len(deployedFiles)+len(retainedOrphans)),
+	}
+	for _, file := range deployedFiles {
+		hash := file.Hash
+		if hash == "" {
+			var err error
+			hash, err = HashFile(file.SourcePath)
+			if err != nil {
+				return DeployedState{}, err
+			}
+		}
+		state.Files = append(state.Files, DeployedStateFile{
+			FileName:            file.FileName,
+			RelativePath:        file.RelativePath,
+			Hash:                hash,
+			CustomisationFolder: file.CustomisationFolder,
+		})
+	}
+	state.Files = append(state.Files, retainedOrphans...)
+	return state, nil
+}
+
+// Save state as JSON into provided file.
+func (ds DeployedState) Save(stateFileFullPath string) error {
+	stateBytes, err := json.MarshalIndent(ds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return SaveBytesIntoFile(stateFileFullPath, stateBytes)
+}
+
+// Find previously deployed files from customisation folders which disappeared from sources.
+// Files still present in current final list not returned.
+func (ds DeployedState) FindOrphanedFiles(currentFolders []string, finalFilesList []CustomisationFile) []DeployedStateFile {
+	existingFolders := make(map[string]bool, len(currentFolders))
+	for _, folder := range currentFolders {
+		existingFolders[strings.ToLower(folder)] = true
+	}
+	deployedFiles := make(map[string]bool, len(finalFilesList))
+	for _, file := range finalFilesList {
+		deployedFiles[strings.ToLower(filepath.Join(file.RelativePath, file.FileName))] = true
+	}
+	orphans := make([]DeployedStateFile, 0, 8)
+	for _, file := range ds.Files {
+		if existingFolders[strings.ToLower(file.CustomisationFolder)] {
+			continue
+		}
+		if deployedFiles[strings.ToLower(filepath.Join(file.RelativePath, file.FileName))] {
+			continue
+		}
+		orphans = append(orphans, file)
+	}
+	return orphans
+}
+
+// Remove orphaned files from WDE folder according to policy. Return full paths of removed files
+// and orphans left in WDE folder: kept by policy, declined or failed to remove.
+// Registry entries of removed files disappear because "CustomFiles" rebuilt from final files list.
+func RemoveOrphanedFiles(orphans []DeployedStateFile, targetDirectory, policy string, audit *AuditLog, events *HistoryEvents, logger *zap.Logger) ([]string, []DeployedStateFile) {
+	if len(orphans) == 0 {
+		return nil, nil
+	}
+	for _, orphan := range orphans {
+		logger.Info(fmt.Sprintf("Customisation folder '%v' removed from sources, file '%v' is orphaned",
+			orphan.CustomisationFolder, filepath.Join(orphan.RelativePath, orphan.FileName)))
+	}
+	switch strings.ToLower(policy) {
+	case OrphansRemove:
+	case OrphansAsk:
+		if Unattended() {
+			logger.Info("Unattended mode, orphaned files kept")
+			return nil, orphans
+		}
+		if !AskYesNo(fmt.Sprintf("%d files of removed customisation folders found in WDE folder. Remove them?", len(orphans))) {
+			logger.Info("Orphaned files removal declined")
+			return nil, orphans
+		}
+	default:
+		logger.Info("Orphaned files kept by policy")
+		return nil, orphans
+	}
+	removed := make([]string, 0, len(orphans))
+	retained := make([]DeployedStateFile, 0)
+	for _, orphan := range orphans {
+		fullPath := filepath.Join(targetDirectory, orphan.RelativePath, orphan.FileName)
+		beforeHash := audit.FileHash(fullPath)
+		err := os.Remove(fullPath)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Warn(fmt.Sprint("Can't remove orphaned file - ", err))
+			retained = append(retained, orphan)
+			continue
+		}
+		if err == nil {
+			audit.Record(AuditFileDeleted, fullPath, beforeHash, "", fmt.Sprint("orphan of customisation folder ", orphan.CustomisationFolder))
+			removed = append(removed, fullPath)
+		}
+		events.Add("Removed orphaned file '%v' of customisation folder '%v'", fullPath, orphan.CustomisationFolder)
+	}
+	return removed, retained
+}
+
+// Calculate SHA-256 of file content in hex.
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
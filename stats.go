@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const LargestFilesInStats = 3 // Number of largest files reported per customisation folder.
+
+// Statistics of one customisation folder collected while run.
+type FolderStats struct {
+	Folder    string     `json:"folder"`
+	Files     int        `json:"files"`
+	Size      int64      `json:"size"` // Total size of collected files in bytes.
+	Redundant int        `json:"redundant"`
+	Blocked   int        `json:"blocked"`
+	Largest   []FileSize `json:"largest"`
+}
+
+// File path relative to customisation folder with its size.
+type FileSize struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// Calculate statistics per customisation folder in order of folders.
+// Statuses are validation statuses of files.
+func CollectionStats(folders []string, files []CustomisationFile, statuses []FileStatus) []FolderStats {
+	stats := make([]FolderStats, len(folders))
+	folderIndex := make(map[string]int, len(folders))
+	for id, folder := range folders {
+		stats[id].Folder = folder
+		folderIndex[folder] = id
+	}
+	for id, file := range files {
+		folderID, ok := folderIndex[file.CustomisationFolder]
+		if !ok {
+			folderID = len(stats)
+			folderIndex[file.CustomisationFolder] = folderID
+			stats = append(stats, FolderStats{Folder: file.CustomisationFolder})
+		}
+		folderStats := &stats[folderID]
+		folderStats.Files++
+		folderStats.Size += file.Size
+		if id < len(statuses) {
+			switch statuses[id] {
+			case StatusRedundant:
+				folderStats.Redundant++
+			case StatusBlocked:
+				folderStats.Blocked++
+			}
+		}
+		folderStats.Largest = append(folderStats.Largest, FileSize{Path: filepath.Join(file.RelativePath, file.FileName), Size: file.Size})
+	}
+	for id := range stats {
+		largest := stats[id].Largest
+		sort.SliceStable(largest, func(i, j int) bool { return largest[i].Size > largest[j].Size })
+		if len(largest) > LargestFilesInStats {
+			stats[id].Largest = largest[:LargestFilesInStats]
+		}
+	}
+	return stats
+}
+
+// Format statistics as history section lines.
+func FormatCollectionStats(stats []FolderStats) []string {
+	lines := make([]string, 0, len(stats))
+	for _, folder := range stats {
+		line := fmt.Sprintf("%v: %v files, %v bytes, %v redundant, %v blocked", folder.Folder, folder.Files, folder.Size, folder.Redundant, folder.Blocked)
+		largest := make([]string, 0, len(folder.Largest))
+		for _, file := range folder.Largest {
+			largest = append(largest, fmt.Sprintf("%v (%v bytes)", file.Path, file.Size))
+		}
+		if len(largest) > 0 {
+			line = fmt.Sprint(line, ", largest: ", strings.Join(largest, ", "))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Log statistics of each customisation folder.
+func LogCollectionStats(stats []FolderStats, logger *zap.Logger) {
+	for _, line := range FormatCollectionStats(stats) {
+		logger.Info(fmt.Sprint("Collection statistics - ", line))
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Difference between files from sources and deployed state.
+type Drift struct {
+	SourceHash   string   // Hash of files chosen from sources.
+	DeployedHash string   // Hash of files in deployed state.
+	Added        []string // Files in sources not deployed yet.
+	Changed      []string // Deployed files with other content in sources.
+	Removed      []string // Deployed files not present in sources anymore.
+}
+
+// Run "status" subcommand. Print running update status, last run result
+// and whether sources differ from deployed state.
+func RunStatusCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	checkDrift := flags.Bool("drift", true, "scan sources and compare with deployed state")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	running, err := QueryStatusPipe()
+	if err == nil && running.Running {
+		fmt.Printf("Update running since %v, phase: %v\n", FileTimestamp(running.StartTime), running.Phase)
+		if running.Progress != nil && running.Progress.Total > 0 && !plainFlag {
+			fmt.Printf("  %v %d%%\n", running.Progress.Item, running.Progress.Done*100/running.Progress.Total)
+		}
+	}
+
+	summary, err := ReadLastSummary(HistoryFolderPath(mainConfig, programDirectory))
+	if err != nil {
+		fmt.Println("Last run: unknown -", err)
+	} else {
+		fmt.Printf("Last run: %v at %v, duration %v\n", strings.ToUpper(summary.Result), FileTimestamp(summary.StartTime), summary.Duration)
+		fmt.Printf("  folders %d, files %d, copied %d\n", summary.Folders, summary.Files, summary.Copied)
+		if summary.Result != RunResultSuccess {
+			fmt.Printf("  failed phase: %v\n", summary.Phase)
+			fmt.Printf("  error: %v\n", summary.Error)
+		}
+	}
+
+	if !*checkDrift {
+		return nil
+	}
+	mainConfig, err = ResolveConfigSecrets(mainConfig)
+	if err != nil {
+		return err
+	}
+	deployed, err := ReadDeployedState(filepath.Join(StateFolderPath(mainConfig, programDirectory), StateFileName))
+	if err != nil {
+		return fmt.Errorf("can't read deployed state - %v", err)
+	}
+	manifest, err := CurrentManifest(mainConfig, programDirectory)
+	if err != nil {
+		return err
+	}
+	drift := FindDrift(manifest, deployed)
+	if drift.SourceHash == drift.DeployedHash {
+		fmt.Println("Sources: in sync with deployed state")
+		return nil
+	}
+	fmt.Printf("Sources: DIFFER from deployed state (%d added, %d changed, %d removed)\n", len(drift.Added), len(drift.Changed), len(drift.Removed))
+	for _, path := range drift.Added {
+		fmt.Println("  added:  ", path)
+	}
+	for _, path := range drift.Changed {
+		fmt.Println("  changed:", path)
+	}
+	for _, path := range drift.Removed {
+		fmt.Println("  removed:", path)
+	}
+	return nil
+}
+
+// Read newest run summary from history folder.
+func ReadLastSummary(historyFolder string) (RunSummary, error) {
+	summaries, err := ReadRecentSummaries(historyFolder, 1)
+	if err != nil {
+		return RunSummary{}, err
+	}
+	return summaries[0], nil
+}
+
+// Read up to count newest run summaries from history folder, newest first.
+// Return error if folder has no summaries.
+func ReadRecentSummaries(historyFolder string, count int) ([]RunSummary, error) {
+	paths, err := filepath.Glob(filepath.Join(historyFolder, fmt.Sprint(SummaryFileName, "*.json")))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no run summaries in '%v'", historyFolder)
+	}
+	// Names contain start time in sortable layout.
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	if len(paths) > count {
+		paths = paths[:count]
+	}
+	summaries := make([]RunSummary, 0, len(paths))
+	for _, path := range paths {
+		summaryBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var summary RunSummary
+		err = json.Unmarshal(summaryBytes, &summary)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse '%v' - %v", filepath.Base(path), err)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// Return pinned manifest if configured, otherwise scan sources.
+// Only files changed since last run hashed, others taken from scan cache of last run. Cache not updated.
+func CurrentManifest(mainConfig MainCfgYAML, programDirectory string) (Manifest, error) {
+	if mainConfig.Manifest != "" {
+		return ReadManifest(mainConfig.Manifest)
+	}
+	cache, _ := ReadScanCache(ScanCacheFilePath(mainConfig, programDirectory)) // Damaged cache is empty, all files hashed.
+	return ScanSources(mainConfig, cache, zap.NewNop())
+}
+
+// Compare files chosen in manifest with deployed state.
+func FindDrift(manifest Manifest, deployed DeployedState) Drift {
+	sourceFiles := make(map[string]string, len(manifest.Files))
+	for _, file := range manifest.Files {
+		if file.Winner {
+			sourceFiles[strings.ToLower(filepath.Join(file.RelativePath, file.FileName))] = file.Hash
+		}
+	}
+	deployedFiles := make(map[string]string, len(deployed.Files))
+	for _, file := range deployed.Files {
+		deployedFiles[strings.ToLower(filepath.Join(file.RelativePath, file.FileName))] = file.Hash
+	}
+	drift := Drift{SourceHash: fileSetHash(sourceFiles), DeployedHash: fileSetHash(deployedFiles)}
+	for path, hash := range sourceFiles {
+		deployedHash, ok := deployedFiles[path]
+		switch {
+		case !ok:
+			drift.Added = append(drift.Added, path)
+		case deployedHash != hash:
+			drift.Changed = append(drift.Changed, path)
+		}
+	}
+	for path := range deployedFiles {
+		if _, ok := sourceFiles[path]; !ok {
+			drift.Removed = append(drift.Removed, path)
+		}
+	}
+	sort.Strings(drift.Added)
+	sort.Strings(drift.Changed)
+	sort.Strings(drift.Removed)
+	return drift
+}
+
+// Hash of sorted "path hash" lines.
+func fileSetHash(files map[string]string) string {
+	lines := make([]string, 0, len(files))
+	for path, hash := range files {
+		lines = append(lines, fmt.Sprint(path, " ", hash))
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
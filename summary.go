@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Run results for RunSummary.Result.
+const (
+	RunResultSuccess string = "success"
+	RunResultPartial string = "partial" // Run finished, but some files failed and excluded from deployment.
+	RunResultFailed  string = "failed"
+)
+
+// Process exit codes of single update run by its result.
+const (
+	ExitCodeSuccess int = 0
+	ExitCodeFailed  int = 1
+	ExitCodePartial int = 2
+)
+
+// Store run results for machine readable summary file and notifications.
+type RunSummary struct {
+	ProgramVersion string             `json:"programVersion"`
+	Hostname       string             `json:"hostname"`
+	Host           HostFacts          `json:"host"` // Facts about machine and WDE installation.
+	StartTime      time.Time          `json:"startTime"`
+	EndTime        time.Time          `json:"endTime"`
+	Duration       string             `json:"duration"`
+	Result         string             `json:"result"`
+	DryRun         bool               `json:"dryRun,omitempty"` // Changes only reported, nothing applied.
+	RunLabels                         // Tag and change ticket of run.
+	Error          string             `json:"error,omitempty"`           // Last error logged while run.
+	Transient      bool               `json:"transient,omitempty"`       // Run failed by transient infrastructure error.
+	Retrying       bool               `json:"retrying,omitempty"`        // Failed run will be retried, so not notified.
+	Folders        int                `json:"folders"`                   // Collected customisation folders.
+	Files          int                `json:"files"`                     // Collected customisation files.
+	Copied         int                `json:"copied"`                    // Files deployed into WDE folder, copied or already identical there, as before statuses.
+	CopiedBytes    int64              `json:"copiedBytes,omitempty"`     // Total size of files copied into WDE folder.
+	CopyTime       string             `json:"copyTime,omitempty"`        // Time spent copying files, without checks around copy.
+	Statuses       map[FileStatus]int `json:"statuses,omitempty"`        // Number of collected files by status, files really copied are "COPIED".
+	FolderStats    []FolderStats      `json:"folderStats,omitempty"`     // Statistics per customisation folder.
+	PublishExit    *int               `json:"publishExitCode,omitempty"` // Exit code of DM executable or publish command.
+	DMLogErrors    []string           `json:"dmLogErrors,omitempty"`     // Error lines from DM log written while run.
+	ReleaseVersion string             `json:"releaseVersion,omitempty"`  // Customisation release version.
+	ReleaseNotes   []ReleaseNote      `json:"releaseNotes,omitempty"`    // Release notes of customisation folders.
+	Phase          string             `json:"phase"`                     // Last started phase. For failed run it is failed phase.
+	Phases         []PhaseDuration    `json:"phases"`                    // Durations of run phases.
+	Targets        []TargetResult     `json:"targets,omitempty"`         // Results of targets updated concurrently.
+	phaseStart     time.Time
+	MaxDuration    string `json:"maxDuration,omitempty"`
+	Overrun        string `json:"overrun,omitempty"` // How much run exceeded MaxDuration.
+}
+
+// Return summary with filled start data. Result is failed until run explicitly finished successfully.
+func NewRunSummary(startTime time.Time) RunSummary {
+	hostname, _ := os.Hostname()
+	return RunSummary{
+		ProgramVersion: programVersion,
+		Hostname:       hostname,
+		StartTime:      startTime,
+		Result:         RunResultFailed,
+	}
+}
+
+// Duration of one run phase.
+type PhaseDuration struct {
+	Name     string `json:"name"`
+	Duration string `json:"duration"`
+	elapsed  time.Duration
+}
+
+// Close current phase and start new one.
+func (rs *RunSummary) StartPhase(name string) {
+	now := time.Now()
+	rs.closePhase(now)
+	rs.Phase = name
+	rs.phaseStart = now
+}
+
+// Save duration of current phase.
+func (rs *RunSummary) closePhase(now time.Time) {
+	if rs.Phase == "" || rs.phaseStart.IsZero() {
+		return
+	}
+	elapsed := now.Sub(rs.phaseStart)
+	rs.Phases = append(rs.Phases, PhaseDuration{Name: rs.Phase, Duration: elapsed.String(), elapsed: elapsed})
+	rs.phaseStart = time.Time{}
+}
+
+// Return outcome category: "success", "partial" or "failed:<phase>".
+func (rs RunSummary) OutcomeCategory() string {
+	if rs.Result == RunResultSuccess || rs.Result == RunResultPartial {
+		return rs.Result
+	}
+	return fmt.Sprint(RunResultFailed, ":", rs.Phase)
+}
+
+// Hook for zap logger. Save last error message into summary.
+func (rs *RunSummary) LogHook(entry zapcore.Entry) error {
+	if entry.Level >= zapcore.ErrorLevel {
+		rs.Error = entry.Message
+	}
+	return nil
+}
+
+// Fill end time and duration, check run duration budget.
+// Return true if run exceeded budget.
+func (rs *RunSummary) Finish(endTime time.Time, maxDuration time.Duration) bool {
+	rs.EndTime = endTime
+	rs.closePhase(endTime)
+	duration := endTime.Sub(rs.StartTime)
+	rs.Duration = duration.String()
+	if maxDuration <= 0 {
+		return false
+	}
+	rs.MaxDuration = maxDuration.String()
+	if duration <= maxDuration {
+		return false
+	}
+	rs.Overrun = (duration - maxDuration).String()
+	return true
+}
+
+// Return process exit code for run result.
+func (rs RunSummary) ExitCode() int {
+	switch rs.Result {
+	case RunResultSuccess:
+		return ExitCodeSuccess
+	case RunResultPartial:
+		return ExitCodePartial
+	}
+	return ExitCodeFailed
+}
+
+// Save summary as JSON into provided file. If redaction enabled, machine and user fields masked,
+// other text masked by Redact.
+func (rs RunSummary) Save(fullPath string) error {
+	if RedactionEnabled() {
+		rs.Hostname, rs.Host.Hostname = RedactedHost, RedactedHost
+		if rs.Host.LoggedInUser != "" {
+			rs.Host.LoggedInUser = RedactedUser
+		}
+	}
+	summaryBytes, err := json.MarshalIndent(rs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return SaveBytesIntoFile(fullPath, []byte(Redact(string(summaryBytes))))
+}
+
+// Mark files copied by run as rolled back in its saved summary. Missing summary ignored.
+func MarkRunRolledBack(summaryFileFullPath string) error {
+	summaryBytes, err := ioutil.ReadFile(summaryFileFullPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var summary RunSummary
+	err = json.Unmarshal(summaryBytes, &summary)
+	if err != nil {
+		return err
+	}
+	if summary.Statuses == nil || summary.Statuses[StatusCopied] == 0 {
+		return nil
+	}
+	summary.Statuses[StatusRolledBack] += summary.Statuses[StatusCopied]
+	delete(summary.Statuses, StatusCopied)
+	return summary.Save(summaryFileFullPath)
+}
+
+// Finish run summary, save it and send notification if needed.
+// Intended to be deferred in main, so must be called on every exit path of the run.
+// Copy durations used for telemetry, they taken by pointer because filled after defer.
+func FinishRun(summary *RunSummary, mainConfig MainCfgYAML, summaryFileFullPath string, copyDurations *[]time.Duration, logger *zap.Logger) {
+	var maxDuration time.Duration
+	if mainConfig.Run.MaxDuration != "" {
+		var err error
+		maxDuration, err = time.ParseDuration(mainConfig.Run.MaxDuration)
+		if err != nil {
+			logger.Warn(fmt.Sprint("Can't parse Run.MaxDuration - ", err))
+		}
+	}
+	overrun := summary.Finish(TimestampNow(), maxDuration)
+	if overrun {
+		logger.Warn(fmt.Sprintf("Run took %v which exceeds expected maximum %v by %v", summary.Duration, summary.MaxDuration, summary.Overrun))
+	}
+	summary.Retrying = summary.Transient && summary.Result == RunResultFailed && mainConfig.Run.Retries > 0
+	if summary.Retrying {
+		logger.Warn(fmt.Sprintf("Run failed by transient error, %v retries left", mainConfig.Run.Retries))
+	}
+
+	// Unsaved summary only not mirrored, telemetry and failure notification still sent.
+	err := summary.Save(summaryFileFullPath)
+	if err != nil {
+		logger.Error(fmt.Sprint("Can't save run summary - ", err))
+	} else {
+		MirrorFileWithLog(mainConfig.Mirror.Folder, "History", summaryFileFullPath, logger)
+	}
+	if !summary.DryRun {
+		err = ClearOldFiles(filepath.Dir(summaryFileFullPath), SummaryFileName, 15)
+		if err != nil {
+			logger.Warn(fmt.Sprint("Can't clear old run summary files - ", err))
+		}
+	}
+
+	if mainConfig.Telemetry.Enabled {
+		SendTelemetry(mainConfig.Telemetry.Endpoint, NewTelemetryReport(*summary, *copyDurations), logger)
+	}
+
+	if (summary.Result != RunResultSuccess && !summary.Retrying) || (overrun && mainConfig.Run.NotifyOnOverrun) {
+		RunNotifyCommand(mainConfig.Notify.Command, summaryFileFullPath, summary.RunLabels, logger)
+	}
+}
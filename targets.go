@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TargetsFolder            string = "Targets" // Workspace subfolder with workspaces of targets.
+	DefaultTargetParallelism int    = 4         // Targets updated at the same time by default.
+)
+
+// Valid target name, used as folder name.
+var targetNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// WDE installation updated as separate target, e.g. per user installation on RDS host.
+type TargetConfig struct {
+	Name                  string `yaml:"Name"`                  // Unique name, also name of target workspace subfolder.
+	WDEInstallationFolder string `yaml:"WDEInstallationFolder"` // WDE installation of target.
+	UserSID               string `yaml:"UserSID"`               // User whose DM registry written, by default current user.
+	SkipDeployment        bool   `yaml:"SkipDeployment"`        // Don't run DM for target, required with UserSID.
+}
+
+// Result of one target in aggregated run summary.
+type TargetResult struct {
+	Name     string `json:"name"`
+	Result   string `json:"result"`
+	Phase    string `json:"phase,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Copied   int    `json:"copied"`
+	Duration string `json:"duration"`
+}
+
+// Check target names and folders unique and only one target use DM registry of current user.
+// DM runs as current user and publishes from its HKCU, so targets of other users must skip deployment.
+func ValidateTargets(targets []TargetConfig, skipDeployment bool) error {
+	names := make(map[string]bool, len(targets))
+	folders := make(map[string]bool, len(targets))
+	currentUserTargets := 0
+	for _, target := range targets {
+		if !targetNamePattern.MatchString(target.Name) {
+			return fmt.Errorf("invalid target name '%v', letters, digits, '.', '_' and '-' allowed", target.Name)
+		}
+		if names[strings.ToLower(target.Name)] {
+			return fmt.Errorf("duplicate target name '%v'", target.Name)
+		}
+		names[strings.ToLower(target.Name)] = true
+		if target.WDEInstallationFolder == "" {
+			return fmt.Errorf("target '%v' has no WDEInstallationFolder", target.Name)
+		}
+		folder := strings.ToLower(filepath.Clean(ExpandWindowsEnv(target.WDEInstallationFolder)))
+		if folders[folder] {
+			return fmt.Errorf("target '%v' WDE folder used by another target", target.Name)
+		}
+		folders[folder] = true
+		if target.UserSID == "" {
+			currentUserTargets++
+		} else if !target.SkipDeployment && !skipDeployment {
+			return fmt.Errorf("target '%v' with UserSID must set SkipDeployment, DM publishes from registry of current user only", target.Name)
+		}
+	}
+	if currentUserTargets > 1 {
+		return fmt.Errorf("%v targets without UserSID write DM registry of current user, only one allowed", currentUserTargets)
+	}
+	return nil
+}
+
+// Return config and workspace of target. Artifacts isolated in target workspace,
+// absolute artifact folders get target subfolder. Target runs not mirrored and not notified,
+// aggregated summary is.
+func TargetConfigFor(mainConfig MainCfgYAML, programDirectory string, target TargetConfig) (MainCfgYAML, string) {
+	workspace := filepath.Join(WorkspaceFolderPath(mainConfig, programDirectory), TargetsFolder, target.Name)
+	config := mainConfig
+	config.Targets = nil
+	config.WDEInstallationFolder = ExpandWindowsEnv(target.WDEInstallationFolder)
+	config.Session.UserWDEInstallationFolder = ""
+	config.Workspace.Folder = workspace
+	for _, folder := range []*string{&config.Log.Folder, &config.History.Folder, &config.State.Folder, &config.Audit.Folder, &config.Cache.Folder, &config.Backup.Folder} {
+		if filepath.IsAbs(*folder) {
+			*folder = filepath.Join(*folder, TargetsFolder, target.Name)
+		}
+	}
+	config.Mirror.Folder = ""
+	config.Notify.Command = nil
+	config.DM.Skip = config.DM.Skip || target.SkipDeployment
+	// Other users written by their own targets.
+	config.Registry.Users = ""
+	config.Registry.UserSIDs = nil
+	return config, workspace
+}
+
+// Return registry store of target user.
+func TargetRegistryStore(target TargetConfig) RegistryStore {
+	if target.UserSID != "" {
+		return NewUserRegistryStore(target.UserSID)
+	}
+	return DefaultRegistryStore()
+}
+
+// Update configured targets concurrently or run single update if no targets configured.
+// Runs failed by transient error retried by Run.Retries, targets retried together after all finished.
+func RunConfiguredUpdate(mainConfig MainCfgYAML, programDirectory string, registryStore RegistryStore, reload *ConfigReload) RunSummary {
+	if len(mainConfig.Targets) == 0 {
+		return RunUpdateWithRetries(mainConfig, programDirectory, registryStore, reload)
+	}
+	return RunTargets(mainConfig, programDirectory, reload)
+}
+
+// Update each target in its own workspace, up to Run.Parallelism at the same time.
+// Failure of one target not affect others. Aggregated summary saved into history folder.
+func RunTargets(mainConfig MainCfgYAML, programDirectory string, reload *ConfigReload) (summary RunSummary) {
+	ConfigureRedaction(mainConfig)
+	timestampsErr := ConfigureTimestamps(mainConfig)
+	startTime := TimestampNow()
+	startTimeString := FileTimestamp(startTime)
+	logFullPath := filepath.Join(
+		LogFolderPath(mainConfig, programDirectory),
+		fmt.Sprint(LogFilePrefix(mainConfig), startTimeString, ".log"),
+	)
+	logger := NewZapSimpleLoggerWithRotation(mainConfig.Log.Verbose, logFullPath, 10, 1)
+	defer logger.Sync()
+	if timestampsErr != nil {
+		logger.Warn(fmt.Sprint("Invalid timestamp settings - ", timestampsErr))
+	}
+
+	summary = NewRunSummary(startTime)
+	summary.RunLabels = CurrentRunLabels()
+	summary.Phase = "targets"
+	runStatus.Start(startTime)
+	defer func() { runStatus.Finish(summary) }()
+	summaryFileFullPath := filepath.Join(
+		HistoryFolderPath(mainConfig, programDirectory),
+		fmt.Sprint(SummaryFileName, startTimeString, ".json"),
+	)
+	// Targets send own telemetry with copy durations.
+	aggregateConfig := mainConfig
+	aggregateConfig.Telemetry.Enabled = false
+	defer func() { FinishRun(&summary, aggregateConfig, summaryFileFullPath, &[]time.Duration{}, logger) }()
+
+	err := ValidateTargets(mainConfig.Targets, mainConfig.DM.Skip)
+	if err != nil {
+		summary.Error = err.Error()
+		logger.Error(fmt.Sprint("Invalid targets - ", err))
+		return
+	}
+	aggregateConfig, err = ResolveConfigSecrets(aggregateConfig)
+	if err != nil {
+		summary.Error = err.Error()
+		logger.Error(fmt.Sprint("Can't resolve config secrets - ", err))
+		return
+	}
+	summary.DryRun = *dryRunFlag
+	// Services and processes stopped by stop phase of targets, shared while any target changes WDE folder,
+	// so approval, release gate and retry waits of targets run with services up.
+	parallelism := mainConfig.Run.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultTargetParallelism
+	}
+	logger.Info(fmt.Sprintf("Update %v targets, up to %v at the same time", len(mainConfig.Targets), parallelism))
+
+	// Targets failed by transient error retried together after all targets finished,
+	// so retry pause never holds parallelism slot.
+	retries := mainConfig.Run.Retries
+	delay, maxDelay, err := RetryDelays(mainConfig)
+	if err != nil {
+		logger.Warn(fmt.Sprint("Invalid retry settings, targets not retried - ", err))
+		retries = 0
+	}
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	results := make([]RunSummary, len(mainConfig.Targets))
+	pending := make([]int, 0, len(mainConfig.Targets))
+	for id := range mainConfig.Targets {
+		pending = append(pending, id)
+	}
+	for retry := 0; ; retry++ {
+		slots := make(chan struct{}, parallelism)
+		var wait sync.WaitGroup
+		for _, id := range pending {
+			wait.Add(1)
+			go func(id int, target TargetConfig) {
+				defer wait.Done()
+				slots <- struct{}{}
+				defer func() { <-slots }()
+				config, workspace := TargetConfigFor(mainConfig, programDirectory, target)
+				config.Run.Retries = retries - retry // Retries left, checked when run finished.
+				logger.Info(fmt.Sprintf("Target '%v' started, workspace '%v'", target.Name, workspace))
+				results[id] = RunUpdate(config, workspace, TargetRegistryStore(target), reload)
+				logger.Info(fmt.Sprintf("Target '%v' finished with result '%v'", target.Name, results[id].Result))
+			}(id, mainConfig.Targets[id])
+		}
+		wait.Wait()
+		retrying := make([]int, 0)
+		for _, id := range pending {
+			if results[id].Retrying {
+				retrying = append(retrying, id)
+			}
+		}
+		if len(retrying) == 0 {
+			break
+		}
+		pending = retrying
+		pause := RetryPause(delay, maxDelay, retry, random)
+		logger.Warn(fmt.Sprintf("%v targets failed by transient error, retry %v of %v in %v", len(pending), retry+1, retries, pause.Round(time.Second)))
+		time.Sleep(pause)
+		reload = nil // Config reload already logged by first attempt.
+	}
+
+	failed := make([]string, 0)
+	succeeded := 0
+	for id, result := range results {
+		name := mainConfig.Targets[id].Name
+		summary.Targets = append(summary.Targets, TargetResult{
+			Name:     name,
+			Result:   result.Result,
+			Phase:    result.Phase,
+			Error:    result.Error,
+			Copied:   result.Copied,
+			Duration: result.Duration,
+		})
+		summary.Files += result.Files
+		summary.Copied += result.Copied
+		if result.Result == RunResultSuccess {
+			succeeded++
+			continue
+		}
+		failed = append(failed, name)
+		logger.Error(fmt.Sprintf("Target '%v' %v in phase '%v' - %v", name, result.Result, result.Phase, result.Error))
+	}
+	switch {
+	case len(failed) == 0:
+		summary.Result = RunResultSuccess
+		logger.Info("All targets updated successful.")
+	case succeeded > 0:
+		summary.Result = RunResultPartial
+		summary.Error = fmt.Sprintf("%v of %v targets not updated successful: %v", len(failed), len(results), strings.Join(failed, ", "))
+	default:
+		summary.Error = fmt.Sprintf("all %v targets not updated successful", len(results))
+	}
+	return
+}
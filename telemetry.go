@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Timeout for send telemetry report.
+const TelemetryTimeout = 10 * time.Second
+
+// Anonymous telemetry report. Contains no host, user or path data.
+type TelemetryReport struct {
+	ProgramVersion string           `json:"programVersion"`
+	Outcome        string           `json:"outcome"`    // "success" or "failed:<phase>".
+	DurationMs     int64            `json:"durationMs"` // Whole run duration.
+	PhasesMs       map[string]int64 `json:"phasesMs"`   // Duration of each phase.
+	Files          int              `json:"files"`
+	Copied         int              `json:"copied"`
+	CopyP50Ms      int64            `json:"copyP50Ms"` // Percentiles of single file copy duration.
+	CopyP90Ms      int64            `json:"copyP90Ms"`
+	CopyP99Ms      int64            `json:"copyP99Ms"`
+}
+
+// Construct telemetry report from finished run summary and file copy durations.
+func NewTelemetryReport(summary RunSummary, copyDurations []time.Duration) TelemetryReport {
+	report := TelemetryReport{
+		ProgramVersion: summary.ProgramVersion,
+		Outcome:        summary.OutcomeCategory(),
+		DurationMs:     summary.EndTime.Sub(summary.StartTime).Milliseconds(),
+		PhasesMs:       make(map[string]int64, len(summary.Phases)),
+		Files:          summary.Files,
+		Copied:         summary.Copied,
+	}
+	for _, phase := range summary.Phases {
+		report.PhasesMs[phase.Name] += phase.elapsed.Milliseconds()
+	}
+	sorted := append(make([]time.Duration, 0, len(copyDurations)), copyDurations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	report.CopyP50Ms = Percentile(sorted, 50).Milliseconds()
+	report.CopyP90Ms = Percentile(sorted, 90).Milliseconds()
+	report.CopyP99Ms = Percentile(sorted, 99).Milliseconds()
+	return report
+}
+
+// Return nearest-rank percentile of sorted durations. Zero for empty slice.
+func Percentile(sorted []time.Duration, percent int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (percent*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Send report to telemetry endpoint. Failure only logged.
+func SendTelemetry(endpoint string, report TelemetryReport, logger *zap.Logger) {
+	if endpoint == "" {
+		logger.Warn("Telemetry enabled but endpoint not configured")
+		return
+	}
+	reportBytes, err := json.Marshal(report)
+	if err != nil {
+		logger.Warn(fmt.Sprint("Can't marshal telemetry report - ", err))
+		return
+	}
+	client := http.Client{Timeout: TelemetryTimeout}
+	response, err := client.Post(endpoint, "application/json", bytes.NewReader(reportBytes))
+	if err != nil {
+		logger.Warn(fmt.Sprint("Can't send telemetry report - ", err))
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		logger.Warn(fmt.Sprint("Telemetry endpoint response status - ", response.Status))
+		return
+	}
+	logger.Debug("Telemetry report sent")
+}
@@ -0,0 +1,11 @@
+// Source of Company.Wde.Fixture.dll used by assemblymetadata_test.go. Built with:
+// csc -noconfig -nostdlib -deterministic -optimize -target:library -out:Company.Wde.Fixture.dll -r:netstandard.dll Fixture.cs
+using System.Reflection;
+[assembly: AssemblyVersion("1.2.3.4")]
+namespace Company.Wde.Fixture
+{
+    public class Module
+    {
+        public static string Name() { return typeof(Module).Assembly.GetName().Name; }
+    }
+}
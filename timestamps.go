@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // IANA zones available on machines without Go installation.
+)
+
+// Timestamp defaults and zones.
+const (
+	DefaultLogTimeLayout string = "2006.01.02 15:04:05" // Default layout for time in log lines.
+	TimeZoneLocal        string = "local"               // Machine local time zone, default.
+	TimeZoneUTC          string = "utc"                 // Coordinated universal time.
+	fileNameForbidden    string = `\/:*?"<>|`           // Characters not allowed in Windows file names.
+)
+
+// Active timestamp settings, see ConfigureTimestamps.
+var (
+	fileTimeLayout      = logHistLayout
+	logTimeLayout       = DefaultLogTimeLayout
+	timeLocation        = time.Local
+	timeSettingsMutex   sync.RWMutex
+	timeLayoutReference = time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+)
+
+// Apply timestamp layouts and zone from config.
+// Invalid settings replaced by defaults and reported by error.
+func ConfigureTimestamps(mainConfig MainCfgYAML) error {
+	fileLayout, logLayout, location := logHistLayout, DefaultLogTimeLayout, time.Local
+	var problems []string
+
+	if layout := mainConfig.Log.Time.FileLayout; layout != "" {
+		sample := timeLayoutReference.Format(layout)
+		changed := sample != timeLayoutReference.AddDate(1, 1, 1).Add(time.Hour+time.Minute+time.Second).Format(layout)
+		switch {
+		case strings.ContainsAny(sample, fileNameForbidden) || !changed:
+			problems = append(problems, fmt.Sprintf("file layout '%v' gives '%v', not usable in file name", layout, sample))
+		case !isSortableLayout(layout):
+			problems = append(problems, fmt.Sprintf("file layout '%v' not sorted by time, file names sorted by history readers and rotation", layout))
+		default:
+			fileLayout = layout
+		}
+	}
+	if layout := mainConfig.Log.Time.LineLayout; layout != "" {
+		logLayout = layout
+	}
+	switch zone := mainConfig.Log.Time.Zone; strings.ToLower(zone) {
+	case "", TimeZoneLocal:
+	case TimeZoneUTC:
+		location = time.UTC
+	default:
+		loaded, err := time.LoadLocation(zone)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("unknown zone '%v' - %v", zone, err))
+		} else {
+			location = loaded
+		}
+	}
+
+	timeSettingsMutex.Lock()
+	defer timeSettingsMutex.Unlock()
+	fileTimeLayout, logTimeLayout, timeLocation = fileLayout, logLayout, location
+	if len(problems) > 0 {
+		return fmt.Errorf("defaults used for invalid timestamp settings - %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Check that times formatted by layout sort as text in time order.
+// Sequences stepped by every time unit catch unpadded fields, 12-hour clock, month names and day before month.
+func isSortableLayout(layout string) bool {
+	steps := []struct {
+		step  time.Duration
+		count int
+	}{
+		{time.Second, 130},
+		{time.Minute, 130},
+		{time.Hour, 50},
+		{24 * time.Hour, 70},
+		{7*time.Hour + 13*time.Minute + 37*time.Second, 2700}, // About two years.
+	}
+	for _, s := range steps {
+		previous := timeLayoutReference.Format(layout)
+		for i := 1; i <= s.count; i++ {
+			current := timeLayoutReference.Add(time.Duration(i) * s.step).Format(layout)
+			if current < previous {
+				return false
+			}
+			previous = current
+		}
+	}
+	return true
+}
+
+// Return current time in configured zone.
+func TimestampNow() time.Time {
+	timeSettingsMutex.RLock()
+	defer timeSettingsMutex.RUnlock()
+	return time.Now().In(timeLocation)
+}
+
+// Format time for "log", "history", summary and other file names in configured layout and zone.
+func FileTimestamp(t time.Time) string {
+	timeSettingsMutex.RLock()
+	defer timeSettingsMutex.RUnlock()
+	return t.In(timeLocation).Format(fileTimeLayout)
+}
+
+// Parse time from file name written with FileTimestamp.
+// Files written before layout change parsed with default layout.
+func ParseFileTimestamp(text string) (time.Time, error) {
+	timeSettingsMutex.RLock()
+	defer timeSettingsMutex.RUnlock()
+	parsed, err := time.ParseInLocation(fileTimeLayout, text, timeLocation)
+	if err != nil && fileTimeLayout != logHistLayout {
+		var fallbackErr error
+		parsed, fallbackErr = time.ParseInLocation(logHistLayout, text, time.Local)
+		if fallbackErr == nil {
+			return parsed, nil
+		}
+	}
+	return parsed, err
+}
+
+// Return configured file name time layout.
+func FileTimeLayout() string {
+	timeSettingsMutex.RLock()
+	defer timeSettingsMutex.RUnlock()
+	return fileTimeLayout
+}
+
+// Encode log line time in configured layout and zone.
+func encodeLogTime(t time.Time, encoder zapcore.PrimitiveArrayEncoder) {
+	timeSettingsMutex.RLock()
+	defer timeSettingsMutex.RUnlock()
+	encoder.AppendString(t.In(timeLocation).Format(logTimeLayout))
+}
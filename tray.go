@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const TrayDefaultRefresh time.Duration = 30 * time.Second // Interval of tray status refresh.
+
+// Customisation state shown by tray helper.
+type TrayState struct {
+	Tooltip string
+	Running bool      // Update in progress.
+	Failed  bool      // Last run not succeeded.
+	LastRun time.Time // Start time of last finished run, zero if unknown.
+	Error   string    // Error of last run.
+	Report  string    // Full path to newest history file, empty if none.
+}
+
+// Collect state of running update and last finished run for tray icon.
+func ReadTrayState(mainConfig MainCfgYAML, programDirectory string) TrayState {
+	var state TrayState
+	lines := []string{"WDE customisations"}
+	running, err := QueryStatusPipe()
+	if err == nil && running.Running {
+		state.Running = true
+		lines = append(lines, fmt.Sprintf("Update running, phase: %v", running.Phase))
+	}
+	historyFolder := HistoryFolderPath(mainConfig, programDirectory)
+	summary, err := ReadLastSummary(historyFolder)
+	if err != nil {
+		lines = append(lines, "Last run: unknown")
+	} else {
+		state.LastRun = summary.StartTime
+		state.Failed = summary.Result != RunResultSuccess
+		state.Error = summary.Error
+		lines = append(lines, fmt.Sprintf("Last run: %v at %v", strings.ToUpper(summary.Result), FileTimestamp(summary.StartTime)))
+		if summary.ReleaseVersion != "" {
+			lines = append(lines, fmt.Sprint("Release: ", summary.ReleaseVersion))
+		}
+	}
+	names, err := ListHistoryFiles(historyFolder, HistoryFilePrefix(mainConfig))
+	if err == nil && len(names) > 0 {
+		state.Report = filepath.Join(historyFolder, names[0])
+	}
+	state.Tooltip = strings.Join(lines, "\n") // Truncated by icon if too long.
+	return state
+}
+
+// Start update in separate unattended process with hidden window, don't wait for it.
+func StartUpdateNow(programDirectory string) error {
+	running, err := QueryStatusPipe()
+	if err == nil && running.Running {
+		return fmt.Errorf("update already running, phase '%v'", running.Phase)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, "-unattended")
+	cmd.Dir = programDirectory
+	cmd.SysProcAttr = HiddenWindowProcAttr()
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	// Process not waited, release its resources.
+	return cmd.Process.Release()
+}
+
+// Run "tray" subcommand. Show customisation state in notification area until exit chosen in menu.
+func RunTrayCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	flags := flag.NewFlagSet("tray", flag.ContinueOnError)
+	refresh := flags.Duration("refresh", TrayDefaultRefresh, "interval of status refresh")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *refresh < time.Second {
+		return fmt.Errorf("refresh interval must be at least 1s")
+	}
+	mainConfig, err = ResolveConfigSecrets(mainConfig)
+	if err != nil {
+		return err
+	}
+	return RunTray(mainConfig, programDirectory, *refresh)
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import (
+	"time"
+)
+
+// Notification area available only on Windows.
+func RunTray(mainConfig MainCfgYAML, programDirectory string, refresh time.Duration) error {
+	return ErrNotSupportedOnPlatform
+}
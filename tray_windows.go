@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows"
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+// Window messages, notification area and menu constants used by tray helper.
+const (
+	wmDestroy       = 0x0002
+	wmNull          = 0x0000
+	wmTimer         = 0x0113
+	wmLButtonDblClk = 0x0203
+	wmRButtonUp     = 0x0205
+	wmTrayCallback  = 0x8000 + 1 // WM_APP + 1.
+
+	nimAdd      = 0
+	nimModify   = 1
+	nimDelete   = 2
+	nifMessage  = 0x01
+	nifIcon     = 0x02
+	nifTip      = 0x04
+	nifInfo     = 0x10
+	niifInfo    = 0x01
+	niifWarning = 0x02
+
+	idiApplication = 32512
+	idiWarning     = 32515
+	idiInformation = 32516
+
+	mfString       = 0x0000
+	mfGrayed       = 0x0001
+	mfSeparator    = 0x0800
+	tpmRightButton = 0x0002
+	tpmReturnCmd   = 0x0100
+	swShowNormal   = 1
+
+	trayTimerID    = 1
+	trayMenuRunNow = 1
+	trayMenuReport = 2
+	trayMenuExit   = 3
+)
+
+var (
+	shell32                    = windows.NewLazySystemDLL("shell32.dll")
+	procShellNotifyIconW       = shell32.NewProc("Shell_NotifyIconW")
+	procRegisterClassExW       = user32.NewProc("RegisterClassExW")
+	procCreateWindowExW        = user32.NewProc("CreateWindowExW")
+	procDefWindowProcW         = user32.NewProc("DefWindowProcW")
+	procDestroyWindow          = user32.NewProc("DestroyWindow")
+	procGetMessageW            = user32.NewProc("GetMessageW")
+	procTranslateMessage       = user32.NewProc("TranslateMessage")
+	procDispatchMessageW       = user32.NewProc("DispatchMessageW")
+	procPostQuitMessage        = user32.NewProc("PostQuitMessage")
+	procLoadIconW              = user32.NewProc("LoadIconW")
+	procCreatePopupMenu        = user32.NewProc("CreatePopupMenu")
+	procAppendMenuW            = user32.NewProc("AppendMenuW")
+	procDestroyMenu            = user32.NewProc("DestroyMenu")
+	procTrackPopupMenu         = user32.NewProc("TrackPopupMenu")
+	procSetForegroundWindow    = user32.NewProc("SetForegroundWindow")
+	procGetCursorPos           = user32.NewProc("GetCursorPos")
+	procSetTimer               = user32.NewProc("SetTimer")
+	procRegisterWindowMessageW = user32.NewProc("RegisterWindowMessageW")
+)
+
+// NOTIFYICONDATAW.
+type notifyIconData struct {
+	Size            uint32
+	Window          windows.HWND
+	ID              uint32
+	Flags           uint32
+	CallbackMessage uint32
+	Icon            windows.Handle
+	Tip             [128]uint16
+	State           uint32
+	StateMask       uint32
+	Info            [256]uint16
+	Version         uint32
+	InfoTitle       [64]uint16
+	InfoFlags       uint32
+	GUID            windows.GUID
+	BalloonIcon     windows.Handle
+}
+
+// WNDCLASSEXW.
+type windowClassEx struct {
+	Size       uint32
+	Style      uint32
+	WndProc    uintptr
+	ClsExtra   int32
+	WndExtra   int32
+	Instance   windows.Handle
+	Icon       windows.Handle
+	Cursor     windows.Handle
+	Background windows.Handle
+	MenuName   *uint16
+	ClassName  *uint16
+	IconSm     windows.Handle
+}
+
+// MSG.
+type windowMessage struct {
+	Window  windows.HWND
+	Message uint32
+	WParam  uintptr
+	LParam  uintptr
+	Time    uint32
+	X, Y    int32
+}
+
+// Notification area icon with status of customisations.
+type trayIcon struct {
+	mainConfig       MainCfgYAML
+	programDirectory string
+	window           windows.HWND
+	taskbarCreated   uint32 // Message sent when Explorer restarted, icon must be added again.
+	state            TrayState
+}
+
+// Show tray icon and process its messages until exit chosen in menu.
+func RunTray(mainConfig MainCfgYAML, programDirectory string, refresh time.Duration) error {
+	// Window messages delivered to thread created window.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	tray := &trayIcon{mainConfig: mainConfig, programDirectory: programDirectory}
+	var instance windows.Handle
+	err := windows.GetModuleHandleEx(0, nil, &instance)
+	if err != nil {
+		return err
+	}
+	className, _ := windows.UTF16PtrFromString("WdeCustomizationUpdaterTray")
+	class := windowClassEx{WndProc: windows.NewCallback(tray.windowProc), Instance: instance, ClassName: className}
+	class.Size = uint32(unsafe.Sizeof(class))
+	atom, _, err := procRegisterClassExW.Call(uintptr(unsafe.Pointer(&class)))
+	if atom == 0 {
+		return fmt.Errorf("can't register tray window class - %v", err)
+	}
+	// Window never shown, it only receive icon and menu messages.
+	hwnd, _, err := procCreateWindowExW.Call(0, uintptr(unsafe.Pointer(className)), uintptr(unsafe.Pointer(className)), 0, 0, 0, 0, 0, 0, 0, uintptr(instance), 0)
+	if hwnd == 0 {
+		return fmt.Errorf("can't create tray window - %v", err)
+	}
+	tray.window = windows.HWND(hwnd)
+	taskbarCreated, _ := windows.UTF16PtrFromString("TaskbarCreated")
+	message, _, _ := procRegisterWindowMessageW.Call(uintptr(unsafe.Pointer(taskbarCreated)))
+	tray.taskbarCreated = uint32(message)
+
+	tray.state = ReadTrayState(mainConfig, programDirectory)
+	err = tray.notify(nimAdd, "", "", 0)
+	if err != nil {
+		procDestroyWindow.Call(hwnd)
+		return fmt.Errorf("can't add tray icon - %v", err)
+	}
+	procSetTimer.Call(hwnd, trayTimerID, uintptr(refresh/time.Millisecond), 0)
+
+	var msg windowMessage
+	for {
+		result, _, _ := procGetMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+		if int32(result) <= 0 {
+			return nil
+		}
+		procTranslateMessage.Call(uintptr(unsafe.Pointer(&msg)))
+		procDispatchMessageW.Call(uintptr(unsafe.Pointer(&msg)))
+	}
+}
+
+// Process messages of tray window.
+func (ti *trayIcon) windowProc(hwnd uintptr, message uint32, wParam, lParam uintptr) uintptr {
+	switch message {
+	case wmTrayCallback:
+		switch lParam & 0xFFFF {
+		case wmRButtonUp:
+			ti.showMenu()
+		case wmLButtonDblClk:
+			ti.openReport()
+		}
+		return 0
+	case wmTimer:
+		ti.refresh()
+		return 0
+	case wmDestroy:
+		ti.notify(nimDelete, "", "", 0)
+		procPostQuitMessage.Call(0)
+		return 0
+	case ti.taskbarCreated:
+		ti.notify(nimAdd, "", "", 0)
+		return 0
+	}
+	result, _, _ := procDefWindowProcW.Call(hwnd, uintptr(message), wParam, lParam)
+	return result
+}
+
+// Read state again, update icon and show balloon if new run failed.
+func (ti *trayIcon) refresh() {
+	previous := ti.state
+	ti.state = ReadTrayState(ti.mainConfig, ti.programDirectory)
+	if ti.state.LastRun.After(previous.LastRun) && !previous.LastRun.IsZero() && ti.state.Failed {
+		ti.notify(nimModify, "Customisation update failed", ti.state.Error, niifWarning)
+		return
+	}
+	ti.notify(nimModify, "", "", 0)
+}
+
+// Add, modify or delete icon. Balloon shown if title provided.
+func (ti *trayIcon) notify(action uintptr, title, text string, infoFlags uint32) error {
+	data := notifyIconData{Window: ti.window, ID: 1, Flags: nifMessage | nifIcon | nifTip, CallbackMessage: wmTrayCallback}
+	data.Size = uint32(unsafe.Sizeof(data))
+	icon := uintptr(idiInformation)
+	switch {
+	case ti.state.Running:
+		icon = idiApplication
+	case ti.state.Failed:
+		icon = idiWarning
+	}
+	handle, _, _ := procLoadIconW.Call(0, icon)
+	data.Icon = windows.Handle(handle)
+	copyUTF16(data.Tip[:], ti.state.Tooltip)
+	if title != "" {
+		data.Flags |= nifInfo
+		data.InfoFlags = infoFlags
+		copyUTF16(data.InfoTitle[:], title)
+		copyUTF16(data.Info[:], text)
+	}
+	result, _, err := procShellNotifyIconW.Call(action, uintptr(unsafe.Pointer(&data)))
+	if result == 0 {
+		return err
+	}
+	return nil
+}
+
+// Show context menu at cursor and execute chosen item.
+func (ti *trayIcon) showMenu() {
+	menu, _, _ := procCreatePopupMenu.Call()
+	if menu == 0 {
+		return
+	}
+	defer procDestroyMenu.Call(menu)
+	appendItem := func(id uintptr, title string, enabled bool) {
+		flags := uintptr(mfString)
+		if !enabled {
+			flags |= mfGrayed
+		}
+		titlePtr, _ := windows.UTF16PtrFromString(title)
+		procAppendMenuW.Call(menu, flags, id, uintptr(unsafe.Pointer(titlePtr)))
+	}
+	appendItem(trayMenuRunNow, "Run now", !ti.state.Running)
+	appendItem(trayMenuReport, "Open latest history", ti.state.Report != "")
+	procAppendMenuW.Call(menu, mfSeparator, 0, 0)
+	appendItem(trayMenuExit, "Exit", true)
+
+	var cursor struct{ X, Y int32 }
+	procGetCursorPos.Call(uintptr(unsafe.Pointer(&cursor)))
+	// Menu closed by click outside only if window is foreground.
+	procSetForegroundWindow.Call(uintptr(ti.window))
+	command, _, _ := procTrackPopupMenu.Call(menu, tpmRightButton|tpmReturnCmd, uintptr(cursor.X), uintptr(cursor.Y), 0, uintptr(ti.window), 0)
+	procPostMessageW.Call(uintptr(ti.window), wmNull, 0, 0)
+
+	switch command {
+	case trayMenuRunNow:
+		err := StartUpdateNow(ti.programDirectory)
+		if err != nil {
+			ti.notify(nimModify, "Can't run update", err.Error(), niifWarning)
+			return
+		}
+		ti.notify(nimModify, "Update started", "Status refreshed automatically", niifInfo)
+	case trayMenuReport:
+		ti.openReport()
+	case trayMenuExit:
+		procDestroyWindow.Call(uintptr(ti.window))
+	}
+}
+
+// Open newest history file by associated application.
+func (ti *trayIcon) openReport() {
+	if ti.state.Report == "" {
+		return
+	}
+	verb, _ := windows.UTF16PtrFromString("open")
+	file, _ := windows.UTF16PtrFromString(ti.state.Report)
+	err := windows.ShellExecute(windows.Handle(ti.window), verb, file, nil, nil, swShowNormal)
+	if err != nil {
+		ti.notify(nimModify, "Can't open history", err.Error(), niifWarning)
+	}
+}
+
+// Copy string into fixed size UTF-16 buffer, truncated and zero terminated.
+func copyUTF16(buffer []uint16, text string) {
+	encoded, err := windows.UTF16FromString(text)
+	if err != nil {
+		return
+	}
+	if len(encoded) > len(buffer) {
+		encoded = encoded[:len(buffer)]
+		encoded[len(encoded)-1] = 0
+	}
+	copy(buffer, encoded)
+}
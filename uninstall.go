@@ -0,0 +1,196 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const UninstalledRegFileLabel string = "UNINSTALLED_" // Label of saved registry file written by uninstall.
+
+// Run "uninstall" subcommand. Remove customisation deployed into WDE folder to decommission machine:
+// files listed in current "CustomFiles" value deleted from WDE folder, "CustomFiles" cleared and "AddCustomFile" set "False".
+// Services stopped before change, changes recorded in audit log, files not deleted kept in deployed state.
+// Usage: uninstall [-yes]
+func RunUninstallCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	flags := flag.NewFlagSet("uninstall", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "uninstall without confirmation, required in unattended mode")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage: uninstall [-yes]")
+	}
+
+	timeString := FileTimestamp(TimestampNow())
+	logFullPath := filepath.Join(
+		LogFolderPath(mainConfig, programDirectory),
+		fmt.Sprint(LogFilePrefix(mainConfig), timeString, ".log"),
+	)
+	logger := NewZapSimpleLoggerWithRotation(mainConfig.Log.Verbose, logFullPath, 10, 1)
+	defer logger.Sync()
+	// Registry read under lock, so values written back never stale.
+	release, err := AcquireRunLock(mainConfig, programDirectory, logger)
+	if err != nil {
+		return err
+	}
+	defer release()
+	store := DefaultRegistryStore()
+	liveData, err := store.Read(DMRegistryDir)
+	if err == ErrRegistryKeyNotExist {
+		return fmt.Errorf("registry key '%v' not exist, nothing to uninstall", DMRegistryDir)
+	}
+	if err != nil {
+		return fmt.Errorf("can't read registry values - %v", err)
+	}
+	entries, err := RegistryValues(liveData).CustomFilesEntries()
+	if err != nil && err != ErrCustomFilesNotFound {
+		return fmt.Errorf("can't parse \"CustomFiles\" value - %v", err)
+	}
+
+	// Entries pointing outside WDE folder or to protected files never deleted.
+	targetDirectory := WDETargetFolder(mainConfig)
+	policy := NewFilePolicy(nil, nil, mainConfig.Policy.ProtectedFiles)
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		relativePath := filepath.Join(strings.ReplaceAll(entry.RelativePath, `\`, string(filepath.Separator)), entry.FileName)
+		if filepath.IsAbs(relativePath) || relativePath == "." || strings.HasPrefix(relativePath, "..") {
+			fmt.Printf("Entry '%v' points outside WDE folder, skipped\n", relativePath)
+			continue
+		}
+		if policy.Protected(entry) {
+			fmt.Printf("Entry '%v' is protected WDE file, skipped\n", relativePath)
+			continue
+		}
+		files = append(files, filepath.Join(targetDirectory, relativePath))
+	}
+	fmt.Printf("%v files listed in \"CustomFiles\" will be deleted from '%v', \"CustomFiles\" cleared and \"AddCustomFile\" set \"False\"\n", len(files), targetDirectory)
+	if !*yes && !AskYesNo("Uninstall customisation?") {
+		if Unattended() {
+			return fmt.Errorf("%v - use -yes to uninstall", ErrInteractionNotAllowed)
+		}
+		return fmt.Errorf("uninstall cancelled")
+	}
+
+	logger.Info(fmt.Sprintf("Uninstall of %v files from '%v' started", len(files), targetDirectory))
+	audit := NewAuditLog(mainConfig, programDirectory, timeString, CurrentRunLabels(), logger)
+	events := make(HistoryEvents, 0, 8)
+	startServices, err := StopConfiguredServices(mainConfig, &events, logger)
+	defer startServices()
+	if err != nil {
+		logger.Error(fmt.Sprint("Can't stop services, nothing changed - ", err))
+		return err
+	}
+
+	// Registry changed first, so DM never references deleted files.
+	savedRegistryDir := SavedRegistryFolderPath(mainConfig, programDirectory)
+	backupFullPath, err := BackupRegistryDir(store, DMRegistryDir, savedRegistryDir, timeString)
+	if err != nil {
+		logger.Error(fmt.Sprint("Can't backup registry, nothing changed - ", err))
+		return fmt.Errorf("can't backup registry before write, nothing changed - %v", err)
+	}
+	if backupFullPath != "" {
+		logger.Info(fmt.Sprintf("Registry exported into '%v'", backupFullPath))
+		audit.Record(AuditFileBackedUp, backupFullPath, "", audit.FileHash(backupFullPath), fmt.Sprint("registry ", DMRegistryDir))
+	}
+	liveHashes := make(map[string]string, len(liveData))
+	for _, value := range liveData {
+		liveHashes[value.Name] = HashRegistryData(value.Data)
+	}
+	values := append(RegistryValues{}, liveData...)
+	values.InsertActualCustomFilesValue(ConstructCustomFilesRegistryKey(nil))
+	values.InsertAddCustomFileValue("False")
+	err = WriteRegistryVerified(store, DMRegistryDir, values)
+	if err != nil {
+		logger.Error(fmt.Sprint("Can't write registry, files not deleted - ", err))
+		return fmt.Errorf("can't write registry, files not deleted - %v", err)
+	}
+	for _, value := range values {
+		if value.Name == "CustomFiles" || value.Name == "AddCustomFile" {
+			audit.Record(AuditRegistryWritten, fmt.Sprint(DMRegistryDir, `\`, value.Name), liveHashes[value.Name], HashRegistryData(value.Data), "uninstall")
+		}
+	}
+	logger.Info(fmt.Sprintf("\"CustomFiles\" cleared and \"AddCustomFile\" set \"False\" in '%v'", DMRegistryDir))
+	regBytes, err := MarshalRegistryData(values)
+	if err == nil {
+		err = SaveBytesIntoFile(filepath.Join(savedRegistryDir, fmt.Sprint(RegFileName, UninstalledRegFileLabel, timeString, ".yaml")), regBytes)
+	}
+	if err != nil {
+		logger.Error(fmt.Sprint("Can't save registry data - ", err))
+	}
+
+	removed := make([]string, 0, len(files))
+	failedFiles := make([]string, 0)
+	for _, fullPath := range files {
+		beforeHash := audit.FileHash(fullPath)
+		err := os.Remove(fullPath)
+		switch {
+		case err == nil:
+			removed = append(removed, fullPath)
+			logger.Info(fmt.Sprintf("File '%v' deleted", fullPath))
+			audit.Record(AuditFileDeleted, fullPath, beforeHash, "", "uninstall")
+		case os.IsNotExist(err):
+			logger.Info(fmt.Sprintf("File '%v' already absent", fullPath))
+		default:
+			failedFiles = append(failedFiles, fullPath)
+			logger.Error(fmt.Sprintf("Can't delete '%v' - %v", fullPath, err))
+		}
+	}
+	failed := len(failedFiles)
+	if mainConfig.State.RemoveEmptyDirectories {
+		emptied := RemoveEmptyDirectories(removed, targetDirectory, nil, audit, &events, logger)
+		logger.Info(fmt.Sprintf("%v empty directories removed from WDE folder", len(emptied)))
+	}
+
+	// Only files not deleted left in state, so next run offers them for removal again.
+	stateFileFullPath := filepath.Join(StateFolderPath(mainConfig, programDirectory), StateFileName)
+	err = uninstalledState(stateFileFullPath, failedFiles, targetDirectory).Save(stateFileFullPath)
+	if err != nil {
+		logger.Error(fmt.Sprint("Can't save deployed state - ", err))
+	}
+	db, err := ReadProvenance(ProvenanceFilePath(mainConfig, programDirectory))
+	if err == nil {
+		db.Update(nil, removed, targetDirectory, timeString, ReleaseInfo{}, CurrentRunLabels(), TimestampNow())
+		err = db.Save(ProvenanceFilePath(mainConfig, programDirectory))
+	}
+	if err != nil {
+		logger.Error(fmt.Sprint("Can't record provenance of deleted files - ", err))
+	}
+
+	logger.Info(fmt.Sprintf("Uninstall finished, %v files deleted, %v failed", len(removed), failed))
+	fmt.Printf("%v files deleted, %v already absent, %v failed\n", len(removed), len(files)-len(removed)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%v files not deleted, see log '%v'", failed, logFullPath)
+	}
+	fmt.Println("Uninstall finished, run WDE Deployment Manager to publish WDE without customisation")
+	return nil
+}
+
+// Return deployed state after uninstall with files which were not deleted.
+// Their records taken from previous state if present, otherwise made from path.
+func uninstalledState(stateFileFullPath string, failedFiles []string, targetDirectory string) DeployedState {
+	previous, _ := ReadDeployedState(stateFileFullPath) // Damaged state has no records, paths used.
+	records := make(map[string]DeployedStateFile, len(previous.Files))
+	for _, file := range previous.Files {
+		records[strings.ToLower(filepath.Join(file.RelativePath, file.FileName))] = file
+	}
+	state := DeployedState{RunTime: TimestampNow(), Files: make([]DeployedStateFile, 0, len(failedFiles))}
+	for _, fullPath := range failedFiles {
+		relativePath, err := filepath.Rel(targetDirectory, fullPath)
+		if err != nil {
+			continue
+		}
+		record, ok := records[strings.ToLower(relativePath)]
+		if !ok {
+			record = DeployedStateFile{FileName: filepath.Base(relativePath), RelativePath: filepath.Dir(relativePath)}
+			if record.RelativePath == "." {
+				record.RelativePath = ""
+			}
+		}
+		state.Files = append(state.Files, record)
+	}
+	return state
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"unicode/utf16"
+)
+
+const (
+	peDirectoryResource  int    = 2          // Index of resource directory in PE data directories.
+	resourceTypeVersion  uint32 = 16         // RT_VERSION resource type.
+	fixedFileInfoSign    uint32 = 0xFEEF04BD // Signature of VS_FIXEDFILEINFO.
+	maxResourceDirDepth  int    = 3          // Type, name and language levels of resource tree.
+	versionBlockTypeText uint16 = 1          // Value of version block is UTF-16 text.
+)
+
+// Source of file and product versions of PE files.
+type VersionReader interface {
+	FileVersion(path string) (FileVersion, error)
+	ProductVersion(path string) (string, error)
+}
+
+// Reader used by GetFileVersion and GetProductVersion.
+var versionReader = DefaultVersionReader()
+
+// Get file version from file info. Typically for .dll.
+func GetFileVersion(path string) (FileVersion, error) {
+	return versionReader.FileVersion(path)
+}
+
+// Get product version string from first translation of version resource.
+// If absent, product version from fixed file info used.
+func GetProductVersion(path string) (string, error) {
+	return versionReader.ProductVersion(path)
+}
+
+// Try primary reader and use fallback if it failed or panicked,
+// e.g. version.dll restricted on hardened build or behave differently under Wine.
+type FallbackVersionReader struct {
+	Primary  VersionReader
+	Fallback VersionReader
+}
+
+func (fvr FallbackVersionReader) FileVersion(path string) (FileVersion, error) {
+	var version FileVersion
+	err := recoverVersionPanic(func() (err error) {
+		version, err = fvr.Primary.FileVersion(path)
+		return
+	})
+	if err == nil {
+		return version, nil
+	}
+	return fvr.Fallback.FileVersion(path)
+}
+
+func (fvr FallbackVersionReader) ProductVersion(path string) (string, error) {
+	var version string
+	err := recoverVersionPanic(func() (err error) {
+		version, err = fvr.Primary.ProductVersion(path)
+		return
+	})
+	if err == nil {
+		return version, nil
+	}
+	return fvr.Fallback.ProductVersion(path)
+}
+
+// Return panic of version call as error.
+func recoverVersionPanic(call func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("version API panic - %v", r)
+		}
+	}()
+	return call()
+}
+
+// Pure Go reader of version resource from PE file, without Windows API.
+type PEVersionReader struct{}
+
+func (PEVersionReader) FileVersion(path string) (FileVersion, error) {
+	info, err := readVersionResource(path)
+	if err != nil {
+		return FileVersion{}, err
+	}
+	fixed, _, err := parseVersionInfo(info)
+	if err != nil {
+		return FileVersion{}, err
+	}
+	version := uint64(binary.LittleEndian.Uint32(fixed[8:]))<<32 | uint64(binary.LittleEndian.Uint32(fixed[12:]))
+	return FileVersion{version, version >> 48, version >> 32 & 0xFFFF, version >> 16 & 0xFFFF, version & 0xFFFF}, nil
+}
+
+func (PEVersionReader) ProductVersion(path string) (string, error) {
+	info, err := readVersionResource(path)
+	if err != nil {
+		return "", err
+	}
+	fixed, productVersion, err := parseVersionInfo(info)
+	if err != nil {
+		return "", err
+	}
+	if productVersion != "" {
+		return productVersion, nil
+	}
+	ms, ls := binary.LittleEndian.Uint32(fixed[16:]), binary.LittleEndian.Uint32(fixed[20:])
+	return fmt.Sprintf("%d.%d.%d.%d", ms>>16, ms&0xFFFF, ls>>16, ls&0xFFFF), nil
+}
+
+// Read VS_VERSIONINFO resource of PE file. Files without it, including not PE files, return ErrVersionNotExist.
+func readVersionResource(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, ErrVersionNotExist
+	}
+	peFile, err := pe.Open(path)
+	if err != nil {
+		return nil, ErrVersionNotExist
+	}
+	defer peFile.Close()
+	resources, err := readPEDirectory(peFile, info.Size(), peDirectoryResource)
+	if err != nil || len(resources) == 0 {
+		return nil, ErrVersionNotExist
+	}
+	// Version resource is first name and language of RT_VERSION type.
+	offset, isDirectory, ok := findResourceEntry(resources, 0, resourceTypeVersion, true)
+	for depth := 1; ok && isDirectory && depth < maxResourceDirDepth; depth++ {
+		offset, isDirectory, ok = findResourceEntry(resources, offset, 0, false)
+	}
+	if !ok || isDirectory || int(offset)+8 > len(resources) {
+		return nil, ErrVersionNotExist
+	}
+	rva, size := binary.LittleEndian.Uint32(resources[offset:]), binary.LittleEndian.Uint32(resources[offset+4:])
+	return readPERange(peFile, info.Size(), rva, size)
+}
+
+// Find entry of resource directory at offset by ID or take first entry.
+// Return offset of subdirectory or data entry.
+func findResourceEntry(resources []byte, offset, id uint32, byID bool) (uint32, bool, bool) {
+	if int(offset)+16 > len(resources) {
+		return 0, false, false
+	}
+	named := uint32(binary.LittleEndian.Uint16(resources[offset+12:]))
+	entries := named + uint32(binary.LittleEndian.Uint16(resources[offset+14:]))
+	for entry := uint32(0); entry < entries; entry++ {
+		entryOffset := offset + 16 + entry*8
+		if int(entryOffset)+8 > len(resources) {
+			break
+		}
+		name, data := binary.LittleEndian.Uint32(resources[entryOffset:]), binary.LittleEndian.Uint32(resources[entryOffset+4:])
+		if byID && (entry < named || name != id) {
+			continue
+		}
+		return data & 0x7FFFFFFF, data&0x80000000 != 0, true
+	}
+	return 0, false, false
+}
+
+// Block of version resource: key, value and child blocks.
+type versionBlock struct {
+	key       string
+	value     []byte
+	valueType uint16
+	children  []byte
+}
+
+// Parse block at start of data, return it and aligned length of it.
+func readVersionBlock(data []byte) (versionBlock, int, error) {
+	if len(data) < 6 {
+		return versionBlock{}, 0, fmt.Errorf("version block truncated")
+	}
+	length := int(binary.LittleEndian.Uint16(data))
+	if length < 6 || length > len(data) {
+		return versionBlock{}, 0, fmt.Errorf("invalid version block length %v", length)
+	}
+	data = data[:length]
+	block := versionBlock{valueType: binary.LittleEndian.Uint16(data[4:])}
+	valueLength := int(binary.LittleEndian.Uint16(data[2:]))
+	if block.valueType == versionBlockTypeText {
+		valueLength *= 2 // Length of text in UTF-16 characters.
+	}
+	position := 6
+	key := make([]uint16, 0, 16)
+	for ; position+1 < length; position += 2 {
+		char := binary.LittleEndian.Uint16(data[position:])
+		if char == 0 {
+			position += 2
+			break
+		}
+		key = append(key, char)
+	}
+	block.key = string(utf16.Decode(key))
+	position = align4(position)
+	if position+valueLength > length {
+		valueLength = length - position
+	}
+	if valueLength > 0 {
+		block.value = data[position : position+valueLength]
+		position = align4(position + valueLength)
+	}
+	if position < length {
+		block.children = data[position:]
+	}
+	return block, align4(length), nil
+}
+
+// Call visit for each block in data.
+func eachVersionBlock(data []byte, visit func(block versionBlock)) {
+	for len(data) > 0 {
+		block, length, err := readVersionBlock(data)
+		if err != nil {
+			return
+		}
+		visit(block)
+		if length >= len(data) {
+			return
+		}
+		data = data[length:]
+	}
+}
+
+// Return VS_FIXEDFILEINFO and ProductVersion string of first string table which has it.
+func parseVersionInfo(info []byte) ([]byte, string, error) {
+	root, _, err := readVersionBlock(info)
+	if err != nil {
+		return nil, "", err
+	}
+	if root.key != "VS_VERSION_INFO" || len(root.value) < 52 || binary.LittleEndian.Uint32(root.value) != fixedFileInfoSign {
+		return nil, "", ErrVersionNotExist
+	}
+	productVersion := ""
+	eachVersionBlock(root.children, func(fileInfo versionBlock) {
+		if fileInfo.key != "StringFileInfo" {
+			return
+		}
+		eachVersionBlock(fileInfo.children, func(table versionBlock) {
+			eachVersionBlock(table.children, func(value versionBlock) {
+				if productVersion == "" && value.key == "ProductVersion" {
+					productVersion = decodeUTF16String(value.value)
+				}
+			})
+		})
+	})
+	return root.value, productVersion, nil
+}
+
+// Decode null-terminated UTF-16 string.
+func decodeUTF16String(data []byte) string {
+	chars := make([]uint16, 0, len(data)/2)
+	for position := 0; position+1 < len(data); position += 2 {
+		char := binary.LittleEndian.Uint16(data[position:])
+		if char == 0 {
+			break
+		}
+		chars = append(chars, char)
+	}
+	return string(utf16.Decode(chars))
+}
+
+// Round offset up to 32-bit boundary.
+func align4(offset int) int {
+	return (offset + 3) &^ 3
+}
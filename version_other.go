@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// Windows version API not available, version resource parsed from PE file.
+func DefaultVersionReader() VersionReader {
+	return PEVersionReader{}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gonutz/w32"
+)
+
+// Read versions by Windows version API, PE resource parsed if API failed.
+func DefaultVersionReader() VersionReader {
+	return FallbackVersionReader{Primary: W32VersionReader{}, Fallback: PEVersionReader{}}
+}
+
+// Version reader based on GetFileVersionInfo and VerQueryValue of version.dll.
+type W32VersionReader struct{}
+
+// Get file version from file info. Typically for .dll.
+func (W32VersionReader) FileVersion(path string) (FileVersion, error) {
+	size := w32.GetFileVersionInfoSize(path)
+	if size <= 0 {
+		return FileVersion{}, ErrVersionNotExist
+	}
+	info := make([]byte, size)
+	ok := w32.GetFileVersionInfo(path, info)
+	if !ok {
+		return FileVersion{}, ErrVersionNotExist
+	}
+	fixed, ok := w32.VerQueryValueRoot(info)
+	if !ok {
+		return FileVersion{}, ErrVersionNotExist
+	}
+	version := fixed.FileVersion()
+	v1 := version & 0xFFFF000000000000 >> 48
+	v2 := version & 0x0000FFFF00000000 >> 32
+	v3 := version & 0x00000000FFFF0000 >> 16
+	v4 := version & 0x000000000000FFFF >> 0
+	return FileVersion{version, v1, v2, v3, v4}, nil
+}
+
+// Get product version string from first translation of version resource.
+// If absent, product version from fixed file info used.
+func (W32VersionReader) ProductVersion(path string) (string, error) {
+	size := w32.GetFileVersionInfoSize(path)
+	if size <= 0 {
+		return "", ErrVersionNotExist
+	}
+	info := make([]byte, size)
+	ok := w32.GetFileVersionInfo(path, info)
+	if !ok {
+		return "", ErrVersionNotExist
+	}
+	translations, ok := w32.VerQueryValueTranslations(info)
+	if ok && len(translations) > 0 {
+		productVersion, ok := w32.VerQueryValueString(info, translations[0], "ProductVersion")
+		if ok && productVersion != "" {
+			return productVersion, nil
+		}
+	}
+	fixed, ok := w32.VerQueryValueRoot(info)
+	if !ok {
+		return "", ErrVersionNotExist
+	}
+	version := fixed.ProductVersion()
+	return fmt.Sprintf("%d.%d.%d.%d", version>>48, version>>32&0xFFFF, version>>16&0xFFFF, version&0xFFFF), nil
+}
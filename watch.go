@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	WatchDefaultInterval  = time.Hour            // Pause between runs in watch mode if not configured.
+	RemoteConfigTimeout   = 30 * time.Second     // Timeout for remote config download.
+	ConfigDiffMissedValue = "<not set>"          // Shown in config diff for absent values.
+	RemoteConfigSignature = "X-Config-Signature" // Response header with hex HMAC-SHA256 of remote config with Watch.ConfigSecret.
+)
+
+// Config keys which remote config may change, matched by key or its parent key.
+// Keys choosing sources, commands, endpoints, folders or secrets never changed remotely.
+var RemoteConfigAllowedKeys = []string{
+	"Watch.Interval",
+	"Log.Verbose",
+	"Run.MaxDuration",
+	"Run.NotifyOnOverrun",
+	"Run.Retries",
+	"Run.RetryDelay",
+	"Run.RetryMaxDelay",
+	"Limits",
+	"CustomFiles.Mode",
+	"CustomFiles.Order",
+	"CustomFiles.WarnSizeKB",
+	"CustomFiles.Compact",
+	"Policy.DenyExtensions",
+	"CompareStrategy",
+	"RedundantFiles",
+}
+
+// Result of config reload before run in watch mode.
+type ConfigReload struct {
+	Changes []string // Changed values in form "Key: 'old' -> 'new'".
+	Err     error    // Reload error. Previous config used if set.
+}
+
+// Log config reload result into run log. Nil reload ignored.
+func (cr *ConfigReload) Log(logger *zap.Logger) {
+	if cr == nil {
+		return
+	}
+	if cr.Err != nil {
+		logger.Warn(fmt.Sprint("Config reload failed, previous config used - ", cr.Err))
+	}
+	if len(cr.Changes) == 0 {
+		logger.Info("Config not changed since previous run")
+		return
+	}
+	logger.Info(fmt.Sprintf("Config changed since previous run, %v values:", len(cr.Changes)))
+	for _, change := range cr.Changes {
+		logger.Info(fmt.Sprint("    ", change))
+	}
+}
+
+// Run update persistently. Before each run config file and remote config re-read,
+// so changes applied without restart.
+func RunWatch(confFilePath string, mainConfig MainCfgYAML, programDirectory string, registryStore RegistryStore) {
+	log.Printf("Watch mode started, config `%v`", confFilePath)
+	err := StartHealthReporting(mainConfig, programDirectory, runStatus)
+	if err != nil {
+		log.Println("Can't start health reporting -", err)
+	}
+	effectiveConfig, err := ApplyRemoteConfig(mainConfig)
+	if err != nil {
+		log.Println("Can't apply remote config -", err)
+		effectiveConfig = mainConfig
+	}
+	var reload *ConfigReload
+	for {
+		RunConfiguredUpdate(effectiveConfig, programDirectory, registryStore, reload)
+
+		interval := WatchDefaultInterval
+		if effectiveConfig.Watch.Interval != "" {
+			interval, err = time.ParseDuration(effectiveConfig.Watch.Interval)
+			if err != nil {
+				log.Printf("Can't parse Watch.Interval, default %v used - %v", WatchDefaultInterval, err)
+				interval = WatchDefaultInterval
+			}
+		}
+		log.Printf("Next run in %v", interval)
+		time.Sleep(interval)
+
+		reload = &ConfigReload{}
+		newConfig, err := ReadConfigFile(confFilePath)
+		if err == nil {
+			newConfig, err = ApplyRemoteConfig(newConfig)
+		}
+		if err == nil {
+			err = ApplyLogOverrides(&newConfig)
+		}
+		if err != nil {
+			reload.Err = err
+			continue
+		}
+		reload.Changes, err = ConfigDiff(effectiveConfig, newConfig)
+		if err != nil {
+			reload.Err = err
+		}
+		effectiveConfig = newConfig
+	}
+}
+
+// Download remote config from Watch.ConfigURL and apply its values over provided config.
+// Format detected by URL path extension like for local file. Config accepted only over HTTPS
+// with valid signature of Watch.ConfigSecret and only if it changes keys of RemoteConfigAllowedKeys.
+// Config returned unchanged if URL not configured.
+func ApplyRemoteConfig(mainConfig MainCfgYAML) (MainCfgYAML, error) {
+	if mainConfig.Watch.ConfigURL == "" {
+		return mainConfig, nil
+	}
+	configURL, err := ResolveSecretReferences(mainConfig.Watch.ConfigURL)
+	if err != nil {
+		return mainConfig, err
+	}
+	parsedURL, err := url.Parse(configURL)
+	if err != nil {
+		return mainConfig, fmt.Errorf("can't parse Watch.ConfigURL - %v", err)
+	}
+	if parsedURL.Scheme != "https" {
+		return mainConfig, fmt.Errorf("remote config accepted only over https")
+	}
+	secret, err := ResolveSecretReferences(mainConfig.Watch.ConfigSecret)
+	if err != nil {
+		return mainConfig, err
+	}
+	if secret == "" {
+		return mainConfig, fmt.Errorf("remote config can't be verified, Watch.ConfigSecret not set")
+	}
+	client := http.Client{Timeout: RemoteConfigTimeout}
+	response, err := client.Get(configURL)
+	if err != nil {
+		return mainConfig, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return mainConfig, fmt.Errorf("remote config request failed with status '%v'", response.Status)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return mainConfig, err
+	}
+	return verifyRemoteConfig(mainConfig, data, ConfigFormat(response.Request.URL.Path), response.Header.Get(RemoteConfigSignature), secret)
+}
+
+// Check signature of remote config data and apply it over copy of local config.
+// Local config returned unchanged if signature not valid or not allowed keys changed.
+func verifyRemoteConfig(mainConfig MainCfgYAML, data []byte, format, signature, secret string) (MainCfgYAML, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+		return mainConfig, fmt.Errorf("remote config signature not valid")
+	}
+	// Unmarshalled over deep copy, otherwise maps shared with local config changed too and not detected.
+	remoteConfig, err := copyConfig(mainConfig)
+	if err != nil {
+		return mainConfig, err
+	}
+	err = UnmarshalConfig(data, format, &remoteConfig)
+	if err != nil {
+		return mainConfig, err
+	}
+	err = checkRemoteConfigKeys(mainConfig, remoteConfig)
+	if err != nil {
+		return mainConfig, err
+	}
+	return remoteConfig, nil
+}
+
+// Return deep copy of config, maps and slices not shared with original.
+func copyConfig(mainConfig MainCfgYAML) (MainCfgYAML, error) {
+	data, err := yaml.Marshal(mainConfig)
+	if err != nil {
+		return mainConfig, err
+	}
+	var copied MainCfgYAML
+	err = yaml.Unmarshal(data, &copied)
+	if err != nil {
+		return mainConfig, err
+	}
+	return copied, nil
+}
+
+// Check remote config changes only allowed keys. Whole remote config rejected otherwise.
+func checkRemoteConfigKeys(localConfig, remoteConfig MainCfgYAML) error {
+	localValues, err := FlattenConfig(localConfig)
+	if err != nil {
+		return err
+	}
+	remoteValues, err := FlattenConfig(remoteConfig)
+	if err != nil {
+		return err
+	}
+	denied := make([]string, 0)
+	check := func(key string) {
+		if localValues[key] == remoteValues[key] {
+			return
+		}
+		for _, allowed := range RemoteConfigAllowedKeys {
+			if key == allowed || strings.HasPrefix(key, allowed+".") || strings.HasPrefix(key, allowed+"[") {
+				return
+			}
+		}
+		denied = append(denied, key)
+	}
+	for key := range remoteValues {
+		check(key)
+	}
+	for key := range localValues {
+		if _, ok := remoteValues[key]; !ok {
+			check(key)
+		}
+	}
+	if len(denied) > 0 {
+		sort.Strings(denied)
+		return fmt.Errorf("remote config changes keys not allowed remotely: %v", strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// Compare two configs and return changed values sorted by key.
+// Values shown redacted as in support bundle, see RedactedConfigYAML.
+func ConfigDiff(oldConfig, newConfig MainCfgYAML) ([]string, error) {
+	oldValues, err := FlattenConfig(oldConfig)
+	if err != nil {
+		return nil, err
+	}
+	newValues, err := FlattenConfig(newConfig)
+	if err != nil {
+		return nil, err
+	}
+	oldShown, err := flattenRedactedConfig(oldConfig)
+	if err != nil {
+		return nil, err
+	}
+	newShown, err := flattenRedactedConfig(newConfig)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(newValues))
+	for key := range newValues {
+		keys = append(keys, key)
+	}
+	for key := range oldValues {
+		if _, ok := newValues[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := make([]string, 0, 8)
+	for _, key := range keys {
+		oldValue, oldOk := oldValues[key]
+		newValue, newOk := newValues[key]
+		if oldOk && newOk && oldValue == newValue {
+			continue
+		}
+		oldValue, newValue = oldShown[key], newShown[key]
+		if !oldOk {
+			oldValue = ConfigDiffMissedValue
+		}
+		if !newOk {
+			newValue = ConfigDiffMissedValue
+		}
+		changes = append(changes, fmt.Sprintf("%v: '%v' -> '%v'", key, oldValue, newValue))
+	}
+	return changes, nil
+}
+
+// Get config values as map with keys like "Log.Folder" or "RedundantFiles[0]".
+func FlattenConfig(mainConfig MainCfgYAML) (map[string]string, error) {
+	tree, err := configTree(mainConfig)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, 64)
+	flattenYAMLValue("", tree, values)
+	return values, nil
+}
+
+// Get config values with secrets redacted as map, keys as by FlattenConfig.
+func flattenRedactedConfig(mainConfig MainCfgYAML) (map[string]string, error) {
+	tree, err := RedactedConfigTree(mainConfig)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, 64)
+	flattenYAMLValue("", tree, values)
+	return values, nil
+}
+
+// Get config as ordered YAML tree.
+func configTree(mainConfig MainCfgYAML) (yaml.MapSlice, error) {
+	data, err := yaml.Marshal(mainConfig)
+	if err != nil {
+		return nil, err
+	}
+	var tree yaml.MapSlice
+	err = yaml.Unmarshal(data, &tree)
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// Recursively write YAML value into map by its path.
+func flattenYAMLValue(path string, value interface{}, values map[string]string) {
+	switch typed := value.(type) {
+	case yaml.MapSlice:
+		for _, item := range typed {
+			key := fmt.Sprint(item.Key)
+			if path != "" {
+				key = fmt.Sprint(path, ".", key)
+			}
+			flattenYAMLValue(key, item.Value, values)
+		}
+	case []interface{}:
+		for id, item := range typed {
+			flattenYAMLValue(fmt.Sprintf("%v[%v]", path, id), item, values)
+		}
+	default:
+		values[path] = fmt.Sprint(value)
+	}
+}
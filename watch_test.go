@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+const remoteConfigTestSecret string = "test-secret" // Watch.ConfigSecret of remote config tests.
+
+// Return hex HMAC-SHA256 signature of remote config data as sent in X-Config-Signature.
+func signRemoteConfig(data []byte) string {
+	mac := hmac.New(sha256.New, []byte(remoteConfigTestSecret))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Return local config with registry template map, shared by copies of config struct.
+func remoteConfigTestLocal() MainCfgYAML {
+	var mainConfig MainCfgYAML
+	mainConfig.Watch.Interval = "1h"
+	mainConfig.Registry.Template = map[string]string{"Language": "en-US"}
+	return mainConfig
+}
+
+func TestVerifyRemoteConfigAllowedKey(t *testing.T) {
+	local := remoteConfigTestLocal()
+	data := []byte("Watch:\n  Interval: 30m\n")
+	remote, err := verifyRemoteConfig(local, data, "yaml", signRemoteConfig(data), remoteConfigTestSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.Watch.Interval != "30m" {
+		t.Errorf("Watch.Interval '%v', want '30m'", remote.Watch.Interval)
+	}
+	if local.Watch.Interval != "1h" {
+		t.Errorf("local Watch.Interval changed to '%v'", local.Watch.Interval)
+	}
+}
+
+func TestVerifyRemoteConfigDeniedMapKey(t *testing.T) {
+	local := remoteConfigTestLocal()
+	data := []byte("Registry:\n  Template:\n    PublishURL: https://evil\n")
+	_, err := verifyRemoteConfig(local, data, "yaml", signRemoteConfig(data), remoteConfigTestSecret)
+	if err == nil {
+		t.Error("remote config changed Registry.Template without error")
+	}
+	if _, ok := local.Registry.Template["PublishURL"]; ok || len(local.Registry.Template) != 1 {
+		t.Errorf("local Registry.Template changed by remote config: %v", local.Registry.Template)
+	}
+}
+
+func TestVerifyRemoteConfigDeniedScalarKey(t *testing.T) {
+	data := []byte("Notify:\n  Command: [cmd, /c, calc]\n")
+	_, err := verifyRemoteConfig(remoteConfigTestLocal(), data, "yaml", signRemoteConfig(data), remoteConfigTestSecret)
+	if err == nil {
+		t.Error("remote config changed Notify.Command without error")
+	}
+}
+
+func TestVerifyRemoteConfigSignature(t *testing.T) {
+	data := []byte("Watch:\n  Interval: 30m\n")
+	for _, signature := range []string{"", signRemoteConfig([]byte("Watch:\n  Interval: 1m\n"))} {
+		_, err := verifyRemoteConfig(remoteConfigTestLocal(), data, "yaml", signature, remoteConfigTestSecret)
+		if err == nil {
+			t.Errorf("remote config with signature '%v' accepted", signature)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const IgnoreFileName string = ".wdeignore" // Gitignore-style exclusions in root of customisation folder.
+
+// One pattern line of ignore file.
+type IgnoreRule struct {
+	segments []string // Pattern split by "/", "**" matches any number of segments.
+	negate   bool     // Pattern started with "!", matched path included back.
+	dirOnly  bool     // Pattern ended with "/", match only directories.
+	anchored bool     // Pattern contains "/", matched from customisation folder root.
+}
+
+// Patterns of ignore file in file order. Last matched pattern decides.
+type IgnoreRules []IgnoreRule
+
+// Read ignore file from customisation folder root. Return no rules if file not exists.
+// Supported gitignore syntax: "#" comments, "!" negation, trailing "/" for directories,
+// leading or middle "/" for anchored patterns, "*", "?", "[...]" and "**" wildcards.
+// Matching is case insensitive.
+func ReadIgnoreFile(customisationFolder string) (IgnoreRules, error) {
+	file, err := os.Open(filepath.Join(customisationFolder, IgnoreFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	rules := make(IgnoreRules, 0, 8)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		rule, ok := ParseIgnoreRule(scanner.Text())
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// Parse one line of ignore file. Return false for comments and empty lines.
+func ParseIgnoreRule(line string) (IgnoreRule, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return IgnoreRule{}, false
+	}
+	var rule IgnoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	line = strings.ToLower(strings.ReplaceAll(line, `\`, "/"))
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return IgnoreRule{}, false
+	}
+	rule.segments = strings.Split(line, "/")
+	return rule, true
+}
+
+// Check that path relative to customisation folder excluded by rules.
+func (ir IgnoreRules) Match(relativePath string, isDir bool) bool {
+	pathSegments := strings.Split(strings.ToLower(filepath.ToSlash(relativePath)), "/")
+	ignored := false
+	for _, rule := range ir {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		var matched bool
+		if rule.anchored {
+			matched = matchIgnoreSegments(rule.segments, pathSegments)
+		} else {
+			matched = matchIgnoreSegments(rule.segments, pathSegments[len(pathSegments)-1:])
+		}
+		if matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// Match path segments by pattern segments, "**" matches zero or more segments.
+func matchIgnoreSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for skip := 0; skip <= len(segments); skip++ {
+			if matchIgnoreSegments(pattern[1:], segments[skip:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	matched, err := path.Match(pattern[0], segments[0])
+	if err != nil || !matched {
+		return false
+	}
+	return matchIgnoreSegments(pattern[1:], segments[1:])
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"path/filepath"
+)
+
+// Installed WDE version read from WDE executable.
+type WDEVersion struct {
+	Path    string      // Full path of WDE executable.
+	File    FileVersion // File version, used for compatibility checks.
+	Product string      // Product version string as shown in Programs and Features.
+}
+
+// Read version of WDE executable in WDE folder from config.
+func DetectWDEVersion(mainConfig MainCfgYAML) (WDEVersion, error) {
+	executablePath := filepath.Join(WDETargetFolder(mainConfig), WDEExecutableName)
+	fileVersion, err := GetFileVersion(executablePath)
+	if err != nil {
+		return WDEVersion{Path: executablePath}, fmt.Errorf("can't read version of '%v' - %v", executablePath, err)
+	}
+	productVersion, err := GetProductVersion(executablePath)
+	if err != nil {
+		productVersion = fileVersion.String()
+	}
+	return WDEVersion{Path: executablePath, File: fileVersion, Product: productVersion}, nil
+}
+
+// Log detected WDE version or warning if it is unknown.
+func LogWDEVersion(version WDEVersion, err error, logger *zap.Logger) {
+	if err != nil {
+		logger.Warn(fmt.Sprint("Installed WDE version not detected - ", err))
+		return
+	}
+	logger.Info("Installed WDE version",
+		zap.String("fileVersion", version.File.String()),
+		zap.String("productVersion", version.Product),
+		zap.String("path", version.Path),
+	)
+}
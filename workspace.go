@@ -0,0 +1,259 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	WorkspaceDefaultName    string = "WdeCustomizationUpdater" // Default workspace folder in %ProgramData%.
+	WorkspaceMigratedMarker string = "Migrated.txt"            // Created in workspace after artifacts moved from program directory.
+)
+
+// Get workspace folder for run-time artifacts (logs, history, registry data, state, audit).
+// By default "%ProgramData%\WdeCustomizationUpdater", program directory if ProgramData not defined.
+// On multi-session host workspace has subfolder per user SID, so HKCU registry snapshots, state,
+// ownership and baseline of one user never restored or claimed into hive of another.
+func WorkspaceFolderPath(mainConfig MainCfgYAML, programDirectory string) string {
+	workspace := programDirectory
+	if mainConfig.Workspace.Folder != "" {
+		workspace = ExpandWindowsEnv(mainConfig.Workspace.Folder)
+	} else if programData := os.Getenv("ProgramData"); programData != "" {
+		workspace = filepath.Join(programData, WorkspaceDefaultName)
+	}
+	if IsMultiSessionHost() {
+		if sid := CurrentUserSID(); sid != "" {
+			return filepath.Join(workspace, sid)
+		}
+	}
+	return workspace
+}
+
+// Get artifact folder: configured absolute folder as is, relative one or default name inside workspace.
+func WorkspaceArtifactPath(mainConfig MainCfgYAML, programDirectory, configured, defaultName string) string {
+	if configured == "" {
+		configured = defaultName
+	}
+	if filepath.IsAbs(configured) {
+		return configured
+	}
+	return filepath.Join(WorkspaceFolderPath(mainConfig, programDirectory), configured)
+}
+
+// Get folder of saved registry data in workspace.
+func SavedRegistryFolderPath(mainConfig MainCfgYAML, programDirectory string) string {
+	return filepath.Join(WorkspaceFolderPath(mainConfig, programDirectory), SavedRegFolder)
+}
+
+// Return artifact folders placed inside workspace, relative to it.
+// Folders configured by absolute path not included.
+func workspaceArtifactFolders(mainConfig MainCfgYAML) []string {
+	folders := []string{SavedRegFolder}
+	for _, folder := range [][2]string{
+		{mainConfig.Log.Folder, "Log"},
+		{mainConfig.History.Folder, "History"},
+		{mainConfig.State.Folder, "State"},
+		{mainConfig.Audit.Folder, "Audit"},
+		{mainConfig.Backup.Folder, BackupFolder},
+	} {
+		if folder[0] == "" {
+			folders = append(folders, folder[1])
+		} else if !filepath.IsAbs(folder[0]) {
+			folders = append(folders, folder[0])
+		}
+	}
+	return folders
+}
+
+// Result of artifacts migration into workspace.
+type WorkspaceMigration struct {
+	Moved []string // Moved folders and files.
+	Kept  []string // Files left in source folder, already present in workspace.
+}
+
+// Move artifact folders left in program directory by previous versions into workspace.
+// Done once: marker file created in workspace after successful migration, force ignore it.
+// Folders already present in workspace merged file by file, existing files not overwritten.
+// Folders configured by absolute path not moved.
+func MigrateWorkspace(mainConfig MainCfgYAML, programDirectory string, force bool) (WorkspaceMigration, error) {
+	migration := WorkspaceMigration{Moved: make([]string, 0), Kept: make([]string, 0)}
+	workspace := WorkspaceFolderPath(mainConfig, programDirectory)
+	if filepath.Clean(workspace) == filepath.Clean(programDirectory) {
+		return migration, nil
+	}
+	markerPath := filepath.Join(workspace, WorkspaceMigratedMarker)
+	if _, err := os.Stat(markerPath); err == nil && !force {
+		return migration, nil
+	}
+	for _, name := range workspaceArtifactFolders(mainConfig) {
+		source := filepath.Join(programDirectory, name)
+		target := filepath.Join(workspace, name)
+		if info, err := os.Stat(source); err != nil || !info.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			err = os.MkdirAll(filepath.Dir(target), 0755)
+			if err != nil {
+				return migration, err
+			}
+			err = moveWorkspaceEntry(source, target)
+			if err != nil {
+				return migration, fmt.Errorf("can't move '%v' into workspace - %v", source, err)
+			}
+			migration.Moved = append(migration.Moved, fmt.Sprintf("'%v' -> '%v'", source, target))
+			continue
+		}
+		err := mergeWorkspaceFolder(source, target, &migration)
+		if err != nil {
+			return migration, fmt.Errorf("can't merge '%v' into workspace - %v", source, err)
+		}
+	}
+	err := os.MkdirAll(workspace, 0755)
+	if err != nil {
+		return migration, err
+	}
+	err = ioutil.WriteFile(markerPath, []byte(fmt.Sprintln("Migrated from", programDirectory, "at", TimestampNow().Format(time.RFC3339))), 0644)
+	if err != nil {
+		return migration, fmt.Errorf("can't create migration marker - %v", err)
+	}
+	return migration, nil
+}
+
+// Move files of source folder missing in target folder, nested folders included.
+// Emptied source folders removed.
+func mergeWorkspaceFolder(source, target string, migration *WorkspaceMigration) error {
+	dirContent, err := ioutil.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(target, 0755)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirContent {
+		sourcePath := filepath.Join(source, entry.Name())
+		targetPath := filepath.Join(target, entry.Name())
+		if entry.IsDir() {
+			err = mergeWorkspaceFolder(sourcePath, targetPath, migration)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := os.Stat(targetPath); err == nil {
+			migration.Kept = append(migration.Kept, sourcePath)
+			continue
+		}
+		err = moveWorkspaceEntry(sourcePath, targetPath)
+		if err != nil {
+			return err
+		}
+		migration.Moved = append(migration.Moved, fmt.Sprintf("'%v' -> '%v'", sourcePath, targetPath))
+	}
+	remaining, err := ioutil.ReadDir(source)
+	if err == nil && len(remaining) == 0 {
+		os.Remove(source)
+	}
+	return nil
+}
+
+// Move file or folder. Rename not possible between volumes, copy and remove source in that case.
+func moveWorkspaceEntry(source, target string) error {
+	err := os.Rename(source, target)
+	if err == nil {
+		return nil
+	}
+	info, statErr := os.Stat(source)
+	if statErr != nil {
+		return err
+	}
+	if !info.IsDir() {
+		_, err = copyFile(source, target)
+		if err != nil {
+			os.Remove(target)
+			return err
+		}
+		os.Chtimes(target, info.ModTime(), info.ModTime())
+		return os.Remove(source)
+	}
+	err = os.MkdirAll(target, 0755)
+	if err != nil {
+		return err
+	}
+	dirContent, err := ioutil.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirContent {
+		err = moveWorkspaceEntry(filepath.Join(source, entry.Name()), filepath.Join(target, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return os.Remove(source)
+}
+
+// Run "migrate-data" subcommand. Move artifacts from program directory (or folder set by -from)
+// into workspace regardless of migration marker and record migration in history.
+func RunMigrateDataCommand(args []string, mainConfig MainCfgYAML, programDirectory string) error {
+	flags := flag.NewFlagSet("migrate-data", flag.ContinueOnError)
+	sourceDirectory := flags.String("from", programDirectory, "folder with artifacts of previous versions")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	startTime := TimestampNow()
+	startTimeString := FileTimestamp(startTime)
+	logFullPath := filepath.Join(
+		LogFolderPath(mainConfig, programDirectory),
+		fmt.Sprint(LogFilePrefix(mainConfig), startTimeString, ".log"),
+	)
+	logger := NewZapSimpleLoggerWithRotation(mainConfig.Log.Verbose, logFullPath, 10, 1)
+	defer logger.Sync()
+	workspace := WorkspaceFolderPath(mainConfig, programDirectory)
+	logger.Info(fmt.Sprintf("Migration of artifacts from '%v' into workspace '%v' started", *sourceDirectory, workspace))
+
+	migration, err := MigrateWorkspace(mainConfig, *sourceDirectory, true)
+	events := make(HistoryEvents, 0, len(migration.Moved)+len(migration.Kept)+1)
+	for _, moved := range migration.Moved {
+		logger.Info(fmt.Sprint("Artifact moved into workspace: ", moved))
+		events.Add("Artifact moved into workspace: %v", moved)
+	}
+	for _, kept := range migration.Kept {
+		logger.Warn(fmt.Sprint("Artifact already exists in workspace, not moved: ", kept))
+		events.Add("Artifact already exists in workspace, not moved: %v", kept)
+	}
+	if err != nil {
+		logger.Error(fmt.Sprint("Artifacts migration failed - ", err))
+		return err
+	}
+	if len(events) == 0 {
+		logger.Info("Nothing to migrate")
+		log.Println("Nothing to migrate")
+		return nil
+	}
+
+	// Record migration in history file without collected files.
+	wdeVersion, _ := DetectWDEVersion(mainConfig)
+	historyWritingEnd := make(chan bool, 1)
+	historyName := HistoryFilePrefix(mainConfig)
+	historyFileFullPath := filepath.Join(
+		HistoryFolderPath(mainConfig, programDirectory),
+		fmt.Sprint(historyName, startTimeString, ".log"),
+	)
+	events.Add("Artifacts migrated into workspace '%v'", workspace)
+	WriteHistoryFile(nil, CollectHostFacts(wdeVersion), ReleaseInfo{}, CurrentRunLabels(), historyFileFullPath, historyName, true, historyWritingEnd, logger)
+	FinishHistoryFile(historyFileFullPath, nil, &events, historyWritingEnd, mainConfig.Mirror.Folder, logger)
+
+	for _, event := range events {
+		log.Println(event)
+	}
+	logger.Info("Migration of artifacts finished")
+	return nil
+}